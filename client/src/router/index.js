@@ -28,6 +28,15 @@ const routes = [
       layout: "Default",
     },
   },
+  {
+    path: "/oidc/:provider",
+    name: "OIDC",
+    component: () => import("@/views/OIDC.vue"),
+    meta: {
+      requiredAuth: false,
+      layout: "Default",
+    },
+  },
   {
     path: "/signup",
     name: "Signup",
@@ -13,17 +13,52 @@ const authClient = () =>
     },
   });
 
+// refreshing is shared so concurrent requests don't reuse the same refresh token
+let refreshing = null;
+
+// tokenExpiresSoon checks if the access token expires within a minute
+const tokenExpiresSoon = () => {
+  const token = localStorage.getItem("token");
+  if (!token) return false;
+
+  try {
+    const claims = JSON.parse(atob(token.split(".")[1].replace(/-/g, "+").replace(/_/g, "/")));
+    return claims.exp * 1000 - Date.now() < 60 * 1000;
+  } catch {
+    return true;
+  }
+};
+
 export default {
   async refresh_token() {
+    if (!localStorage.getItem("refresh_token") || !tokenExpiresSoon()) return;
+
+    if (!refreshing) {
+      refreshing = baseClient()
+        .post("/user/refresh_token", {
+          refresh_token: localStorage.getItem("refresh_token"),
+        })
+        .then((response) => {
+          localStorage.setItem("token", response.data.data.access_token);
+          localStorage.setItem("refresh_token", response.data.data.refresh_token);
+        })
+        .catch(() => {
+          localStorage.removeItem("token");
+          localStorage.removeItem("refresh_token");
+        })
+        .finally(() => {
+          refreshing = null;
+        });
+    }
+    await refreshing;
+  },
+
+  async logout() {
     await authClient()
-      .post("/user/refresh_token")
-      .then((response) => {
-        let token = response.data.data.refresh_token;
-        localStorage.setItem("token", token);
-      })
-      .catch(() => {
-        localStorage.removeItem("token");
-      });
+      .post("/user/logout")
+      .catch(() => {});
+    localStorage.removeItem("token");
+    localStorage.removeItem("refresh_token");
   },
 
   // user
@@ -32,9 +67,9 @@ export default {
     return await authClient().get("/user");
   },
 
-  async activateVoucher(voucher) {
+  async activateVoucher(voucher, team_id = 0) {
     await this.refresh_token();
-    return await authClient().put("/user/activate_voucher", { voucher });
+    return await authClient().put("/user/activate_voucher", { voucher, team_id });
   },
 
   async updateUser(name, ssh_key) {
@@ -45,6 +80,118 @@ export default {
     });
   },
 
+  async signInTwoFactor(email, two_factor_token, code) {
+    return await baseClient().post("/user/signin/2fa", {
+      email,
+      two_factor_token,
+      code,
+    });
+  },
+
+  async listOIDCProviders() {
+    return await baseClient().get("/user/oidc/providers");
+  },
+
+  async oidcLogin(provider) {
+    return await baseClient().get(`/user/oidc/${provider}/login`);
+  },
+
+  async oidcCallback(provider, code, state) {
+    return await baseClient().post(`/user/oidc/${provider}/callback`, {
+      code,
+      state,
+    });
+  },
+
+  async getTwoFactor() {
+    await this.refresh_token();
+    return await authClient().get("/user/2fa");
+  },
+
+  async enrollTwoFactor() {
+    await this.refresh_token();
+    return await authClient().post("/user/2fa");
+  },
+
+  async confirmTwoFactor(code) {
+    await this.refresh_token();
+    return await authClient().post("/user/2fa/confirm", { code });
+  },
+
+  async regenerateRecoveryCodes(code) {
+    await this.refresh_token();
+    return await authClient().post("/user/2fa/recovery_codes", { code });
+  },
+
+  async disableTwoFactor(code) {
+    await this.refresh_token();
+    return await authClient().delete("/user/2fa", { data: { code } });
+  },
+
+  async listAccessTokens() {
+    await this.refresh_token();
+    return await authClient().get("/user/tokens");
+  },
+
+  async createAccessToken(name, scopes, expires_in_days) {
+    await this.refresh_token();
+    return await authClient().post("/user/tokens", {
+      name,
+      scopes,
+      expires_in_days,
+    });
+  },
+
+  async revokeAccessToken(id) {
+    await this.refresh_token();
+    return await authClient().delete(`/user/tokens/${id}`);
+  },
+
+  async listTeams() {
+    await this.refresh_token();
+    return await authClient().get("/team");
+  },
+
+  async createTeam(name) {
+    await this.refresh_token();
+    return await authClient().post("/team", { name });
+  },
+
+  async getTeam(id) {
+    await this.refresh_token();
+    return await authClient().get(`/team/${id}`);
+  },
+
+  async deleteTeam(id) {
+    await this.refresh_token();
+    return await authClient().delete(`/team/${id}`);
+  },
+
+  async inviteTeamMember(id, email) {
+    await this.refresh_token();
+    return await authClient().post(`/team/${id}/invitations`, { email });
+  },
+
+  async removeTeamMember(id, user_id) {
+    await this.refresh_token();
+    return await authClient().delete(`/team/${id}/members/${user_id}`);
+  },
+
+  async listTeamInvitations() {
+    await this.refresh_token();
+    return await authClient().get("/team/invitations");
+  },
+
+  async acceptTeamInvitation(id) {
+    await this.refresh_token();
+    return await authClient().put(`/team/invitations/${id}/accept`);
+  },
+
+  async deleteTeamInvitation(id) {
+    await this.refresh_token();
+    return await authClient().delete(`/team/invitations/${id}`);
+  },
+
   async changePassword(email, password, confirm_password) {
     await this.refresh_token();
     return await authClient().put("/user/change_password", {
@@ -54,10 +201,12 @@ export default {
     });
   },
 
-  async newVoucher(vms, public_ips, reason) {
+  async newVoucher(cru, mru, sru, public_ips, reason) {
     await this.refresh_token();
     return await authClient().post("/user/apply_voucher", {
-      vms,
+      cru,
+      mru,
+      sru,
       public_ips,
       reason,
     });
@@ -79,9 +228,14 @@ export default {
     return await authClient().get(`/vm/validate/${name}`);
   },
 
-  async deployVm(name, resources, checked) {
+  async deployVm(name, resources, checked, team_id = 0) {
     await this.refresh_token();
-    return await authClient().post("/vm", { name, resources, public: checked });
+    return await authClient().post("/vm", {
+      name,
+      resources,
+      public: checked,
+      team_id,
+    });
   },
 
   async deleteVm(id) {
@@ -105,13 +259,14 @@ export default {
     return await authClient().get(`/k8s/validate/${name}`);
   },
 
-  async deployK8s(master_name, resources, workers, checked) {
+  async deployK8s(master_name, resources, workers, checked, team_id = 0) {
     await this.refresh_token();
     return await authClient().post("/k8s", {
       master_name,
       resources,
       workers,
       public: checked,
+      team_id,
     });
   },
 
@@ -153,9 +308,15 @@ export default {
     return await authClient().put("/voucher");
   },
 
-  async generateVoucher(length, vms, public_ips) {
+  async generateVoucher(length, cru, mru, sru, public_ips) {
     await this.refresh_token();
-    return await authClient().post("/voucher", { length, vms, public_ips });
+    return await authClient().post("/voucher", {
+      length,
+      cru,
+      mru,
+      sru,
+      public_ips,
+    });
   },
 
   // balance
@@ -181,10 +342,47 @@ export default {
     return await authClient().put("/set_admin", { email, admin });
   },
 
+  // roles
+  async listRoles() {
+    await this.refresh_token();
+    return await authClient().get("/role");
+  },
+
+  async listStaff() {
+    await this.refresh_token();
+    return await authClient().get("/role/users");
+  },
+
+  async assignRole(email, role) {
+    await this.refresh_token();
+    return await authClient().put("/role/assign", { email, role });
+  },
+
+  async unassignRole(email, role) {
+    await this.refresh_token();
+    return await authClient().put("/role/unassign", { email, role });
+  },
+
+  // audit log
+  async listAuditEvents(filter) {
+    await this.refresh_token();
+    return await authClient().get("/audit", { params: filter });
+  },
+
+  async exportAuditEvents(filter) {
+    await this.refresh_token();
+    return await authClient().get("/audit", {
+      params: { ...filter, format: "csv" },
+      responseType: "blob",
+    });
+  },
+
   // notifications
   async getNotifications() {
     await this.refresh_token();
-    return await authClient().get("/notification");
+    return await authClient().get("/notification", {
+      params: { unread: true, limit: 100 },
+    });
   },
 
   async seenNotification(id) {
@@ -225,9 +423,8 @@ export default {
   async handleNextLaunch(){
     await this.getUser()
       .then((response) => {
-        const { user } = response.data.data;
-        const isAdmin = user.admin;
-        if (isAdmin) {
+        const { roles } = response.data.data;
+        if (roles && roles.length) {
           localStorage.setItem("nextlaunch", "true");
         }
       })
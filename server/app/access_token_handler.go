@@ -0,0 +1,164 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codescalers/cloud4students/internal"
+	"github.com/codescalers/cloud4students/middlewares"
+	"github.com/codescalers/cloud4students/models"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultAccessTokenDays = 30
+	maxAccessTokenDays     = 365
+	maxAccessTokenNameLen  = 50
+)
+
+// AccessTokenInput struct for data needed to create a personal access token
+type AccessTokenInput struct {
+	Name   string             `json:"name" binding:"required"`
+	Scopes models.TokenScopes `json:"scopes" binding:"required"`
+	// ExpiresInDays is the lifetime of the token, default is 30 days
+	ExpiresInDays int `json:"expires_in_days"`
+}
+
+// validate checks the input of a token of the user, only users with roles can use the admin scope
+func (input *AccessTokenInput) validate(roles []models.Role) error {
+	input.Name = strings.TrimSpace(input.Name)
+	if input.Name == "" {
+		return errors.New("token name is required")
+	}
+	if len(input.Name) > maxAccessTokenNameLen {
+		return fmt.Errorf("token name should be at most %d characters", maxAccessTokenNameLen)
+	}
+
+	if len(input.Scopes) == 0 {
+		return errors.New("token scopes are required")
+	}
+	seen := map[models.TokenScope]bool{}
+	for _, scope := range input.Scopes {
+		switch scope {
+		case models.ReadScope, models.DeployScope:
+		case models.AdminScope:
+			if len(roles) == 0 {
+				return errors.New("only admins can create tokens with admin scope")
+			}
+		default:
+			return fmt.Errorf("invalid token scope '%s', scopes are read, deploy and admin", scope)
+		}
+		if seen[scope] {
+			return fmt.Errorf("token scope '%s' is repeated", scope)
+		}
+		seen[scope] = true
+	}
+
+	if input.ExpiresInDays == 0 {
+		input.ExpiresInDays = defaultAccessTokenDays
+	}
+	if input.ExpiresInDays < 0 || input.ExpiresInDays > maxAccessTokenDays {
+		return fmt.Errorf("token expiry should be between 1 and %d days", maxAccessTokenDays)
+	}
+	return nil
+}
+
+// CreateAccessTokenHandler creates a personal access token of the user, the token is only shown once
+func (a *App) CreateAccessTokenHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+
+	var input AccessTokenInput
+	err := json.NewDecoder(req.Body).Decode(&input)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, BadRequest(errors.New("failed to read token data"))
+	}
+
+	roles, err := a.db.ListUserRoles(userID)
+	if err == gorm.ErrRecordNotFound {
+		return nil, NotFound(errors.New("user is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	if err := input.validate(roles); err != nil {
+		return nil, BadRequest(err)
+	}
+
+	token, prefix, err := internal.GenerateAccessToken()
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	accessToken := models.AccessToken{
+		UserID:    userID,
+		Name:      input.Name,
+		Prefix:    prefix,
+		TokenHash: internal.HashOpaqueToken(token),
+		Scopes:    input.Scopes,
+		ExpiresAt: time.Now().Add(time.Duration(input.ExpiresInDays) * 24 * time.Hour),
+	}
+	if err := a.db.CreateAccessToken(&accessToken); err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Access token is created, copy it now as it won't be shown again",
+		Data: map[string]interface{}{
+			"token":        token,
+			"access_token": accessToken,
+		},
+	}, Created()
+}
+
+// ListAccessTokensHandler lists the personal access tokens of the user
+func (a *App) ListAccessTokensHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+
+	tokens, err := a.db.ListAccessTokens(userID)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Access tokens are found",
+		Data:    tokens,
+	}, Ok()
+}
+
+// RevokeAccessTokenHandler revokes a personal access token of the user
+func (a *App) RevokeAccessTokenHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+
+	id, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, BadRequest(errors.New("failed to read token id"))
+	}
+
+	err = a.db.DeleteAccessToken(id, userID)
+	if err == gorm.ErrRecordNotFound {
+		return nil, NotFound(errors.New("access token is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Access token is revoked successfully",
+	}, Ok()
+}
@@ -0,0 +1,129 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/codescalers/cloud4students/internal"
+	"github.com/codescalers/cloud4students/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccessTokenHandlers(t *testing.T) {
+	app := SetUp(t)
+
+	user.Verified = true
+	err := app.db.CreateUser(user)
+	assert.NoError(t, err)
+
+	token, err := newAccessToken(app, user.ID.String(), user.Email)
+	assert.NoError(t, err)
+
+	request := func(handler Handler, body string, vars map[string]string) authHandlerConfig {
+		return authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				body:        bytes.NewBuffer([]byte(body)),
+				handlerFunc: handler,
+				api:         fmt.Sprintf("/%s/user/tokens", app.config.Version),
+			},
+			token:  token,
+			config: app.config,
+			db:     app.db,
+			vars:   vars,
+		}
+	}
+
+	var accessToken string
+	var tokenID int
+
+	t.Run("create access token: success", func(t *testing.T) {
+		response := authorizedHandler(request(app.CreateAccessTokenHandler, `{"name":"ci","scopes":["read","deploy"]}`, nil))
+		assert.Equal(t, response.Code, http.StatusCreated)
+
+		var data struct {
+			Token       string             `json:"token"`
+			AccessToken models.AccessToken `json:"access_token"`
+		}
+		responseData(t, response, &data)
+		assert.Contains(t, data.Token, internal.AccessTokenPrefix)
+		assert.Equal(t, models.TokenScopes{models.ReadScope, models.DeployScope}, data.AccessToken.Scopes)
+		assert.WithinDuration(t, time.Now().Add(defaultAccessTokenDays*24*time.Hour), data.AccessToken.ExpiresAt, time.Minute)
+
+		accessToken = data.Token
+		tokenID = data.AccessToken.ID
+	})
+
+	t.Run("create access token: invalid input", func(t *testing.T) {
+		for body, want := range map[string]string{
+			`{"name":" ","scopes":["read"]}`:                        "token name is required",
+			`{"name":"ci","scopes":[]}`:                             "token scopes are required",
+			`{"name":"ci","scopes":["write"]}`:                      "invalid token scope 'write', scopes are read, deploy and admin",
+			`{"name":"ci","scopes":["read","read"]}`:                "token scope 'read' is repeated",
+			`{"name":"ci","scopes":["admin"]}`:                      "only admins can create tokens with admin scope",
+			`{"name":"ci","scopes":["read"],"expires_in_days":400}`: "token expiry should be between 1 and 365 days",
+		} {
+			response := authorizedHandler(request(app.CreateAccessTokenHandler, body, nil))
+			assert.Equal(t, fmt.Sprintf(`{"err":"%s"}`, want)+"\n", response.Body.String(), body)
+			assert.Equal(t, response.Code, http.StatusBadRequest, body)
+		}
+	})
+
+	t.Run("access token authorizes requests", func(t *testing.T) {
+		req := request(app.GetUserHandler, "", nil)
+		req.token = accessToken
+
+		response := authorizedHandler(req)
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		response = authorizedHandler(request(app.ListAccessTokensHandler, "", nil))
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		var tokens []models.AccessToken
+		responseData(t, response, &tokens)
+		assert.Len(t, tokens, 1)
+		assert.NotNil(t, tokens[0].LastUsedAt)
+		assert.Equal(t, accessToken[:len(tokens[0].Prefix)], tokens[0].Prefix)
+	})
+
+	t.Run("expired access token", func(t *testing.T) {
+		expired := "c4s_expired"
+		err := app.db.CreateAccessToken(&models.AccessToken{
+			UserID:    user.ID.String(),
+			Name:      "expired",
+			TokenHash: internal.HashOpaqueToken(expired),
+			Scopes:    models.TokenScopes{models.ReadScope},
+			ExpiresAt: time.Now().Add(-time.Minute),
+		})
+		assert.NoError(t, err)
+
+		req := request(app.GetUserHandler, "", nil)
+		req.token = expired
+
+		response := authorizedHandler(req)
+		assert.Equal(t, response.Code, http.StatusUnauthorized)
+	})
+
+	t.Run("revoke access token: not found", func(t *testing.T) {
+		response := authorizedHandler(request(app.RevokeAccessTokenHandler, "", map[string]string{"id": "1000"}))
+		want := `{"err":"access token is not found"}` + "\n"
+		assert.Equal(t, response.Body.String(), want)
+		assert.Equal(t, response.Code, http.StatusNotFound)
+	})
+
+	t.Run("revoke access token: success", func(t *testing.T) {
+		response := authorizedHandler(request(app.RevokeAccessTokenHandler, "", map[string]string{"id": fmt.Sprint(tokenID)}))
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		req := request(app.GetUserHandler, "", nil)
+		req.token = accessToken
+
+		response = authorizedHandler(req)
+		want := `{"err":"user is not authorized"}` + "\n"
+		assert.Equal(t, response.Body.String(), want)
+		assert.Equal(t, response.Code, http.StatusUnauthorized)
+	})
+}
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/codescalers/cloud4students/internal"
+	"github.com/codescalers/cloud4students/middlewares"
 	"github.com/codescalers/cloud4students/models"
 	"github.com/rs/zerolog/log"
 	"gopkg.in/validator.v2"
@@ -110,12 +111,13 @@ func (a *App) ResetUsersQuota(req *http.Request) (interface{}, Response) {
 	}
 
 	for _, user := range users {
-		err = a.db.UpdateUserQuota(user.UserID, 0, 0)
+		err = a.db.UpdateUserQuota(user.UserID, models.Resources{})
 		if err != nil {
 			log.Error().Err(err).Send()
 			return nil, InternalServerError(errors.New(internalServerErrorMsg))
 		}
 	}
+	middlewares.AuditChange(req, "", nil, map[string]int{"users": len(users)})
 
 	return ResponseMsg{
 		Message: "Quota is reset successfully",
@@ -136,6 +138,14 @@ func (a *App) DeleteAllDeployments(req *http.Request) (interface{}, Response) {
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
 	}
 
+	// deployments deleted before a failure are recorded too
+	deletedVMs, deletedClusters := []int{}, []int{}
+	defer func() {
+		middlewares.AuditChange(req, "", nil, map[string]interface{}{
+			"vms": deletedVMs, "vms_count": len(deletedVMs), "k8s": deletedClusters, "k8s_count": len(deletedClusters),
+		})
+	}()
+
 	for _, user := range users {
 		// vms
 		vms, err := a.db.GetAllVms(user.UserID)
@@ -161,6 +171,9 @@ func (a *App) DeleteAllDeployments(req *http.Request) (interface{}, Response) {
 			log.Error().Err(err).Send()
 			return nil, InternalServerError(errors.New(internalServerErrorMsg))
 		}
+		for _, vm := range vms {
+			deletedVMs = append(deletedVMs, vm.ID)
+		}
 
 		// k8s clusters
 		clusters, err := a.db.GetAllK8s(user.UserID)
@@ -186,6 +199,9 @@ func (a *App) DeleteAllDeployments(req *http.Request) (interface{}, Response) {
 			log.Error().Err(err).Send()
 			return nil, InternalServerError(errors.New(internalServerErrorMsg))
 		}
+		for _, cluster := range clusters {
+			deletedClusters = append(deletedClusters, cluster.ID)
+		}
 	}
 
 	return ResponseMsg{
@@ -253,6 +269,16 @@ func (a *App) UpdateMaintenanceHandler(req *http.Request) (interface{}, Response
 		return nil, BadRequest(errors.New("failed to read maintenance update data"))
 	}
 
+	before, err := a.db.GetMaintenance()
+	if err == gorm.ErrRecordNotFound {
+		return nil, NotFound(errors.New("maintenance is not found"))
+	}
+
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
 	err = a.db.UpdateMaintenance(input.ON)
 	if err == gorm.ErrRecordNotFound {
 		return nil, NotFound(errors.New("maintenance is not found"))
@@ -263,6 +289,8 @@ func (a *App) UpdateMaintenanceHandler(req *http.Request) (interface{}, Response
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
 	}
 
+	middlewares.AuditChange(req, "", map[string]bool{"active": before.Active}, map[string]bool{"active": input.ON})
+
 	return ResponseMsg{
 		Message: "Maintenance is updated successfully",
 		Data:    nil,
@@ -322,11 +350,23 @@ func (a *App) SetAdmin(req *http.Request) (interface{}, Response) {
 	if err == gorm.ErrRecordNotFound {
 		return nil, NotFound(errors.New("user is not found"))
 	}
+	if err == models.ErrLastSuperAdmin {
+		return nil, BadRequest(errors.New("the last superadmin can't be removed"))
+	}
 
 	if err != nil {
 		log.Error().Err(err).Send()
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
 	}
+	middlewares.AuditChange(req, "user="+user.Email, map[string]bool{"admin": user.Admin}, map[string]bool{"admin": input.Admin})
+
+	// demoted admins have to sign in again
+	if !input.Admin {
+		if err := a.db.RevokeUserSessions(user.ID.String(), ""); err != nil {
+			log.Error().Err(err).Send()
+			return nil, InternalServerError(errors.New(internalServerErrorMsg))
+		}
+	}
 
 	return ResponseMsg{
 		Message: "User is updated successfully",
@@ -338,8 +378,13 @@ func (a *App) notifyAdmins() {
 	ticker := time.NewTicker(time.Hour * time.Duration(a.config.NotifyAdminsIntervalHours))
 
 	for range ticker.C {
-		// get admins
-		admins, err := a.db.ListAdmins()
+		// get the users reviewing vouchers and following the balance
+		reviewers, err := a.staffWithPermission(models.ReviewVouchersPermission)
+		if err != nil {
+			log.Error().Err(err).Send()
+		}
+
+		admins, err := a.staffWithPermission(models.ReadBalancePermission)
 		if err != nil {
 			log.Error().Err(err).Send()
 		}
@@ -351,13 +396,11 @@ func (a *App) notifyAdmins() {
 		}
 
 		if len(pending) > 0 {
-			subject, body := internal.NotifyAdminsMailContent(len(pending), a.config.Server.Host)
-
-			for _, admin := range admins {
-				err = internal.SendMail(a.config.MailSender.Email, a.config.MailSender.SendGridKey, admin.Email, subject, body)
-				if err != nil {
-					log.Error().Err(err).Send()
-				}
+			mail, err := internal.NotifyAdminsMailContent(a.config.Server.Host, internal.PendingVouchersMailData{Vouchers: len(pending)})
+			if err != nil {
+				log.Error().Err(err).Send()
+			} else {
+				a.enqueueAdminsMail(reviewers, mail)
 			}
 		}
 
@@ -368,18 +411,27 @@ func (a *App) notifyAdmins() {
 		}
 
 		if int(balance) < a.config.BalanceThreshold {
-			subject, body := internal.NotifyAdminsMailLowBalanceContent(balance, a.config.Server.Host)
-
-			for _, admin := range admins {
-				err = internal.SendMail(a.config.MailSender.Email, a.config.MailSender.SendGridKey, admin.Email, subject, body)
-				if err != nil {
-					log.Error().Err(err).Send()
-				}
+			mail, err := internal.NotifyAdminsMailLowBalanceContent(a.config.Server.Host, internal.LowBalanceMailData{Balance: balance})
+			if err != nil {
+				log.Error().Err(err).Send()
+			} else {
+				a.enqueueAdminsMail(admins, mail)
 			}
 		}
 	}
 }
 
+func (a *App) enqueueAdminsMail(admins []models.User, mail internal.Mail) {
+	var emails []models.Email
+	for _, admin := range admins {
+		emails = append(emails, internal.NewEmail(admin.Email, mail))
+	}
+
+	if err := a.outbox.EnqueueAll(emails); err != nil {
+		log.Error().Err(err).Send()
+	}
+}
+
 // CreateNewAnnouncement creates a new administrator announcement and sends it to all users as an email and notification
 func (a *App) CreateNewAnnouncement(req *http.Request) (interface{}, Response) {
 	var adminAnnouncement AdminAnnouncement
@@ -404,23 +456,43 @@ func (a *App) CreateNewAnnouncement(req *http.Request) (interface{}, Response) {
 		}, Ok()
 	}
 
+	var emails []models.Email
 	for _, user := range users {
-		subject, body := internal.AdminAnnouncementMailContent(adminAnnouncement.Subject, adminAnnouncement.Body, a.config.Server.Host, user.Name)
-
-		err = internal.SendMail(a.config.MailSender.Email, a.config.MailSender.SendGridKey, user.Email, subject, body)
+		mail, err := internal.AdminAnnouncementMailContent(a.config.Server.Host, internal.AnnouncementMailData{
+			Name:    user.Name,
+			Subject: adminAnnouncement.Subject,
+			Body:    adminAnnouncement.Body,
+		})
 		if err != nil {
 			log.Error().Err(err).Send()
 			return nil, InternalServerError(errors.New(internalServerErrorMsg))
 		}
+		emails = append(emails, internal.NewEmail(user.Email, mail))
+	}
 
-		notification := models.Notification{UserID: user.UserID, Msg: fmt.Sprintf("Announcement: %s", adminAnnouncement.Body)}
+	err = a.outbox.EnqueueAll(emails)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	for _, user := range users {
+		notification := models.Notification{
+			UserID:   user.UserID,
+			Msg:      fmt.Sprintf("Announcement: %s", adminAnnouncement.Body),
+			Type:     models.AnnouncementType,
+			Severity: models.SeverityInfo,
+		}
 		err = a.db.CreateNotification(&notification)
 		if err != nil {
 			log.Error().Err(err).Send()
 			return nil, InternalServerError(errors.New(internalServerErrorMsg))
 		}
+		a.publishNotification(notification)
 	}
 
+	middlewares.AuditChange(req, "", nil, map[string]interface{}{"subject": adminAnnouncement.Subject, "users": len(users)})
+
 	return ResponseMsg{
 		Message: "new announcement is sent successfully",
 	}, Created()
@@ -453,20 +525,36 @@ func (a *App) SendEmail(req *http.Request) (interface{}, Response) {
 		return nil, BadRequest(errors.New("failed to get user"))
 	}
 
-	subject, body := internal.AdminMailContent(emailUser.Subject, emailUser.Body, a.config.Server.Host, user.Name)
+	mail, err := internal.AdminMailContent(a.config.Server.Host, internal.AnnouncementMailData{
+		Name:    user.Name,
+		Subject: emailUser.Subject,
+		Body:    emailUser.Body,
+	})
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
 
-	err = internal.SendMail(a.config.MailSender.Email, a.config.MailSender.SendGridKey, user.Email, subject, body)
+	err = a.outbox.Enqueue(user.Email, mail)
 	if err != nil {
 		log.Error().Err(err).Send()
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
 	}
 
-	notification := models.Notification{UserID: user.ID.String(), Msg: fmt.Sprintf("Email: %s", emailUser.Body)}
+	notification := models.Notification{
+		UserID:   user.ID.String(),
+		Msg:      fmt.Sprintf("Email: %s", emailUser.Body),
+		Type:     models.EmailType,
+		Severity: models.SeverityInfo,
+	}
 	err = a.db.CreateNotification(&notification)
 	if err != nil {
 		log.Error().Err(err).Send()
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
 	}
+	a.publishNotification(notification)
+
+	middlewares.AuditChange(req, "user="+user.Email, nil, map[string]string{"subject": emailUser.Subject})
 
 	return ResponseMsg{
 		Message: "new email is sent successfully",
@@ -482,6 +570,16 @@ func (a *App) UpdateNextLaunchHandler(req *http.Request) (interface{}, Response)
 		return nil, BadRequest(errors.New("failed to read NextLaunch update data"))
 	}
 
+	before, err := a.db.GetNextLaunch()
+	if err == gorm.ErrRecordNotFound {
+		return nil, NotFound(errors.New("next launch is not found"))
+	}
+
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
 	err = a.db.UpdateNextLaunch(input.Launched)
 	if err == gorm.ErrRecordNotFound {
 		return nil, NotFound(errors.New("next launch is not found"))
@@ -492,6 +590,8 @@ func (a *App) UpdateNextLaunchHandler(req *http.Request) (interface{}, Response)
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
 	}
 
+	middlewares.AuditChange(req, "", map[string]bool{"launched": before.Launched}, map[string]bool{"launched": input.Launched})
+
 	return ResponseMsg{
 		Message: "Next Launch is updated successfully",
 		Data:    nil,
@@ -516,3 +616,24 @@ func (a *App) GetNextLaunchHandler(req *http.Request) (interface{}, Response) {
 		Data:    nextlaunch,
 	}, Ok()
 }
+
+// ListCompensationsHandler lists the deployments whose contracts were canceled because they couldn't be saved
+func (a *App) ListCompensationsHandler(req *http.Request) (interface{}, Response) {
+	compensations, err := a.db.ListCompensations()
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	if len(compensations) == 0 {
+		return ResponseMsg{
+			Message: "Compensations are not found",
+			Data:    compensations,
+		}, Ok()
+	}
+
+	return ResponseMsg{
+		Message: "Compensations are found",
+		Data:    compensations,
+	}, Ok()
+}
@@ -7,7 +7,6 @@ import (
 	"net/http"
 	"testing"
 
-	"github.com/codescalers/cloud4students/internal"
 	"github.com/codescalers/cloud4students/models"
 	"github.com/stretchr/testify/assert"
 )
@@ -27,7 +26,7 @@ func TestGetAllUsersHandler(t *testing.T) {
 	user, err := app.db.GetUserByEmail(admin.Email)
 	assert.NoError(t, err)
 
-	token, err := internal.CreateJWT(user.ID.String(), user.Email, app.config.Token.Secret, app.config.Token.Timeout)
+	token, err := newAccessToken(app, user.ID.String(), user.Email)
 	assert.NoError(t, err)
 
 	t.Run("Get all users: success", func(t *testing.T) {
@@ -59,7 +58,7 @@ func TestGetAllUsersHandler(t *testing.T) {
 		user, err := app.db.GetUserByEmail(u.Email)
 		assert.NoError(t, err)
 
-		token, err := internal.CreateJWT(user.ID.String(), user.Email, app.config.Token.Secret, app.config.Token.Timeout)
+		token, err := newAccessToken(app, user.ID.String(), user.Email)
 		assert.NoError(t, err)
 
 		req := authHandlerConfig{
@@ -220,7 +219,7 @@ func TestCreateNewAnnouncement(t *testing.T) {
 	user, err := app.db.GetUserByEmail(admin.Email)
 	assert.NoError(t, err)
 
-	token, err := internal.CreateJWT(user.ID.String(), user.Email, app.config.Token.Secret, app.config.Token.Timeout)
+	token, err := newAccessToken(app, user.ID.String(), user.Email)
 	assert.NoError(t, err)
 	t.Run("announcement created successfully", func(t *testing.T) {
 		adminAnnouncement := []byte(`{
@@ -240,5 +239,10 @@ func TestCreateNewAnnouncement(t *testing.T) {
 		}
 		response := adminHandler(req)
 		assert.Equal(t, http.StatusCreated, response.Code)
+
+		emails, err := app.db.ListEmails(models.EmailsFilter{States: []models.EmailState{models.EmailQueued}})
+		assert.NoError(t, err)
+		assert.Len(t, emails, 1)
+		assert.Equal(t, admin.Email, emails[0].Receiver)
 	})
 }
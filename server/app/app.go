@@ -10,6 +10,7 @@ import (
 	"github.com/codescalers/cloud4students/middlewares"
 	"github.com/codescalers/cloud4students/models"
 	"github.com/codescalers/cloud4students/streams"
+	"github.com/go-redis/redis"
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -20,9 +21,17 @@ import (
 type App struct {
 	config   internal.Configuration
 	server   server
-	db       models.DB
-	redis    streams.RedisClient
+	db       models.Store
+	queue    streams.Queue
 	deployer c4sDeployer.Deployer
+	// outbox delivers the emails queued by handlers
+	outbox *internal.Outbox
+	// notifications pushes new notifications to the users streaming them
+	notifications *streams.NotificationHub
+	// rateLimits holds the buckets of rate limited routes
+	rateLimits middlewares.RateLimitStore
+	// oidc holds the clients of the single sign on providers by their names
+	oidc map[string]*internal.OIDCClient
 }
 
 // NewApp creates new server app all configurations
@@ -33,7 +42,7 @@ func NewApp(ctx context.Context, configFile string) (app *App, err error) {
 	}
 
 	db := models.NewDB()
-	err = db.Connect(config.Database.File)
+	err = db.ConnectDriver(config.Database.Driver, config.Database.DataSource())
 	if err != nil {
 		return
 	}
@@ -42,7 +51,7 @@ func NewApp(ctx context.Context, configFile string) (app *App, err error) {
 		return
 	}
 
-	redis, err := streams.NewRedisClient(config)
+	queue, err := streams.NewQueue(config)
 	if err != nil {
 		return
 	}
@@ -55,7 +64,17 @@ func NewApp(ctx context.Context, configFile string) (app *App, err error) {
 		return
 	}
 
-	newDeployer, err := c4sDeployer.NewDeployer(db, redis, tfPluginClient)
+	newDeployer, err := c4sDeployer.NewDeployer(db, queue, c4sDeployer.NewTFGridBackend(tfPluginClient), config.DeployRetry)
+	if err != nil {
+		return
+	}
+
+	mailer, err := internal.NewMailer(config.MailSender)
+	if err != nil {
+		return
+	}
+
+	rateLimits, err := newRateLimitStore(config)
 	if err != nil {
 		return
 	}
@@ -63,14 +82,34 @@ func NewApp(ctx context.Context, configFile string) (app *App, err error) {
 	server := newServer(config.Server.Host, config.Server.Port)
 
 	return &App{
-		config:   config,
-		server:   *server,
-		db:       db,
-		redis:    redis,
-		deployer: newDeployer,
+		config:        config,
+		server:        *server,
+		db:            db,
+		queue:         queue,
+		deployer:      newDeployer,
+		outbox:        internal.NewOutbox(db, mailer, config.MailSender.Outbox),
+		notifications: streams.NewNotificationHub(queue),
+		rateLimits:    rateLimits,
+		oidc:          newOIDCClients(config.OIDC),
 	}, nil
 }
 
+// newRateLimitStore creates the rate limits store selected in the configuration
+func newRateLimitStore(config internal.Configuration) (middlewares.RateLimitStore, error) {
+	if config.RateLimits.Store != internal.RedisRateLimitStore {
+		return middlewares.NewMemoryRateLimitStore(), nil
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:     config.Server.RedisHost + ":" + config.Server.RedisPort,
+		Password: config.Server.RedisPass,
+	})
+	if err := client.Ping().Err(); err != nil {
+		return nil, err
+	}
+	return middlewares.NewRedisRateLimitStore(client), nil
+}
+
 // Start starts the app
 func (a *App) Start(ctx context.Context) (err error) {
 	a.registerHandlers()
@@ -86,10 +125,11 @@ func (a *App) startBackgroundWorkers(ctx context.Context) {
 	// periodic deployments
 	go a.deployer.PeriodicRequests(ctx, substrateBlockDiffInSeconds)
 	go a.deployer.PeriodicDeploy(ctx, substrateBlockDiffInSeconds)
+	go a.deployer.ListenDeploymentResults(ctx)
+
+	go a.notifications.Run(ctx)
 
-	// check pending deployments
-	a.deployer.ConsumeVMRequest(ctx, true)
-	a.deployer.ConsumeK8sRequest(ctx, true)
+	go a.outbox.Run(ctx)
 }
 
 func (a *App) registerHandlers() {
@@ -106,6 +146,8 @@ func (a *App) registerHandlers() {
 	notificationRouter := authRouter.PathPrefix("/notification").Subrouter()
 	vmRouter := authRouter.PathPrefix("/vm").Subrouter()
 	k8sRouter := authRouter.PathPrefix("/k8s").Subrouter()
+	jobRouter := authRouter.PathPrefix("/jobs").Subrouter()
+	teamRouter := authRouter.PathPrefix("/team").Subrouter()
 
 	// sub routes with no authorization
 	unAuthUserRouter := versionRouter.PathPrefix("/user").Subrouter()
@@ -118,15 +160,41 @@ func (a *App) registerHandlers() {
 	balanceRouter := adminRouter.PathPrefix("/balance").Subrouter()
 	deploymentsRouter := adminRouter.PathPrefix("/deployments").Subrouter()
 	nextLaunchRouter := adminRouter.PathPrefix("/nextlaunch").Subrouter()
+	deadLetterRouter := adminRouter.PathPrefix("/dead_letter").Subrouter()
+	emailsRouter := adminRouter.PathPrefix("/emails").Subrouter()
+	roleRouter := adminRouter.PathPrefix("/role").Subrouter()
+	auditRouter := adminRouter.PathPrefix("/audit").Subrouter()
+
+	// rate limits
+	authLimit := middlewares.RateLimit("auth", a.config.RateLimits.Auth, a.config.RateLimits.TrustProxyHeaders, a.rateLimits)
+	deployLimit := middlewares.RateLimit("deploy", a.config.RateLimits.Deploy, a.config.RateLimits.TrustProxyHeaders, a.rateLimits)
+
+	// credentials can't be managed with personal access tokens
+	sessionOnly := middlewares.TokenScopes("", "")
 
 	unAuthUserRouter.HandleFunc("/signup", WrapFunc(a.SignUpHandler)).Methods("POST", "OPTIONS")
 	unAuthUserRouter.HandleFunc("/signup/verify_email", WrapFunc(a.VerifySignUpCodeHandler)).Methods("POST", "OPTIONS")
 	unAuthUserRouter.HandleFunc("/signin", WrapFunc(a.SignInHandler)).Methods("POST", "OPTIONS")
+	unAuthUserRouter.HandleFunc("/signin/2fa", WrapFunc(a.SignInTwoFactorHandler)).Methods("POST", "OPTIONS")
+	unAuthUserRouter.HandleFunc("/oidc/providers", WrapFunc(a.ListOIDCProvidersHandler)).Methods("GET", "OPTIONS")
+	unAuthUserRouter.HandleFunc("/oidc/{provider}/login", WrapFunc(a.OIDCLoginHandler)).Methods("GET", "OPTIONS")
+	unAuthUserRouter.HandleFunc("/oidc/{provider}/callback", WrapFunc(a.OIDCCallbackHandler)).Methods("POST", "OPTIONS")
 	unAuthUserRouter.HandleFunc("/refresh_token", WrapFunc(a.RefreshJWTHandler)).Methods("POST", "OPTIONS")
 	unAuthUserRouter.HandleFunc("/forgot_password", WrapFunc(a.ForgotPasswordHandler)).Methods("POST", "OPTIONS")
 	unAuthUserRouter.HandleFunc("/forget_password/verify_email", WrapFunc(a.VerifyForgetPasswordCodeHandler)).Methods("POST", "OPTIONS")
 
-	userRouter.HandleFunc("/change_password", WrapFunc(a.ChangePasswordHandler)).Methods("PUT", "OPTIONS")
+	userRouter.Handle("/change_password", sessionOnly(WrapFunc(a.ChangePasswordHandler))).Methods("PUT", "OPTIONS")
+	userRouter.Handle("/logout", sessionOnly(WrapFunc(a.LogoutHandler))).Methods("POST", "OPTIONS")
+	userRouter.Handle("/2fa", sessionOnly(WrapFunc(a.GetTwoFactorHandler))).Methods("GET", "OPTIONS")
+	userRouter.Handle("/2fa", sessionOnly(WrapFunc(a.EnrollTwoFactorHandler))).Methods("POST", "OPTIONS")
+	userRouter.Handle("/2fa", sessionOnly(WrapFunc(a.DisableTwoFactorHandler))).Methods("DELETE", "OPTIONS")
+	userRouter.Handle("/2fa/confirm", sessionOnly(WrapFunc(a.ConfirmTwoFactorHandler))).Methods("POST", "OPTIONS")
+	userRouter.Handle("/2fa/recovery_codes", sessionOnly(WrapFunc(a.RegenerateRecoveryCodesHandler))).Methods("POST", "OPTIONS")
+	userRouter.Handle("/sessions", sessionOnly(WrapFunc(a.ListSessionsHandler))).Methods("GET", "OPTIONS")
+	userRouter.Handle("/sessions/{id}", sessionOnly(WrapFunc(a.RevokeSessionHandler))).Methods("DELETE", "OPTIONS")
+	userRouter.Handle("/tokens", sessionOnly(WrapFunc(a.CreateAccessTokenHandler))).Methods("POST", "OPTIONS")
+	userRouter.Handle("/tokens", sessionOnly(WrapFunc(a.ListAccessTokensHandler))).Methods("GET", "OPTIONS")
+	userRouter.Handle("/tokens/{id}", sessionOnly(WrapFunc(a.RevokeAccessTokenHandler))).Methods("DELETE", "OPTIONS")
 	userRouter.HandleFunc("", WrapFunc(a.UpdateUserHandler)).Methods("PUT", "OPTIONS")
 	userRouter.HandleFunc("", WrapFunc(a.GetUserHandler)).Methods("GET", "OPTIONS")
 	userRouter.HandleFunc("/apply_voucher", WrapFunc(a.ApplyForVoucherHandler)).Methods("POST", "OPTIONS")
@@ -135,52 +203,108 @@ func (a *App) registerHandlers() {
 	quotaRouter.HandleFunc("", WrapFunc(a.GetQuotaHandler)).Methods("GET", "OPTIONS")
 
 	notificationRouter.HandleFunc("", WrapFunc(a.ListNotificationsHandler)).Methods("GET", "OPTIONS")
+	notificationRouter.HandleFunc("/stream", a.StreamNotificationsHandler).Methods("GET", "OPTIONS")
+	notificationRouter.HandleFunc("/read_all", WrapFunc(a.ReadAllNotificationsHandler)).Methods("PUT", "OPTIONS")
 	notificationRouter.HandleFunc("/{id}", WrapFunc(a.UpdateNotificationsHandler)).Methods("PUT", "OPTIONS")
+	notificationRouter.HandleFunc("/{id}", WrapFunc(a.DeleteNotificationHandler)).Methods("DELETE", "OPTIONS")
 
-	vmRouter.HandleFunc("", WrapFunc(a.DeployVMHandler)).Methods("POST", "OPTIONS")
+	vmRouter.Handle("", deployLimit(WrapFunc(a.DeployVMHandler))).Methods("POST", "OPTIONS")
 	vmRouter.HandleFunc("/validate/{name}", WrapFunc(a.ValidateVMNameHandler)).Methods("Get", "OPTIONS")
 	vmRouter.HandleFunc("/{id}", WrapFunc(a.GetVMHandler)).Methods("GET", "OPTIONS")
 	vmRouter.HandleFunc("/{id}", WrapFunc(a.DeleteVMHandler)).Methods("DELETE", "OPTIONS")
 	vmRouter.HandleFunc("", WrapFunc(a.ListVMsHandler)).Methods("GET", "OPTIONS")
 	vmRouter.HandleFunc("", WrapFunc(a.DeleteAllVMsHandler)).Methods("DELETE", "OPTIONS")
 
-	k8sRouter.HandleFunc("", WrapFunc(a.K8sDeployHandler)).Methods("POST", "OPTIONS")
+	k8sRouter.Handle("", deployLimit(WrapFunc(a.K8sDeployHandler))).Methods("POST", "OPTIONS")
 	k8sRouter.HandleFunc("/validate/{name}", WrapFunc(a.ValidateK8sNameHandler)).Methods("Get", "OPTIONS")
 	k8sRouter.HandleFunc("/{id}", WrapFunc(a.K8sGetHandler)).Methods("GET", "OPTIONS")
 	k8sRouter.HandleFunc("/{id}", WrapFunc(a.K8sDeleteHandler)).Methods("DELETE", "OPTIONS")
 	k8sRouter.HandleFunc("", WrapFunc(a.K8sGetAllHandler)).Methods("GET", "OPTIONS")
 	k8sRouter.HandleFunc("", WrapFunc(a.K8sDeleteAllHandler)).Methods("DELETE", "OPTIONS")
 
+	jobRouter.HandleFunc("", WrapFunc(a.ListJobsHandler)).Methods("GET", "OPTIONS")
+	jobRouter.HandleFunc("/{id}", WrapFunc(a.GetJobHandler)).Methods("GET", "OPTIONS")
+	jobRouter.HandleFunc("/{id}/cancel", WrapFunc(a.CancelJobHandler)).Methods("PUT", "OPTIONS")
+
+	teamRouter.HandleFunc("", WrapFunc(a.CreateTeamHandler)).Methods("POST", "OPTIONS")
+	teamRouter.HandleFunc("", WrapFunc(a.ListTeamsHandler)).Methods("GET", "OPTIONS")
+	teamRouter.HandleFunc("/invitations", WrapFunc(a.ListTeamInvitationsHandler)).Methods("GET", "OPTIONS")
+	teamRouter.HandleFunc("/invitations/{id}/accept", WrapFunc(a.AcceptTeamInvitationHandler)).Methods("PUT", "OPTIONS")
+	teamRouter.HandleFunc("/invitations/{id}", WrapFunc(a.DeleteTeamInvitationHandler)).Methods("DELETE", "OPTIONS")
+	teamRouter.HandleFunc("/{id}", WrapFunc(a.GetTeamHandler)).Methods("GET", "OPTIONS")
+	teamRouter.HandleFunc("/{id}", WrapFunc(a.DeleteTeamHandler)).Methods("DELETE", "OPTIONS")
+	teamRouter.HandleFunc("/{id}/invitations", WrapFunc(a.InviteTeamMemberHandler)).Methods("POST", "OPTIONS")
+	teamRouter.HandleFunc("/{id}/members/{user_id}", WrapFunc(a.RemoveTeamMemberHandler)).Methods("DELETE", "OPTIONS")
+	teamRouter.HandleFunc("/{id}/deployments", WrapFunc(a.ListTeamDeploymentsHandler)).Methods("GET", "OPTIONS")
+
 	unAuthMaintenanceRouter.HandleFunc("", WrapFunc(a.GetMaintenanceHandler)).Methods("GET", "OPTIONS")
 	unauthNextLaunchRouter.HandleFunc("", WrapFunc(a.GetNextLaunchHandler)).Methods("GET", "OPTIONS")
 
 	// ADMIN ACCESS
-	adminRouter.HandleFunc("/user/all", WrapFunc(a.GetAllUsersHandler)).Methods("GET", "OPTIONS")
-	adminRouter.HandleFunc("/quota/reset", WrapFunc(a.ResetUsersQuota)).Methods("PUT", "OPTIONS")
-	adminRouter.HandleFunc("/deployment/count", WrapFunc(a.GetDlsCountHandler)).Methods("GET", "OPTIONS")
-	adminRouter.HandleFunc("/announcement", WrapFunc(a.CreateNewAnnouncement)).Methods("POST", "OPTIONS")
-	adminRouter.HandleFunc("/email", WrapFunc(a.SendEmail)).Methods("POST", "OPTIONS")
-	adminRouter.HandleFunc("/set_admin", WrapFunc(a.SetAdmin)).Methods("PUT", "OPTIONS")
-	balanceRouter.HandleFunc("", WrapFunc(a.GetBalanceHandler)).Methods("GET", "OPTIONS")
-	maintenanceRouter.HandleFunc("", WrapFunc(a.UpdateMaintenanceHandler)).Methods("PUT", "OPTIONS")
-	deploymentsRouter.HandleFunc("", WrapFunc(a.DeleteAllDeployments)).Methods("DELETE", "OPTIONS")
-	deploymentsRouter.HandleFunc("", WrapFunc(a.ListDeployments)).Methods("GET", "OPTIONS")
-	nextLaunchRouter.HandleFunc("", WrapFunc(a.UpdateNextLaunchHandler)).Methods("PUT", "OPTIONS")
-
-	voucherRouter.HandleFunc("", WrapFunc(a.GenerateVoucherHandler)).Methods("POST", "OPTIONS")
-	voucherRouter.HandleFunc("", WrapFunc(a.ListVouchersHandler)).Methods("GET", "OPTIONS")
-	voucherRouter.HandleFunc("/{id}", WrapFunc(a.UpdateVoucherHandler)).Methods("PUT", "OPTIONS")
-	voucherRouter.HandleFunc("", WrapFunc(a.ApproveAllVouchersHandler)).Methods("PUT", "OPTIONS")
+	// every admin route needs a permission of the roles of the user
+	can := func(permission models.Permission, handler Handler) http.Handler {
+		return middlewares.RequirePermission(permission)(WrapFunc(handler))
+	}
+	// routes changing data are recorded in the audit log
+	audited := func(action models.AuditAction, permission models.Permission, handler Handler) http.Handler {
+		return middlewares.Audit(a.db, action, a.config.RateLimits.TrustProxyHeaders)(can(permission, handler))
+	}
+
+	adminRouter.Handle("/user/all", can(models.ReadUsersPermission, a.GetAllUsersHandler)).Methods("GET", "OPTIONS")
+	adminRouter.Handle("/quota/reset", audited(models.ResetQuotaAction, models.ResetQuotaPermission, a.ResetUsersQuota)).Methods("PUT", "OPTIONS")
+	adminRouter.Handle("/deployment/count", can(models.ReadDeploymentsPermission, a.GetDlsCountHandler)).Methods("GET", "OPTIONS")
+	adminRouter.Handle("/announcement", audited(models.CreateAnnouncementAction, models.SendAnnouncementsPermission, a.CreateNewAnnouncement)).Methods("POST", "OPTIONS")
+	adminRouter.Handle("/email", audited(models.SendEmailAction, models.SendAnnouncementsPermission, a.SendEmail)).Methods("POST", "OPTIONS")
+	adminRouter.Handle("/set_admin", audited(models.SetAdminAction, models.ManageRolesPermission, a.SetAdmin)).Methods("PUT", "OPTIONS")
+	balanceRouter.Handle("", can(models.ReadBalancePermission, a.GetBalanceHandler)).Methods("GET", "OPTIONS")
+	maintenanceRouter.Handle("", audited(models.UpdateMaintenanceAction, models.ManageMaintenancePermission, a.UpdateMaintenanceHandler)).Methods("PUT", "OPTIONS")
+	deploymentsRouter.Handle("", audited(models.DeleteDeploymentsAction, models.DeleteDeploymentsPermission, a.DeleteAllDeployments)).Methods("DELETE", "OPTIONS")
+	deploymentsRouter.Handle("", can(models.ReadDeploymentsPermission, a.ListDeployments)).Methods("GET", "OPTIONS")
+	deploymentsRouter.Handle("/compensations", can(models.ReadDeploymentsPermission, a.ListCompensationsHandler)).Methods("GET", "OPTIONS")
+	nextLaunchRouter.Handle("", audited(models.UpdateNextLaunchAction, models.ManageMaintenancePermission, a.UpdateNextLaunchHandler)).Methods("PUT", "OPTIONS")
+
+	voucherRouter.Handle("", audited(models.GenerateVoucherAction, models.GenerateVouchersPermission, a.GenerateVoucherHandler)).Methods("POST", "OPTIONS")
+	voucherRouter.Handle("", can(models.ReadVouchersPermission, a.ListVouchersHandler)).Methods("GET", "OPTIONS")
+	voucherRouter.Handle("/{id}", audited(models.ReviewVoucherAction, models.ReviewVouchersPermission, a.UpdateVoucherHandler)).Methods("PUT", "OPTIONS")
+	voucherRouter.Handle("", audited(models.ApproveAllVouchersAction, models.ReviewVouchersPermission, a.ApproveAllVouchersHandler)).Methods("PUT", "OPTIONS")
+
+	deadLetterRouter.Handle("/{type}", can(models.ManageDeadLettersPermission, a.ListDeadLettersHandler)).Methods("GET", "OPTIONS")
+	deadLetterRouter.Handle("/{type}/{id}", can(models.ManageDeadLettersPermission, a.GetDeadLetterHandler)).Methods("GET", "OPTIONS")
+	deadLetterRouter.Handle("/{type}/{id}/requeue", audited(models.RequeueDeadLetterAction, models.ManageDeadLettersPermission, a.RequeueDeadLetterHandler)).Methods("PUT", "OPTIONS")
+	deadLetterRouter.Handle("/{type}/{id}", audited(models.DeleteDeadLetterAction, models.ManageDeadLettersPermission, a.DeleteDeadLetterHandler)).Methods("DELETE", "OPTIONS")
+	deadLetterRouter.Handle("/{type}", audited(models.PurgeDeadLettersAction, models.ManageDeadLettersPermission, a.PurgeDeadLettersHandler)).Methods("DELETE", "OPTIONS")
+
+	emailsRouter.Handle("", can(models.ManageEmailsPermission, a.ListEmailsHandler)).Methods("GET", "OPTIONS")
+	emailsRouter.Handle("/{id}/resend", audited(models.ResendEmailAction, models.ManageEmailsPermission, a.ResendEmailHandler)).Methods("PUT", "OPTIONS")
+	emailsRouter.Handle("/templates/{name}/preview", can(models.ManageEmailsPermission, a.PreviewEmailTemplateHandler)).Methods("GET", "OPTIONS")
+
+	roleRouter.Handle("", can(models.ManageRolesPermission, a.ListRolesHandler)).Methods("GET", "OPTIONS")
+	roleRouter.Handle("/users", can(models.ManageRolesPermission, a.ListStaffHandler)).Methods("GET", "OPTIONS")
+	roleRouter.Handle("/assign", audited(models.AssignRoleAction, models.ManageRolesPermission, a.AssignRoleHandler)).Methods("PUT", "OPTIONS")
+	roleRouter.Handle("/unassign", audited(models.UnassignRoleAction, models.ManageRolesPermission, a.UnassignRoleHandler)).Methods("PUT", "OPTIONS")
+
+	auditRouter.Handle("", middlewares.RequirePermission(models.ReadAuditPermission)(http.HandlerFunc(a.ExportAuditEventsHandler))).Queries("format", "csv").Methods("GET", "OPTIONS")
+	auditRouter.Handle("", can(models.ReadAuditPermission, a.ListAuditEventsHandler)).Methods("GET", "OPTIONS")
 
 	// middlewares
 	r.Use(middlewares.LoggingMW)
 	r.Use(middlewares.EnableCors)
 
 	authRouter.Use(middlewares.Authorization(a.db, a.config.Token.Secret, a.config.Token.Timeout))
-	adminRouter.Use(middlewares.AdminAccess(a.db))
+	unAuthUserRouter.Use(authLimit)
+	adminRouter.Use(middlewares.TokenScopes(models.AdminScope, models.AdminScope))
+	adminRouter.Use(middlewares.AdminAccess(a.db, a.config.TwoFactor.RequiredForAdmins))
+
+	// scopes of personal access tokens
+	for _, router := range []*mux.Router{userRouter, quotaRouter, notificationRouter, teamRouter} {
+		router.Use(middlewares.TokenScopes(models.ReadScope, ""))
+	}
+	for _, router := range []*mux.Router{vmRouter, k8sRouter, jobRouter} {
+		router.Use(middlewares.TokenScopes(models.ReadScope, models.DeployScope))
+	}
 
 	// prometheus registration
-	prometheus.MustRegister(middlewares.Requests, middlewares.UserCreations, middlewares.VoucherActivated, middlewares.VoucherApplied, middlewares.Deployments, middlewares.Deletions)
+	prometheus.MustRegister(middlewares.Requests, middlewares.UserCreations, middlewares.VoucherActivated, middlewares.VoucherApplied, middlewares.Deployments, middlewares.Deletions, middlewares.RateLimited)
 	http.Handle("/metrics", promhttp.Handler())
 
 	http.Handle("/", r)
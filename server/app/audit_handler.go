@@ -0,0 +1,155 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codescalers/cloud4students/middlewares"
+	"github.com/codescalers/cloud4students/models"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultAuditEventsLimit = 100
+	maxAuditEventsLimit     = 1000
+)
+
+// auditFilter reads the filter and pagination of audit events from the query parameters,
+// the time range is RFC 3339 timestamps
+func auditFilter(req *http.Request) (models.AuditFilter, error) {
+	query := req.URL.Query()
+	filter := models.AuditFilter{
+		ActorEmail: query.Get("actor"),
+		Action:     models.AuditAction(query.Get("action")),
+		Target:     query.Get("target"),
+		Limit:      defaultAuditEventsLimit,
+	}
+
+	if from := query.Get("from"); from != "" {
+		t, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			return filter, errors.New("from should be a RFC 3339 time")
+		}
+		filter.From = t
+	}
+
+	if to := query.Get("to"); to != "" {
+		t, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			return filter, errors.New("to should be a RFC 3339 time")
+		}
+		filter.To = t
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l <= 0 || l > maxAuditEventsLimit {
+			return filter, fmt.Errorf("limit should be a number between 1 and %d", maxAuditEventsLimit)
+		}
+		filter.Limit = l
+	}
+
+	if offset := query.Get("offset"); offset != "" {
+		o, err := strconv.Atoi(offset)
+		if err != nil || o < 0 {
+			return filter, errors.New("offset should be a positive number")
+		}
+		filter.Offset = o
+	}
+
+	return filter, nil
+}
+
+// ListAuditEventsHandler lists a page of the audit log, the newest first
+func (a *App) ListAuditEventsHandler(req *http.Request) (interface{}, Response) {
+	filter, err := auditFilter(req)
+	if err != nil {
+		return nil, BadRequest(err)
+	}
+
+	events, err := a.db.ListAuditEvents(filter)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	if len(events) == 0 {
+		return ResponseMsg{
+			Message: "Audit events are not found",
+			Data:    events,
+		}, Ok()
+	}
+
+	return ResponseMsg{
+		Message: "Audit events are found",
+		Data:    events,
+	}, Ok()
+}
+
+// ExportAuditEventsHandler downloads the audit events matching the filter as csv, all of them unless
+// a limit is set
+func (a *App) ExportAuditEventsHandler(w http.ResponseWriter, req *http.Request) {
+	filter, err := auditFilter(req)
+	if err != nil {
+		WrapFunc(func(r *http.Request) (interface{}, Response) {
+			return nil, BadRequest(err)
+		})(w, req)
+		return
+	}
+	if req.URL.Query().Get("limit") == "" {
+		filter.Limit = 0
+	}
+
+	events, err := a.db.ListAuditEvents(filter)
+	if err != nil {
+		log.Error().Err(err).Send()
+		WrapFunc(func(r *http.Request) (interface{}, Response) {
+			return nil, InternalServerError(errors.New(internalServerErrorMsg))
+		})(w, req)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="audit-%s.csv"`, time.Now().UTC().Format("20060102-150405")))
+	w.WriteHeader(http.StatusOK)
+	middlewares.Requests.WithLabelValues(req.Method, req.RequestURI, fmt.Sprint(http.StatusOK)).Inc()
+
+	writer := csv.NewWriter(w)
+	rows := [][]string{{"id", "created_at", "actor_id", "actor_email", "action", "target", "before", "after", "ip", "status"}}
+	for _, event := range events {
+		row := []string{
+			fmt.Sprint(event.ID),
+			event.CreatedAt.UTC().Format(time.RFC3339),
+			event.ActorID,
+			event.ActorEmail,
+			string(event.Action),
+			event.Target,
+			event.Before,
+			event.After,
+			event.IP,
+			fmt.Sprint(event.Status),
+		}
+		for i := range row {
+			row[i] = escapeCSVCell(row[i])
+		}
+		rows = append(rows, row)
+	}
+	if err := writer.WriteAll(rows); err != nil {
+		log.Error().Err(err).Msg("failed to write audit events")
+	}
+}
+
+// escapeCSVCell prefixes cells spreadsheets would run as formulas with a quote, so exported values
+// such as targets set by users are only shown
+func escapeCSVCell(cell string) string {
+	if cell != "" && strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+		return "'" + cell
+	}
+	return cell
+}
@@ -0,0 +1,177 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/codescalers/cloud4students/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditHandlers(t *testing.T) {
+	app := SetUp(t)
+
+	admin := models.User{Name: "admin", Email: "admin@gmail.com", Verified: true, Admin: true}
+	err := app.db.CreateUser(&admin)
+	assert.NoError(t, err)
+
+	support := models.User{Name: "support", Email: "support@gmail.com", Verified: true}
+	err = app.db.CreateUser(&support)
+	assert.NoError(t, err)
+	_, err = app.db.AddUserRole(support.ID.String(), models.SupportRole)
+	assert.NoError(t, err)
+
+	adminToken, err := newAccessToken(app, admin.ID.String(), admin.Email)
+	assert.NoError(t, err)
+
+	supportToken, err := newAccessToken(app, support.ID.String(), support.Email)
+	assert.NoError(t, err)
+
+	request := func(token string, action models.AuditAction, permission models.Permission, handler Handler, api, body string) *httptest.ResponseRecorder {
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				body:        bytes.NewBuffer([]byte(body)),
+				handlerFunc: handler,
+				api:         fmt.Sprintf("/%s/%s", app.config.Version, api),
+			},
+			token:      token,
+			config:     app.config,
+			db:         app.db,
+			permission: permission,
+			action:     action,
+		}
+		return adminHandler(req)
+	}
+
+	t.Run("audit: actions are recorded", func(t *testing.T) {
+		response := request(adminToken, models.UpdateMaintenanceAction, models.ManageMaintenancePermission, app.UpdateMaintenanceHandler, "maintenance", `{"on":true}`)
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		response = request(adminToken, models.SetAdminAction, models.ManageRolesPermission, app.SetAdmin, "set_admin", `{"email":"support@gmail.com","admin":true}`)
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		events, err := app.db.ListAuditEvents(models.AuditFilter{})
+		assert.NoError(t, err)
+		assert.Len(t, events, 2)
+
+		assert.Equal(t, models.SetAdminAction, events[0].Action)
+		assert.Equal(t, admin.ID.String(), events[0].ActorID)
+		assert.Equal(t, admin.Email, events[0].ActorEmail)
+		assert.Equal(t, "user=support@gmail.com", events[0].Target)
+		assert.Equal(t, `{"admin":false}`, events[0].Before)
+		assert.Equal(t, `{"admin":true}`, events[0].After)
+		assert.Equal(t, http.StatusOK, events[0].Status)
+		assert.NotEmpty(t, events[0].IP)
+
+		assert.Equal(t, models.UpdateMaintenanceAction, events[1].Action)
+		assert.Equal(t, `{"active":false}`, events[1].Before)
+		assert.Equal(t, `{"active":true}`, events[1].After)
+	})
+
+	t.Run("audit: denied actions are recorded", func(t *testing.T) {
+		err := app.db.UpdateAdminUserByID(support.ID.String(), false)
+		assert.NoError(t, err)
+
+		response := request(supportToken, models.ResetQuotaAction, models.ResetQuotaPermission, app.ResetUsersQuota, "quota/reset", "")
+		assert.Equal(t, response.Code, http.StatusForbidden)
+
+		events, err := app.db.ListAuditEvents(models.AuditFilter{Action: models.ResetQuotaAction})
+		assert.NoError(t, err)
+		assert.Len(t, events, 1)
+		assert.Equal(t, support.Email, events[0].ActorEmail)
+		assert.Equal(t, http.StatusForbidden, events[0].Status)
+	})
+
+	t.Run("audit: list events", func(t *testing.T) {
+		response := request(adminToken, "", models.ReadAuditPermission, app.ListAuditEventsHandler, "audit?actor=admin@gmail.com&limit=1", "")
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		var events []models.AuditEvent
+		responseData(t, response, &events)
+		assert.Len(t, events, 1)
+		assert.Equal(t, models.SetAdminAction, events[0].Action)
+
+		response = request(adminToken, "", models.ReadAuditPermission, app.ListAuditEventsHandler, "audit?action=quota.reset&from=2000-01-01T00:00:00Z", "")
+		responseData(t, response, &events)
+		assert.Len(t, events, 1)
+	})
+
+	t.Run("audit: invalid filter", func(t *testing.T) {
+		response := request(adminToken, "", models.ReadAuditPermission, app.ListAuditEventsHandler, "audit?from=yesterday", "")
+		want := `{"err":"from should be a RFC 3339 time"}` + "\n"
+		assert.Equal(t, response.Body.String(), want)
+		assert.Equal(t, response.Code, http.StatusBadRequest)
+	})
+
+	t.Run("audit: support can't read the audit log", func(t *testing.T) {
+		response := request(supportToken, "", models.ReadAuditPermission, app.ListAuditEventsHandler, "audit", "")
+		assert.Equal(t, response.Code, http.StatusForbidden)
+	})
+
+	t.Run("audit: export events as csv", func(t *testing.T) {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/%s/audit?format=csv", app.config.Version), nil)
+		response := httptest.NewRecorder()
+		app.ExportAuditEventsHandler(response, req)
+
+		assert.Equal(t, response.Code, http.StatusOK)
+		assert.Equal(t, "text/csv", response.Header().Get("Content-Type"))
+		assert.Contains(t, response.Header().Get("Content-Disposition"), "attachment")
+
+		rows, err := csv.NewReader(response.Body).ReadAll()
+		assert.NoError(t, err)
+		assert.Len(t, rows, 4)
+		assert.Equal(t, "actor_email", rows[0][3])
+		assert.Equal(t, string(models.ResetQuotaAction), rows[1][4])
+		assert.Equal(t, `{"admin":true}`, rows[2][7])
+	})
+
+	t.Run("audit: formulas aren't exported", func(t *testing.T) {
+		event := models.AuditEvent{ActorEmail: "@admin@gmail.com", Action: models.SetAdminAction, Target: `=HYPERLINK("http://evil.com")`, Before: "-1", After: "+1"}
+		err := app.db.CreateAuditEvent(&event)
+		assert.NoError(t, err)
+
+		req := httptest.NewRequest("GET", fmt.Sprintf("/%s/audit?format=csv&limit=1", app.config.Version), nil)
+		response := httptest.NewRecorder()
+		app.ExportAuditEventsHandler(response, req)
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		rows, err := csv.NewReader(response.Body).ReadAll()
+		assert.NoError(t, err)
+		assert.Len(t, rows, 2)
+		assert.Equal(t, "'@admin@gmail.com", rows[1][3])
+		assert.Equal(t, `'=HYPERLINK("http://evil.com")`, rows[1][5])
+		assert.Equal(t, "'-1", rows[1][6])
+		assert.Equal(t, "'+1", rows[1][7])
+	})
+
+	t.Run("audit: bulk actions record what they change", func(t *testing.T) {
+		vm := models.VM{UserID: admin.ID.String(), Name: "vm", ContractID: 1, NetworkContractID: 2}
+		err := app.db.CreateVM(&vm)
+		assert.NoError(t, err)
+
+		voucher := models.Voucher{UserID: admin.ID.String(), Voucher: "voucher"}
+		err = app.db.CreateVoucher(&voucher)
+		assert.NoError(t, err)
+
+		response := request(adminToken, models.DeleteDeploymentsAction, models.DeleteDeploymentsPermission, app.DeleteAllDeployments, "deployments", "")
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		response = request(adminToken, models.ApproveAllVouchersAction, models.ReviewVouchersPermission, app.ApproveAllVouchersHandler, "voucher", "")
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		events, err := app.db.ListAuditEvents(models.AuditFilter{Action: models.DeleteDeploymentsAction})
+		assert.NoError(t, err)
+		assert.Len(t, events, 1)
+		assert.Equal(t, fmt.Sprintf(`{"k8s":[],"k8s_count":0,"vms":[%d],"vms_count":1}`, vm.ID), events[0].After)
+
+		events, err = app.db.ListAuditEvents(models.AuditFilter{Action: models.ApproveAllVouchersAction})
+		assert.NoError(t, err)
+		assert.Len(t, events, 1)
+		assert.Equal(t, fmt.Sprintf(`{"approved":[%d],"count":1}`, voucher.ID), events[0].After)
+	})
+}
@@ -0,0 +1,165 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"errors"
+	"net/http"
+
+	c4sDeployer "github.com/codescalers/cloud4students/deployer"
+	"github.com/codescalers/cloud4students/middlewares"
+	"github.com/codescalers/cloud4students/models"
+	"github.com/codescalers/cloud4students/streams"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+)
+
+// deadLetterStream returns the dead letter stream of the requested deployment type
+func deadLetterStream(req *http.Request) (string, error) {
+	switch mux.Vars(req)["type"] {
+	case models.VMsType:
+		return streams.DeadVMStreamName, nil
+	case models.K8sType:
+		return streams.DeadK8sStreamName, nil
+	default:
+		return "", errors.New("dead letters type should be vms or k8s")
+	}
+}
+
+// ListDeadLettersHandler lists the deployment requests that failed all attempts
+func (a *App) ListDeadLettersHandler(req *http.Request) (interface{}, Response) {
+	stream, err := deadLetterStream(req)
+	if err != nil {
+		return nil, BadRequest(err)
+	}
+
+	deadLetters, err := a.deployer.DeadLetters(stream)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	if len(deadLetters) == 0 {
+		return ResponseMsg{
+			Message: "Dead letters are not found",
+			Data:    deadLetters,
+		}, Ok()
+	}
+
+	return ResponseMsg{
+		Message: "Dead letters are found",
+		Data:    deadLetters,
+	}, Ok()
+}
+
+// GetDeadLetterHandler returns a dead lettered deployment request
+func (a *App) GetDeadLetterHandler(req *http.Request) (interface{}, Response) {
+	stream, err := deadLetterStream(req)
+	if err != nil {
+		return nil, BadRequest(err)
+	}
+
+	deadLetter, err := a.deployer.DeadLetter(stream, mux.Vars(req)["id"])
+	if err == c4sDeployer.ErrDeadLetterNotFound {
+		return nil, NotFound(errors.New("dead letter is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Dead letter is found",
+		Data:    deadLetter,
+	}, Ok()
+}
+
+// RequeueDeadLetterHandler pushes a dead lettered deployment request back to be deployed
+func (a *App) RequeueDeadLetterHandler(req *http.Request) (interface{}, Response) {
+	stream, err := deadLetterStream(req)
+	if err != nil {
+		return nil, BadRequest(err)
+	}
+
+	id := mux.Vars(req)["id"]
+	letter, err := a.deployer.DeadLetter(stream, id)
+	if err == c4sDeployer.ErrDeadLetterNotFound {
+		return nil, NotFound(errors.New("dead letter is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	err = a.deployer.RequeueDeadLetter(stream, id)
+	if err == c4sDeployer.ErrDeadLetterNotFound {
+		return nil, NotFound(errors.New("dead letter is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+	middlewares.AuditChange(req, "", letter, map[string]string{"stream": letter.Stream})
+
+	return ResponseMsg{
+		Message: "Dead letter is requeued successfully",
+	}, Accepted()
+}
+
+// DeleteDeadLetterHandler deletes a dead lettered deployment request
+func (a *App) DeleteDeadLetterHandler(req *http.Request) (interface{}, Response) {
+	stream, err := deadLetterStream(req)
+	if err != nil {
+		return nil, BadRequest(err)
+	}
+
+	id := mux.Vars(req)["id"]
+	letter, err := a.deployer.DeadLetter(stream, id)
+	if err == c4sDeployer.ErrDeadLetterNotFound {
+		return nil, NotFound(errors.New("dead letter is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	err = a.deployer.PurgeDeadLetters(stream, id)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+	middlewares.AuditChange(req, "", letter, nil)
+
+	return ResponseMsg{
+		Message: "Dead letter is deleted successfully",
+	}, Ok()
+}
+
+// PurgeDeadLettersHandler deletes all dead lettered deployment requests of a type
+func (a *App) PurgeDeadLettersHandler(req *http.Request) (interface{}, Response) {
+	stream, err := deadLetterStream(req)
+	if err != nil {
+		return nil, BadRequest(err)
+	}
+
+	letters, err := a.deployer.DeadLetters(stream)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	err = a.deployer.PurgeDeadLetters(stream)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	ids := []string{}
+	for _, letter := range letters {
+		ids = append(ids, letter.ID)
+	}
+	middlewares.AuditChange(req, "", map[string]interface{}{"ids": ids, "count": len(ids)}, nil)
+
+	return ResponseMsg{
+		Message: "Dead letters are deleted successfully",
+	}, Ok()
+}
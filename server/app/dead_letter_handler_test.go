@@ -0,0 +1,127 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/codescalers/cloud4students/models"
+	"github.com/codescalers/cloud4students/streams"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeadLetterHandlers(t *testing.T) {
+	app := SetUp(t)
+
+	admin := models.User{
+		Name:     "admin",
+		Email:    "admin@gmail.com",
+		Verified: true,
+		Admin:    true,
+	}
+	err := app.db.CreateUser(&admin)
+	assert.NoError(t, err)
+
+	token, err := newAccessToken(app, admin.ID.String(), admin.Email)
+	assert.NoError(t, err)
+
+	deadLetter, err := json.Marshal(streams.DeadLetter{
+		Stream:   streams.ReqVMStreamName,
+		Data:     `{"Input":{"name":"vm"}}`,
+		Error:    "grid is down",
+		Attempts: 5,
+		FailedAt: time.Now(),
+	})
+	assert.NoError(t, err)
+	err = app.deployer.Queue.Push(streams.DeadVMStreamName, deadLetter)
+	assert.NoError(t, err)
+
+	messages, err := app.deployer.Queue.Range(streams.DeadVMStreamName, 0)
+	assert.NoError(t, err)
+	id := messages[0].ID
+
+	request := func(handler Handler, vars map[string]string) authHandlerConfig {
+		return authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: handler,
+				api:         fmt.Sprintf("/%s/dead_letter", app.config.Version),
+			},
+			userID: admin.ID.String(),
+			token:  token,
+			config: app.config,
+			db:     app.db,
+			vars:   vars,
+		}
+	}
+
+	t.Run("list dead letters: invalid type", func(t *testing.T) {
+		response := adminHandler(request(app.ListDeadLettersHandler, map[string]string{"type": "vm"}))
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("list dead letters: success", func(t *testing.T) {
+		response := adminHandler(request(app.ListDeadLettersHandler, map[string]string{"type": models.VMsType}))
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Contains(t, response.Body.String(), "grid is down")
+	})
+
+	t.Run("get dead letter: not found", func(t *testing.T) {
+		response := adminHandler(request(app.GetDeadLetterHandler, map[string]string{"type": models.VMsType, "id": "0-0"}))
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+
+	t.Run("get dead letter: success", func(t *testing.T) {
+		response := adminHandler(request(app.GetDeadLetterHandler, map[string]string{"type": models.VMsType, "id": id}))
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Contains(t, response.Body.String(), id)
+	})
+
+	t.Run("requeue dead letter: success", func(t *testing.T) {
+		req := request(app.RequeueDeadLetterHandler, map[string]string{"type": models.VMsType, "id": id})
+		req.action = models.RequeueDeadLetterAction
+		response := adminHandler(req)
+		assert.Equal(t, http.StatusAccepted, response.Code)
+
+		messages, err := app.deployer.Queue.Range(streams.ReqVMStreamName, 0)
+		assert.NoError(t, err)
+		assert.Len(t, messages, 1)
+
+		events, err := app.db.ListAuditEvents(models.AuditFilter{Action: models.RequeueDeadLetterAction})
+		assert.NoError(t, err)
+		assert.Len(t, events, 1)
+		assert.Contains(t, events[0].Before, "grid is down")
+		assert.Equal(t, fmt.Sprintf(`{"stream":"%s"}`, streams.ReqVMStreamName), events[0].After)
+	})
+
+	t.Run("delete dead letter: not found", func(t *testing.T) {
+		response := adminHandler(request(app.DeleteDeadLetterHandler, map[string]string{"type": models.VMsType, "id": id}))
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+
+	t.Run("purge dead letters: success", func(t *testing.T) {
+		err = app.deployer.Queue.Push(streams.DeadK8sStreamName, deadLetter)
+		assert.NoError(t, err)
+
+		deadLetters, err := app.deployer.DeadLetters(streams.DeadK8sStreamName)
+		assert.NoError(t, err)
+		assert.Len(t, deadLetters, 1)
+
+		req := request(app.PurgeDeadLettersHandler, map[string]string{"type": models.K8sType})
+		req.action = models.PurgeDeadLettersAction
+		response := adminHandler(req)
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		purged := deadLetters[0].ID
+		deadLetters, err = app.deployer.DeadLetters(streams.DeadK8sStreamName)
+		assert.NoError(t, err)
+		assert.Empty(t, deadLetters)
+
+		events, err := app.db.ListAuditEvents(models.AuditFilter{Action: models.PurgeDeadLettersAction})
+		assert.NoError(t, err)
+		assert.Len(t, events, 1)
+		assert.Equal(t, fmt.Sprintf(`{"count":1,"ids":["%s"]}`, purged), events[0].Before)
+	})
+}
@@ -0,0 +1,143 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/codescalers/cloud4students/internal"
+	"github.com/codescalers/cloud4students/middlewares"
+	"github.com/codescalers/cloud4students/models"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultEmailsLimit = 100
+	maxEmailsLimit     = 1000
+)
+
+// emailsFilter reads the states and pagination of emails from the query parameters
+func emailsFilter(req *http.Request) (models.EmailsFilter, error) {
+	query := req.URL.Query()
+	filter := models.EmailsFilter{
+		States: []models.EmailState{models.EmailQueued, models.EmailSending, models.EmailFailed},
+		Limit:  defaultEmailsLimit,
+	}
+
+	if states := query.Get("state"); states != "" {
+		filter.States = nil
+		for _, state := range strings.Split(states, ",") {
+			switch s := models.EmailState(state); s {
+			case models.EmailQueued, models.EmailSending, models.EmailSent, models.EmailFailed:
+				filter.States = append(filter.States, s)
+			default:
+				return filter, fmt.Errorf("email state '%s' is not supported", state)
+			}
+		}
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l <= 0 || l > maxEmailsLimit {
+			return filter, fmt.Errorf("limit should be a number between 1 and %d", maxEmailsLimit)
+		}
+		filter.Limit = l
+	}
+
+	if offset := query.Get("offset"); offset != "" {
+		o, err := strconv.Atoi(offset)
+		if err != nil || o < 0 {
+			return filter, errors.New("offset should be a positive number")
+		}
+		filter.Offset = o
+	}
+
+	return filter, nil
+}
+
+// ListEmailsHandler lists a page of the emails of the outbox that are not sent yet, or in the requested states.
+// Bodies of emails aren't listed as they may hold verification codes
+func (a *App) ListEmailsHandler(req *http.Request) (interface{}, Response) {
+	filter, err := emailsFilter(req)
+	if err != nil {
+		return nil, BadRequest(err)
+	}
+
+	emails, err := a.db.ListEmails(filter)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	if len(emails) == 0 {
+		return ResponseMsg{
+			Message: "Emails are not found",
+			Data:    emails,
+		}, Ok()
+	}
+
+	return ResponseMsg{
+		Message: "Emails are found",
+		Data:    emails,
+	}, Ok()
+}
+
+// ResendEmailHandler queues a failed or sent email to be sent again
+func (a *App) ResendEmailHandler(req *http.Request) (interface{}, Response) {
+	id, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, BadRequest(errors.New("failed to read email id"))
+	}
+
+	email, err := a.db.GetEmail(id)
+	if err == gorm.ErrRecordNotFound {
+		return nil, NotFound(errors.New("email is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	if email.Sensitive && (email.State == models.EmailSent || email.State == models.EmailFailed) {
+		return nil, BadRequest(errors.New("email holds a secret that isn't kept, its user should request it again"))
+	}
+
+	resent, err := a.db.ResendEmail(id)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+	if !resent {
+		return nil, BadRequest(fmt.Errorf("email is %s and will be sent", email.State))
+	}
+	middlewares.AuditChange(req, "email="+email.Receiver, map[string]interface{}{"state": email.State, "attempts": email.Attempts}, map[string]interface{}{"state": models.EmailQueued, "attempts": 0})
+
+	return ResponseMsg{
+		Message: "Email is queued to be sent again",
+	}, Accepted()
+}
+
+// PreviewEmailTemplateHandler renders an email template with sample data
+func (a *App) PreviewEmailTemplateHandler(req *http.Request) (interface{}, Response) {
+	name := mux.Vars(req)["name"]
+
+	mail, err := internal.PreviewMail(name, a.config.Server.Host)
+	if err == internal.ErrMailTemplateNotFound {
+		return nil, NotFound(fmt.Errorf("email template '%s' is not found", name))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Email template is rendered",
+		Data:    mail,
+	}, Ok()
+}
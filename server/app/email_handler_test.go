@@ -0,0 +1,150 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/codescalers/cloud4students/internal"
+	"github.com/codescalers/cloud4students/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmailHandlers(t *testing.T) {
+	app := SetUp(t)
+
+	admin := models.User{
+		Name:     "admin",
+		Email:    "admin@gmail.com",
+		Verified: true,
+		Admin:    true,
+	}
+	err := app.db.CreateUser(&admin)
+	assert.NoError(t, err)
+
+	token, err := newAccessToken(app, admin.ID.String(), admin.Email)
+	assert.NoError(t, err)
+
+	err = app.outbox.Enqueue("failed@gmail.com", internal.Mail{Subject: "subject", HTML: "body"})
+	assert.NoError(t, err)
+	err = app.db.UpdateEmailState(1, models.EmailFailed, "mail server is down", time.Now())
+	assert.NoError(t, err)
+
+	err = app.outbox.Enqueue("queued@gmail.com", internal.Mail{Subject: "subject", HTML: "body"})
+	assert.NoError(t, err)
+
+	err = app.outbox.Enqueue("sensitive@gmail.com", internal.Mail{Subject: "subject", HTML: "code 123456", Sensitive: true})
+	assert.NoError(t, err)
+	err = app.db.UpdateEmailState(3, models.EmailSent, "", time.Now())
+	assert.NoError(t, err)
+
+	newReq := func(handler Handler, api string, id int) authHandlerConfig {
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: handler,
+				api:         fmt.Sprintf("/%s/emails%s", app.config.Version, api),
+			},
+			userID: admin.ID.String(),
+			token:  token,
+			config: app.config,
+			db:     app.db,
+		}
+		if id != 0 {
+			req.vars = map[string]string{"id": fmt.Sprint(id)}
+		}
+		return req
+	}
+
+	t.Run("List emails: not sent", func(t *testing.T) {
+		response := adminHandler(newReq(app.ListEmailsHandler, "", 0))
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Contains(t, response.Body.String(), "failed@gmail.com")
+		assert.Contains(t, response.Body.String(), "queued@gmail.com")
+	})
+
+	t.Run("List emails: by state", func(t *testing.T) {
+		response := adminHandler(newReq(app.ListEmailsHandler, "?state=failed", 0))
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Contains(t, response.Body.String(), "mail server is down")
+		assert.NotContains(t, response.Body.String(), "queued@gmail.com")
+	})
+
+	t.Run("List emails: bodies aren't listed", func(t *testing.T) {
+		response := adminHandler(newReq(app.ListEmailsHandler, "?state=sent", 0))
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Contains(t, response.Body.String(), "sensitive@gmail.com")
+		assert.NotContains(t, response.Body.String(), "123456")
+	})
+
+	t.Run("List emails: paginated", func(t *testing.T) {
+		response := adminHandler(newReq(app.ListEmailsHandler, "?limit=1&offset=1", 0))
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Contains(t, response.Body.String(), "failed@gmail.com")
+		assert.NotContains(t, response.Body.String(), "queued@gmail.com")
+	})
+
+	t.Run("List emails: invalid limit", func(t *testing.T) {
+		response := adminHandler(newReq(app.ListEmailsHandler, "?limit=-1", 0))
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("List emails: invalid state", func(t *testing.T) {
+		response := adminHandler(newReq(app.ListEmailsHandler, "?state=lost", 0))
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("Resend email: queued", func(t *testing.T) {
+		response := adminHandler(newReq(app.ResendEmailHandler, "/2/resend", 2))
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("Resend email: secret is purged", func(t *testing.T) {
+		response := adminHandler(newReq(app.ResendEmailHandler, "/3/resend", 3))
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+
+		email, err := app.db.GetEmail(3)
+		assert.NoError(t, err)
+		assert.Empty(t, email.Body)
+	})
+
+	t.Run("Resend email: not found", func(t *testing.T) {
+		response := adminHandler(newReq(app.ResendEmailHandler, "/10/resend", 10))
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+
+	t.Run("Resend email: success", func(t *testing.T) {
+		req := newReq(app.ResendEmailHandler, "/1/resend", 1)
+		req.action = models.ResendEmailAction
+		response := adminHandler(req)
+		assert.Equal(t, http.StatusAccepted, response.Code)
+
+		events, err := app.db.ListAuditEvents(models.AuditFilter{Action: models.ResendEmailAction})
+		assert.NoError(t, err)
+		assert.Len(t, events, 1)
+		assert.Equal(t, "email=failed@gmail.com", events[0].Target)
+		assert.Equal(t, `{"attempts":0,"state":"failed"}`, events[0].Before)
+		assert.Equal(t, `{"attempts":0,"state":"queued"}`, events[0].After)
+
+		email, err := app.db.GetEmail(1)
+		assert.NoError(t, err)
+		assert.Equal(t, models.EmailQueued, email.State)
+		assert.Zero(t, email.Attempts)
+	})
+
+	t.Run("Preview email template: success", func(t *testing.T) {
+		req := newReq(app.PreviewEmailTemplateHandler, "/templates/signup/preview", 0)
+		req.vars = map[string]string{"name": internal.SignUpMail}
+		response := adminHandler(req)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Contains(t, response.Body.String(), "123456")
+	})
+
+	t.Run("Preview email template: not found", func(t *testing.T) {
+		req := newReq(app.PreviewEmailTemplateHandler, "/templates/unknown/preview", 0)
+		req.vars = map[string]string{"name": "unknown"}
+		response := adminHandler(req)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+}
@@ -0,0 +1,106 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/codescalers/cloud4students/middlewares"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// GetJobHandler returns a deployment job by its id
+func (a *App) GetJobHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+	id, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		return nil, BadRequest(errors.New("failed to read job id"))
+	}
+
+	job, err := a.db.GetJob(id)
+	if err == gorm.ErrRecordNotFound {
+		return nil, NotFound(errors.New("job is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	if job.UserID != userID {
+		return nil, NotFound(errors.New("job is not found"))
+	}
+
+	return ResponseMsg{
+		Message: "Job is found",
+		Data:    job,
+	}, Ok()
+}
+
+// ListJobsHandler returns all deployment jobs of user
+func (a *App) ListJobsHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+
+	jobs, err := a.db.ListJobs(userID)
+	if err == gorm.ErrRecordNotFound || len(jobs) == 0 {
+		return ResponseMsg{
+			Message: "no jobs found",
+			Data:    jobs,
+		}, Ok()
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Jobs are found",
+		Data:    jobs,
+	}, Ok()
+}
+
+// CancelJobHandler cancels a deployment job that is still queued and releases its quota
+func (a *App) CancelJobHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+	id, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		return nil, BadRequest(errors.New("failed to read job id"))
+	}
+
+	job, err := a.db.GetJob(id)
+	if err == gorm.ErrRecordNotFound {
+		return nil, NotFound(errors.New("job is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	if job.UserID != userID {
+		return nil, NotFound(errors.New("job is not found"))
+	}
+
+	canceled, err := a.db.CancelJob(id)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	if !canceled {
+		return nil, BadRequest(errors.New("only queued jobs can be canceled"))
+	}
+
+	reservation, err := a.db.GetJobQuotaReservation(id)
+	if err == nil {
+		err = a.db.ReleaseQuotaReservation(reservation.ID)
+	}
+	if err != nil && err != gorm.ErrRecordNotFound {
+		log.Error().Err(err).Send()
+	}
+
+	return ResponseMsg{
+		Message: "Job is canceled successfully",
+	}, Ok()
+}
@@ -0,0 +1,190 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/codescalers/cloud4students/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobHandlers(t *testing.T) {
+	app := SetUp(t)
+
+	user.Verified = true
+	err := app.db.CreateUser(user)
+	assert.NoError(t, err)
+
+	err = app.db.CreateQuota(&models.Quota{UserID: user.ID.String(), Resources: models.Resources{CRU: 10, MRU: 20, SRU: 250, PublicIPs: 1}})
+	assert.NoError(t, err)
+
+	token, err := newAccessToken(app, user.ID.String(), user.Email)
+	assert.NoError(t, err)
+
+	var job models.Job
+
+	t.Run("Deploy vm: job is queued", func(t *testing.T) {
+		body := []byte(`{"name":"myvm","resources":"small"}`)
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				body:        bytes.NewBuffer(body),
+				handlerFunc: app.DeployVMHandler,
+				api:         fmt.Sprintf("/%s/vm", app.config.Version),
+			},
+			userID: user.ID.String(),
+			token:  token,
+			config: app.config,
+			db:     app.db,
+		}
+
+		response := authorizedHandler(req)
+		assert.Equal(t, http.StatusCreated, response.Code)
+
+		var res struct {
+			Data models.Job `json:"data"`
+		}
+		err := json.NewDecoder(response.Body).Decode(&res)
+		assert.NoError(t, err)
+
+		job = res.Data
+		assert.NotZero(t, job.ID)
+		assert.Equal(t, models.JobQueued, job.State)
+		assert.Equal(t, models.VMsType, job.Type)
+		assert.Equal(t, "myvm", job.Name)
+	})
+
+	t.Run("Get job: success", func(t *testing.T) {
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: app.GetJobHandler,
+				api:         fmt.Sprintf("/%s/jobs/%d", app.config.Version, job.ID),
+			},
+			userID: user.ID.String(),
+			token:  token,
+			config: app.config,
+			db:     app.db,
+			varID:  job.ID,
+		}
+
+		response := authorizedHandler(req)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Contains(t, response.Body.String(), `"state":"queued"`)
+	})
+
+	t.Run("Get job: not found", func(t *testing.T) {
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: app.GetJobHandler,
+				api:         fmt.Sprintf("/%s/jobs/%d", app.config.Version, job.ID+1),
+			},
+			userID: user.ID.String(),
+			token:  token,
+			config: app.config,
+			db:     app.db,
+			varID:  job.ID + 1,
+		}
+
+		response := authorizedHandler(req)
+		want := `{"err":"job is not found"}` + "\n"
+		assert.Equal(t, want, response.Body.String())
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+
+	t.Run("Get job: other user", func(t *testing.T) {
+		other := models.User{Name: "other", Email: "other@gmail.com", Verified: true}
+		err := app.db.CreateUser(&other)
+		assert.NoError(t, err)
+
+		otherToken, err := newAccessToken(app, other.ID.String(), other.Email)
+		assert.NoError(t, err)
+
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: app.GetJobHandler,
+				api:         fmt.Sprintf("/%s/jobs/%d", app.config.Version, job.ID),
+			},
+			userID: other.ID.String(),
+			token:  otherToken,
+			config: app.config,
+			db:     app.db,
+			varID:  job.ID,
+		}
+
+		response := authorizedHandler(req)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+
+	t.Run("List jobs: success", func(t *testing.T) {
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: app.ListJobsHandler,
+				api:         fmt.Sprintf("/%s/jobs", app.config.Version),
+			},
+			userID: user.ID.String(),
+			token:  token,
+			config: app.config,
+			db:     app.db,
+		}
+
+		response := authorizedHandler(req)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Contains(t, response.Body.String(), "Jobs are found")
+	})
+
+	t.Run("Deploy vm: queued requests can't exceed quota", func(t *testing.T) {
+		usage, err := app.db.GetUserQuotaUsage(user.ID.String())
+		assert.NoError(t, err)
+		assert.Equal(t, models.Resources{CRU: 9, MRU: 18, SRU: 225, PublicIPs: 1}, usage.Resources)
+		assert.Equal(t, models.Resources{CRU: 1, MRU: 2, SRU: 25}, usage.Reserved)
+
+		err = app.db.UpdateUserQuota(user.ID.String(), models.Resources{CRU: 2, MRU: 4, SRU: 50, PublicIPs: 1})
+		assert.NoError(t, err)
+
+		body := []byte(`{"name":"largevm","resources":"large"}`)
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				body:        bytes.NewBuffer(body),
+				handlerFunc: app.DeployVMHandler,
+				api:         fmt.Sprintf("/%s/vm", app.config.Version),
+			},
+			userID: user.ID.String(),
+			token:  token,
+			config: app.config,
+			db:     app.db,
+		}
+
+		response := authorizedHandler(req)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("Cancel job: success", func(t *testing.T) {
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: app.CancelJobHandler,
+				api:         fmt.Sprintf("/%s/jobs/%d/cancel", app.config.Version, job.ID),
+			},
+			userID: user.ID.String(),
+			token:  token,
+			config: app.config,
+			db:     app.db,
+			varID:  job.ID,
+		}
+
+		response := authorizedHandler(req)
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		usage, err := app.db.GetUserQuotaUsage(user.ID.String())
+		assert.NoError(t, err)
+		assert.Equal(t, models.Resources{CRU: 3, MRU: 6, SRU: 75, PublicIPs: 1}, usage.Resources)
+		assert.Zero(t, usage.Reserved)
+
+		response = authorizedHandler(req)
+		want := `{"err":"only queued jobs can be canceled"}` + "\n"
+		assert.Equal(t, want, response.Body.String())
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+}
@@ -43,18 +43,13 @@ func (a *App) K8sDeployHandler(req *http.Request) (interface{}, Response) {
 		return nil, BadRequest(errors.New("invalid kubernetes data"))
 	}
 
-	// quota verification
-	quota, err := a.db.GetUserQuota(user.ID.String())
-	if err == gorm.ErrRecordNotFound {
-		log.Error().Err(err).Send()
-		return nil, NotFound(errors.New("user quota is not found"))
-	}
-	if err != nil {
-		log.Error().Err(err).Send()
-		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	// quota verification of user or team
+	quota, res := a.deploymentQuota(user.ID.String(), k8sDeployInput.TeamID)
+	if res != nil {
+		return nil, res
 	}
 
-	_, err = deployer.ValidateK8sQuota(k8sDeployInput, quota.Vms, quota.PublicIPs)
+	neededQuota, err := deployer.ValidateK8sQuota(k8sDeployInput, quota)
 	if err != nil {
 		log.Error().Err(err).Send()
 		return nil, BadRequest(errors.New(err.Error()))
@@ -75,15 +70,42 @@ func (a *App) K8sDeployHandler(req *http.Request) (interface{}, Response) {
 		return nil, BadRequest(errors.New("kubernetes master name is not available, please choose a different name"))
 	}
 
-	err = a.deployer.Redis.PushK8sRequest(streams.K8sDeployRequest{User: user, Input: k8sDeployInput, AdminSSHKey: a.config.AdminSSHKey})
+	job := models.Job{
+		UserID: user.ID.String(),
+		Type:   models.K8sType,
+		Name:   k8sDeployInput.MasterName,
+		State:  models.JobQueued,
+	}
+	// the quota is held from now so queued requests can't use more than the available quota
+	reservation := models.QuotaReservation{
+		UserID:    user.ID.String(),
+		TeamID:    k8sDeployInput.TeamID,
+		Resources: neededQuota,
+	}
+	err = a.db.CreateJobWithReservation(&job, &reservation)
+	if err == models.ErrInsufficientQuota {
+		return nil, BadRequest(errors.New("no available quota for deployment, you can request a new voucher"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	err = streams.PushK8sRequest(a.deployer.Queue, streams.K8sDeployRequest{JobID: job.ID, User: user, Input: k8sDeployInput, AdminSSHKey: a.config.AdminSSHKey})
 	if err != nil {
 		log.Error().Err(err).Send()
+		if err := a.db.UpdateJobState(job.ID, models.JobFailed, internalServerErrorMsg); err != nil {
+			log.Error().Err(err).Send()
+		}
+		if err := a.db.ReleaseQuotaReservation(reservation.ID); err != nil {
+			log.Error().Err(err).Send()
+		}
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
 	}
 
 	return ResponseMsg{
 		Message: "Kubernetes cluster request is being deployed, you'll receive a confirmation notification soon",
-		Data:    nil,
+		Data:    job,
 	}, Created()
 }
 
@@ -124,7 +146,7 @@ func (a *App) K8sGetHandler(req *http.Request) (interface{}, Response) {
 	}
 
 	cluster, err := a.db.GetK8s(id)
-	if err == gorm.ErrRecordNotFound || cluster.UserID != userID {
+	if err == gorm.ErrRecordNotFound {
 		return nil, NotFound(errors.New("kubernetes cluster is not found"))
 	}
 	if err != nil {
@@ -132,6 +154,15 @@ func (a *App) K8sGetHandler(req *http.Request) (interface{}, Response) {
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
 	}
 
+	allowed, err := a.canAccessDeployment(userID, cluster.UserID, cluster.TeamID, false)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+	if !allowed {
+		return nil, NotFound(errors.New("kubernetes cluster is not found"))
+	}
+
 	return ResponseMsg{
 		Message: "Kubernetes cluster is found",
 		Data:    cluster,
@@ -169,7 +200,7 @@ func (a *App) K8sDeleteHandler(req *http.Request) (interface{}, Response) {
 	}
 
 	cluster, err := a.db.GetK8s(id)
-	if err == gorm.ErrRecordNotFound || cluster.UserID != userID {
+	if err == gorm.ErrRecordNotFound {
 		return nil, NotFound(errors.New("kubernetes cluster is not found"))
 	}
 	if err != nil {
@@ -177,6 +208,15 @@ func (a *App) K8sDeleteHandler(req *http.Request) (interface{}, Response) {
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
 	}
 
+	allowed, err := a.canAccessDeployment(userID, cluster.UserID, cluster.TeamID, true)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+	if !allowed {
+		return nil, NotFound(errors.New("kubernetes cluster is not found"))
+	}
+
 	err = a.deployer.CancelDeployment(uint64(cluster.ClusterContract), uint64(cluster.NetworkContract), "k8s", cluster.Master.Name)
 	if err != nil && !strings.Contains(err.Error(), "ContractNotExists") {
 		log.Error().Err(err).Send()
@@ -2,48 +2,148 @@
 package app
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/codescalers/cloud4students/middlewares"
+	"github.com/codescalers/cloud4students/models"
+	"github.com/codescalers/cloud4students/streams"
 	"github.com/gorilla/mux"
 	"github.com/rs/zerolog/log"
 	"gorm.io/gorm"
 )
 
-// ListNotificationsHandler lists notifications for a user
+// notificationsKeepAlive is how often a comment is sent to streams without notifications
+// so proxies don't close them
+const notificationsKeepAlive = 30 * time.Second
+
+const (
+	defaultNotificationsLimit = 20
+	maxNotificationsLimit     = 100
+)
+
+// notificationTypes are the types notifications can be filtered by
+var notificationTypes = map[string]bool{
+	models.VMsType:          true,
+	models.K8sType:          true,
+	models.VoucherType:      true,
+	models.AnnouncementType: true,
+	models.EmailType:        true,
+	models.TeamType:         true,
+}
+
+// NotificationsPage is a page of notifications with the cursor of the next one
+type NotificationsPage struct {
+	Notifications []models.Notification `json:"notifications"`
+	// NextCursor is passed as cursor to get the next page, it is 0 for the last page
+	NextCursor  int   `json:"next_cursor"`
+	UnreadCount int64 `json:"unread_count"`
+}
+
+// notificationsFilter reads the filter and pagination of notifications from the query parameters
+func notificationsFilter(req *http.Request) (models.NotificationsFilter, error) {
+	query := req.URL.Query()
+	filter := models.NotificationsFilter{Limit: defaultNotificationsLimit}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		c, err := strconv.Atoi(cursor)
+		if err != nil || c < 0 {
+			return filter, errors.New("cursor should be a positive number")
+		}
+		filter.Cursor = c
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l <= 0 || l > maxNotificationsLimit {
+			return filter, fmt.Errorf("limit should be a number between 1 and %d", maxNotificationsLimit)
+		}
+		filter.Limit = l
+	}
+
+	// types can be repeated or comma separated
+	for _, value := range query["type"] {
+		for _, t := range strings.Split(value, ",") {
+			if !notificationTypes[t] {
+				return filter, fmt.Errorf("notification type '%s' is not supported", t)
+			}
+			filter.Types = append(filter.Types, t)
+		}
+	}
+
+	if unread := query.Get("unread"); unread != "" {
+		u, err := strconv.ParseBool(unread)
+		if err != nil {
+			return filter, errors.New("unread should be true or false")
+		}
+		filter.Unread = u
+	}
+
+	return filter, nil
+}
+
+// ListNotificationsHandler lists a page of notifications for a user
 func (a *App) ListNotificationsHandler(req *http.Request) (interface{}, Response) {
 	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
 
-	notifications, err := a.db.ListNotifications(userID)
-	if errors.Is(err, gorm.ErrRecordNotFound) || len(notifications) == 0 {
-		return ResponseMsg{
-			Message: "You don't have any notifications yet",
-			Data:    notifications,
-		}, Ok()
+	filter, err := notificationsFilter(req)
+	if err != nil {
+		return nil, BadRequest(err)
 	}
 
+	// one more notification is loaded to know if there is a next page
+	limit := filter.Limit
+	filter.Limit++
+	notifications, err := a.db.ListNotifications(userID, filter)
 	if err != nil {
 		log.Error().Err(err).Send()
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
 	}
 
+	unread, err := a.db.CountUnreadNotifications(userID)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	page := NotificationsPage{Notifications: notifications, UnreadCount: unread}
+	if len(notifications) > limit {
+		page.Notifications = notifications[:limit]
+		page.NextCursor = page.Notifications[limit-1].ID
+	}
+
+	if len(page.Notifications) == 0 {
+		return ResponseMsg{
+			Message: "You don't have any notifications yet",
+			Data:    page,
+		}, Ok()
+	}
+
 	return ResponseMsg{
 		Message: "You have notifications",
-		Data:    notifications,
+		Data:    page,
 	}, Ok()
 }
 
-// UpdateNotificationsHandler updates notifications for a user
+// UpdateNotificationsHandler marks a notification of a user as seen
 func (a *App) UpdateNotificationsHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+
 	id, err := strconv.Atoi(mux.Vars(req)["id"])
 	if err != nil {
 		log.Error().Err(err).Send()
 		return nil, BadRequest(errors.New("failed to read notification id"))
 	}
 
-	err = a.db.UpdateNotification(id, true)
+	err = a.db.UpdateNotification(id, userID, true)
+	if err == gorm.ErrRecordNotFound {
+		return nil, NotFound(errors.New("notification is not found"))
+	}
 	if err != nil {
 		log.Error().Err(err).Send()
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
@@ -54,3 +154,110 @@ func (a *App) UpdateNotificationsHandler(req *http.Request) (interface{}, Respon
 		Data:    nil,
 	}, Ok()
 }
+
+// ReadAllNotificationsHandler marks all notifications of a user as seen
+func (a *App) ReadAllNotificationsHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+
+	err := a.db.UpdateAllNotifications(userID, true)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "All notifications are marked as seen",
+		Data:    nil,
+	}, Ok()
+}
+
+// DeleteNotificationHandler deletes a notification of a user
+func (a *App) DeleteNotificationHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+
+	id, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, BadRequest(errors.New("failed to read notification id"))
+	}
+
+	err = a.db.DeleteNotification(id, userID)
+	if err == gorm.ErrRecordNotFound {
+		return nil, NotFound(errors.New("notification is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Notification is deleted successfully",
+		Data:    nil,
+	}, Ok()
+}
+
+// StreamNotificationsHandler pushes new notifications of the user as server-sent events
+func (a *App) StreamNotificationsHandler(w http.ResponseWriter, req *http.Request) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		WrapFunc(func(r *http.Request) (interface{}, Response) {
+			return nil, Error(errors.New("streaming is not supported"), http.StatusInternalServerError)
+		})(w, req)
+		return
+	}
+
+	notifications, unsubscribe := a.notifications.Subscribe(userID)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// disable buffering of nginx proxies
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	middlewares.Requests.WithLabelValues(req.Method, req.RequestURI, fmt.Sprint(http.StatusOK)).Inc()
+
+	keepAlive := time.NewTicker(notificationsKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case notification, ok := <-notifications:
+			if !ok {
+				return
+			}
+			if err := writeNotificationEvent(w, notification); err != nil {
+				log.Error().Err(err).Send()
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func writeNotificationEvent(w http.ResponseWriter, notification models.Notification) error {
+	data, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: notification\ndata: %s\n\n", notification.ID, data)
+	return err
+}
+
+// publishNotification pushes a created notification to the user if they are streaming notifications
+func (a *App) publishNotification(notification models.Notification) {
+	if err := streams.PublishNotification(a.queue, notification); err != nil {
+		log.Error().Err(err).Msgf("failed to publish notification: %+v", notification)
+	}
+}
@@ -0,0 +1,218 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codescalers/cloud4students/middlewares"
+	"github.com/codescalers/cloud4students/models"
+	"github.com/codescalers/cloud4students/streams"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotificationHandlers(t *testing.T) {
+	app := SetUp(t)
+
+	user.Verified = true
+	err := app.db.CreateUser(user)
+	assert.NoError(t, err)
+
+	token, err := newAccessToken(app, user.ID.String(), user.Email)
+	assert.NoError(t, err)
+
+	for i, nType := range []string{models.VMsType, models.K8sType, models.VoucherType} {
+		err := app.db.CreateNotification(&models.Notification{UserID: user.ID.String(), Msg: fmt.Sprint(i), Type: nType})
+		assert.NoError(t, err)
+	}
+
+	other := models.Notification{UserID: "other", Msg: "other", Type: models.VMsType}
+	err = app.db.CreateNotification(&other)
+	assert.NoError(t, err)
+
+	newReq := func(handler Handler, api string, id int) authHandlerConfig {
+		return authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: handler,
+				api:         fmt.Sprintf("/%s/notification%s", app.config.Version, api),
+			},
+			userID: user.ID.String(),
+			token:  token,
+			config: app.config,
+			db:     app.db,
+			varID:  id,
+		}
+	}
+
+	listPage := func(api string) (int, NotificationsPage) {
+		response := authorizedHandler(newReq(app.ListNotificationsHandler, api, 0))
+
+		var res struct {
+			Data NotificationsPage `json:"data"`
+		}
+		err := json.NewDecoder(response.Body).Decode(&res)
+		assert.NoError(t, err)
+		return response.Code, res.Data
+	}
+
+	t.Run("List notifications: pages", func(t *testing.T) {
+		code, page := listPage("?limit=2")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Len(t, page.Notifications, 2)
+		assert.Equal(t, "2", page.Notifications[0].Msg)
+		assert.Equal(t, int64(3), page.UnreadCount)
+		assert.NotZero(t, page.NextCursor)
+
+		_, page = listPage(fmt.Sprintf("?limit=2&cursor=%d", page.NextCursor))
+		assert.Len(t, page.Notifications, 1)
+		assert.Equal(t, "0", page.Notifications[0].Msg)
+		assert.Zero(t, page.NextCursor)
+	})
+
+	t.Run("List notifications: by type", func(t *testing.T) {
+		code, page := listPage("?type=k8s,voucher")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Len(t, page.Notifications, 2)
+	})
+
+	t.Run("List notifications: invalid filters", func(t *testing.T) {
+		for _, api := range []string{"?type=unknown", "?limit=0", "?limit=1000", "?cursor=x", "?unread=maybe"} {
+			response := authorizedHandler(newReq(app.ListNotificationsHandler, api, 0))
+			assert.Equal(t, http.StatusBadRequest, response.Code, api)
+		}
+	})
+
+	t.Run("Update notification: not owned", func(t *testing.T) {
+		response := authorizedHandler(newReq(app.UpdateNotificationsHandler, fmt.Sprintf("/%d", other.ID), other.ID))
+		assert.Equal(t, http.StatusNotFound, response.Code)
+
+		n, err := app.db.ListNotifications("other", models.NotificationsFilter{})
+		assert.NoError(t, err)
+		assert.False(t, n[0].Seen)
+	})
+
+	t.Run("Delete notification: not owned", func(t *testing.T) {
+		response := authorizedHandler(newReq(app.DeleteNotificationHandler, fmt.Sprintf("/%d", other.ID), other.ID))
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+
+	t.Run("Update notification: success", func(t *testing.T) {
+		_, page := listPage("")
+		id := page.Notifications[0].ID
+
+		response := authorizedHandler(newReq(app.UpdateNotificationsHandler, fmt.Sprintf("/%d", id), id))
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		_, page = listPage("?unread=true")
+		assert.Len(t, page.Notifications, 2)
+		assert.Equal(t, int64(2), page.UnreadCount)
+	})
+
+	t.Run("Read all notifications: success", func(t *testing.T) {
+		response := authorizedHandler(newReq(app.ReadAllNotificationsHandler, "/read_all", 0))
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		_, page := listPage("")
+		assert.Len(t, page.Notifications, 3)
+		assert.Zero(t, page.UnreadCount)
+
+		count, err := app.db.CountUnreadNotifications("other")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+	})
+
+	t.Run("Delete notification: success", func(t *testing.T) {
+		_, page := listPage("")
+		id := page.Notifications[0].ID
+
+		response := authorizedHandler(newReq(app.DeleteNotificationHandler, fmt.Sprintf("/%d", id), id))
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		_, page = listPage("")
+		assert.Len(t, page.Notifications, 2)
+	})
+}
+
+func TestStreamNotificationsHandler(t *testing.T) {
+	app := SetUp(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go app.notifications.Run(ctx)
+
+	user.Verified = true
+	err := app.db.CreateUser(user)
+	assert.NoError(t, err)
+
+	token, err := newAccessToken(app, user.ID.String(), user.Email)
+	assert.NoError(t, err)
+
+	handler := middlewares.Authorization(app.db, app.config.Token.Secret, app.config.Token.Timeout)(http.HandlerFunc(app.StreamNotificationsHandler))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	t.Run("stream notifications: unauthorized", func(t *testing.T) {
+		response, err := http.Get(server.URL)
+		assert.NoError(t, err)
+		defer response.Body.Close()
+
+		assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+	})
+
+	t.Run("stream notifications: success", func(t *testing.T) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+		assert.NoError(t, err)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", token))
+
+		response, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer response.Body.Close()
+
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		assert.Equal(t, "text/event-stream", response.Header.Get("Content-Type"))
+
+		notification := models.Notification{UserID: user.ID.String(), Msg: "your vm is deployed", Type: models.VMsType}
+		err = app.db.CreateNotification(&notification)
+		assert.NoError(t, err)
+
+		// publish until the hub subscribed to the queue receives it
+		events := make(chan string)
+		go func() {
+			reader := bufio.NewReader(response.Body)
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if strings.HasPrefix(line, "data: ") {
+					events <- line
+					return
+				}
+			}
+		}()
+
+		ticker := time.NewTicker(50 * time.Millisecond)
+		defer ticker.Stop()
+		timeout := time.After(5 * time.Second)
+
+		for {
+			select {
+			case event := <-events:
+				assert.Contains(t, event, "your vm is deployed")
+				return
+			case <-ticker.C:
+				err := streams.PublishNotification(app.queue, notification)
+				assert.NoError(t, err)
+			case <-timeout:
+				t.Fatal("notification is not streamed")
+			}
+		}
+	})
+}
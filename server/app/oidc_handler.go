@@ -0,0 +1,305 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/codescalers/cloud4students/internal"
+	"github.com/codescalers/cloud4students/middlewares"
+	"github.com/codescalers/cloud4students/models"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// oidcLoginTimeout is how long a user has to sign in at the provider
+const oidcLoginTimeout = 10 * time.Minute
+
+// oidcStateCookie ties the state of a sign in to the browser that started it
+const oidcStateCookie = "oidc_state"
+
+// OIDCCallbackInput struct for the data the provider redirected the user with
+type OIDCCallbackInput struct {
+	Code  string `json:"code" binding:"required"`
+	State string `json:"state" binding:"required"`
+}
+
+// OIDCProviderInfo struct for a provider users can sign in with
+type OIDCProviderInfo struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+}
+
+// newOIDCClients creates the clients of the configured providers by their names
+func newOIDCClients(providers []internal.OIDCProvider) map[string]*internal.OIDCClient {
+	clients := make(map[string]*internal.OIDCClient, len(providers))
+	for _, provider := range providers {
+		clients[provider.Name] = internal.NewOIDCClient(provider)
+	}
+	return clients
+}
+
+// ListOIDCProvidersHandler lists the providers users can sign in with
+func (a *App) ListOIDCProvidersHandler(req *http.Request) (interface{}, Response) {
+	providers := []OIDCProviderInfo{}
+	for _, provider := range a.config.OIDC {
+		providers = append(providers, OIDCProviderInfo{Name: provider.Name, DisplayName: provider.DisplayName})
+	}
+
+	return ResponseMsg{
+		Message: "Providers are found",
+		Data:    providers,
+	}, Ok()
+}
+
+// OIDCLoginHandler starts signing in with a provider, the user is redirected to the returned url
+func (a *App) OIDCLoginHandler(req *http.Request) (interface{}, Response) {
+	name := mux.Vars(req)["provider"]
+	client, ok := a.oidc[name]
+	if !ok {
+		return nil, NotFound(errors.New("provider is not found"))
+	}
+
+	state, err := internal.GenerateOpaqueToken()
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	nonce, err := internal.GenerateOpaqueToken()
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	verifier, challenge, err := internal.NewPKCE()
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	authURL, err := client.AuthCodeURL(req.Context(), state, nonce, challenge)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	login := models.OIDCLogin{
+		StateHash:    internal.HashOpaqueToken(state),
+		Provider:     name,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().Add(oidcLoginTimeout),
+	}
+	if err := a.db.CreateOIDCLogin(&login); err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	cookie := a.newOIDCStateCookie(name, hex.EncodeToString(login.StateHash), oidcLoginTimeout)
+	return ResponseMsg{
+		Message: "Please sign in with " + client.Provider().DisplayName,
+		Data:    map[string]string{"authorization_url": authURL},
+	}, Ok().WithHeader("Set-Cookie", cookie.String())
+}
+
+// OIDCCallbackHandler completes signing in with a provider. The account of the provider is linked to the user
+// with its email if the provider verified it, or a new verified user is created
+func (a *App) OIDCCallbackHandler(req *http.Request) (interface{}, Response) {
+	name := mux.Vars(req)["provider"]
+	client, ok := a.oidc[name]
+	if !ok {
+		return nil, NotFound(errors.New("provider is not found"))
+	}
+
+	var input OIDCCallbackInput
+	err := json.NewDecoder(req.Body).Decode(&input)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, BadRequest(errors.New("failed to read sign in data"))
+	}
+
+	if strings.TrimSpace(input.Code) == "" || strings.TrimSpace(input.State) == "" {
+		return nil, BadRequest(errors.New("code and state are required"))
+	}
+
+	// the state must be the one given to this browser, so nobody can sign a user in to their account
+	stateHash := internal.HashOpaqueToken(input.State)
+	cookie, err := req.Cookie(oidcStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(hex.EncodeToString(stateHash))) != 1 {
+		return nil, UnAuthorized(errors.New("sign in is not started from this browser, please sign in again"))
+	}
+
+	login, err := a.db.ConsumeOIDCLogin(stateHash)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+	if err == gorm.ErrRecordNotFound || login.Provider != name || login.ExpiresAt.Before(time.Now()) {
+		return nil, UnAuthorized(errors.New("sign in is expired, please sign in again"))
+	}
+
+	claims, err := client.Exchange(req.Context(), input.Code, login.CodeVerifier, login.Nonce)
+	if err == internal.ErrOIDCEmailNotVerified {
+		return nil, BadRequest(fmt.Errorf("your email is not verified by %s", client.Provider().DisplayName))
+	}
+	if err != nil {
+		log.Error().Err(err).Str("provider", name).Send()
+		return nil, UnAuthorized(fmt.Errorf("failed to sign in with %s", client.Provider().DisplayName))
+	}
+
+	user, created, err := a.oidcUser(name, claims)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	if created {
+		middlewares.UserCreations.WithLabelValues(user.ID.String(), user.Email, user.College, fmt.Sprint(user.TeamSize)).Inc()
+
+		mail, err := internal.WelcomeMailContent(a.config.Server.Host, internal.WelcomeMailData{Name: user.Name})
+		if err != nil {
+			log.Error().Err(err).Send()
+			return nil, InternalServerError(errors.New(internalServerErrorMsg))
+		}
+
+		if err := a.outbox.Enqueue(user.Email, mail); err != nil {
+			log.Error().Err(err).Send()
+			return nil, InternalServerError(errors.New(internalServerErrorMsg))
+		}
+	}
+
+	// the state is used once, so its cookie is removed
+	cookie = a.newOIDCStateCookie(name, "", -1)
+
+	// the session is only created once the two factor code is verified
+	if user.TOTPEnabled {
+		token, err := a.newTwoFactorChallenge(user.ID.String())
+		if err != nil {
+			log.Error().Err(err).Send()
+			return nil, InternalServerError(errors.New(internalServerErrorMsg))
+		}
+
+		return ResponseMsg{
+			Message: "Please enter the code of your authenticator app",
+			Data:    map[string]string{"two_factor_token": token, "email": user.Email},
+		}, Ok().WithHeader("Set-Cookie", cookie.String())
+	}
+
+	tokens, err := a.newSession(req, user)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+	tokens["user_id"] = user.ID.String()
+
+	return ResponseMsg{
+		Message: "You are signed in successfully",
+		Data:    tokens,
+	}, Ok().WithHeader("Set-Cookie", cookie.String())
+}
+
+// newOIDCStateCookie creates the cookie of the state of a sign in with a provider, it's removed if maxAge is negative
+func (a *App) newOIDCStateCookie(provider, value string, maxAge time.Duration) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     fmt.Sprintf("/%s/user/oidc/%s", a.config.Version, provider),
+		MaxAge:   int(maxAge.Seconds()),
+		Secure:   strings.HasPrefix(a.config.Server.Host, "https://"),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if maxAge < 0 {
+		cookie.MaxAge = -1
+	}
+	return cookie
+}
+
+// oidcUser gets the user of a provider account. The account is linked to the user with the same email
+// if there is no user linked to it yet, and a new user is created if there is no such user.
+// It returns true if the account of the user is created or verified by signing in
+func (a *App) oidcUser(provider string, claims internal.OIDCClaims) (models.User, bool, error) {
+	identity, err := a.db.GetUserIdentity(provider, claims.Subject)
+	if err == nil {
+		user, err := a.db.GetUserByID(identity.UserID)
+		if err != nil {
+			return models.User{}, false, err
+		}
+		return user, false, a.fillCollege(&user, claims.College)
+	}
+	if err != gorm.ErrRecordNotFound {
+		return models.User{}, false, err
+	}
+
+	name := claims.Name
+	if name == "" {
+		name = strings.Split(claims.Email, "@")[0]
+	}
+
+	var user models.User
+	created := false
+	err = a.db.Transaction(func(tx models.Store) error {
+		user, err = tx.GetUserByEmail(claims.Email)
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return err
+		}
+
+		switch {
+		case err == gorm.ErrRecordNotFound:
+			user = models.User{
+				Name:     name,
+				Email:    claims.Email,
+				College:  claims.College,
+				Verified: true,
+				Admin:    internal.Contains(a.config.Admins, claims.Email),
+			}
+			if err := tx.CreateUser(&user); err != nil {
+				return err
+			}
+
+			// create empty quota
+			if err := tx.CreateQuota(&models.Quota{UserID: user.ID.String()}); err != nil {
+				return err
+			}
+			created = true
+		case !user.Verified:
+			if err := tx.ClaimUnverifiedUser(user.ID.String(), name, claims.College); err != nil {
+				return err
+			}
+			if user, err = tx.GetUserByID(user.ID.String()); err != nil {
+				return err
+			}
+			created = true
+		}
+
+		return tx.CreateUserIdentity(&models.UserIdentity{
+			UserID:   user.ID.String(),
+			Provider: provider,
+			Subject:  claims.Subject,
+			Email:    claims.Email,
+		})
+	})
+	if err != nil {
+		return models.User{}, false, err
+	}
+
+	return user, created, a.fillCollege(&user, claims.College)
+}
+
+// fillCollege sets the college of the provider for users who didn't set one
+func (a *App) fillCollege(user *models.User, college string) error {
+	if user.College != "" || college == "" {
+		return nil
+	}
+
+	user.College = college
+	return a.db.UpdateUserByID(models.User{ID: user.ID, College: college, UpdatedAt: time.Now()})
+}
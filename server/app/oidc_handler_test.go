@@ -0,0 +1,221 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/codescalers/cloud4students/internal"
+	"github.com/codescalers/cloud4students/internal/oidctest"
+	"github.com/codescalers/cloud4students/models"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOIDCHandlers(t *testing.T) {
+	app := SetUp(t)
+
+	issuer, err := oidctest.NewIssuer()
+	assert.NoError(t, err)
+	defer issuer.Close()
+
+	app.config.OIDC = []internal.OIDCProvider{{
+		Name:        "test",
+		DisplayName: "Test",
+		Issuer:      issuer.URL,
+		ClientID:    "client",
+		RedirectURL: "http://localhost/oidc/test",
+		College:     "university",
+		Scopes:      []string{"openid", "email", "profile"},
+	}}
+	app.oidc = newOIDCClients(app.config.OIDC)
+
+	request := func(handler Handler, provider, body string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/%s/user/oidc/%s", app.config.Version, provider), bytes.NewBuffer([]byte(body)))
+		req = mux.SetURLVars(req, map[string]string{"provider": provider})
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		response := httptest.NewRecorder()
+		WrapFunc(handler).ServeHTTP(response, req)
+		return response
+	}
+
+	// stateCookie is the cookie of the last sign in the browser started
+	var stateCookie *http.Cookie
+
+	// authorize signs in at the provider and returns the code and state it redirects with
+	authorize := func(t *testing.T, claims map[string]interface{}) (string, string) {
+		response := request(app.OIDCLoginHandler, "test", "")
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		cookies := response.Result().Cookies()
+		assert.Len(t, cookies, 1)
+		stateCookie = cookies[0]
+
+		data := map[string]string{}
+		responseData(t, response, &data)
+
+		code, state, err := issuer.Authorize(data["authorization_url"], claims)
+		assert.NoError(t, err)
+		return code, state
+	}
+
+	callback := func(code, state string) *httptest.ResponseRecorder {
+		return request(app.OIDCCallbackHandler, "test", fmt.Sprintf(`{"code":%q,"state":%q}`, code, state), stateCookie)
+	}
+
+	signIn := func(t *testing.T, claims map[string]interface{}) map[string]string {
+		response := callback(authorize(t, claims))
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		data := map[string]string{}
+		responseData(t, response, &data)
+		return data
+	}
+
+	t.Run("oidc: list providers", func(t *testing.T) {
+		response := request(app.ListOIDCProvidersHandler, "", "")
+		want := `{"msg":"Providers are found","data":[{"name":"test","display_name":"Test"}]}` + "\n"
+		assert.Equal(t, response.Body.String(), want)
+		assert.Equal(t, response.Code, http.StatusOK)
+	})
+
+	t.Run("oidc: provider not found", func(t *testing.T) {
+		response := request(app.OIDCLoginHandler, "other", "")
+		want := `{"err":"provider is not found"}` + "\n"
+		assert.Equal(t, response.Body.String(), want)
+		assert.Equal(t, response.Code, http.StatusNotFound)
+	})
+
+	t.Run("oidc: sign up a new user", func(t *testing.T) {
+		data := signIn(t, map[string]interface{}{
+			"sub": "new", "email": "new@gmail.com", "email_verified": true, "name": "new user",
+		})
+		assert.NotEmpty(t, data["access_token"])
+		assert.NotEmpty(t, data["refresh_token"])
+
+		got, err := app.db.GetUserByEmail("new@gmail.com")
+		assert.NoError(t, err)
+		assert.True(t, got.Verified)
+		assert.Equal(t, "new user", got.Name)
+		assert.Equal(t, "university", got.College)
+		assert.Equal(t, got.ID.String(), data["user_id"])
+
+		_, err = app.db.GetUserQuota(got.ID.String())
+		assert.NoError(t, err)
+
+		// signing in again uses the same user
+		again := signIn(t, map[string]interface{}{
+			"sub": "new", "email": "changed@gmail.com", "email_verified": true,
+		})
+		assert.Equal(t, data["user_id"], again["user_id"])
+	})
+
+	t.Run("oidc: link a user by email", func(t *testing.T) {
+		user.Verified = true
+		err := app.db.CreateUser(user)
+		assert.NoError(t, err)
+
+		data := signIn(t, map[string]interface{}{
+			"sub": "linked", "email": user.Email, "email_verified": true,
+		})
+		assert.Equal(t, user.ID.String(), data["user_id"])
+
+		got, err := app.db.GetUserByID(user.ID.String())
+		assert.NoError(t, err)
+		assert.Equal(t, "clg", got.College)
+		assert.Equal(t, user.HashedPassword, got.HashedPassword)
+	})
+
+	t.Run("oidc: claim an unverified user", func(t *testing.T) {
+		unverified := models.User{Name: "someone", Email: "unverified@gmail.com", HashedPassword: []byte("password")}
+		err := app.db.CreateUser(&unverified)
+		assert.NoError(t, err)
+
+		data := signIn(t, map[string]interface{}{
+			"sub": "unverified", "email": unverified.Email, "email_verified": true, "name": "owner",
+		})
+		assert.Equal(t, unverified.ID.String(), data["user_id"])
+
+		// the password of the unverified sign up can't be used
+		got, err := app.db.GetUserByID(unverified.ID.String())
+		assert.NoError(t, err)
+		assert.True(t, got.Verified)
+		assert.Empty(t, got.HashedPassword)
+		assert.Equal(t, "owner", got.Name)
+	})
+
+	t.Run("oidc: email not verified", func(t *testing.T) {
+		response := callback(authorize(t, map[string]interface{}{"sub": "other", "email": "other@gmail.com"}))
+		want := `{"err":"your email is not verified by Test"}` + "\n"
+		assert.Equal(t, response.Body.String(), want)
+		assert.Equal(t, response.Code, http.StatusBadRequest)
+
+		_, err := app.db.GetUserByEmail("other@gmail.com")
+		assert.Error(t, err)
+	})
+
+	t.Run("oidc: state is used once", func(t *testing.T) {
+		code, state := authorize(t, map[string]interface{}{"sub": "new", "email": "new@gmail.com", "email_verified": true})
+		response := callback(code, state)
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		response = callback(code, state)
+		want := `{"err":"sign in is expired, please sign in again"}` + "\n"
+		assert.Equal(t, response.Body.String(), want)
+		assert.Equal(t, response.Code, http.StatusUnauthorized)
+	})
+
+	t.Run("oidc: state is tied to the browser", func(t *testing.T) {
+		claims := map[string]interface{}{"sub": "new", "email": "new@gmail.com", "email_verified": true}
+		code, state := authorize(t, claims)
+		assert.True(t, stateCookie.HttpOnly)
+		assert.Equal(t, http.SameSiteLaxMode, stateCookie.SameSite)
+
+		// the state is started by another browser
+		response := request(app.OIDCCallbackHandler, "test", fmt.Sprintf(`{"code":%q,"state":%q}`, code, state))
+		want := `{"err":"sign in is not started from this browser, please sign in again"}` + "\n"
+		assert.Equal(t, response.Body.String(), want)
+		assert.Equal(t, response.Code, http.StatusUnauthorized)
+
+		// the cookie is of another sign in
+		otherCode, otherState := authorize(t, claims)
+		response = callback(code, state)
+		assert.Equal(t, response.Body.String(), want)
+		assert.Equal(t, response.Code, http.StatusUnauthorized)
+
+		response = callback(otherCode, otherState)
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		cookies := response.Result().Cookies()
+		assert.Len(t, cookies, 1)
+		assert.Equal(t, -1, cookies[0].MaxAge)
+	})
+
+	t.Run("oidc: wrong code", func(t *testing.T) {
+		_, state := authorize(t, map[string]interface{}{"sub": "new", "email": "new@gmail.com", "email_verified": true})
+		response := callback("wrong", state)
+		want := `{"err":"failed to sign in with Test"}` + "\n"
+		assert.Equal(t, response.Body.String(), want)
+		assert.Equal(t, response.Code, http.StatusUnauthorized)
+	})
+
+	t.Run("oidc: two factor authentication", func(t *testing.T) {
+		secret, err := internal.GenerateTOTPSecret()
+		assert.NoError(t, err)
+		err = app.db.SetTOTPSecret(user.ID.String(), secret)
+		assert.NoError(t, err)
+		err = app.db.EnableTOTP(user.ID.String(), internal.TOTPStep(time.Now()), nil)
+		assert.NoError(t, err)
+
+		data := signIn(t, map[string]interface{}{"sub": "linked", "email": user.Email, "email_verified": true})
+		assert.Empty(t, data["access_token"])
+		assert.NotEmpty(t, data["two_factor_token"])
+		assert.Equal(t, user.Email, data["email"])
+	})
+}
@@ -10,11 +10,11 @@ import (
 	"gorm.io/gorm"
 )
 
-// GetQuotaHandler gets quota
+// GetQuotaHandler gets the available, reserved and used quota
 func (a *App) GetQuotaHandler(req *http.Request) (interface{}, Response) {
 	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
 
-	quota, err := a.db.GetUserQuota(userID)
+	quota, err := a.db.GetUserQuotaUsage(userID)
 	if err == gorm.ErrRecordNotFound {
 		return nil, NotFound(errors.New("user quota is not found"))
 	}
@@ -0,0 +1,179 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/codescalers/cloud4students/middlewares"
+	"github.com/codescalers/cloud4students/models"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// RoleInput struct for data needed to assign a role to a user
+type RoleInput struct {
+	Email string      `json:"email" binding:"required"`
+	Role  models.Role `json:"role" binding:"required"`
+}
+
+// RoleInfo struct for a role and its permissions
+type RoleInfo struct {
+	Role        models.Role         `json:"role"`
+	Permissions []models.Permission `json:"permissions"`
+}
+
+// twoFactorRequired checks if the user has to use two factor authentication as they have roles
+func (a *App) twoFactorRequired(userID string) (bool, error) {
+	if !a.config.TwoFactor.RequiredForAdmins {
+		return false, nil
+	}
+
+	roles, err := a.db.ListUserRoles(userID)
+	return len(roles) > 0, err
+}
+
+// staffWithPermission lists the users who have roles with the permission
+func (a *App) staffWithPermission(permission models.Permission) ([]models.User, error) {
+	staff, err := a.db.ListStaff()
+	if err != nil {
+		return nil, err
+	}
+
+	var users []models.User
+	for _, s := range staff {
+		if models.HasPermission(s.Roles, permission) {
+			users = append(users, s.User)
+		}
+	}
+	return users, nil
+}
+
+// ListRolesHandler lists the roles and their permissions
+func (a *App) ListRolesHandler(req *http.Request) (interface{}, Response) {
+	roles := []RoleInfo{{Role: models.SuperAdminRole, Permissions: models.Permissions}}
+	for role, permissions := range models.RolePermissions {
+		roles = append(roles, RoleInfo{Role: role, Permissions: permissions})
+	}
+	sort.Slice(roles[1:], func(i, j int) bool { return roles[i+1].Role < roles[j+1].Role })
+
+	return ResponseMsg{
+		Message: "Roles are found",
+		Data:    roles,
+	}, Ok()
+}
+
+// ListStaffHandler lists the users who have roles
+func (a *App) ListStaffHandler(req *http.Request) (interface{}, Response) {
+	staff, err := a.db.ListStaff()
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Users with roles are found",
+		Data:    staff,
+	}, Ok()
+}
+
+// readRoleInput reads the role and the user of a role request
+func (a *App) readRoleInput(req *http.Request) (RoleInput, models.User, Response) {
+	var input RoleInput
+	err := json.NewDecoder(req.Body).Decode(&input)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return input, models.User{}, BadRequest(errors.New("failed to read role data"))
+	}
+
+	if !input.Role.Valid() {
+		return input, models.User{}, BadRequest(fmt.Errorf("role '%s' is not found", input.Role))
+	}
+
+	user, err := a.db.GetUserByEmail(input.Email)
+	if err == gorm.ErrRecordNotFound {
+		return input, models.User{}, NotFound(errors.New("user is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return input, models.User{}, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return input, user, nil
+}
+
+// AssignRoleHandler assigns a role to a user, the superadmin role sets the user as an admin
+func (a *App) AssignRoleHandler(req *http.Request) (interface{}, Response) {
+	input, user, res := a.readRoleInput(req)
+	if res != nil {
+		return nil, res
+	}
+
+	added := !user.Admin
+	var err error
+	if input.Role == models.SuperAdminRole {
+		if added {
+			err = a.db.UpdateAdminUserByID(user.ID.String(), true)
+		}
+	} else {
+		added, err = a.db.AddUserRole(user.ID.String(), input.Role)
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	if !added {
+		return ResponseMsg{
+			Message: fmt.Sprintf("User already has the role %s", input.Role),
+		}, Ok()
+	}
+
+	middlewares.AuditChange(req, "user="+user.Email, nil, map[string]models.Role{"role": input.Role})
+
+	return ResponseMsg{
+		Message: fmt.Sprintf("Role %s is assigned successfully", input.Role),
+	}, Ok()
+}
+
+// UnassignRoleHandler removes a role of a user, users have to sign in again once they lose a role
+func (a *App) UnassignRoleHandler(req *http.Request) (interface{}, Response) {
+	input, user, res := a.readRoleInput(req)
+	if res != nil {
+		return nil, res
+	}
+
+	var err error
+	if input.Role == models.SuperAdminRole {
+		err = gorm.ErrRecordNotFound
+		if user.Admin {
+			err = a.db.UpdateAdminUserByID(user.ID.String(), false)
+		}
+	} else {
+		err = a.db.RemoveUserRole(user.ID.String(), input.Role)
+	}
+	if err == gorm.ErrRecordNotFound {
+		return nil, NotFound(fmt.Errorf("user doesn't have the role %s", input.Role))
+	}
+	if err == models.ErrLastSuperAdmin {
+		return nil, BadRequest(errors.New("the last superadmin can't be removed"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	middlewares.AuditChange(req, "user="+user.Email, map[string]models.Role{"role": input.Role}, nil)
+
+	if err := a.db.RevokeUserSessions(user.ID.String(), ""); err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: fmt.Sprintf("Role %s is removed successfully", input.Role),
+	}, Ok()
+}
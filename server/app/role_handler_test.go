@@ -0,0 +1,150 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/codescalers/cloud4students/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoleHandlers(t *testing.T) {
+	app := SetUp(t)
+
+	admin := models.User{Name: "admin", Email: "admin@gmail.com", Verified: true, Admin: true}
+	err := app.db.CreateUser(&admin)
+	assert.NoError(t, err)
+
+	reviewer := models.User{Name: "reviewer", Email: "reviewer@gmail.com", Verified: true}
+	err = app.db.CreateUser(&reviewer)
+	assert.NoError(t, err)
+
+	adminToken, err := newAccessToken(app, admin.ID.String(), admin.Email)
+	assert.NoError(t, err)
+
+	reviewerToken, err := newAccessToken(app, reviewer.ID.String(), reviewer.Email)
+	assert.NoError(t, err)
+
+	request := func(token string, permission models.Permission, handler Handler, body string) *httptest.ResponseRecorder {
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				body:        bytes.NewBuffer([]byte(body)),
+				handlerFunc: handler,
+				api:         fmt.Sprintf("/%s/role", app.config.Version),
+			},
+			token:      token,
+			config:     app.config,
+			db:         app.db,
+			permission: permission,
+		}
+		return adminHandler(req)
+	}
+
+	t.Run("roles: list roles", func(t *testing.T) {
+		response := request(adminToken, models.ManageRolesPermission, app.ListRolesHandler, "")
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		var roles []RoleInfo
+		responseData(t, response, &roles)
+		assert.Len(t, roles, 4)
+		assert.Equal(t, models.SuperAdminRole, roles[0].Role)
+		assert.Equal(t, models.Permissions, roles[0].Permissions)
+	})
+
+	t.Run("roles: no roles", func(t *testing.T) {
+		response := request(reviewerToken, models.ReadVouchersPermission, app.ListVouchersHandler, "")
+		want := `{"err":"user 'reviewer' doesn't have an admin access"}` + "\n"
+		assert.Equal(t, response.Body.String(), want)
+		assert.Equal(t, response.Code, http.StatusUnauthorized)
+	})
+
+	t.Run("roles: assign a role", func(t *testing.T) {
+		body := `{"email":"reviewer@gmail.com","role":"voucher-reviewer"}`
+		response := request(adminToken, models.ManageRolesPermission, app.AssignRoleHandler, body)
+		want := `{"msg":"Role voucher-reviewer is assigned successfully"}` + "\n"
+		assert.Equal(t, response.Body.String(), want)
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		response = request(adminToken, models.ManageRolesPermission, app.AssignRoleHandler, body)
+		want = `{"msg":"User already has the role voucher-reviewer"}` + "\n"
+		assert.Equal(t, response.Body.String(), want)
+	})
+
+	t.Run("roles: unknown role", func(t *testing.T) {
+		body := `{"email":"reviewer@gmail.com","role":"owner"}`
+		response := request(adminToken, models.ManageRolesPermission, app.AssignRoleHandler, body)
+		want := `{"err":"role 'owner' is not found"}` + "\n"
+		assert.Equal(t, response.Body.String(), want)
+		assert.Equal(t, response.Code, http.StatusBadRequest)
+	})
+
+	t.Run("roles: permissions of a role", func(t *testing.T) {
+		response := request(reviewerToken, models.ReadVouchersPermission, app.ListVouchersHandler, "")
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		response = request(reviewerToken, models.DeleteDeploymentsPermission, app.DeleteAllDeployments, "")
+		want := `{"err":"user doesn't have the 'deployments:delete' permission"}` + "\n"
+		assert.Equal(t, response.Body.String(), want)
+		assert.Equal(t, response.Code, http.StatusForbidden)
+
+		response = request(reviewerToken, models.ManageRolesPermission, app.AssignRoleHandler, `{"email":"reviewer@gmail.com","role":"superadmin"}`)
+		assert.Equal(t, response.Code, http.StatusForbidden)
+	})
+
+	t.Run("roles: list staff", func(t *testing.T) {
+		response := request(adminToken, models.ManageRolesPermission, app.ListStaffHandler, "")
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		var staff []models.StaffUser
+		responseData(t, response, &staff)
+		assert.Len(t, staff, 2)
+		assert.Equal(t, []models.Role{models.VoucherReviewerRole}, staff[1].Roles)
+	})
+
+	t.Run("roles: unassign a role", func(t *testing.T) {
+		body := `{"email":"reviewer@gmail.com","role":"voucher-reviewer"}`
+		response := request(adminToken, models.ManageRolesPermission, app.UnassignRoleHandler, body)
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		// the sessions of the user are revoked
+		response = request(reviewerToken, models.ReadVouchersPermission, app.ListVouchersHandler, "")
+		assert.Equal(t, response.Code, http.StatusUnauthorized)
+
+		response = request(adminToken, models.ManageRolesPermission, app.UnassignRoleHandler, body)
+		want := `{"err":"user doesn't have the role voucher-reviewer"}` + "\n"
+		assert.Equal(t, response.Body.String(), want)
+		assert.Equal(t, response.Code, http.StatusNotFound)
+	})
+
+	t.Run("roles: superadmin is the admin flag", func(t *testing.T) {
+		body := `{"email":"reviewer@gmail.com","role":"superadmin"}`
+		response := request(adminToken, models.ManageRolesPermission, app.AssignRoleHandler, body)
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		got, err := app.db.GetUserByEmail(reviewer.Email)
+		assert.NoError(t, err)
+		assert.True(t, got.Admin)
+
+		response = request(adminToken, models.ManageRolesPermission, app.UnassignRoleHandler, body)
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		got, err = app.db.GetUserByEmail(reviewer.Email)
+		assert.NoError(t, err)
+		assert.False(t, got.Admin)
+	})
+	t.Run("roles: the last superadmin can't be removed", func(t *testing.T) {
+		body := `{"email":"admin@gmail.com","role":"superadmin"}`
+		response := request(adminToken, models.ManageRolesPermission, app.UnassignRoleHandler, body)
+		want := `{"err":"the last superadmin can't be removed"}` + "\n"
+		assert.Equal(t, response.Body.String(), want)
+		assert.Equal(t, response.Code, http.StatusBadRequest)
+
+		got, err := app.db.GetUserByEmail(admin.Email)
+		assert.NoError(t, err)
+		assert.True(t, got.Admin)
+	})
+}
@@ -0,0 +1,206 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/codescalers/cloud4students/internal"
+	"github.com/codescalers/cloud4students/middlewares"
+	"github.com/codescalers/cloud4students/models"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// RefreshTokenInput struct for the refresh token of a session
+type RefreshTokenInput struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// newSession signs the user in a new session, it returns the access and refresh tokens of the session
+func (a *App) newSession(req *http.Request, user models.User) (map[string]string, error) {
+	refreshToken, err := internal.GenerateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := models.Session{
+		ID:         uuid.NewString(),
+		UserID:     user.ID.String(),
+		UserAgent:  req.UserAgent(),
+		IP:         middlewares.ClientIP(req, a.config.RateLimits.TrustProxyHeaders),
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(time.Duration(a.config.Token.RefreshTimeout) * time.Minute),
+	}
+	if err := a.db.CreateSession(&session, &models.RefreshToken{TokenHash: internal.HashOpaqueToken(refreshToken)}); err != nil {
+		return nil, err
+	}
+
+	accessToken, err := internal.CreateJWT(user.ID.String(), user.Email, session.ID, a.config.Token.Secret, a.config.Token.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{"access_token": accessToken, "refresh_token": refreshToken}, nil
+}
+
+// revokeOtherSessions revokes the sessions of the user with the email except the session of the request
+func (a *App) revokeOtherSessions(req *http.Request, email string) error {
+	user, err := a.db.GetUserByEmail(email)
+	if err != nil {
+		return err
+	}
+
+	sessionID, _ := req.Context().Value(middlewares.SessionIDKey("SessionID")).(string)
+	return a.db.RevokeUserSessions(user.ID.String(), sessionID)
+}
+
+// RefreshJWTHandler rotates the refresh token of a session and creates a new access token.
+// A refresh token can only be used once, using it again revokes its session as it may be stolen
+func (a *App) RefreshJWTHandler(req *http.Request) (interface{}, Response) {
+	var input RefreshTokenInput
+	err := json.NewDecoder(req.Body).Decode(&input)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, BadRequest(errors.New("failed to read refresh token"))
+	}
+
+	if strings.TrimSpace(input.RefreshToken) == "" {
+		return nil, BadRequest(errors.New("refresh token is required"))
+	}
+
+	token, err := a.db.GetRefreshToken(internal.HashOpaqueToken(input.RefreshToken))
+	if err == gorm.ErrRecordNotFound {
+		return nil, UnAuthorized(errors.New("refresh token is invalid"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	session, err := a.db.GetSession(token.SessionID)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	if !session.Active() {
+		return nil, UnAuthorized(errors.New("session is revoked, please sign in again"))
+	}
+
+	if token.UsedAt != nil {
+		return nil, a.revokeReusedSession(session)
+	}
+
+	user, err := a.db.GetUserByID(session.UserID)
+	if err == gorm.ErrRecordNotFound {
+		return nil, NotFound(errors.New("user is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	refreshToken, err := internal.GenerateOpaqueToken()
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	rotated, err := a.db.RotateRefreshToken(token.ID, &models.RefreshToken{SessionID: session.ID, TokenHash: internal.HashOpaqueToken(refreshToken)})
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+	// the token is used by another request in the meantime
+	if !rotated {
+		return nil, a.revokeReusedSession(session)
+	}
+
+	accessToken, err := internal.CreateJWT(user.ID.String(), user.Email, session.ID, a.config.Token.Secret, a.config.Token.Timeout)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Token is refreshed successfully",
+		Data:    map[string]string{"access_token": accessToken, "refresh_token": refreshToken},
+	}, Ok()
+}
+
+// revokeReusedSession revokes the session of a refresh token that is used twice
+func (a *App) revokeReusedSession(session models.Session) Response {
+	log.Warn().Str("session", session.ID).Str("user", session.UserID).Msg("refresh token is reused, revoking its session")
+
+	if err := a.db.RevokeSession(session.ID, session.UserID); err != nil && err != gorm.ErrRecordNotFound {
+		log.Error().Err(err).Send()
+		return InternalServerError(errors.New(internalServerErrorMsg))
+	}
+	return UnAuthorized(errors.New("refresh token is already used, please sign in again"))
+}
+
+// LogoutHandler revokes the session of the user's token
+func (a *App) LogoutHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+	sessionID := req.Context().Value(middlewares.SessionIDKey("SessionID")).(string)
+
+	err := a.db.RevokeSession(sessionID, userID)
+	if err == gorm.ErrRecordNotFound {
+		return nil, NotFound(errors.New("session is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "You are signed out successfully",
+	}, Ok()
+}
+
+// ListSessionsHandler lists the active sessions of the user
+func (a *App) ListSessionsHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+	sessionID, _ := req.Context().Value(middlewares.SessionIDKey("SessionID")).(string)
+
+	sessions, err := a.db.ListSessions(userID)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == sessionID
+	}
+
+	return ResponseMsg{
+		Message: "Sessions are found",
+		Data:    sessions,
+	}, Ok()
+}
+
+// RevokeSessionHandler revokes a session of the user
+func (a *App) RevokeSessionHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+	id := mux.Vars(req)["id"]
+
+	err := a.db.RevokeSession(id, userID)
+	if err == gorm.ErrRecordNotFound {
+		return nil, NotFound(errors.New("session is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Session is revoked successfully",
+	}, Ok()
+}
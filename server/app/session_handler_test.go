@@ -0,0 +1,150 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionHandlers(t *testing.T) {
+	app := SetUp(t)
+
+	user.Verified = true
+	err := app.db.CreateUser(user)
+	assert.NoError(t, err)
+
+	signIn := func(t *testing.T) string {
+		req := unAuthHandlerConfig{
+			body:        bytes.NewBuffer([]byte(`{"email":"name@gmail.com","password":"1234567"}`)),
+			handlerFunc: app.SignInHandler,
+			api:         fmt.Sprintf("/%s/user/signin", app.config.Version),
+		}
+
+		response := unAuthorizedHandler(req)
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		var res struct {
+			Data map[string]string `json:"data"`
+		}
+		assert.NoError(t, json.NewDecoder(response.Body).Decode(&res))
+		return res.Data["access_token"]
+	}
+
+	token := signIn(t)
+	otherToken := signIn(t)
+	var otherSession string
+
+	t.Run("list sessions: success", func(t *testing.T) {
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: app.ListSessionsHandler,
+				api:         fmt.Sprintf("/%s/user/sessions", app.config.Version),
+			},
+			token:  token,
+			config: app.config,
+			db:     app.db,
+		}
+
+		response := authorizedHandler(req)
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		var res struct {
+			Data []struct {
+				ID      string `json:"id"`
+				Current bool   `json:"current"`
+			} `json:"data"`
+		}
+		assert.NoError(t, json.NewDecoder(response.Body).Decode(&res))
+		assert.Len(t, res.Data, 2)
+
+		current := 0
+		for _, s := range res.Data {
+			if s.Current {
+				current++
+			} else {
+				otherSession = s.ID
+			}
+		}
+		assert.Equal(t, 1, current)
+	})
+
+	t.Run("revoke session: not found", func(t *testing.T) {
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: app.RevokeSessionHandler,
+				api:         fmt.Sprintf("/%s/user/sessions/unknown", app.config.Version),
+			},
+			token:  token,
+			config: app.config,
+			db:     app.db,
+			vars:   map[string]string{"id": "unknown"},
+		}
+
+		response := authorizedHandler(req)
+		want := `{"err":"session is not found"}` + "\n"
+		assert.Equal(t, response.Body.String(), want)
+		assert.Equal(t, response.Code, http.StatusNotFound)
+	})
+
+	t.Run("revoke session: success", func(t *testing.T) {
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: app.RevokeSessionHandler,
+				api:         fmt.Sprintf("/%s/user/sessions/%s", app.config.Version, otherSession),
+			},
+			token:  token,
+			config: app.config,
+			db:     app.db,
+			vars:   map[string]string{"id": otherSession},
+		}
+
+		response := authorizedHandler(req)
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		// the token of the revoked session is rejected
+		req.token = otherToken
+		response = authorizedHandler(req)
+		want := `{"err":"session is revoked, please sign in again"}` + "\n"
+		assert.Equal(t, response.Body.String(), want)
+		assert.Equal(t, response.Code, http.StatusUnauthorized)
+	})
+
+	t.Run("logout: success", func(t *testing.T) {
+		otherToken = signIn(t)
+
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: app.LogoutHandler,
+				api:         fmt.Sprintf("/%s/user/logout", app.config.Version),
+			},
+			token:  otherToken,
+			config: app.config,
+			db:     app.db,
+		}
+
+		response := authorizedHandler(req)
+		assert.Equal(t, response.Code, http.StatusOK)
+	})
+
+	t.Run("logout: revoked token is rejected", func(t *testing.T) {
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: app.LogoutHandler,
+				api:         fmt.Sprintf("/%s/user/logout", app.config.Version),
+			},
+			token:  otherToken,
+			config: app.config,
+			db:     app.db,
+		}
+
+		response := authorizedHandler(req)
+		want := `{"err":"session is revoked, please sign in again"}` + "\n"
+		assert.Equal(t, response.Body.String(), want)
+		assert.Equal(t, response.Code, http.StatusUnauthorized)
+	})
+}
@@ -5,20 +5,21 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
-
 	"testing"
+	"time"
 
 	c4sDeployer "github.com/codescalers/cloud4students/deployer"
 	"github.com/codescalers/cloud4students/internal"
 	"github.com/codescalers/cloud4students/middlewares"
 	"github.com/codescalers/cloud4students/models"
 	"github.com/codescalers/cloud4students/streams"
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
-	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/deployer"
 )
 
 type authHandlerConfig struct {
@@ -26,8 +27,14 @@ type authHandlerConfig struct {
 	userID string
 	token  string
 	config internal.Configuration
-	db     models.DB
+	db     models.Store
 	varID  int
+	// vars are set as url vars of the request
+	vars map[string]string
+	// permission is required by adminHandler if it is set
+	permission models.Permission
+	// action records the request in the audit log by adminHandler if it is set
+	action models.AuditAction
 }
 
 type unAuthHandlerConfig struct {
@@ -53,8 +60,9 @@ func SetUp(t testing.TB) *App {
 		"redisPass": ""		
 	},
 	"mailSender": {
-      "email": "email",
-      "sendgrid_key": "my sendgrid_key",
+      "email": "sender@gmail.com",
+      "provider": "file",
+      "dir": "%s",
       "timeout": 60 
     },
     "account": {
@@ -70,7 +78,7 @@ func SetUp(t testing.TB) *App {
     },
 	"version": "v1"
 }
-	`, dbPath)
+	`, filepath.Join(dir, "mails"), dbPath)
 
 	err := os.WriteFile(configPath, []byte(config), 0644)
 	assert.NoError(t, err)
@@ -85,18 +93,22 @@ func SetUp(t testing.TB) *App {
 	err = db.Migrate()
 	assert.NoError(t, err)
 
-	tfPluginClient, err := deployer.NewTFPluginClient(configuration.Account.Mnemonics, deployer.WithNetwork(configuration.Account.Network))
+	mailer, err := internal.NewMailer(configuration.MailSender)
 	assert.NoError(t, err)
 
-	newDeployer, err := c4sDeployer.NewDeployer(db, streams.RedisClient{}, tfPluginClient)
+	queue := streams.NewMemoryQueue()
+	newDeployer, err := c4sDeployer.NewDeployer(db, queue, c4sDeployer.NewFakeGridBackend(), configuration.DeployRetry)
 	assert.NoError(t, err)
 
 	app := &App{
-		config:   configuration,
-		server:   server{},
-		db:       db,
-		redis:    streams.RedisClient{},
-		deployer: newDeployer,
+		config:        configuration,
+		server:        server{},
+		db:            db,
+		queue:         queue,
+		deployer:      newDeployer,
+		outbox:        internal.NewOutbox(db, mailer, configuration.MailSender.Outbox),
+		notifications: streams.NewNotificationHub(queue),
+		rateLimits:    middlewares.NewMemoryRateLimitStore(),
 	}
 
 	return app
@@ -130,6 +142,9 @@ func authorizedHandler(req authHandlerConfig) (response *httptest.ResponseRecord
 			"id": fmt.Sprint(req.varID),
 		})
 	}
+	if req.vars != nil {
+		request = mux.SetURLVars(request, req.vars)
+	}
 
 	request.Header.Set("Authorization", fmt.Sprintf("Bearer %v", req.token))
 	response = httptest.NewRecorder()
@@ -142,12 +157,44 @@ func authorizedHandler(req authHandlerConfig) (response *httptest.ResponseRecord
 
 func adminHandler(req authHandlerConfig) (response *httptest.ResponseRecorder) {
 	request := httptest.NewRequest("GET", req.api, req.body)
+	if req.vars != nil {
+		request = mux.SetURLVars(request, req.vars)
+	}
 	request.Header.Set("Authorization", fmt.Sprintf("Bearer %v", req.token))
 	response = httptest.NewRecorder()
 
-	handler := WrapFunc(req.handlerFunc)
-	handlerWithAdmin := middlewares.AdminAccess(req.db)(handler)
+	var handler http.Handler = WrapFunc(req.handlerFunc)
+	if req.permission != "" {
+		handler = middlewares.RequirePermission(req.permission)(handler)
+	}
+	if req.action != "" {
+		handler = middlewares.Audit(req.db, req.action, false)(handler)
+	}
+	handlerWithAdmin := middlewares.AdminAccess(req.db, req.config.TwoFactor.RequiredForAdmins)(handler)
 	handlerWithAuth := middlewares.Authorization(req.db, req.config.Token.Secret, req.config.Token.Timeout)(handlerWithAdmin)
 	handlerWithAuth.ServeHTTP(response, request)
 	return
 }
+
+// newAccessToken signs the user in a new session and returns the access token of the session
+func newAccessToken(app *App, userID, email string) (string, error) {
+	now := time.Now()
+	session := models.Session{
+		ID:         uuid.NewString(),
+		UserID:     userID,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(time.Hour),
+	}
+
+	refreshToken, err := internal.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = app.db.CreateSession(&session, &models.RefreshToken{TokenHash: internal.HashOpaqueToken(refreshToken)})
+	if err != nil {
+		return "", err
+	}
+
+	return internal.CreateJWT(userID, email, session.ID, app.config.Token.Secret, app.config.Token.Timeout)
+}
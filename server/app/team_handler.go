@@ -0,0 +1,508 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/codescalers/cloud4students/internal"
+	"github.com/codescalers/cloud4students/middlewares"
+	"github.com/codescalers/cloud4students/models"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/validator.v2"
+	"gorm.io/gorm"
+)
+
+// TeamInput struct for data needed to create a team
+type TeamInput struct {
+	Name string `json:"name" binding:"required" validate:"min=3,max=30"`
+}
+
+// TeamInvitationInput struct for data needed to invite a user to a team
+type TeamInvitationInput struct {
+	Email string `json:"email" binding:"required" validate:"mail"`
+}
+
+// TeamDetails struct holds a team with its members and pending invitations
+type TeamDetails struct {
+	models.Team
+	Role        models.TeamRole         `json:"role"`
+	Members     []models.TeamMemberUser `json:"members"`
+	Invitations []models.TeamInvitation `json:"invitations"`
+}
+
+// teamMember gets the membership of the user in the team of the request, users only see the teams they are members of
+func (a *App) teamMember(teamID int, userID string) (models.TeamMember, Response) {
+	member, err := a.db.GetTeamMember(teamID, userID)
+	if err == gorm.ErrRecordNotFound {
+		return models.TeamMember{}, NotFound(errors.New("team is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return models.TeamMember{}, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+	return member, nil
+}
+
+// teamOwner checks that the user is the owner of the team
+func (a *App) teamOwner(teamID int, userID string) Response {
+	member, res := a.teamMember(teamID, userID)
+	if res != nil {
+		return res
+	}
+	if member.Role != models.TeamOwnerRole {
+		return Forbidden(errors.New("only the owner of the team can do this"))
+	}
+	return nil
+}
+
+// deploymentQuota gets the available quota of the user, or of the team if the deployment is owned by a team
+func (a *App) deploymentQuota(userID string, teamID int) (models.Resources, Response) {
+	if teamID == 0 {
+		quota, err := a.db.GetUserQuota(userID)
+		if err == gorm.ErrRecordNotFound {
+			return models.Resources{}, NotFound(errors.New("user quota is not found"))
+		}
+		if err != nil {
+			log.Error().Err(err).Send()
+			return models.Resources{}, InternalServerError(errors.New(internalServerErrorMsg))
+		}
+		return quota.Resources, nil
+	}
+
+	if _, res := a.teamMember(teamID, userID); res != nil {
+		return models.Resources{}, res
+	}
+
+	team, err := a.db.GetTeam(teamID)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return models.Resources{}, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+	return team.Resources, nil
+}
+
+// canAccessDeployment checks if the user can see a deployment, or manage it if manage is set.
+// Team members see all team deployments, and manage the ones they created unless they are the owner
+// of the team who manages all of them
+func (a *App) canAccessDeployment(userID, deploymentUserID string, teamID int, manage bool) (bool, error) {
+	if teamID == 0 {
+		return deploymentUserID == userID, nil
+	}
+
+	member, err := a.db.GetTeamMember(teamID, userID)
+	if err == gorm.ErrRecordNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return !manage || deploymentUserID == userID || member.Role == models.TeamOwnerRole, nil
+}
+
+// teamID reads the team id from the url
+func teamID(req *http.Request) (int, error) {
+	id, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		return 0, errors.New("failed to read team id")
+	}
+	return id, nil
+}
+
+// CreateTeamHandler creates a new team owned by the user
+func (a *App) CreateTeamHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+
+	var input TeamInput
+	err := json.NewDecoder(req.Body).Decode(&input)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, BadRequest(errors.New("failed to read team data"))
+	}
+
+	err = validator.Validate(input)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, BadRequest(errors.New("invalid team data, name should be 3 to 30 characters"))
+	}
+
+	team := models.Team{Name: input.Name, OwnerID: userID}
+	if err := a.db.CreateTeam(&team); err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Team is created successfully",
+		Data:    team,
+	}, Created()
+}
+
+// ListTeamsHandler lists the teams of the user
+func (a *App) ListTeamsHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+
+	teams, err := a.db.ListUserTeams(userID)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	if len(teams) == 0 {
+		return ResponseMsg{
+			Message: "Teams are not found",
+			Data:    teams,
+		}, Ok()
+	}
+
+	return ResponseMsg{
+		Message: "Teams are found",
+		Data:    teams,
+	}, Ok()
+}
+
+// GetTeamHandler gets a team of the user with its quota, members and pending invitations
+func (a *App) GetTeamHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+	id, err := teamID(req)
+	if err != nil {
+		return nil, BadRequest(err)
+	}
+
+	member, res := a.teamMember(id, userID)
+	if res != nil {
+		return nil, res
+	}
+
+	team, err := a.db.GetTeam(id)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	members, err := a.db.ListTeamMembers(id)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	invitations, err := a.db.ListTeamInvitations(id)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Team is found",
+		Data:    TeamDetails{Team: team, Role: member.Role, Members: members, Invitations: invitations},
+	}, Ok()
+}
+
+// DeleteTeamHandler deletes a team of the user, its deployments have to be deleted first
+func (a *App) DeleteTeamHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+	id, err := teamID(req)
+	if err != nil {
+		return nil, BadRequest(err)
+	}
+
+	if res := a.teamOwner(id, userID); res != nil {
+		return nil, res
+	}
+
+	err = a.db.DeleteTeam(id)
+	if err == models.ErrTeamHasDeployments {
+		return nil, BadRequest(errors.New("team has deployments, please delete them first"))
+	}
+	if err == gorm.ErrRecordNotFound {
+		return nil, NotFound(errors.New("team is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Team is deleted successfully",
+	}, Ok()
+}
+
+// InviteTeamMemberHandler invites an email to a team of the user by an email and a notification
+func (a *App) InviteTeamMemberHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+	id, err := teamID(req)
+	if err != nil {
+		return nil, BadRequest(err)
+	}
+
+	var input TeamInvitationInput
+	err = json.NewDecoder(req.Body).Decode(&input)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, BadRequest(errors.New("failed to read invitation data"))
+	}
+
+	err = validator.Validate(input)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, BadRequest(errors.New("invalid email"))
+	}
+
+	if res := a.teamOwner(id, userID); res != nil {
+		return nil, res
+	}
+
+	team, err := a.db.GetTeam(id)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	owner, err := a.db.GetUserByID(userID)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	invited, err := a.db.GetUserByEmail(input.Email)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+	registered := err == nil
+
+	if registered {
+		_, err := a.db.GetTeamMember(id, invited.ID.String())
+		if err == nil {
+			return nil, BadRequest(errors.New("user is already a member of the team"))
+		}
+		if err != gorm.ErrRecordNotFound {
+			log.Error().Err(err).Send()
+			return nil, InternalServerError(errors.New(internalServerErrorMsg))
+		}
+	}
+
+	invitation := models.TeamInvitation{TeamID: id, TeamName: team.Name, Email: input.Email, InvitedBy: owner.Email}
+	created, err := a.db.CreateTeamInvitation(&invitation)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	if !created {
+		return ResponseMsg{
+			Message: fmt.Sprintf("%s is already invited", input.Email),
+		}, Ok()
+	}
+
+	mail, err := internal.TeamInvitationMailContent(a.config.Server.Host, internal.TeamInvitationMailData{Owner: owner.Name, Team: team.Name})
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	if err := a.outbox.Enqueue(input.Email, mail); err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	if registered {
+		notification := models.Notification{
+			UserID:   invited.ID.String(),
+			Msg:      fmt.Sprintf("%s invited you to join the team %s", owner.Name, team.Name),
+			Type:     models.TeamType,
+			Severity: models.SeverityInfo,
+			Payload:  models.NotificationPayload{TeamID: team.ID},
+		}
+		if err := a.db.CreateNotification(&notification); err != nil {
+			log.Error().Err(err).Send()
+			return nil, InternalServerError(errors.New(internalServerErrorMsg))
+		}
+		a.publishNotification(notification)
+	}
+
+	return ResponseMsg{
+		Message: fmt.Sprintf("%s is invited successfully", input.Email),
+		Data:    invitation,
+	}, Created()
+}
+
+// RemoveTeamMemberHandler removes a member of a team, the owner removes members and members leave the team.
+// The team deployments of the member are given to the owner
+func (a *App) RemoveTeamMemberHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+	id, err := teamID(req)
+	if err != nil {
+		return nil, BadRequest(err)
+	}
+	memberID := mux.Vars(req)["user_id"]
+
+	member, res := a.teamMember(id, userID)
+	if res != nil {
+		return nil, res
+	}
+
+	if member.Role != models.TeamOwnerRole && memberID != userID {
+		return nil, Forbidden(errors.New("only the owner of the team can do this"))
+	}
+
+	if member.Role == models.TeamOwnerRole && memberID == userID {
+		return nil, BadRequest(errors.New("the owner can't leave the team, you can delete the team instead"))
+	}
+
+	err = a.db.RemoveTeamMember(id, memberID)
+	if err == gorm.ErrRecordNotFound {
+		return nil, NotFound(errors.New("member is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Member is removed successfully",
+	}, Ok()
+}
+
+// ListTeamDeploymentsHandler lists the vms and kubernetes clusters of a team
+func (a *App) ListTeamDeploymentsHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+	id, err := teamID(req)
+	if err != nil {
+		return nil, BadRequest(err)
+	}
+
+	if _, res := a.teamMember(id, userID); res != nil {
+		return nil, res
+	}
+
+	vms, err := a.db.GetTeamVms(id)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	clusters, err := a.db.GetTeamK8s(id)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Team deployments are listed successfully",
+		Data:    map[string]interface{}{"vms": vms, "k8s": clusters},
+	}, Ok()
+}
+
+// ListTeamInvitationsHandler lists the pending team invitations of the user
+func (a *App) ListTeamInvitationsHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+	user, err := a.db.GetUserByID(userID)
+	if err == gorm.ErrRecordNotFound {
+		return nil, NotFound(errors.New("user is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	invitations, err := a.db.ListEmailInvitations(user.Email)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	if len(invitations) == 0 {
+		return ResponseMsg{
+			Message: "Invitations are not found",
+			Data:    invitations,
+		}, Ok()
+	}
+
+	return ResponseMsg{
+		Message: "Invitations are found",
+		Data:    invitations,
+	}, Ok()
+}
+
+// invitation gets an invitation of the request, only its invited user or the owner of its team can see it
+func (a *App) invitation(req *http.Request, userID string, ownerAllowed bool) (models.TeamInvitation, models.User, Response) {
+	id, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		return models.TeamInvitation{}, models.User{}, BadRequest(errors.New("failed to read invitation id"))
+	}
+
+	user, err := a.db.GetUserByID(userID)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return models.TeamInvitation{}, models.User{}, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	invitation, err := a.db.GetTeamInvitation(id)
+	if err == gorm.ErrRecordNotFound {
+		return models.TeamInvitation{}, models.User{}, NotFound(errors.New("invitation is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return models.TeamInvitation{}, models.User{}, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	if invitation.Email == user.Email {
+		return invitation, user, nil
+	}
+	if ownerAllowed && a.teamOwner(invitation.TeamID, userID) == nil {
+		return invitation, user, nil
+	}
+	return models.TeamInvitation{}, models.User{}, NotFound(errors.New("invitation is not found"))
+}
+
+// AcceptTeamInvitationHandler adds the user to the team of an invitation sent to its email
+func (a *App) AcceptTeamInvitationHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+
+	invitation, _, res := a.invitation(req, userID, false)
+	if res != nil {
+		return nil, res
+	}
+
+	err := a.db.AcceptTeamInvitation(invitation.ID, userID)
+	if err == gorm.ErrRecordNotFound {
+		return nil, NotFound(errors.New("invitation is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: fmt.Sprintf("You joined the team %s successfully", invitation.TeamName),
+	}, Ok()
+}
+
+// DeleteTeamInvitationHandler declines an invitation of the user or cancels an invitation of a team of the user
+func (a *App) DeleteTeamInvitationHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+
+	invitation, _, res := a.invitation(req, userID, true)
+	if res != nil {
+		return nil, res
+	}
+
+	err := a.db.DeleteTeamInvitation(invitation.ID)
+	if err == gorm.ErrRecordNotFound {
+		return nil, NotFound(errors.New("invitation is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Invitation is deleted successfully",
+	}, Ok()
+}
@@ -0,0 +1,187 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/codescalers/cloud4students/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTeamHandlers(t *testing.T) {
+	app := SetUp(t)
+
+	owner := models.User{Name: "owner", Email: "owner@gmail.com", Verified: true}
+	err := app.db.CreateUser(&owner)
+	assert.NoError(t, err)
+
+	member := models.User{Name: "member", Email: "member@gmail.com", Verified: true}
+	err = app.db.CreateUser(&member)
+	assert.NoError(t, err)
+
+	ownerToken, err := newAccessToken(app, owner.ID.String(), owner.Email)
+	assert.NoError(t, err)
+
+	memberToken, err := newAccessToken(app, member.ID.String(), member.Email)
+	assert.NoError(t, err)
+
+	request := func(token string, handler Handler, body string, vars map[string]string) *httptest.ResponseRecorder {
+		return authorizedHandler(authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				body:        bytes.NewBuffer([]byte(body)),
+				handlerFunc: handler,
+				api:         fmt.Sprintf("/%s/team", app.config.Version),
+			},
+			token:  token,
+			config: app.config,
+			db:     app.db,
+			vars:   vars,
+		})
+	}
+
+	var team models.Team
+
+	t.Run("create team: success", func(t *testing.T) {
+		response := request(ownerToken, app.CreateTeamHandler, `{"name":"project"}`, nil)
+		assert.Equal(t, response.Code, http.StatusCreated)
+
+		responseData(t, response, &team)
+		assert.Equal(t, "project", team.Name)
+		assert.Equal(t, owner.ID.String(), team.OwnerID)
+	})
+
+	t.Run("create team: invalid name", func(t *testing.T) {
+		response := request(ownerToken, app.CreateTeamHandler, `{"name":"p"}`, nil)
+		want := `{"err":"invalid team data, name should be 3 to 30 characters"}` + "\n"
+		assert.Equal(t, response.Body.String(), want)
+		assert.Equal(t, response.Code, http.StatusBadRequest)
+	})
+
+	teamVars := func() map[string]string {
+		return map[string]string{"id": fmt.Sprint(team.ID)}
+	}
+
+	t.Run("invite member: only the owner invites", func(t *testing.T) {
+		response := request(memberToken, app.InviteTeamMemberHandler, `{"email":"member@gmail.com"}`, teamVars())
+		assert.Equal(t, response.Code, http.StatusNotFound)
+	})
+
+	var invitation models.TeamInvitation
+
+	t.Run("invite member: success", func(t *testing.T) {
+		response := request(ownerToken, app.InviteTeamMemberHandler, `{"email":"member@gmail.com"}`, teamVars())
+		assert.Equal(t, response.Code, http.StatusCreated)
+		responseData(t, response, &invitation)
+		assert.Equal(t, "project", invitation.TeamName)
+
+		emails, err := app.db.ListEmails(models.EmailsFilter{States: []models.EmailState{models.EmailQueued}})
+		assert.NoError(t, err)
+		assert.Len(t, emails, 1)
+		assert.Equal(t, member.Email, emails[0].Receiver)
+
+		notifications, err := app.db.ListNotifications(member.ID.String(), models.NotificationsFilter{Limit: 10, Types: []string{models.TeamType}})
+		assert.NoError(t, err)
+		assert.Len(t, notifications, 1)
+		assert.Equal(t, team.ID, notifications[0].Payload.TeamID)
+
+		response = request(ownerToken, app.InviteTeamMemberHandler, `{"email":"member@gmail.com"}`, teamVars())
+		want := `{"msg":"member@gmail.com is already invited"}` + "\n"
+		assert.Equal(t, response.Body.String(), want)
+	})
+
+	t.Run("accept invitation: success", func(t *testing.T) {
+		response := request(memberToken, app.ListTeamInvitationsHandler, "", nil)
+		var invitations []models.TeamInvitation
+		responseData(t, response, &invitations)
+		assert.Len(t, invitations, 1)
+
+		// only the invited user accepts the invitation
+		response = request(ownerToken, app.AcceptTeamInvitationHandler, "", map[string]string{"id": fmt.Sprint(invitation.ID)})
+		assert.Equal(t, response.Code, http.StatusNotFound)
+
+		response = request(memberToken, app.AcceptTeamInvitationHandler, "", map[string]string{"id": fmt.Sprint(invitation.ID)})
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		response = request(memberToken, app.GetTeamHandler, "", teamVars())
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		var details TeamDetails
+		responseData(t, response, &details)
+		assert.Equal(t, models.TeamMemberRole, details.Role)
+		assert.Len(t, details.Members, 2)
+		assert.Empty(t, details.Invitations)
+
+		response = request(ownerToken, app.InviteTeamMemberHandler, `{"email":"member@gmail.com"}`, teamVars())
+		want := `{"err":"user is already a member of the team"}` + "\n"
+		assert.Equal(t, response.Body.String(), want)
+	})
+
+	t.Run("team deployments: members see them and manage their own", func(t *testing.T) {
+		vm := models.VM{UserID: owner.ID.String(), TeamID: team.ID, Name: "teamvm"}
+		err := app.db.CreateVM(&vm)
+		assert.NoError(t, err)
+
+		response := request(memberToken, app.GetVMHandler, "", map[string]string{"id": fmt.Sprint(vm.ID)})
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		response = request(memberToken, app.DeleteVMHandler, "", map[string]string{"id": fmt.Sprint(vm.ID)})
+		assert.Equal(t, response.Code, http.StatusNotFound)
+
+		response = request(memberToken, app.ListTeamDeploymentsHandler, "", teamVars())
+		var deployments struct {
+			VMs []models.VM `json:"vms"`
+		}
+		responseData(t, response, &deployments)
+		assert.Len(t, deployments.VMs, 1)
+
+		response = request(ownerToken, app.DeleteTeamHandler, "", teamVars())
+		want := `{"err":"team has deployments, please delete them first"}` + "\n"
+		assert.Equal(t, response.Body.String(), want)
+
+		err = app.db.DeleteVMByID(vm.ID)
+		assert.NoError(t, err)
+	})
+
+	t.Run("activate voucher into team quota", func(t *testing.T) {
+		err := app.db.CreateVoucher(&models.Voucher{Voucher: "teamvoucher", Resources: models.Resources{CRU: 4, MRU: 8, SRU: 100, PublicIPs: 1}, Approved: true})
+		assert.NoError(t, err)
+
+		response := request(memberToken, app.ActivateVoucherHandler, fmt.Sprintf(`{"voucher":"teamvoucher","team_id":%d}`, team.ID), nil)
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		got, err := app.db.GetTeam(team.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, models.Resources{CRU: 4, MRU: 8, SRU: 100, PublicIPs: 1}, got.Resources)
+	})
+
+	t.Run("remove member: the owner can't leave", func(t *testing.T) {
+		vars := map[string]string{"id": fmt.Sprint(team.ID), "user_id": owner.ID.String()}
+		response := request(memberToken, app.RemoveTeamMemberHandler, "", vars)
+		assert.Equal(t, response.Code, http.StatusForbidden)
+
+		response = request(ownerToken, app.RemoveTeamMemberHandler, "", vars)
+		assert.Equal(t, response.Code, http.StatusBadRequest)
+	})
+
+	t.Run("remove member: members leave", func(t *testing.T) {
+		vars := map[string]string{"id": fmt.Sprint(team.ID), "user_id": member.ID.String()}
+		response := request(memberToken, app.RemoveTeamMemberHandler, "", vars)
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		response = request(memberToken, app.GetTeamHandler, "", teamVars())
+		assert.Equal(t, response.Code, http.StatusNotFound)
+	})
+
+	t.Run("delete team: success", func(t *testing.T) {
+		response := request(ownerToken, app.DeleteTeamHandler, "", teamVars())
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		response = request(ownerToken, app.ListTeamsHandler, "", nil)
+		want := `{"msg":"Teams are not found","data":[]}` + "\n"
+		assert.Equal(t, response.Body.String(), want)
+	})
+}
@@ -0,0 +1,393 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/codescalers/cloud4students/internal"
+	"github.com/codescalers/cloud4students/middlewares"
+	"github.com/codescalers/cloud4students/models"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// twoFactorSignInTimeout is how long a user has to enter the two factor code after the password
+const twoFactorSignInTimeout = 5 * time.Minute
+
+// TwoFactorCodeInput struct for a code of the authenticator app or a recovery code
+type TwoFactorCodeInput struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// TwoFactorSignInInput struct for the second step of signing in with two factor authentication
+type TwoFactorSignInInput struct {
+	Email string `json:"email" binding:"required"`
+	Token string `json:"two_factor_token" binding:"required"`
+	Code  string `json:"code" binding:"required"`
+}
+
+// newTwoFactorChallenge saves a challenge for a user who signed in with a password, it returns the token
+// that has to be sent back with the two factor code
+func (a *App) newTwoFactorChallenge(userID string) (string, error) {
+	token, err := internal.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	err = a.db.SaveVerificationCode(&models.VerificationCode{
+		UserID:     userID,
+		Purpose:    models.TwoFactorSignIn,
+		HashedCode: internal.HashOpaqueToken(token),
+		ExpiresAt:  now.Add(twoFactorSignInTimeout),
+		SentAt:     now,
+	})
+	return token, err
+}
+
+// twoFactorChallenge responds with a new two factor challenge instead of a session
+func (a *App) twoFactorChallenge(userID string) (interface{}, Response) {
+	token, err := a.newTwoFactorChallenge(userID)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Please enter the code of your authenticator app",
+		Data:    map[string]string{"two_factor_token": token},
+	}, Ok()
+}
+
+// checkTwoFactorCode checks a code of the authenticator app or an unused recovery code of the user,
+// used codes can't be used again
+func (a *App) checkTwoFactorCode(user models.User, code string) (bool, error) {
+	if step, ok := internal.ValidateTOTP(user.TOTPSecret, code, time.Now()); ok {
+		return a.db.UseTOTPStep(user.ID.String(), step)
+	}
+
+	return a.db.UseRecoveryCode(user.ID.String(), internal.HashRecoveryCode(a.config.Token.Secret, code))
+}
+
+// newRecoveryCodes generates recovery codes, it returns the codes to show to the user and their hashes to store
+func (a *App) newRecoveryCodes() ([]string, []models.RecoveryCode, error) {
+	codes, err := internal.GenerateRecoveryCodes()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hashed := make([]models.RecoveryCode, len(codes))
+	for i, code := range codes {
+		hashed[i] = models.RecoveryCode{HashedCode: internal.HashRecoveryCode(a.config.Token.Secret, code)}
+	}
+	return codes, hashed, nil
+}
+
+// SignInTwoFactorHandler completes signing in of a user with two factor authentication
+func (a *App) SignInTwoFactorHandler(req *http.Request) (interface{}, Response) {
+	var input TwoFactorSignInInput
+	err := json.NewDecoder(req.Body).Decode(&input)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, BadRequest(errors.New("failed to read two factor sign in data"))
+	}
+
+	if strings.TrimSpace(input.Token) == "" || strings.TrimSpace(input.Code) == "" {
+		return nil, BadRequest(errors.New("two factor token and code are required"))
+	}
+
+	user, err := a.db.GetUserByEmail(input.Email)
+	if err == gorm.ErrRecordNotFound {
+		return nil, NotFound(errors.New("user is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	challenge, err := a.db.GetVerificationCode(user.ID.String(), models.TwoFactorSignIn)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+	if err == gorm.ErrRecordNotFound || challenge.ExpiresAt.Before(time.Now()) ||
+		!hmac.Equal(challenge.HashedCode, internal.HashOpaqueToken(input.Token)) {
+		return nil, UnAuthorized(errors.New("two factor sign in is expired, please sign in again"))
+	}
+
+	attempted, err := a.db.AttemptVerificationCode(challenge.ID, a.config.VerificationCode.MaxAttempts)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+	if !attempted {
+		return nil, TooManyRequests(errors.New("too many wrong attempts, please sign in again"), time.Until(challenge.ExpiresAt))
+	}
+
+	valid, err := a.checkTwoFactorCode(user, input.Code)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+	if !valid {
+		return nil, BadRequest(errors.New("wrong two factor code"))
+	}
+
+	if err := a.db.DeleteVerificationCode(challenge.ID); err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	tokens, err := a.newSession(req, user)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "You are signed in successfully",
+		Data:    tokens,
+	}, Ok()
+}
+
+// GetTwoFactorHandler gets the two factor authentication status of the user
+func (a *App) GetTwoFactorHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+
+	user, err := a.db.GetUserByID(userID)
+	if err == gorm.ErrRecordNotFound {
+		return nil, NotFound(errors.New("user is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	codes, err := a.db.CountRecoveryCodes(userID)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	required, err := a.twoFactorRequired(userID)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Two factor authentication status is found",
+		Data: map[string]interface{}{
+			"enabled":             user.TOTPEnabled,
+			"required":            required,
+			"recovery_codes_left": codes,
+		},
+	}, Ok()
+}
+
+// EnrollTwoFactorHandler generates a new TOTP secret of the user to be added to an authenticator app
+func (a *App) EnrollTwoFactorHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+
+	user, err := a.db.GetUserByID(userID)
+	if err == gorm.ErrRecordNotFound {
+		return nil, NotFound(errors.New("user is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	if user.TOTPEnabled {
+		return nil, BadRequest(errors.New("two factor authentication is already enabled"))
+	}
+
+	secret, err := internal.GenerateTOTPSecret()
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	err = a.db.SetTOTPSecret(userID, secret)
+	if err == gorm.ErrRecordNotFound {
+		return nil, BadRequest(errors.New("two factor authentication is already enabled"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Add the account to your authenticator app then confirm it with a code",
+		Data: map[string]string{
+			"secret":           secret,
+			"provisioning_uri": internal.TOTPProvisioningURI(a.config.TwoFactor.Issuer, user.Email, secret),
+		},
+	}, Ok()
+}
+
+// ConfirmTwoFactorHandler enables two factor authentication of the user with a code of the enrolled secret
+func (a *App) ConfirmTwoFactorHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+
+	var input TwoFactorCodeInput
+	err := json.NewDecoder(req.Body).Decode(&input)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, BadRequest(errors.New("failed to read two factor code"))
+	}
+
+	user, err := a.db.GetUserByID(userID)
+	if err == gorm.ErrRecordNotFound {
+		return nil, NotFound(errors.New("user is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	if user.TOTPEnabled {
+		return nil, BadRequest(errors.New("two factor authentication is already enabled"))
+	}
+	if user.TOTPSecret == "" {
+		return nil, BadRequest(errors.New("please enroll in two factor authentication first"))
+	}
+
+	step, ok := internal.ValidateTOTP(user.TOTPSecret, input.Code, time.Now())
+	if !ok {
+		return nil, BadRequest(errors.New("wrong two factor code"))
+	}
+
+	codes, hashed, err := a.newRecoveryCodes()
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	err = a.db.EnableTOTP(userID, step, hashed)
+	if err == gorm.ErrRecordNotFound {
+		return nil, BadRequest(errors.New("two factor authentication is already enabled"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	// other sessions were signed in with the password only
+	if err := a.revokeOtherSessions(req, user.Email); err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Two factor authentication is enabled, keep the recovery codes in a safe place",
+		Data:    map[string][]string{"recovery_codes": codes},
+	}, Ok()
+}
+
+// RegenerateRecoveryCodesHandler replaces the recovery codes of the user
+func (a *App) RegenerateRecoveryCodesHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+
+	var input TwoFactorCodeInput
+	err := json.NewDecoder(req.Body).Decode(&input)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, BadRequest(errors.New("failed to read two factor code"))
+	}
+
+	user, err := a.db.GetUserByID(userID)
+	if err == gorm.ErrRecordNotFound {
+		return nil, NotFound(errors.New("user is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	if !user.TOTPEnabled {
+		return nil, BadRequest(errors.New("two factor authentication is not enabled"))
+	}
+
+	valid, err := a.checkTwoFactorCode(user, input.Code)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+	if !valid {
+		return nil, BadRequest(errors.New("wrong two factor code"))
+	}
+
+	codes, hashed, err := a.newRecoveryCodes()
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	if err := a.db.ReplaceRecoveryCodes(userID, hashed); err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Recovery codes are regenerated, keep them in a safe place",
+		Data:    map[string][]string{"recovery_codes": codes},
+	}, Ok()
+}
+
+// DisableTwoFactorHandler disables two factor authentication of the user
+func (a *App) DisableTwoFactorHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+
+	var input TwoFactorCodeInput
+	err := json.NewDecoder(req.Body).Decode(&input)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, BadRequest(errors.New("failed to read two factor code"))
+	}
+
+	user, err := a.db.GetUserByID(userID)
+	if err == gorm.ErrRecordNotFound {
+		return nil, NotFound(errors.New("user is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	if !user.TOTPEnabled {
+		return nil, BadRequest(errors.New("two factor authentication is not enabled"))
+	}
+
+	required, err := a.twoFactorRequired(userID)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+	if required {
+		return nil, Forbidden(errors.New("two factor authentication is required for admins"))
+	}
+
+	valid, err := a.checkTwoFactorCode(user, input.Code)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+	if !valid {
+		return nil, BadRequest(errors.New("wrong two factor code"))
+	}
+
+	if err := a.db.DisableTOTP(userID); err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Two factor authentication is disabled",
+	}, Ok()
+}
@@ -0,0 +1,291 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/codescalers/cloud4students/internal"
+	"github.com/codescalers/cloud4students/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// responseData decodes the data of a response
+func responseData(t *testing.T, response *httptest.ResponseRecorder, data interface{}) {
+	res := struct {
+		Data interface{} `json:"data"`
+	}{Data: data}
+	assert.NoError(t, json.NewDecoder(response.Body).Decode(&res))
+}
+
+func TestTwoFactorHandlers(t *testing.T) {
+	app := SetUp(t)
+
+	user.Verified = true
+	err := app.db.CreateUser(user)
+	assert.NoError(t, err)
+
+	token, err := newAccessToken(app, user.ID.String(), user.Email)
+	assert.NoError(t, err)
+
+	request := func(handler Handler, body string) *httptest.ResponseRecorder {
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				body:        bytes.NewBuffer([]byte(body)),
+				handlerFunc: handler,
+				api:         fmt.Sprintf("/%s/user/2fa", app.config.Version),
+			},
+			token:  token,
+			config: app.config,
+			db:     app.db,
+		}
+		return authorizedHandler(req)
+	}
+
+	signIn := func(t *testing.T) map[string]string {
+		req := unAuthHandlerConfig{
+			body:        bytes.NewBuffer([]byte(`{"email":"name@gmail.com","password":"1234567"}`)),
+			handlerFunc: app.SignInHandler,
+			api:         fmt.Sprintf("/%s/user/signin", app.config.Version),
+		}
+
+		response := unAuthorizedHandler(req)
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		data := map[string]string{}
+		responseData(t, response, &data)
+		return data
+	}
+
+	signInTwoFactor := func(twoFactorToken, code string) *httptest.ResponseRecorder {
+		req := unAuthHandlerConfig{
+			body:        bytes.NewBuffer([]byte(fmt.Sprintf(`{"email":"name@gmail.com","two_factor_token":%q,"code":%q}`, twoFactorToken, code))),
+			handlerFunc: app.SignInTwoFactorHandler,
+			api:         fmt.Sprintf("/%s/user/signin/2fa", app.config.Version),
+		}
+		return unAuthorizedHandler(req)
+	}
+
+	var secret string
+	var recoveryCodes []string
+	// codes are only valid once and only the codes of the steps around now are accepted,
+	// so recovery codes are used once these are used
+	step := internal.TOTPStep(time.Now())
+	nextCode := func(t *testing.T) string {
+		code, err := internal.TOTPCode(secret, step)
+		assert.NoError(t, err)
+		step++
+		return code
+	}
+
+	t.Run("2fa: confirm before enrolling", func(t *testing.T) {
+		response := request(app.ConfirmTwoFactorHandler, `{"code":"123456"}`)
+		want := `{"err":"please enroll in two factor authentication first"}` + "\n"
+		assert.Equal(t, response.Body.String(), want)
+		assert.Equal(t, response.Code, http.StatusBadRequest)
+	})
+
+	t.Run("2fa: enroll", func(t *testing.T) {
+		response := request(app.EnrollTwoFactorHandler, "")
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		data := map[string]string{}
+		responseData(t, response, &data)
+		secret = data["secret"]
+		assert.NotEmpty(t, secret)
+		assert.Contains(t, data["provisioning_uri"], "otpauth://totp/")
+
+		// not enabled until it is confirmed
+		tokens := signIn(t)
+		assert.NotEmpty(t, tokens["access_token"])
+	})
+
+	t.Run("2fa: confirm with wrong code", func(t *testing.T) {
+		response := request(app.ConfirmTwoFactorHandler, `{"code":"000000"}`)
+		want := `{"err":"wrong two factor code"}` + "\n"
+		assert.Equal(t, response.Body.String(), want)
+		assert.Equal(t, response.Code, http.StatusBadRequest)
+	})
+
+	t.Run("2fa: confirm", func(t *testing.T) {
+		response := request(app.ConfirmTwoFactorHandler, fmt.Sprintf(`{"code":%q}`, nextCode(t)))
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		data := map[string][]string{}
+		responseData(t, response, &data)
+		recoveryCodes = data["recovery_codes"]
+		assert.Len(t, recoveryCodes, internal.RecoveryCodesCount)
+
+		response = request(app.EnrollTwoFactorHandler, "")
+		want := `{"err":"two factor authentication is already enabled"}` + "\n"
+		assert.Equal(t, response.Body.String(), want)
+		assert.Equal(t, response.Code, http.StatusBadRequest)
+	})
+
+	t.Run("2fa: sign in needs a code", func(t *testing.T) {
+		data := signIn(t)
+		assert.Empty(t, data["access_token"])
+		assert.NotEmpty(t, data["two_factor_token"])
+
+		response := signInTwoFactor(data["two_factor_token"], "000000")
+		want := `{"err":"wrong two factor code"}` + "\n"
+		assert.Equal(t, response.Body.String(), want)
+		assert.Equal(t, response.Code, http.StatusBadRequest)
+
+		code := nextCode(t)
+		response = signInTwoFactor(data["two_factor_token"], code)
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		tokens := map[string]string{}
+		responseData(t, response, &tokens)
+		assert.NotEmpty(t, tokens["access_token"])
+		assert.NotEmpty(t, tokens["refresh_token"])
+
+		// the challenge and the code are used
+		response = signInTwoFactor(data["two_factor_token"], code)
+		want = `{"err":"two factor sign in is expired, please sign in again"}` + "\n"
+		assert.Equal(t, response.Body.String(), want)
+		assert.Equal(t, response.Code, http.StatusUnauthorized)
+
+		data = signIn(t)
+		response = signInTwoFactor(data["two_factor_token"], code)
+		assert.Equal(t, response.Code, http.StatusBadRequest)
+	})
+
+	t.Run("2fa: reset password needs a code", func(t *testing.T) {
+		saveVerificationCode(t, app, user.ID.String(), models.ResetPasswordCode, time.Now().Add(time.Minute))
+
+		req := unAuthHandlerConfig{
+			body:        bytes.NewBuffer([]byte(fmt.Sprintf(`{"email": "%s", "code": %d}`, user.Email, verificationCode))),
+			handlerFunc: app.VerifyForgetPasswordCodeHandler,
+			api:         fmt.Sprintf("/%s/user/forget_password/verify_email", app.config.Version),
+		}
+
+		response := unAuthorizedHandler(req)
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		data := map[string]string{}
+		responseData(t, response, &data)
+		assert.Empty(t, data["access_token"])
+		assert.NotEmpty(t, data["two_factor_token"])
+	})
+
+	t.Run("2fa: sign in with invalid token", func(t *testing.T) {
+		signIn(t)
+
+		response := signInTwoFactor("invalid", "000000")
+		want := `{"err":"two factor sign in is expired, please sign in again"}` + "\n"
+		assert.Equal(t, response.Body.String(), want)
+		assert.Equal(t, response.Code, http.StatusUnauthorized)
+	})
+
+	t.Run("2fa: sign in attempts are limited", func(t *testing.T) {
+		data := signIn(t)
+		for i := 0; i < app.config.VerificationCode.MaxAttempts; i++ {
+			response := signInTwoFactor(data["two_factor_token"], "000000")
+			assert.Equal(t, response.Code, http.StatusBadRequest)
+		}
+
+		response := signInTwoFactor(data["two_factor_token"], recoveryCodes[0])
+		assert.Equal(t, response.Code, http.StatusTooManyRequests)
+	})
+
+	t.Run("2fa: sign in with a recovery code", func(t *testing.T) {
+		data := signIn(t)
+		response := signInTwoFactor(data["two_factor_token"], recoveryCodes[0])
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		data = signIn(t)
+		response = signInTwoFactor(data["two_factor_token"], recoveryCodes[0])
+		assert.Equal(t, response.Code, http.StatusBadRequest)
+
+		response = request(app.GetTwoFactorHandler, "")
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		status := map[string]interface{}{}
+		responseData(t, response, &status)
+		assert.Equal(t, true, status["enabled"])
+		assert.Equal(t, float64(internal.RecoveryCodesCount-1), status["recovery_codes_left"])
+	})
+
+	t.Run("2fa: regenerate recovery codes", func(t *testing.T) {
+		response := request(app.RegenerateRecoveryCodesHandler, fmt.Sprintf(`{"code":%q}`, recoveryCodes[1]))
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		data := map[string][]string{}
+		responseData(t, response, &data)
+		assert.Len(t, data["recovery_codes"], internal.RecoveryCodesCount)
+
+		// old codes don't work anymore
+		response = request(app.DisableTwoFactorHandler, fmt.Sprintf(`{"code":%q}`, recoveryCodes[2]))
+		assert.Equal(t, response.Code, http.StatusBadRequest)
+		recoveryCodes = data["recovery_codes"]
+	})
+
+	t.Run("2fa: disable", func(t *testing.T) {
+		response := request(app.DisableTwoFactorHandler, fmt.Sprintf(`{"code":%q}`, recoveryCodes[0]))
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		tokens := signIn(t)
+		assert.NotEmpty(t, tokens["access_token"])
+	})
+}
+
+func TestTwoFactorRequiredForAdmins(t *testing.T) {
+	app := SetUp(t)
+	app.config.TwoFactor.RequiredForAdmins = true
+
+	admin := models.User{
+		Name:     "admin",
+		Email:    "admin@gmail.com",
+		Verified: true,
+		Admin:    true,
+	}
+	err := app.db.CreateUser(&admin)
+	assert.NoError(t, err)
+
+	token, err := newAccessToken(app, admin.ID.String(), admin.Email)
+	assert.NoError(t, err)
+
+	req := authHandlerConfig{
+		unAuthHandlerConfig: unAuthHandlerConfig{
+			handlerFunc: app.GetAllUsersHandler,
+			api:         fmt.Sprintf("/%s/user/all", app.config.Version),
+		},
+		token:  token,
+		config: app.config,
+		db:     app.db,
+	}
+
+	t.Run("admin without 2fa", func(t *testing.T) {
+		response := adminHandler(req)
+		want := `{"err":"two factor authentication is required for admins, please enable it first"}` + "\n"
+		assert.Equal(t, response.Body.String(), want)
+		assert.Equal(t, response.Code, http.StatusForbidden)
+	})
+
+	t.Run("admin with 2fa", func(t *testing.T) {
+		secret, err := internal.GenerateTOTPSecret()
+		assert.NoError(t, err)
+		err = app.db.SetTOTPSecret(admin.ID.String(), secret)
+		assert.NoError(t, err)
+		err = app.db.EnableTOTP(admin.ID.String(), internal.TOTPStep(time.Now()), nil)
+		assert.NoError(t, err)
+
+		response := adminHandler(req)
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		disableReq := req
+		disableReq.body = bytes.NewBuffer([]byte(`{"code":"123456"}`))
+		disableReq.handlerFunc = app.DisableTwoFactorHandler
+		response = authorizedHandler(disableReq)
+		want := `{"err":"two factor authentication is required for admins"}` + "\n"
+		assert.Equal(t, response.Body.String(), want)
+		assert.Equal(t, response.Code, http.StatusForbidden)
+	})
+}
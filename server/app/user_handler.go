@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"strings"
 	"time"
@@ -13,7 +14,6 @@ import (
 	"github.com/codescalers/cloud4students/middlewares"
 	"github.com/codescalers/cloud4students/models"
 	"github.com/codescalers/cloud4students/validators"
-	"github.com/golang-jwt/jwt/v4"
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 	"gopkg.in/validator.v2"
@@ -66,7 +66,9 @@ type EmailInput struct {
 
 // ApplyForVoucherInput struct for user to apply for voucher
 type ApplyForVoucherInput struct {
-	VMs       int    `json:"vms" binding:"required" validate:"min=0"`
+	CRU       int    `json:"cru" binding:"required" validate:"min=0"`
+	MRU       int    `json:"mru" binding:"required" validate:"min=0"`
+	SRU       int    `json:"sru" binding:"required" validate:"min=0"`
 	PublicIPs int    `json:"public_ips" binding:"required" validate:"min=0"`
 	Reason    string `json:"reason" binding:"required" validate:"nonzero"`
 }
@@ -74,6 +76,8 @@ type ApplyForVoucherInput struct {
 // AddVoucherInput struct for voucher applied by user
 type AddVoucherInput struct {
 	Voucher string `json:"voucher" binding:"required"`
+	// TeamID activates the voucher into the quota of a team of the user
+	TeamID int `json:"team_id"`
 }
 
 // SignUpHandler creates account for user
@@ -112,13 +116,11 @@ func (a *App) SignUpHandler(req *http.Request) (interface{}, Response) {
 		}
 	}
 
-	// send verification code if user is not verified or not exist
-	code := internal.GenerateRandomCode()
-	subject, body := internal.SignUpMailContent(code, a.config.MailSender.Timeout, signUp.Name, a.config.Server.Host)
-	err = internal.SendMail(a.config.MailSender.Email, a.config.MailSender.SendGridKey, signUp.Email, subject, body)
-	if err != nil {
-		log.Error().Err(err).Send()
-		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	// a new code can't be requested before the cooldown of the last one
+	if getErr == nil {
+		if res := a.checkCodeCooldown(user.ID.String(), models.SignUpCode); res != nil {
+			return nil, res
+		}
 	}
 
 	hashedPassword, err := internal.HashAndSaltPassword([]byte(signUp.Password))
@@ -131,7 +133,6 @@ func (a *App) SignUpHandler(req *http.Request) (interface{}, Response) {
 		Name:           signUp.Name,
 		Email:          signUp.Email,
 		HashedPassword: hashedPassword,
-		Code:           code,
 		SSHKey:         signUp.SSHKey,
 		TeamSize:       signUp.TeamSize,
 		ProjectDesc:    signUp.ProjectDesc,
@@ -139,7 +140,7 @@ func (a *App) SignUpHandler(req *http.Request) (interface{}, Response) {
 		Admin:          internal.Contains(a.config.Admins, signUp.Email),
 	}
 
-	// update code if user is not verified but exists
+	// update data if user is not verified but exists
 	if getErr != gorm.ErrRecordNotFound {
 		if !user.Verified {
 			u.ID = user.ID
@@ -163,7 +164,6 @@ func (a *App) SignUpHandler(req *http.Request) (interface{}, Response) {
 		// create empty quota
 		quota := models.Quota{
 			UserID: u.ID.String(),
-			Vms:    0,
 		}
 		err = a.db.CreateQuota(&quota)
 		if err != nil {
@@ -172,6 +172,25 @@ func (a *App) SignUpHandler(req *http.Request) (interface{}, Response) {
 		}
 	}
 
+	// send verification code
+	code, err := a.newVerificationCode(u.ID.String(), models.SignUpCode)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	mail, err := internal.SignUpMailContent(a.config.Server.Host, internal.SignUpMailData{Name: signUp.Name, Code: code, Timeout: a.config.MailSender.Timeout})
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	err = a.outbox.Enqueue(signUp.Email, mail)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
 	return ResponseMsg{
 		Message: "Verification code has been sent to " + signUp.Email,
 		Data:    map[string]int{"timeout": a.config.MailSender.Timeout},
@@ -200,13 +219,10 @@ func (a *App) VerifySignUpCodeHandler(req *http.Request) (interface{}, Response)
 		return nil, BadRequest(errors.New("account is already created"))
 	}
 
-	if user.Code != data.Code {
-		return nil, BadRequest(errors.New("wrong code"))
+	if res := a.verifyCode(user.ID.String(), models.SignUpCode, data.Code); res != nil {
+		return nil, res
 	}
 
-	if user.UpdatedAt.Add(time.Duration(a.config.MailSender.Timeout) * time.Second).Before(time.Now()) {
-		return nil, BadRequest(errors.New("code has expired"))
-	}
 	err = a.db.UpdateVerification(user.ID.String(), true)
 	if err != nil {
 		log.Error().Err(err).Send()
@@ -214,15 +230,20 @@ func (a *App) VerifySignUpCodeHandler(req *http.Request) (interface{}, Response)
 	}
 	middlewares.UserCreations.WithLabelValues(user.ID.String(), user.Email, user.College, fmt.Sprint(user.TeamSize)).Inc()
 
-	// token
-	token, err := internal.CreateJWT(user.ID.String(), user.Email, a.config.Token.Secret, a.config.Token.Timeout)
+	tokens, err := a.newSession(req, user)
 	if err != nil {
 		log.Error().Err(err).Send()
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
 	}
+	tokens["user_id"] = user.ID.String()
 
-	subject, body := internal.WelcomeMailContent(user.Name, a.config.Server.Host)
-	err = internal.SendMail(a.config.MailSender.Email, a.config.MailSender.SendGridKey, user.Email, subject, body)
+	mail, err := internal.WelcomeMailContent(a.config.Server.Host, internal.WelcomeMailData{Name: user.Name})
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	err = a.outbox.Enqueue(user.Email, mail)
 	if err != nil {
 		log.Error().Err(err).Send()
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
@@ -230,7 +251,7 @@ func (a *App) VerifySignUpCodeHandler(req *http.Request) (interface{}, Response)
 
 	return ResponseMsg{
 		Message: "Account is created successfully.",
-		Data:    map[string]string{"user_id": user.ID.String(), "access_token": token},
+		Data:    tokens,
 	}, Ok()
 }
 
@@ -261,7 +282,17 @@ func (a *App) SignInHandler(req *http.Request) (interface{}, Response) {
 		return nil, BadRequest(errors.New("email or password is not correct"))
 	}
 
-	token, err := internal.CreateJWT(user.ID.String(), user.Email, a.config.Token.Secret, a.config.Token.Timeout)
+	// upgrade legacy hashes now that the password is known
+	if internal.PasswordNeedsRehash(user.HashedPassword) {
+		a.rehashPassword(user.Email, input.Password)
+	}
+
+	// the session is only created once the two factor code is verified
+	if user.TOTPEnabled {
+		return a.twoFactorChallenge(user.ID.String())
+	}
+
+	tokens, err := a.newSession(req, user)
 	if err != nil {
 		log.Error().Err(err).Send()
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
@@ -269,54 +300,94 @@ func (a *App) SignInHandler(req *http.Request) (interface{}, Response) {
 
 	return ResponseMsg{
 		Message: "You are signed in successfully",
-		Data:    map[string]string{"access_token": token},
+		Data:    tokens,
 	}, Ok()
 }
 
-// RefreshJWTHandler refreshes the user's token
-func (a *App) RefreshJWTHandler(req *http.Request) (interface{}, Response) {
-	reqToken := req.Header.Get("Authorization")
-	splitToken := strings.Split(reqToken, "Bearer ")
-	if len(splitToken) != 2 {
-		return nil, BadRequest(errors.New("token is required"))
+// newVerificationCode generates a code for the user that replaces the last one sent for the same purpose
+func (a *App) newVerificationCode(userID string, purpose models.CodePurpose) (int, error) {
+	code, err := internal.GenerateRandomCode()
+	if err != nil {
+		return 0, err
 	}
 
-	if strings.TrimSpace(splitToken[1]) == "" {
-		return nil, BadRequest(errors.New("token is required"))
+	now := time.Now()
+	err = a.db.SaveVerificationCode(&models.VerificationCode{
+		UserID:     userID,
+		Purpose:    purpose,
+		HashedCode: internal.HashCode(a.config.Token.Secret, code),
+		ExpiresAt:  now.Add(time.Duration(a.config.MailSender.Timeout) * time.Second),
+		SentAt:     now,
+	})
+	return code, err
+}
+
+// checkCodeCooldown returns a too many requests response if the last code of the user was sent within the cooldown
+func (a *App) checkCodeCooldown(userID string, purpose models.CodePurpose) Response {
+	code, err := a.db.GetVerificationCode(userID, purpose)
+	if err == gorm.ErrRecordNotFound {
+		return nil
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return InternalServerError(errors.New(internalServerErrorMsg))
 	}
-	reqToken = splitToken[1]
 
-	claims := &models.Claims{}
-	tkn, err := jwt.ParseWithClaims(reqToken, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(a.config.Token.Secret), nil
-	})
+	wait := time.Until(code.SentAt.Add(time.Duration(a.config.VerificationCode.ResendCooldownSeconds) * time.Second))
+	if wait > 0 {
+		return TooManyRequests(fmt.Errorf("please wait %d seconds before requesting a new code", int(math.Ceil(wait.Seconds()))), wait)
+	}
+	return nil
+}
 
-	// if user doesn't exist
-	if _, err := a.db.GetUserByID(claims.UserID); err == gorm.ErrRecordNotFound {
-		return nil, NotFound(errors.New("user is not found"))
+// verifyCode checks the code of the user and deletes it once it is used, every guess counts as an attempt
+// and the code is locked when it has no attempts left
+func (a *App) verifyCode(userID string, purpose models.CodePurpose, code int) Response {
+	c, err := a.db.GetVerificationCode(userID, purpose)
+	if err == gorm.ErrRecordNotFound {
+		return BadRequest(errors.New("wrong code"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return InternalServerError(errors.New(internalServerErrorMsg))
 	}
 
-	// if token didn't expire
-	if err == nil && time.Until(claims.ExpiresAt.Time) < time.Duration(a.config.Token.Timeout)*time.Minute && tkn.Valid {
-		return ResponseMsg{
-			Message: "Access Token is valid",
-			Data:    map[string]string{"access_token": reqToken, "refresh_token": reqToken},
-		}, Ok()
+	if c.ExpiresAt.Before(time.Now()) {
+		return BadRequest(errors.New("code has expired"))
 	}
 
-	expirationTime := time.Now().Add(time.Duration(a.config.Token.Timeout) * time.Minute)
-	claims.ExpiresAt = jwt.NewNumericDate(expirationTime)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	newToken, err := token.SignedString([]byte(a.config.Token.Secret))
+	attempted, err := a.db.AttemptVerificationCode(c.ID, a.config.VerificationCode.MaxAttempts)
 	if err != nil {
 		log.Error().Err(err).Send()
-		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+		return InternalServerError(errors.New(internalServerErrorMsg))
+	}
+	if !attempted {
+		return TooManyRequests(errors.New("too many wrong attempts, please request a new code"), time.Until(c.ExpiresAt))
 	}
 
-	return ResponseMsg{
-		Message: "Token is refreshed successfully",
-		Data:    map[string]string{"access_token": reqToken, "refresh_token": newToken},
-	}, Ok()
+	if !internal.VerifyCode(a.config.Token.Secret, c.HashedCode, code) {
+		return BadRequest(errors.New("wrong code"))
+	}
+
+	if err := a.db.DeleteVerificationCode(c.ID); err != nil {
+		log.Error().Err(err).Send()
+		return InternalServerError(errors.New(internalServerErrorMsg))
+	}
+	return nil
+}
+
+// rehashPassword saves the password hashed with the current algorithm, failures are only logged
+// as the old hash still works
+func (a *App) rehashPassword(email, password string) {
+	hashedPassword, err := internal.HashAndSaltPassword([]byte(password))
+	if err != nil {
+		log.Error().Err(err).Msg("failed to rehash password")
+		return
+	}
+
+	if err := a.db.UpdatePassword(email, hashedPassword); err != nil {
+		log.Error().Err(err).Msg("failed to save rehashed password")
+	}
 }
 
 // ForgotPasswordHandler sends user verification code
@@ -341,23 +412,24 @@ func (a *App) ForgotPasswordHandler(req *http.Request) (interface{}, Response) {
 		return nil, BadRequest(errors.New("email is not verified yet, please check the verification email in your inbox"))
 	}
 
+	if res := a.checkCodeCooldown(user.ID.String(), models.ResetPasswordCode); res != nil {
+		return nil, res
+	}
+
 	// send verification code
-	code := internal.GenerateRandomCode()
-	subject, body := internal.ResetPasswordMailContent(code, a.config.MailSender.Timeout, user.Name, a.config.Server.Host)
-	err = internal.SendMail(a.config.MailSender.Email, a.config.MailSender.SendGridKey, email.Email, subject, body)
+	code, err := a.newVerificationCode(user.ID.String(), models.ResetPasswordCode)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
 
+	mail, err := internal.ResetPasswordMailContent(a.config.Server.Host, internal.ResetPasswordMailData{Name: user.Name, Code: code, Timeout: a.config.MailSender.Timeout})
 	if err != nil {
 		log.Error().Err(err).Send()
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
 	}
 
-	err = a.db.UpdateUserByID(
-		models.User{
-			ID:        user.ID,
-			UpdatedAt: time.Now(),
-			Code:      code,
-		},
-	)
+	err = a.outbox.Enqueue(email.Email, mail)
 	if err != nil {
 		log.Error().Err(err).Send()
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
@@ -391,16 +463,16 @@ func (a *App) VerifyForgetPasswordCodeHandler(req *http.Request) (interface{}, R
 		return nil, BadRequest(errors.New("email is not verified yet, please check the verification email in your inbox"))
 	}
 
-	if user.Code != data.Code {
-		return nil, BadRequest(errors.New("wrong code"))
+	if res := a.verifyCode(user.ID.String(), models.ResetPasswordCode, data.Code); res != nil {
+		return nil, res
 	}
 
-	if user.UpdatedAt.Add(time.Duration(a.config.MailSender.Timeout) * time.Second).Before(time.Now()) {
-		return nil, BadRequest(errors.New("code has expired"))
+	// the email alone isn't enough to sign in a user with two factor authentication
+	if user.TOTPEnabled {
+		return a.twoFactorChallenge(user.ID.String())
 	}
 
-	// token
-	token, err := internal.CreateJWT(user.ID.String(), user.Email, a.config.Token.Secret, a.config.Token.Timeout)
+	tokens, err := a.newSession(req, user)
 	if err != nil {
 		log.Error().Err(err).Send()
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
@@ -408,7 +480,7 @@ func (a *App) VerifyForgetPasswordCodeHandler(req *http.Request) (interface{}, R
 
 	return ResponseMsg{
 		Message: "Code is verified",
-		Data:    map[string]string{"access_token": token},
+		Data:    tokens,
 	}, Ok()
 }
 
@@ -446,6 +518,12 @@ func (a *App) ChangePasswordHandler(req *http.Request) (interface{}, Response) {
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
 	}
 
+	// sign out the other sessions, they may be of someone who knows the old password
+	if err := a.revokeOtherSessions(req, data.Email); err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
 	return ResponseMsg{
 		Message: "Password is updated successfully",
 		Data:    nil,
@@ -544,9 +622,19 @@ func (a *App) GetUserHandler(req *http.Request) (interface{}, Response) {
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
 	}
 
+	roles, err := a.db.ListUserRoles(userID)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
 	return ResponseMsg{
 		Message: "User exists",
-		Data:    map[string]interface{}{"user": user},
+		Data: map[string]interface{}{
+			"user":        user,
+			"roles":       roles,
+			"permissions": models.PermissionsOf(roles),
+		},
 	}, Ok()
 }
 
@@ -578,9 +666,8 @@ func (a *App) ApplyForVoucherHandler(req *http.Request) (interface{}, Response)
 	voucher := models.Voucher{
 		Voucher:   v,
 		UserID:    userID,
-		VMs:       input.VMs,
+		Resources: models.Resources{CRU: input.CRU, MRU: input.MRU, SRU: input.SRU, PublicIPs: input.PublicIPs},
 		Reason:    input.Reason,
-		PublicIPs: input.PublicIPs,
 	}
 
 	err = a.db.CreateVoucher(&voucher)
@@ -588,7 +675,7 @@ func (a *App) ApplyForVoucherHandler(req *http.Request) (interface{}, Response)
 		log.Error().Err(err).Send()
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
 	}
-	middlewares.VoucherApplied.WithLabelValues(userID, voucher.Voucher, fmt.Sprint(voucher.VMs), fmt.Sprint(voucher.PublicIPs)).Inc()
+	middlewares.VoucherApplied.WithLabelValues(userID, voucher.Voucher, fmt.Sprint(voucher.CRU), fmt.Sprint(voucher.MRU), fmt.Sprint(voucher.SRU), fmt.Sprint(voucher.PublicIPs)).Inc()
 
 	return ResponseMsg{
 		Message: "Voucher request is being reviewed, you'll receive a confirmation mail soon",
@@ -607,13 +694,20 @@ func (a *App) ActivateVoucherHandler(req *http.Request) (interface{}, Response)
 		return nil, BadRequest(errors.New("failed to read voucher data"))
 	}
 
-	oldQuota, err := a.db.GetUserQuota(userID)
-	if err == gorm.ErrRecordNotFound {
-		return nil, NotFound(errors.New("user quota is not found"))
-	}
-	if err != nil {
-		log.Error().Err(err).Send()
-		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	// the voucher is activated into the quota of the user, or of the team if it is set
+	if input.TeamID != 0 {
+		if _, res := a.teamMember(input.TeamID, userID); res != nil {
+			return nil, res
+		}
+	} else {
+		_, err = a.db.GetUserQuota(userID)
+		if err == gorm.ErrRecordNotFound {
+			return nil, NotFound(errors.New("user quota is not found"))
+		}
+		if err != nil {
+			log.Error().Err(err).Send()
+			return nil, InternalServerError(errors.New(internalServerErrorMsg))
+		}
 	}
 
 	voucherQuota, err := a.db.GetVoucher(input.Voucher)
@@ -637,18 +731,32 @@ func (a *App) ActivateVoucherHandler(req *http.Request) (interface{}, Response)
 		return nil, BadRequest(errors.New("voucher is already used"))
 	}
 
-	err = a.db.DeactivateVoucher(userID, input.Voucher)
-	if err != nil {
-		log.Error().Err(err).Send()
-		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	if input.TeamID != 0 {
+		err = a.db.ActivateTeamVoucher(input.TeamID, userID, input.Voucher)
+		if err == gorm.ErrRecordNotFound {
+			return nil, BadRequest(errors.New("voucher is already used"))
+		}
+		if err != nil {
+			log.Error().Err(err).Send()
+			return nil, InternalServerError(errors.New(internalServerErrorMsg))
+		}
+		middlewares.VoucherActivated.WithLabelValues(userID, voucherQuota.Voucher, fmt.Sprint(voucherQuota.CRU), fmt.Sprint(voucherQuota.MRU), fmt.Sprint(voucherQuota.SRU), fmt.Sprint(voucherQuota.PublicIPs)).Inc()
+
+		return ResponseMsg{
+			Message: "Voucher is applied to the team successfully",
+			Data:    nil,
+		}, Ok()
 	}
 
-	err = a.db.UpdateUserQuota(userID, oldQuota.Vms+voucherQuota.VMs, oldQuota.PublicIPs+voucherQuota.PublicIPs)
+	err = a.db.ActivateVoucher(userID, input.Voucher)
+	if err == gorm.ErrRecordNotFound {
+		return nil, BadRequest(errors.New("voucher is already used"))
+	}
 	if err != nil {
 		log.Error().Err(err).Send()
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
 	}
-	middlewares.VoucherActivated.WithLabelValues(userID, voucherQuota.Voucher, fmt.Sprint(voucherQuota.VMs), fmt.Sprint(voucherQuota.PublicIPs)).Inc()
+	middlewares.VoucherActivated.WithLabelValues(userID, voucherQuota.Voucher, fmt.Sprint(voucherQuota.CRU), fmt.Sprint(voucherQuota.MRU), fmt.Sprint(voucherQuota.SRU), fmt.Sprint(voucherQuota.PublicIPs)).Inc()
 
 	return ResponseMsg{
 		Message: "Voucher is applied successfully",
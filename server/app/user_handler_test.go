@@ -4,8 +4,11 @@ package app
 import (
 	"bytes"
 	"crypto/sha256"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"time"
 
 	"testing"
 
@@ -28,6 +31,19 @@ var user = &models.User{
 	SSHKey:         "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABgQCSJYyNo6j1LxrjDTRGkbBgIyD/puMprzoepKr2zwbNobCEMfAx9DXBFstueQ9wYgcwO0Pu7/95BNgtGhjoRsNDEz5MBO0Iyhcr9hGYfoXrG2Ufr8IYu3i5DWLRmDERzuArZ6/aUWIpCfpheHX+/jH/R9vvnjO2phCutpkWrjx34/33U3pL+RRycA1uTsISZTyrcMZIXfABI4xBMFLundaBk6F4YFZaCjkUOLYld4KDxJ+N6cYnJ5pa5/hLzZQedn6h7SpMvSCghxOdCxqdEwF0m9odfsrXeKRBxRfL+HWxqytNKp9CgfLvE9Knmfn5GWhXYS6/7dY7GNUGxWSje6L1h9DFwhJLjTpEwoboNzveBmlcyDwduewFZZY+q1C/gKmJial3+0n6zkx4daQsiHc29KM5wiH8mvqpm5Ew9vWNOqw85sO7BaE1W5jMkZOuqIEJiz+KW6UicUBbv2YJ8kjvNtMLM1BiE3/WjVXQ3cMf1x1mUH4bFVgW7F42nnkuc2k= alaa@alaa-Inspiron-5537",
 }
 
+var verificationCode = 123456
+
+func saveVerificationCode(t *testing.T, app *App, userID string, purpose models.CodePurpose, expiresAt time.Time) {
+	err := app.db.SaveVerificationCode(&models.VerificationCode{
+		UserID:     userID,
+		Purpose:    purpose,
+		HashedCode: internal.HashCode(app.config.Token.Secret, verificationCode),
+		ExpiresAt:  expiresAt,
+		SentAt:     time.Now(),
+	})
+	assert.NoError(t, err)
+}
+
 func TestSignUpHandler(t *testing.T) {
 	app := SetUp(t)
 
@@ -56,7 +72,30 @@ func TestSignUpHandler(t *testing.T) {
 		assert.Equal(t, response.Code, http.StatusCreated)
 	})
 
+	t.Run("Sign up: code is hashed", func(t *testing.T) {
+		u, err := app.db.GetUserByEmail("name@gmail.com")
+		assert.NoError(t, err)
+
+		code, err := app.db.GetVerificationCode(u.ID.String(), models.SignUpCode)
+		assert.NoError(t, err)
+		assert.Len(t, code.HashedCode, 32)
+		assert.WithinDuration(t, time.Now().Add(60*time.Second), code.ExpiresAt, 5*time.Second)
+	})
+
+	t.Run("Sign up: code is requested again before cooldown", func(t *testing.T) {
+		req := unAuthHandlerConfig{
+			body:        bytes.NewBuffer(signUpBody),
+			handlerFunc: app.SignUpHandler,
+			api:         fmt.Sprintf("/%s/user/signup", app.config.Version),
+		}
+
+		response := unAuthorizedHandler(req)
+		assert.Equal(t, http.StatusTooManyRequests, response.Code)
+		assert.NotEmpty(t, response.Header().Get("Retry-After"))
+	})
+
 	t.Run("Sign up: user exists but not verified", func(t *testing.T) {
+		app.config.VerificationCode.ResendCooldownSeconds = 0
 		req := unAuthHandlerConfig{
 			body:        bytes.NewBuffer(signUpBody),
 			handlerFunc: app.SignUpHandler,
@@ -144,12 +183,12 @@ func TestSignUpHandler(t *testing.T) {
 func TestVerifySignUpCodeHandler(t *testing.T) {
 	app := SetUp(t)
 
-	user.Code = 1234
 	user.Verified = false
 	err := app.db.CreateUser(user)
 	assert.NoError(t, err)
+	saveVerificationCode(t, app, user.ID.String(), models.SignUpCode, time.Now().Add(time.Minute))
 
-	verifyBody := []byte(fmt.Sprintf(`{"email": "%s", "code": %d}`, user.Email, user.Code))
+	verifyBody := []byte(fmt.Sprintf(`{"email": "%s", "code": %d}`, user.Email, verificationCode))
 
 	t.Run("Verify sign up: success", func(t *testing.T) {
 		req := unAuthHandlerConfig{
@@ -174,7 +213,7 @@ func TestVerifySignUpCodeHandler(t *testing.T) {
 	})
 
 	t.Run("Verify sign up: user not found", func(t *testing.T) {
-		body := []byte(fmt.Sprintf(`{"email": "%s", "code": %d}`, "", user.Code))
+		body := []byte(fmt.Sprintf(`{"email": "%s", "code": %d}`, "", verificationCode))
 		req := unAuthHandlerConfig{
 			body:        bytes.NewBuffer(body),
 			handlerFunc: app.VerifySignUpCodeHandler,
@@ -200,10 +239,25 @@ func TestVerifySignUpCodeHandler(t *testing.T) {
 		assert.Equal(t, response.Code, http.StatusBadRequest)
 	})
 
-	t.Run("Verify sign up: wrong code", func(t *testing.T) {
+	t.Run("Verify sign up: code is used", func(t *testing.T) {
 		err := app.db.UpdateVerification(user.ID.String(), false)
 		assert.NoError(t, err)
 
+		req := unAuthHandlerConfig{
+			body:        bytes.NewBuffer(verifyBody),
+			handlerFunc: app.VerifySignUpCodeHandler,
+			api:         fmt.Sprintf("/%s/user/signup/verify_email", app.config.Version),
+		}
+
+		response := unAuthorizedHandler(req)
+		want := `{"err":"wrong code"}` + "\n"
+		assert.Equal(t, response.Body.String(), want)
+		assert.Equal(t, response.Code, http.StatusBadRequest)
+	})
+
+	t.Run("Verify sign up: wrong code", func(t *testing.T) {
+		saveVerificationCode(t, app, user.ID.String(), models.SignUpCode, time.Now().Add(time.Minute))
+
 		body := []byte(fmt.Sprintf(`{"email": "%s", "code": %d}`, user.Email, 0))
 
 		req := unAuthHandlerConfig{
@@ -218,8 +272,34 @@ func TestVerifySignUpCodeHandler(t *testing.T) {
 		assert.Equal(t, response.Code, http.StatusBadRequest)
 	})
 
+	t.Run("Verify sign up: too many attempts", func(t *testing.T) {
+		for i := 1; i < app.config.VerificationCode.MaxAttempts; i++ {
+			body := []byte(fmt.Sprintf(`{"email": "%s", "code": %d}`, user.Email, i))
+			req := unAuthHandlerConfig{
+				body:        bytes.NewBuffer(body),
+				handlerFunc: app.VerifySignUpCodeHandler,
+				api:         fmt.Sprintf("/%s/user/signup/verify_email", app.config.Version),
+			}
+
+			response := unAuthorizedHandler(req)
+			assert.Equal(t, response.Code, http.StatusBadRequest)
+		}
+
+		// the right code is locked too
+		req := unAuthHandlerConfig{
+			body:        bytes.NewBuffer(verifyBody),
+			handlerFunc: app.VerifySignUpCodeHandler,
+			api:         fmt.Sprintf("/%s/user/signup/verify_email", app.config.Version),
+		}
+
+		response := unAuthorizedHandler(req)
+		want := `{"err":"too many wrong attempts, please request a new code"}` + "\n"
+		assert.Equal(t, response.Body.String(), want)
+		assert.Equal(t, response.Code, http.StatusTooManyRequests)
+	})
+
 	t.Run("Verify sign up: code expired", func(t *testing.T) {
-		app.config.MailSender.Timeout = 0
+		saveVerificationCode(t, app, user.ID.String(), models.SignUpCode, time.Now().Add(-time.Second))
 		req := unAuthHandlerConfig{
 			body:        bytes.NewBuffer(verifyBody),
 			handlerFunc: app.VerifySignUpCodeHandler,
@@ -257,6 +337,22 @@ func TestSignInHandler(t *testing.T) {
 		assert.Equal(t, response.Code, http.StatusOK)
 	})
 
+	t.Run("Sign in: legacy password is rehashed", func(t *testing.T) {
+		u, err := app.db.GetUserByEmail(user.Email)
+		assert.NoError(t, err)
+		assert.False(t, internal.PasswordNeedsRehash(u.HashedPassword))
+		assert.True(t, internal.VerifyPassword(u.HashedPassword, password))
+
+		req := unAuthHandlerConfig{
+			body:        bytes.NewBuffer(signInBody),
+			handlerFunc: app.SignInHandler,
+			api:         fmt.Sprintf("/%s/user/signin", app.config.Version),
+		}
+
+		response := unAuthorizedHandler(req)
+		assert.Equal(t, response.Code, http.StatusOK)
+	})
+
 	t.Run("Sign in: wrong password", func(t *testing.T) {
 		body := []byte(`{
 			"name":"name",
@@ -329,64 +425,103 @@ func TestSignInHandler(t *testing.T) {
 func TestRefreshJWTHandler(t *testing.T) {
 	app := SetUp(t)
 
+	user.Verified = true
 	err := app.db.CreateUser(user)
 	assert.NoError(t, err)
 
-	token, err := internal.CreateJWT(user.ID.String(), user.Email, app.config.Token.Secret, app.config.Token.Timeout)
-	assert.NoError(t, err)
+	signIn := func(t *testing.T) map[string]string {
+		req := unAuthHandlerConfig{
+			body:        bytes.NewBuffer([]byte(`{"email":"name@gmail.com","password":"1234567"}`)),
+			handlerFunc: app.SignInHandler,
+			api:         fmt.Sprintf("/%s/user/signin", app.config.Version),
+		}
 
-	t.Run("refresh token: success", func(t *testing.T) {
-		token, err := internal.CreateJWT(user.ID.String(), user.Email, app.config.Token.Secret, 0)
-		assert.NoError(t, err)
+		response := unAuthorizedHandler(req)
+		assert.Equal(t, response.Code, http.StatusOK)
 
-		req := authHandlerConfig{
-			unAuthHandlerConfig: unAuthHandlerConfig{
-				body:        nil,
-				handlerFunc: app.RefreshJWTHandler,
-				api:         fmt.Sprintf("/%s/user/refresh_token", app.config.Version),
-			},
-			userID: user.ID.String(),
-			token:  token,
-			config: app.config,
+		var res struct {
+			Data map[string]string `json:"data"`
+		}
+		assert.NoError(t, json.NewDecoder(response.Body).Decode(&res))
+		assert.NotEmpty(t, res.Data["access_token"])
+		assert.NotEmpty(t, res.Data["refresh_token"])
+		return res.Data
+	}
+
+	refresh := func(refreshToken string) *httptest.ResponseRecorder {
+		req := unAuthHandlerConfig{
+			body:        bytes.NewBuffer([]byte(fmt.Sprintf(`{"refresh_token":%q}`, refreshToken))),
+			handlerFunc: app.RefreshJWTHandler,
+			api:         fmt.Sprintf("/%s/user/refresh_token", app.config.Version),
 		}
+		return unAuthorizedHandler(req)
+	}
 
-		response := authorizedNoMiddlewareHandler(req)
+	t.Run("refresh token: success", func(t *testing.T) {
+		tokens := signIn(t)
+
+		response := refresh(tokens["refresh_token"])
 		assert.Equal(t, response.Code, http.StatusOK)
-	})
 
-	t.Run("refresh token: not expired yet", func(t *testing.T) {
-		req := authHandlerConfig{
-			unAuthHandlerConfig: unAuthHandlerConfig{
-				body:        nil,
-				handlerFunc: app.RefreshJWTHandler,
-				api:         fmt.Sprintf("/%s/user/refresh_token", app.config.Version),
-			},
-			userID: user.ID.String(),
-			token:  token,
-			config: app.config,
+		var res struct {
+			Data map[string]string `json:"data"`
 		}
+		assert.NoError(t, json.NewDecoder(response.Body).Decode(&res))
+		assert.NotEqual(t, tokens["refresh_token"], res.Data["refresh_token"])
 
-		response := authorizedNoMiddlewareHandler(req)
+		// the rotated token works too
+		response = refresh(res.Data["refresh_token"])
 		assert.Equal(t, response.Code, http.StatusOK)
 	})
 
-	t.Run("refresh token: add empty token", func(t *testing.T) {
+	t.Run("refresh token: reused token revokes the session", func(t *testing.T) {
+		tokens := signIn(t)
+
+		response := refresh(tokens["refresh_token"])
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		var res struct {
+			Data map[string]string `json:"data"`
+		}
+		assert.NoError(t, json.NewDecoder(response.Body).Decode(&res))
+
+		response = refresh(tokens["refresh_token"])
+		want := `{"err":"refresh token is already used, please sign in again"}` + "\n"
+		assert.Equal(t, response.Body.String(), want)
+		assert.Equal(t, response.Code, http.StatusUnauthorized)
+
+		// the token of the stolen rotation is rejected as well
+		response = refresh(res.Data["refresh_token"])
+		want = `{"err":"session is revoked, please sign in again"}` + "\n"
+		assert.Equal(t, response.Body.String(), want)
+		assert.Equal(t, response.Code, http.StatusUnauthorized)
+
 		req := authHandlerConfig{
 			unAuthHandlerConfig: unAuthHandlerConfig{
-				body:        nil,
-				handlerFunc: app.RefreshJWTHandler,
-				api:         fmt.Sprintf("/%s/user/refresh_token", app.config.Version),
+				handlerFunc: app.GetUserHandler,
+				api:         fmt.Sprintf("/%s/user", app.config.Version),
 			},
-			userID: user.ID.String(),
-			token:  "",
+			token:  res.Data["access_token"],
 			config: app.config,
+			db:     app.db,
 		}
+		response = authorizedHandler(req)
+		assert.Equal(t, response.Code, http.StatusUnauthorized)
+	})
 
-		response := authorizedNoMiddlewareHandler(req)
-		want := `{"err":"token is required"}` + "\n"
+	t.Run("refresh token: add empty token", func(t *testing.T) {
+		response := refresh("")
+		want := `{"err":"refresh token is required"}` + "\n"
 		assert.Equal(t, response.Body.String(), want)
 		assert.Equal(t, response.Code, http.StatusBadRequest)
 	})
+
+	t.Run("refresh token: invalid token", func(t *testing.T) {
+		response := refresh("invalid")
+		want := `{"err":"refresh token is invalid"}` + "\n"
+		assert.Equal(t, response.Body.String(), want)
+		assert.Equal(t, response.Code, http.StatusUnauthorized)
+	})
 }
 
 func TestForgotPasswordHandler(t *testing.T) {
@@ -411,6 +546,17 @@ func TestForgotPasswordHandler(t *testing.T) {
 		assert.Equal(t, response.Code, http.StatusOK)
 	})
 
+	t.Run("forgot password: code is requested again before cooldown", func(t *testing.T) {
+		req := unAuthHandlerConfig{
+			body:        bytes.NewBuffer(forgetPassBody),
+			handlerFunc: app.ForgotPasswordHandler,
+			api:         fmt.Sprintf("/%s/user/forgot_password", app.config.Version),
+		}
+
+		response := unAuthorizedHandler(req)
+		assert.Equal(t, http.StatusTooManyRequests, response.Code)
+	})
+
 	t.Run("forgot password: add wrong email", func(t *testing.T) {
 		body := []byte(`{
 			"email":"abcde@gmail.com"
@@ -445,12 +591,12 @@ func TestForgotPasswordHandler(t *testing.T) {
 func TestVerifyForgetPasswordCodeHandler(t *testing.T) {
 	app := SetUp(t)
 
-	user.Code = 1234
 	user.Verified = true
 	err := app.db.CreateUser(user)
 	assert.NoError(t, err)
+	saveVerificationCode(t, app, user.ID.String(), models.ResetPasswordCode, time.Now().Add(time.Minute))
 
-	verifyBody := []byte(fmt.Sprintf(`{"email": "%s", "code": %d}`, user.Email, user.Code))
+	verifyBody := []byte(fmt.Sprintf(`{"email": "%s", "code": %d}`, user.Email, verificationCode))
 
 	t.Run("verify forget password: success", func(t *testing.T) {
 		req := unAuthHandlerConfig{
@@ -463,7 +609,21 @@ func TestVerifyForgetPasswordCodeHandler(t *testing.T) {
 		assert.Equal(t, response.Code, http.StatusOK)
 	})
 
+	t.Run("verify forget password: sign up code is not accepted", func(t *testing.T) {
+		saveVerificationCode(t, app, user.ID.String(), models.SignUpCode, time.Now().Add(time.Minute))
+
+		req := unAuthHandlerConfig{
+			body:        bytes.NewBuffer(verifyBody),
+			handlerFunc: app.VerifyForgetPasswordCodeHandler,
+			api:         fmt.Sprintf("/%s/user/forget_password/verify_email", app.config.Version),
+		}
+
+		response := unAuthorizedHandler(req)
+		assert.Equal(t, response.Code, http.StatusBadRequest)
+	})
+
 	t.Run("verify forget password: wrong code", func(t *testing.T) {
+		saveVerificationCode(t, app, user.ID.String(), models.ResetPasswordCode, time.Now().Add(time.Minute))
 		body := []byte(fmt.Sprintf(`{"email": "%s", "code": %d}`, user.Email, 0))
 
 		req := unAuthHandlerConfig{
@@ -529,7 +689,7 @@ func TestChangePasswordHandler(t *testing.T) {
 		"confirm_password":"newpass"
 		}`)
 
-	token, err := internal.CreateJWT(user.ID.String(), user.Email, app.config.Token.Secret, app.config.Token.Timeout)
+	token, err := newAccessToken(app, user.ID.String(), user.Email)
 	assert.NoError(t, err)
 
 	t.Run("change password: success", func(t *testing.T) {
@@ -660,7 +820,7 @@ func TestUpdateUserHandler(t *testing.T) {
 		"confirm_password":"newpass"
 	}`)
 
-	token, err := internal.CreateJWT(user.ID.String(), user.Email, app.config.Token.Secret, app.config.Token.Timeout)
+	token, err := newAccessToken(app, user.ID.String(), user.Email)
 	assert.NoError(t, err)
 
 	t.Run("Update user: success", func(t *testing.T) {
@@ -787,7 +947,7 @@ func TestUpdateUserHandler(t *testing.T) {
 	})
 
 	t.Run("Update user: wrong user ID", func(t *testing.T) {
-		token, err := internal.CreateJWT("", user.Email, app.config.Token.Secret, app.config.Token.Timeout)
+		token, err := newAccessToken(app, "", user.Email)
 		assert.NoError(t, err)
 
 		req := authHandlerConfig{
@@ -816,7 +976,7 @@ func TestGetUserHandler(t *testing.T) {
 	err := app.db.CreateUser(user)
 	assert.NoError(t, err)
 
-	token, err := internal.CreateJWT(user.ID.String(), user.Email, app.config.Token.Secret, app.config.Token.Timeout)
+	token, err := newAccessToken(app, user.ID.String(), user.Email)
 	assert.NoError(t, err)
 
 	t.Run("get user: success", func(t *testing.T) {
@@ -837,7 +997,7 @@ func TestGetUserHandler(t *testing.T) {
 	})
 
 	t.Run("user not found", func(t *testing.T) {
-		token, err := internal.CreateJWT("", user.Email, app.config.Token.Secret, app.config.Token.Timeout)
+		token, err := newAccessToken(app, "", user.Email)
 		assert.NoError(t, err)
 
 		req := authHandlerConfig{
@@ -866,11 +1026,13 @@ func TestApplyForVoucherHandler(t *testing.T) {
 	err := app.db.CreateUser(user)
 	assert.NoError(t, err)
 
-	token, err := internal.CreateJWT(user.ID.String(), user.Email, app.config.Token.Secret, app.config.Token.Timeout)
+	token, err := newAccessToken(app, user.ID.String(), user.Email)
 	assert.NoError(t, err)
 
 	voucherBody := []byte(`{
-		"vms":10,
+		"cru":4,
+		"mru":8,
+		"sru":100,
 		"public_ips":1,
 		"reason":"strongReason"
 	}`)
@@ -913,11 +1075,11 @@ func TestApplyForVoucherHandler(t *testing.T) {
 
 	t.Run("Apply voucher: user already applied before", func(t *testing.T) {
 		v := models.Voucher{
-			UserID:   user.ID.String(),
-			Voucher:  "voucher",
-			VMs:      10,
-			Approved: false,
-			Rejected: false,
+			UserID:    user.ID.String(),
+			Voucher:   "voucher",
+			Resources: models.Resources{CRU: 10, MRU: 20, SRU: 250},
+			Approved:  false,
+			Rejected:  false,
 		}
 		err = app.db.CreateVoucher(&v)
 		assert.NoError(t, err)
@@ -956,15 +1118,15 @@ func TestActivateVoucherHandler(t *testing.T) {
 	assert.NoError(t, err)
 
 	v := models.Voucher{
-		Voucher:  "voucher",
-		VMs:      10,
-		Approved: true,
+		Voucher:   "voucher",
+		Resources: models.Resources{CRU: 10, MRU: 20, SRU: 250},
+		Approved:  true,
 	}
 
 	err = app.db.CreateVoucher(&v)
 	assert.NoError(t, err)
 
-	token, err := internal.CreateJWT(user.ID.String(), user.Email, app.config.Token.Secret, app.config.Token.Timeout)
+	token, err := newAccessToken(app, user.ID.String(), user.Email)
 	assert.NoError(t, err)
 
 	voucherBody := []byte(fmt.Sprintf(`{"voucher" : "%s"}`, v.Voucher))
@@ -1033,7 +1195,7 @@ func TestActivateVoucherHandler(t *testing.T) {
 		err := app.db.CreateUser(newUser)
 		assert.NoError(t, err)
 
-		token, err := internal.CreateJWT(newUser.ID.String(), newUser.Email, app.config.Token.Secret, app.config.Token.Timeout)
+		token, err := newAccessToken(app, newUser.ID.String(), newUser.Email)
 		assert.NoError(t, err)
 
 		req := authHandlerConfig{
@@ -1076,9 +1238,9 @@ func TestActivateVoucherHandler(t *testing.T) {
 
 	t.Run("Activate voucher: voucher is rejected", func(t *testing.T) {
 		v := models.Voucher{
-			Voucher:  "rejected_voucher",
-			VMs:      10,
-			Rejected: true,
+			Voucher:   "rejected_voucher",
+			Resources: models.Resources{CRU: 10, MRU: 20, SRU: 250},
+			Rejected:  true,
 		}
 		err = app.db.CreateVoucher(&v)
 		assert.NoError(t, err)
@@ -1104,10 +1266,10 @@ func TestActivateVoucherHandler(t *testing.T) {
 
 	t.Run("Activate voucher: voucher is not approved yet", func(t *testing.T) {
 		v := models.Voucher{
-			Voucher:  "pending_voucher",
-			VMs:      10,
-			Approved: false,
-			Rejected: false,
+			Voucher:   "pending_voucher",
+			Resources: models.Resources{CRU: 10, MRU: 20, SRU: 250},
+			Approved:  false,
+			Rejected:  false,
 		}
 		err = app.db.CreateVoucher(&v)
 		assert.NoError(t, err)
@@ -44,17 +44,13 @@ func (a *App) DeployVMHandler(req *http.Request) (interface{}, Response) {
 		return nil, BadRequest(errors.New("invalid vm data"))
 	}
 
-	// check quota of user
-	quota, err := a.db.GetUserQuota(user.ID.String())
-	if err == gorm.ErrRecordNotFound {
-		return nil, NotFound(errors.New("user quota is not found"))
-	}
-	if err != nil {
-		log.Error().Err(err).Send()
-		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	// check quota of user or team
+	quota, res := a.deploymentQuota(user.ID.String(), input.TeamID)
+	if res != nil {
+		return nil, res
 	}
 
-	_, err = deployer.ValidateVMQuota(input, quota.Vms, quota.PublicIPs)
+	neededQuota, err := deployer.ValidateVMQuota(input, quota)
 	if err != nil {
 		return nil, BadRequest(errors.New(err.Error()))
 	}
@@ -74,15 +70,42 @@ func (a *App) DeployVMHandler(req *http.Request) (interface{}, Response) {
 		return nil, BadRequest(errors.New("virtual machine name is not available, please choose a different name"))
 	}
 
-	err = a.deployer.Redis.PushVMRequest(streams.VMDeployRequest{User: user, Input: input, AdminSSHKey: a.config.AdminSSHKey})
+	job := models.Job{
+		UserID: user.ID.String(),
+		Type:   models.VMsType,
+		Name:   input.Name,
+		State:  models.JobQueued,
+	}
+	// the quota is held from now so queued requests can't use more than the available quota
+	reservation := models.QuotaReservation{
+		UserID:    user.ID.String(),
+		TeamID:    input.TeamID,
+		Resources: neededQuota,
+	}
+	err = a.db.CreateJobWithReservation(&job, &reservation)
+	if err == models.ErrInsufficientQuota {
+		return nil, BadRequest(errors.New("no available quota for deployment, you can request a new voucher"))
+	}
 	if err != nil {
 		log.Error().Err(err).Send()
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
 	}
 
+	err = streams.PushVMRequest(a.deployer.Queue, streams.VMDeployRequest{JobID: job.ID, User: user, Input: input, AdminSSHKey: a.config.AdminSSHKey})
+	if err != nil {
+		log.Error().Err(err).Send()
+		if err := a.db.UpdateJobState(job.ID, models.JobFailed, internalServerErrorMsg); err != nil {
+			log.Error().Err(err).Send()
+		}
+		if err := a.db.ReleaseQuotaReservation(reservation.ID); err != nil {
+			log.Error().Err(err).Send()
+		}
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
 	return ResponseMsg{
 		Message: "Virtual machine request is being deployed, you'll receive a confirmation notification soon",
-		Data:    nil,
+		Data:    job,
 	}, Created()
 }
 
@@ -130,7 +153,12 @@ func (a *App) GetVMHandler(req *http.Request) (interface{}, Response) {
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
 	}
 
-	if vm.UserID != userID {
+	allowed, err := a.canAccessDeployment(userID, vm.UserID, vm.TeamID, false)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+	if !allowed {
 		return nil, NotFound(errors.New("virtual machine is not found"))
 	}
 
@@ -172,7 +200,7 @@ func (a *App) DeleteVMHandler(req *http.Request) (interface{}, Response) {
 	}
 
 	vm, err := a.db.GetVMByID(id)
-	if err == gorm.ErrRecordNotFound || vm.UserID != userID {
+	if err == gorm.ErrRecordNotFound {
 		return nil, NotFound(errors.New("virtual machine is not found"))
 	}
 	if err != nil {
@@ -180,6 +208,15 @@ func (a *App) DeleteVMHandler(req *http.Request) (interface{}, Response) {
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
 	}
 
+	allowed, err := a.canAccessDeployment(userID, vm.UserID, vm.TeamID, true)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+	if !allowed {
+		return nil, NotFound(errors.New("virtual machine is not found"))
+	}
+
 	err = a.deployer.CancelDeployment(vm.ContractID, vm.NetworkContractID, "vm", vm.Name)
 	if err != nil && !strings.Contains(err.Error(), "ContractNotExists") {
 		log.Error().Err(err).Send()
@@ -7,7 +7,6 @@ import (
 	"net/http"
 	"testing"
 
-	"github.com/codescalers/cloud4students/internal"
 	"github.com/codescalers/cloud4students/models"
 	"github.com/stretchr/testify/assert"
 )
@@ -19,7 +18,7 @@ func TestGetAndDeleteVMHandler(t *testing.T) {
 	err := app.db.CreateUser(user)
 	assert.NoError(t, err)
 
-	token, err := internal.CreateJWT(user.ID.String(), user.Email, app.config.Token.Secret, app.config.Token.Timeout)
+	token, err := newAccessToken(app, user.ID.String(), user.Email)
 	assert.NoError(t, err)
 
 	t.Run("Get vm: not found", func(t *testing.T) {
@@ -210,7 +209,7 @@ func TestListAndDeleteVMsHandler(t *testing.T) {
 	err := app.db.CreateUser(user)
 	assert.NoError(t, err)
 
-	token, err := internal.CreateJWT(user.ID.String(), user.Email, app.config.Token.Secret, app.config.Token.Timeout)
+	token, err := newAccessToken(app, user.ID.String(), user.Email)
 	assert.NoError(t, err)
 
 	t.Run("Get all vms: no vms", func(t *testing.T) {
@@ -4,10 +4,12 @@ package app
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 
 	"github.com/codescalers/cloud4students/internal"
+	"github.com/codescalers/cloud4students/middlewares"
 	"github.com/codescalers/cloud4students/models"
 	"github.com/gorilla/mux"
 	"github.com/rs/zerolog/log"
@@ -18,8 +20,10 @@ import (
 // GenerateVoucherInput struct for data needed when user generate vouchers
 type GenerateVoucherInput struct {
 	Length    int `json:"length" binding:"required" validate:"min=3,max=20"`
-	VMs       int `json:"vms" binding:"required"`
-	PublicIPs int `json:"public_ips" binding:"required"`
+	CRU       int `json:"cru" binding:"required" validate:"min=0"`
+	MRU       int `json:"mru" binding:"required" validate:"min=0"`
+	SRU       int `json:"sru" binding:"required" validate:"min=0"`
+	PublicIPs int `json:"public_ips" binding:"required" validate:"min=0"`
 }
 
 // UpdateVoucherInput struct for data needed when user update voucher
@@ -45,8 +49,7 @@ func (a *App) GenerateVoucherHandler(req *http.Request) (interface{}, Response)
 
 	v := models.Voucher{
 		Voucher:   voucher,
-		VMs:       input.VMs,
-		PublicIPs: input.PublicIPs,
+		Resources: models.Resources{CRU: input.CRU, MRU: input.MRU, SRU: input.SRU, PublicIPs: input.PublicIPs},
 		Approved:  true,
 	}
 
@@ -61,6 +64,7 @@ func (a *App) GenerateVoucherHandler(req *http.Request) (interface{}, Response)
 		log.Error().Err(err).Send()
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
 	}
+	middlewares.AuditChange(req, fmt.Sprintf("voucher=%d", v.ID), nil, input)
 
 	return ResponseMsg{
 		Message: "Voucher is generated successfully",
@@ -126,6 +130,10 @@ func (a *App) UpdateVoucherHandler(req *http.Request) (interface{}, Response) {
 		log.Error().Err(err).Send()
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
 	}
+	middlewares.AuditChange(req, "",
+		map[string]bool{"approved": voucher.Approved, "rejected": voucher.Rejected},
+		map[string]bool{"approved": updatedVoucher.Approved, "rejected": updatedVoucher.Rejected},
+	)
 
 	user, err := a.db.GetUserByID(updatedVoucher.UserID)
 	if err == gorm.ErrRecordNotFound {
@@ -136,14 +144,34 @@ func (a *App) UpdateVoucherHandler(req *http.Request) (interface{}, Response) {
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
 	}
 
-	var subject, body string
+	var mail internal.Mail
+	notification := models.Notification{
+		UserID:  user.ID.String(),
+		Type:    models.VoucherType,
+		Payload: models.NotificationPayload{VoucherID: updatedVoucher.ID},
+	}
 	if input.Approved {
-		subject, body = internal.ApprovedVoucherMailContent(updatedVoucher.Voucher, user.Name, a.config.Server.Host)
+		mail, err = internal.ApprovedVoucherMailContent(a.config.Server.Host, internal.ApprovedVoucherMailData{Name: user.Name, Voucher: updatedVoucher.Voucher})
+		notification.Msg = fmt.Sprintf("Your voucher request is approved, your voucher is %s", updatedVoucher.Voucher)
+		notification.Severity = models.SeveritySuccess
 	} else {
-		subject, body = internal.RejectedVoucherMailContent(user.Name, a.config.Server.Host)
+		mail, err = internal.RejectedVoucherMailContent(a.config.Server.Host, internal.RejectedVoucherMailData{Name: user.Name})
+		notification.Msg = "Your voucher request is rejected"
+		notification.Severity = models.SeverityWarning
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
 	}
 
-	err = internal.SendMail(a.config.MailSender.Email, a.config.MailSender.SendGridKey, user.Email, subject, body)
+	err = a.db.CreateNotification(&notification)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+	a.publishNotification(notification)
+
+	err = a.outbox.Enqueue(user.Email, mail)
 	if err != nil {
 		log.Error().Err(err).Send()
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
@@ -163,6 +191,11 @@ func (a *App) ApproveAllVouchersHandler(req *http.Request) (interface{}, Respons
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
 	}
 
+	approved := []int{}
+	defer func() {
+		middlewares.AuditChange(req, "", nil, map[string]interface{}{"approved": approved, "count": len(approved)})
+	}()
+
 	for _, v := range vouchers {
 		if v.Approved || v.Rejected {
 			continue
@@ -173,6 +206,7 @@ func (a *App) ApproveAllVouchersHandler(req *http.Request) (interface{}, Respons
 			log.Error().Err(err).Send()
 			return nil, InternalServerError(errors.New(internalServerErrorMsg))
 		}
+		approved = append(approved, v.ID)
 
 		user, err := a.db.GetUserByID(v.UserID)
 		if err == gorm.ErrRecordNotFound {
@@ -183,8 +217,27 @@ func (a *App) ApproveAllVouchersHandler(req *http.Request) (interface{}, Respons
 			return nil, InternalServerError(errors.New(internalServerErrorMsg))
 		}
 
-		subject, body := internal.ApprovedVoucherMailContent(v.Voucher, user.Name, a.config.Server.Host)
-		err = internal.SendMail(a.config.MailSender.Email, a.config.MailSender.SendGridKey, user.Email, subject, body)
+		notification := models.Notification{
+			UserID:   user.ID.String(),
+			Msg:      fmt.Sprintf("Your voucher request is approved, your voucher is %s", v.Voucher),
+			Type:     models.VoucherType,
+			Severity: models.SeveritySuccess,
+			Payload:  models.NotificationPayload{VoucherID: v.ID},
+		}
+		err = a.db.CreateNotification(&notification)
+		if err != nil {
+			log.Error().Err(err).Send()
+			return nil, InternalServerError(errors.New(internalServerErrorMsg))
+		}
+		a.publishNotification(notification)
+
+		mail, err := internal.ApprovedVoucherMailContent(a.config.Server.Host, internal.ApprovedVoucherMailData{Name: user.Name, Voucher: v.Voucher})
+		if err != nil {
+			log.Error().Err(err).Send()
+			return nil, InternalServerError(errors.New(internalServerErrorMsg))
+		}
+
+		err = a.outbox.Enqueue(user.Email, mail)
 		if err != nil {
 			log.Error().Err(err).Send()
 			return nil, InternalServerError(errors.New(internalServerErrorMsg))
@@ -7,7 +7,6 @@ import (
 	"net/http"
 	"testing"
 
-	"github.com/codescalers/cloud4students/internal"
 	"github.com/codescalers/cloud4students/models"
 	"github.com/stretchr/testify/assert"
 )
@@ -20,12 +19,14 @@ func TestGenerateVoucherHandler(t *testing.T) {
 	err := app.db.CreateUser(user)
 	assert.NoError(t, err)
 
-	token, err := internal.CreateJWT(user.ID.String(), user.Email, app.config.Token.Secret, app.config.Token.Timeout)
+	token, err := newAccessToken(app, user.ID.String(), user.Email)
 	assert.NoError(t, err)
 
 	voucherBody := []byte(`{
 		"length": 5,
-		"vms": 10,
+		"cru": 4,
+		"mru": 8,
+		"sru": 100,
 		"public_ips": 1
 	}`)
 
@@ -49,7 +50,9 @@ func TestGenerateVoucherHandler(t *testing.T) {
 	t.Run("Generate voucher: invalid data", func(t *testing.T) {
 		body := []byte(`{
 			"length": 2,
-			"vms": 10,
+			"cru": 4,
+			"mru": 8,
+			"sru": 100,
 			"public_ips": 1
 		}`)
 
@@ -99,7 +102,7 @@ func TestListVouchersHandler(t *testing.T) {
 	err := app.db.CreateUser(user)
 	assert.NoError(t, err)
 
-	token, err := internal.CreateJWT(user.ID.String(), user.Email, app.config.Token.Secret, app.config.Token.Timeout)
+	token, err := newAccessToken(app, user.ID.String(), user.Email)
 	assert.NoError(t, err)
 
 	t.Run("List vouchers: no vouchers found", func(t *testing.T) {
@@ -156,7 +159,7 @@ func TestUpdateVoucherHandler(t *testing.T) {
 	err := app.db.CreateUser(user)
 	assert.NoError(t, err)
 
-	token, err := internal.CreateJWT(user.ID.String(), user.Email, app.config.Token.Secret, app.config.Token.Timeout)
+	token, err := newAccessToken(app, user.ID.String(), user.Email)
 	assert.NoError(t, err)
 
 	v := models.Voucher{
@@ -319,7 +322,7 @@ func TestApproveAllVouchers(t *testing.T) {
 	err := app.db.CreateUser(user)
 	assert.NoError(t, err)
 
-	token, err := internal.CreateJWT(user.ID.String(), user.Email, app.config.Token.Secret, app.config.Token.Timeout)
+	token, err := newAccessToken(app, user.ID.String(), user.Email)
 	assert.NoError(t, err)
 
 	t.Run("approve all: no vouchers found", func(t *testing.T) {
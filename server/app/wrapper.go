@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"time"
 
 	"github.com/codescalers/cloud4students/middlewares"
 	"github.com/rs/zerolog/log"
@@ -155,6 +157,11 @@ func Forbidden(err error) Response {
 	return Error(err, http.StatusForbidden)
 }
 
+// TooManyRequests response, retryAfter tells the client when to try again
+func TooManyRequests(err error, retryAfter time.Duration) Response {
+	return Error(err, http.StatusTooManyRequests).WithHeader("Retry-After", fmt.Sprint(int(math.Ceil(retryAfter.Seconds()))))
+}
+
 // Accepted response
 func Accepted() Response {
 	return genericResponse{status: http.StatusAccepted}
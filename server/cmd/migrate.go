@@ -0,0 +1,124 @@
+// Package cmd to make it cmd app
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/codescalers/cloud4students/internal"
+	"github.com/codescalers/cloud4students/models"
+	"github.com/spf13/cobra"
+)
+
+// migrateCmd represents the migrate command
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Manage database schema migrations",
+}
+
+// migrateUpCmd applies pending migrations
+var migrateUpCmd = &cobra.Command{
+	Use:   "up",
+	Short: "Apply pending migrations",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		steps, err := cmd.Flags().GetInt("steps")
+		if err != nil {
+			return fmt.Errorf("failed to parse steps: %w", err)
+		}
+
+		db, err := connectDB(cmd)
+		if err != nil {
+			return err
+		}
+
+		if err := db.MigrateUp(steps); err != nil {
+			return err
+		}
+		return printMigrationsStatus(db)
+	},
+}
+
+// migrateDownCmd rolls back applied migrations
+var migrateDownCmd = &cobra.Command{
+	Use:   "down",
+	Short: "Roll back applied migrations",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		steps, err := cmd.Flags().GetInt("steps")
+		if err != nil {
+			return fmt.Errorf("failed to parse steps: %w", err)
+		}
+
+		db, err := connectDB(cmd)
+		if err != nil {
+			return err
+		}
+
+		if err := db.MigrateDown(steps); err != nil {
+			return err
+		}
+		return printMigrationsStatus(db)
+	},
+}
+
+// migrateStatusCmd lists migrations with their state
+var migrateStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "List migrations and whether they are applied",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, err := connectDB(cmd)
+		if err != nil {
+			return err
+		}
+
+		return printMigrationsStatus(db)
+	},
+}
+
+func connectDB(cmd *cobra.Command) (*models.DB, error) {
+	configFile, err := cmd.Flags().GetString("config")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+
+	config, err := internal.ReadConfFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	db := models.NewDB()
+	err = db.ConnectDriver(config.Database.Driver, config.Database.DataSource())
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	return db, nil
+}
+
+func printMigrationsStatus(db *models.DB) error {
+	statuses, err := db.MigrationsStatus()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+	for _, s := range statuses {
+		appliedAt := "pending"
+		if s.Applied {
+			appliedAt = s.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+	}
+
+	return w.Flush()
+}
+
+func init() {
+	migrateCmd.PersistentFlags().StringP("config", "c", "./config.json", "Enter your configurations path")
+	migrateUpCmd.Flags().IntP("steps", "n", 0, "Number of migrations to apply, 0 applies all pending migrations")
+	migrateDownCmd.Flags().IntP("steps", "n", 1, "Number of migrations to roll back, 0 rolls back all applied migrations")
+
+	migrateCmd.AddCommand(migrateUpCmd, migrateDownCmd, migrateStatusCmd)
+	rootCmd.AddCommand(migrateCmd)
+}
@@ -0,0 +1,30 @@
+// Package deployer for handling deployments
+package deployer
+
+import (
+	"strings"
+
+	"github.com/codescalers/cloud4students/models"
+	"github.com/rs/zerolog/log"
+)
+
+// compensate cancels the contracts of a deployment that failed or couldn't be saved so they aren't paid for
+// without a deployment, and records it to be audited. netType is the network type of the deployment, vm or k8s
+func (d *Deployer) compensate(netType string, compensation models.Compensation) {
+	err := d.CancelDeployment(compensation.ContractID, compensation.NetworkContractID, netType, compensation.Name)
+	if err != nil && !strings.Contains(err.Error(), "ContractNotExists") {
+		compensation.CancelError = err.Error()
+	} else {
+		compensation.Canceled = true
+	}
+
+	logger := log.Warn()
+	if !compensation.Canceled {
+		logger = log.Error()
+	}
+	logger.Msgf("compensated deployment that couldn't be saved: %+v", compensation)
+
+	if err := d.db.CreateCompensation(&compensation); err != nil {
+		log.Error().Err(err).Msgf("failed to record compensation: %+v", compensation)
+	}
+}
@@ -0,0 +1,53 @@
+// Package deployer for handling deployments
+package deployer
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/codescalers/cloud4students/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeployVMRequestCompensation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d, db, grid, user := setupRetryDeployer(t)
+	go d.PeriodicDeploy(ctx, 1)
+	go d.ListenDeploymentResults(ctx)
+
+	// the vm can't be saved because its name is taken
+	err := db.CreateVM(&models.VM{UserID: "other", Name: "vm"})
+	assert.NoError(t, err)
+
+	job := models.Job{UserID: user.ID.String(), Type: models.VMsType, Name: "vm", State: models.JobQueued}
+	err = db.CreateJobWithReservation(&job, &models.QuotaReservation{UserID: user.ID.String(), Resources: models.Resources{CRU: 1, MRU: 2, SRU: 25}})
+	assert.NoError(t, err)
+
+	codeErr, err := d.deployVMRequest(ctx, job.ID, user, models.DeployVMInput{Name: "vm", Resources: "small"}, "")
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusInternalServerError, codeErr)
+
+	// contracts of the deployment are canceled
+	assert.Empty(t, grid.ActiveContracts())
+
+	compensations, err := db.ListCompensations()
+	assert.NoError(t, err)
+	assert.Len(t, compensations, 1)
+	assert.Equal(t, job.ID, compensations[0].JobID)
+	assert.Equal(t, models.VMsType, compensations[0].Type)
+	assert.True(t, compensations[0].Canceled)
+	assert.NotZero(t, compensations[0].ContractID)
+	assert.NotZero(t, compensations[0].NetworkContractID)
+	assert.NotEmpty(t, compensations[0].Error)
+
+	// the reservation isn't committed and the job has no deployment
+	_, err = db.GetJobQuotaReservation(job.ID)
+	assert.NoError(t, err)
+
+	job, err = db.GetJob(job.ID)
+	assert.NoError(t, err)
+	assert.Zero(t, job.DeploymentID)
+}
@@ -0,0 +1,203 @@
+// Package deployer for handling deployments
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/codescalers/cloud4students/models"
+	"github.com/codescalers/cloud4students/streams"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// maxBackoff caps the wait between attempts of a request
+	maxBackoff = time.Hour
+	// staleClaimIdle is how long a request of another consumer stays pending before it is taken over.
+	// It is longer than any deployment so requests still deploying on other replicas are not deployed twice
+	staleClaimIdle = time.Hour
+)
+
+// ErrDeadLetterNotFound is returned if a dead letter doesn't exist
+var ErrDeadLetterNotFound = errors.New("dead letter is not found")
+
+// retryable errors are the internal ones like grid failures, invalid requests fail immediately
+func retryable(codeErr int) bool {
+	return codeErr >= http.StatusInternalServerError
+}
+
+// backoffAfter returns the wait before retrying a request that failed the given attempts
+func (d *Deployer) backoffAfter(attempts int64) time.Duration {
+	backoff := d.backoff
+	for i := int64(1); i < attempts && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > maxBackoff {
+		return maxBackoff
+	}
+	return backoff
+}
+
+// retryLater keeps the request pending to be retried if it has attempts left
+func (d *Deployer) retryLater(id string, codeErr int, attempts int64) bool {
+	if !retryable(codeErr) || attempts >= d.maxAttempts {
+		return false
+	}
+
+	log.Warn().Msgf("request with ID: %s failed attempt %d of %d, it will be retried after %s", id, attempts, d.maxAttempts, d.backoffAfter(attempts))
+	return true
+}
+
+// completeRequest acknowledges the request, or moves it to the dead letters if it failed all its attempts
+func (d *Deployer) completeRequest(stream, group, deadStream string, message streams.Message, attempts int64, codeErr int, resErr error) (int, error) {
+	if resErr != nil && retryable(codeErr) {
+		if err := d.deadLetter(stream, group, deadStream, message, attempts, resErr); err != nil {
+			log.Error().Err(err).Msgf("failed to move request with ID: %s to dead letters", message.ID)
+		}
+		return codeErr, resErr
+	}
+
+	if err := d.Queue.Ack(stream, group, message.ID); err != nil {
+		log.Error().Err(err).Msgf("failed to acknowledge request with ID: %s", message.ID)
+		return http.StatusInternalServerError, err
+	}
+
+	return codeErr, resErr
+}
+
+// deadLetter moves a request that can't be deployed to the dead letter stream
+func (d *Deployer) deadLetter(stream, group, deadStream string, message streams.Message, attempts int64, resErr error) error {
+	bytes, err := json.Marshal(streams.DeadLetter{
+		Stream:    stream,
+		MessageID: message.ID,
+		Data:      string(message.Data),
+		Error:     resErr.Error(),
+		Attempts:  attempts,
+		FailedAt:  time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := d.Queue.Push(deadStream, bytes); err != nil {
+		return err
+	}
+
+	return d.Queue.Ack(stream, group, message.ID)
+}
+
+func (d *Deployer) retryRequests(ctx context.Context, stream, group string, process func(context.Context, streams.Message, int64)) {
+	pending, err := d.Queue.Pending(stream, group, 0)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to list pending requests of stream %s", stream)
+		return
+	}
+
+	var wg sync.WaitGroup
+
+	for _, p := range pending {
+		minIdle := d.backoffAfter(p.RetryCount)
+		if p.Consumer != d.Queue.Consumer() && minIdle < staleClaimIdle {
+			minIdle = staleClaimIdle
+		}
+
+		if p.Idle < minIdle {
+			continue
+		}
+
+		messages, err := d.Queue.Claim(stream, group, minIdle, p.ID)
+		if err != nil {
+			log.Error().Err(err).Msgf("failed to claim request with ID: %s", p.ID)
+			continue
+		}
+
+		for _, message := range messages {
+			wg.Add(1)
+			go func(message streams.Message, attempts int64) {
+				defer wg.Done()
+				process(ctx, message, attempts)
+			}(message, p.RetryCount+1)
+		}
+	}
+	wg.Wait()
+}
+
+// DeadLetters lists the requests of the dead letter stream
+func (d *Deployer) DeadLetters(deadStream string) ([]streams.DeadLetter, error) {
+	messages, err := d.Queue.Range(deadStream, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	deadLetters := []streams.DeadLetter{}
+	for _, message := range messages {
+		var deadLetter streams.DeadLetter
+		if err := json.Unmarshal(message.Data, &deadLetter); err != nil {
+			log.Error().Err(err).Msgf("failed to unmarshal dead letter with ID: %s", message.ID)
+			continue
+		}
+
+		deadLetter.ID = message.ID
+		deadLetters = append(deadLetters, deadLetter)
+	}
+
+	return deadLetters, nil
+}
+
+// DeadLetter returns a request of the dead letter stream by its ID
+func (d *Deployer) DeadLetter(deadStream, id string) (streams.DeadLetter, error) {
+	deadLetters, err := d.DeadLetters(deadStream)
+	if err != nil {
+		return streams.DeadLetter{}, err
+	}
+
+	for _, deadLetter := range deadLetters {
+		if deadLetter.ID == id {
+			return deadLetter, nil
+		}
+	}
+
+	return streams.DeadLetter{}, ErrDeadLetterNotFound
+}
+
+// RequeueDeadLetter pushes a dead lettered request back to its stream with new attempts
+func (d *Deployer) RequeueDeadLetter(deadStream, id string) error {
+	deadLetter, err := d.DeadLetter(deadStream, id)
+	if err != nil {
+		return err
+	}
+
+	if err := d.Queue.Push(deadLetter.Stream, []byte(deadLetter.Data)); err != nil {
+		return err
+	}
+
+	// both vm and k8s requests have a job
+	var req struct{ JobID int }
+	if err := json.Unmarshal([]byte(deadLetter.Data), &req); err == nil {
+		d.updateJob(req.JobID, models.JobQueued, nil)
+	}
+
+	return d.Queue.Delete(deadStream, id)
+}
+
+// PurgeDeadLetters deletes requests from the dead letter stream, all of them if no IDs are given
+func (d *Deployer) PurgeDeadLetters(deadStream string, ids ...string) error {
+	if len(ids) > 0 {
+		return d.Queue.Delete(deadStream, ids...)
+	}
+
+	messages, err := d.Queue.Range(deadStream, 0)
+	if err != nil {
+		return err
+	}
+
+	for _, message := range messages {
+		ids = append(ids, message.ID)
+	}
+	return d.Queue.Delete(deadStream, ids...)
+}
@@ -0,0 +1,277 @@
+// Package deployer for handling deployments
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/codescalers/cloud4students/internal"
+	"github.com/codescalers/cloud4students/models"
+	"github.com/codescalers/cloud4students/streams"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupRetryDeployer(t *testing.T) (Deployer, *models.DB, *FakeGridBackend, models.User) {
+	db := models.NewDB()
+	err := db.Connect(t.TempDir() + "/test.db")
+	assert.NoError(t, err)
+	err = db.Migrate()
+	assert.NoError(t, err)
+
+	user := models.User{Email: "user@gmail.com", Verified: true}
+	err = db.CreateUser(&user)
+	assert.NoError(t, err)
+	err = db.CreateQuota(&models.Quota{UserID: user.ID.String(), Resources: models.Resources{CRU: 10, MRU: 20, SRU: 250, PublicIPs: 1}})
+	assert.NoError(t, err)
+
+	grid := NewFakeGridBackend()
+	d, err := NewDeployer(db, streams.NewMemoryQueue(), grid, internal.DeployRetry{MaxAttempts: 2, BackoffSeconds: 1})
+	assert.NoError(t, err)
+	d.backoff = 0
+
+	return d, db, grid, user
+}
+
+func TestBackoffAfter(t *testing.T) {
+	d := Deployer{backoff: 30 * time.Second}
+	assert.Equal(t, 30*time.Second, d.backoffAfter(1))
+	assert.Equal(t, 60*time.Second, d.backoffAfter(2))
+	assert.Equal(t, 120*time.Second, d.backoffAfter(3))
+	assert.Equal(t, maxBackoff, d.backoffAfter(100))
+}
+
+func TestRetryVMRequests(t *testing.T) {
+	ctx := context.Background()
+	d, db, grid, user := setupRetryDeployer(t)
+	grid.FailOn(FakeOpFilterNodes, errors.New("grid is down"))
+
+	job := models.Job{UserID: user.ID.String(), Type: models.VMsType, Name: "vm", State: models.JobQueued}
+	err := db.CreateJob(&job)
+	assert.NoError(t, err)
+
+	req := streams.VMDeployRequest{JobID: job.ID, User: user, Input: models.DeployVMInput{Name: "vm", Resources: "small"}}
+	err = streams.PushVMRequest(d.Queue, req)
+	assert.NoError(t, err)
+
+	t.Run("failed request is kept for retry", func(t *testing.T) {
+		d.ConsumeVMRequest(ctx)
+
+		pending, err := d.Queue.Pending(streams.ReqVMStreamName, streams.ReqVMConsumerGroupName, 0)
+		assert.NoError(t, err)
+		assert.Len(t, pending, 1)
+
+		notifications, err := db.ListNotifications(user.ID.String(), models.NotificationsFilter{})
+		assert.NoError(t, err)
+		assert.Empty(t, notifications)
+
+		job, err := db.GetJob(job.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, models.JobQueued, job.State)
+		assert.Contains(t, job.Error, "will be retried")
+		assert.Nil(t, job.FinishedAt)
+
+		// the quota is kept reserved for the next attempt
+		usage, err := db.GetUserQuotaUsage(user.ID.String())
+		assert.NoError(t, err)
+		assert.Equal(t, models.Resources{CRU: 9, MRU: 18, SRU: 225, PublicIPs: 1}, usage.Resources)
+		assert.Equal(t, models.Resources{CRU: 1, MRU: 2, SRU: 25}, usage.Reserved)
+	})
+
+	t.Run("request is dead lettered after last attempt", func(t *testing.T) {
+		d.RetryVMRequests(ctx)
+
+		pending, err := d.Queue.Pending(streams.ReqVMStreamName, streams.ReqVMConsumerGroupName, 0)
+		assert.NoError(t, err)
+		assert.Empty(t, pending)
+
+		notifications, err := db.ListNotifications(user.ID.String(), models.NotificationsFilter{})
+		assert.NoError(t, err)
+		assert.Len(t, notifications, 1)
+		assert.Contains(t, notifications[0].Msg, "failed")
+		assert.Equal(t, models.SeverityError, notifications[0].Severity)
+
+		job, err := db.GetJob(job.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, models.JobFailed, job.State)
+		assert.NotNil(t, job.FinishedAt)
+
+		usage, err := db.GetUserQuotaUsage(user.ID.String())
+		assert.NoError(t, err)
+		assert.Equal(t, models.Resources{CRU: 10, MRU: 20, SRU: 250, PublicIPs: 1}, usage.Resources)
+		assert.Zero(t, usage.Reserved)
+
+		deadLetters, err := d.DeadLetters(streams.DeadVMStreamName)
+		assert.NoError(t, err)
+		assert.Len(t, deadLetters, 1)
+		assert.Equal(t, int64(2), deadLetters[0].Attempts)
+		assert.Equal(t, streams.ReqVMStreamName, deadLetters[0].Stream)
+
+		var deadReq streams.VMDeployRequest
+		err = json.Unmarshal([]byte(deadLetters[0].Data), &deadReq)
+		assert.NoError(t, err)
+		assert.Equal(t, "vm", deadReq.Input.Name)
+	})
+
+	t.Run("invalid request is dead lettered", func(t *testing.T) {
+		err := d.Queue.Push(streams.ReqVMStreamName, []byte("{"))
+		assert.NoError(t, err)
+
+		d.ConsumeVMRequest(ctx)
+
+		deadLetters, err := d.DeadLetters(streams.DeadVMStreamName)
+		assert.NoError(t, err)
+		assert.Len(t, deadLetters, 2)
+		assert.Equal(t, "{", deadLetters[1].Data)
+		assert.Equal(t, int64(1), deadLetters[1].Attempts)
+	})
+
+	t.Run("invalid input is not retried", func(t *testing.T) {
+		req := streams.VMDeployRequest{User: user, Input: models.DeployVMInput{Name: "vm2", Resources: "huge"}}
+		err := streams.PushVMRequest(d.Queue, req)
+		assert.NoError(t, err)
+
+		d.ConsumeVMRequest(ctx)
+
+		pending, err := d.Queue.Pending(streams.ReqVMStreamName, streams.ReqVMConsumerGroupName, 0)
+		assert.NoError(t, err)
+		assert.Empty(t, pending)
+
+		deadLetters, err := d.DeadLetters(streams.DeadVMStreamName)
+		assert.NoError(t, err)
+		assert.Len(t, deadLetters, 2)
+	})
+
+	t.Run("requeue and purge", func(t *testing.T) {
+		deadLetters, err := d.DeadLetters(streams.DeadVMStreamName)
+		assert.NoError(t, err)
+
+		deadLetter, err := d.DeadLetter(streams.DeadVMStreamName, deadLetters[0].ID)
+		assert.NoError(t, err)
+		assert.Equal(t, deadLetters[0], deadLetter)
+
+		err = d.RequeueDeadLetter(streams.DeadVMStreamName, deadLetters[0].ID)
+		assert.NoError(t, err)
+
+		job, err := db.GetJob(job.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, models.JobQueued, job.State)
+		assert.Nil(t, job.FinishedAt)
+
+		messages, err := d.Queue.Range(streams.ReqVMStreamName, 0)
+		assert.NoError(t, err)
+		assert.Len(t, messages, 1)
+		assert.Equal(t, deadLetters[0].Data, string(messages[0].Data))
+
+		_, err = d.DeadLetter(streams.DeadVMStreamName, deadLetters[0].ID)
+		assert.ErrorIs(t, err, ErrDeadLetterNotFound)
+
+		err = d.PurgeDeadLetters(streams.DeadVMStreamName)
+		assert.NoError(t, err)
+
+		deadLetters, err = d.DeadLetters(streams.DeadVMStreamName)
+		assert.NoError(t, err)
+		assert.Empty(t, deadLetters)
+	})
+}
+
+func TestFailedAttemptsContractsAreCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d, db, grid, user := setupRetryDeployer(t)
+	go d.PeriodicDeploy(ctx, 1)
+	go d.ListenDeploymentResults(ctx)
+
+	t.Run("network of a failed vm", func(t *testing.T) {
+		grid.FailWorkload("failedvm", errors.New("node is down"))
+
+		req := streams.VMDeployRequest{User: user, Input: models.DeployVMInput{Name: "failedvm", Resources: "small"}}
+		err := streams.PushVMRequest(d.Queue, req)
+		assert.NoError(t, err)
+
+		d.ConsumeVMRequest(ctx)
+
+		assert.Empty(t, grid.ActiveContracts())
+		_, err = grid.LoadNetworkFromGrid(ctx, "failedvmvmNet")
+		assert.Error(t, err)
+	})
+
+	t.Run("network of a failed cluster", func(t *testing.T) {
+		grid.FailWorkload("failedk8s", errors.New("node is down"))
+
+		req := streams.K8sDeployRequest{User: user, Input: models.K8sDeployInput{MasterName: "failedk8s", Resources: "small"}}
+		err := streams.PushK8sRequest(d.Queue, req)
+		assert.NoError(t, err)
+
+		d.ConsumeK8sRequest(ctx)
+
+		assert.Empty(t, grid.ActiveContracts())
+		_, err = grid.LoadNetworkFromGrid(ctx, "failedk8sk8sNet")
+		assert.Error(t, err)
+	})
+
+	t.Run("vm that can't be loaded", func(t *testing.T) {
+		grid.FailOn(FakeOpLoadDeployment, errors.New("rmb timeout"))
+		defer grid.FailOn(FakeOpLoadDeployment, nil)
+
+		req := streams.VMDeployRequest{User: user, Input: models.DeployVMInput{Name: "unloadedvm", Resources: "small"}}
+		err := streams.PushVMRequest(d.Queue, req)
+		assert.NoError(t, err)
+
+		d.ConsumeVMRequest(ctx)
+
+		assert.Empty(t, grid.ActiveContracts())
+	})
+
+	t.Run("cluster that can't be loaded", func(t *testing.T) {
+		grid.FailOn(FakeOpLoadK8s, errors.New("rmb timeout"))
+		defer grid.FailOn(FakeOpLoadK8s, nil)
+
+		req := streams.K8sDeployRequest{User: user, Input: models.K8sDeployInput{MasterName: "unloadedk8s", Resources: "small"}}
+		err := streams.PushK8sRequest(d.Queue, req)
+		assert.NoError(t, err)
+
+		d.ConsumeK8sRequest(ctx)
+
+		assert.Empty(t, grid.ActiveContracts())
+	})
+
+	compensations, err := db.ListCompensations()
+	assert.NoError(t, err)
+	assert.Len(t, compensations, 4)
+	for _, c := range compensations {
+		assert.True(t, c.Canceled)
+	}
+}
+
+func TestCanceledVMRequest(t *testing.T) {
+	d, db, _, user := setupRetryDeployer(t)
+
+	job := models.Job{UserID: user.ID.String(), Type: models.VMsType, Name: "vm", State: models.JobQueued}
+	err := db.CreateJobWithReservation(&job, &models.QuotaReservation{UserID: user.ID.String(), Resources: models.Resources{CRU: 1, MRU: 2, SRU: 25}})
+	assert.NoError(t, err)
+
+	canceled, err := db.CancelJob(job.ID)
+	assert.NoError(t, err)
+	assert.True(t, canceled)
+
+	err = streams.PushVMRequest(d.Queue, streams.VMDeployRequest{JobID: job.ID, User: user, Input: models.DeployVMInput{Name: "vm", Resources: "small"}})
+	assert.NoError(t, err)
+
+	d.ConsumeVMRequest(context.Background())
+
+	pending, err := d.Queue.Pending(streams.ReqVMStreamName, streams.ReqVMConsumerGroupName, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, pending)
+
+	job, err = db.GetJob(job.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, models.JobCanceled, job.State)
+
+	notifications, err := db.ListNotifications(user.ID.String(), models.NotificationsFilter{})
+	assert.NoError(t, err)
+	assert.Empty(t, notifications)
+}
@@ -7,11 +7,11 @@ import (
 	"net"
 	"time"
 
+	"github.com/codescalers/cloud4students/internal"
 	"github.com/codescalers/cloud4students/models"
 	"github.com/codescalers/cloud4students/streams"
 	"github.com/codescalers/cloud4students/validators"
 	"github.com/rs/zerolog/log"
-	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/deployer"
 	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
 	"gopkg.in/validator.v2"
 )
@@ -34,11 +34,6 @@ var (
 	largeMemory  = uint64(8)
 	largeDisk    = uint64(100)
 
-	smallQuota  = 1
-	mediumQuota = 2
-	largeQuota  = 3
-	publicQuota = 1
-
 	trueVal  = true
 	statusUp = "up"
 
@@ -47,16 +42,21 @@ var (
 
 // Deployer struct holds deployments configuration
 type Deployer struct {
-	db             models.DB
-	Redis          streams.RedisClient
-	tfPluginClient deployer.TFPluginClient
+	db    models.Store
+	Queue streams.Queue
+	grid  GridBackend
+
+	// results of deployments delivered to the requests waiting for them
+	results       *deploymentResults
+	deployTimeout time.Duration
 
-	vmDeployed  chan bool
-	k8sDeployed chan bool
+	// maxAttempts of a request before it is dead lettered and backoff before its first retry
+	maxAttempts int64
+	backoff     time.Duration
 }
 
 // NewDeployer create new deployer
-func NewDeployer(db models.DB, redis streams.RedisClient, tfPluginClient deployer.TFPluginClient) (Deployer, error) {
+func NewDeployer(db models.Store, queue streams.Queue, grid GridBackend, retry internal.DeployRetry) (Deployer, error) {
 	// validations
 	err := validator.SetValidationFunc("ssh", validators.ValidateSSHKey)
 	if err != nil {
@@ -73,10 +73,12 @@ func NewDeployer(db models.DB, redis streams.RedisClient, tfPluginClient deploye
 
 	return Deployer{
 		db,
-		redis,
-		tfPluginClient,
-		make(chan bool),
-		make(chan bool),
+		queue,
+		grid,
+		newDeploymentResults(),
+		deploymentTimeout,
+		retry.MaxAttempts,
+		time.Duration(retry.BackoffSeconds) * time.Second,
 	}, nil
 }
 
@@ -84,8 +86,11 @@ func NewDeployer(db models.DB, redis streams.RedisClient, tfPluginClient deploye
 func (d *Deployer) PeriodicRequests(ctx context.Context, sec int) {
 	ticker := time.NewTicker(time.Second * time.Duration(sec))
 	for range ticker.C {
-		d.ConsumeVMRequest(ctx, false)
-		d.ConsumeK8sRequest(ctx, false)
+		d.ConsumeVMRequest(ctx)
+		d.ConsumeK8sRequest(ctx)
+
+		d.RetryVMRequests(ctx)
+		d.RetryK8sRequests(ctx)
 	}
 }
 
@@ -94,74 +99,150 @@ func (d *Deployer) PeriodicDeploy(ctx context.Context, sec int) {
 	ticker := time.NewTicker(time.Second * time.Duration(sec))
 
 	for range ticker.C {
-		vmNets, vms, err := d.consumeVMs()
+		vms, err := d.consumeVMs()
 		if err != nil {
 			log.Error().Err(err).Msg("failed to consume vms")
 		}
 
-		k8sNets, clusters, err := d.consumeK8s()
+		clusters, err := d.consumeK8s()
 		if err != nil {
 			log.Error().Err(err).Msg("failed to consume clusters")
 		}
 
 		if len(vms) > 0 {
-			err := d.tfPluginClient.NetworkDeployer.BatchDeploy(ctx, vmNets)
-			if err != nil {
-				log.Error().Err(err).Msg("failed to batch deploy network")
-			}
+			d.batchDeployVMs(ctx, vms)
+		}
 
-			err = d.tfPluginClient.DeploymentDeployer.BatchDeploy(ctx, vms)
-			if err != nil {
-				log.Error().Err(err).Msg("failed to batch deploy vm")
-			}
+		if len(clusters) > 0 {
+			d.batchDeployK8s(ctx, clusters)
+		}
+	}
+}
 
-			for i := 0; i < len(vms); i++ {
-				d.vmDeployed <- true
-			}
+// batchDeployVMs deploys vms with their networks and replies with the result of each of them
+func (d *Deployer) batchDeployVMs(ctx context.Context, vms []streams.VMDeployment) {
+	var nets []workloads.Network
+	for _, vm := range vms {
+		nets = append(nets, vm.Net)
+	}
+
+	netErr := d.grid.BatchDeployNetworks(ctx, nets)
+	if netErr != nil {
+		log.Error().Err(netErr).Msg("failed to batch deploy network")
+	}
+
+	// vms of failed networks are not deployed
+	var deploying []streams.VMDeployment
+	var dls []*workloads.Deployment
+	for _, vm := range vms {
+		if netErr != nil && !networkDeployed(vm.Net) {
+			d.replyDeployment(vm.ReplyTo, vmResult(vm), fmt.Errorf("failed to deploy network '%s': %w", vm.Net.Name, workloadError(vm.Net.Name, netErr)))
+			continue
 		}
 
-		if len(clusters) > 0 {
-			err := d.tfPluginClient.NetworkDeployer.BatchDeploy(ctx, k8sNets)
-			if err != nil {
-				log.Error().Err(err).Msg("failed to batch deploy network")
-			}
+		deploying = append(deploying, vm)
+		dls = append(dls, vm.DL)
+	}
 
-			err = d.tfPluginClient.K8sDeployer.BatchDeploy(ctx, clusters)
-			if err != nil {
-				log.Error().Err(err).Msg("failed to batch deploy clusters")
-			}
+	if len(dls) == 0 {
+		return
+	}
 
-			for i := 0; i < len(clusters); i++ {
-				d.k8sDeployed <- true
-			}
+	err := d.grid.BatchDeployDeployments(ctx, dls)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to batch deploy vm")
+	}
+
+	for _, vm := range deploying {
+		var resErr error
+		if err != nil && vm.DL.ContractID == 0 {
+			resErr = fmt.Errorf("failed to deploy vm '%s': %w", vm.DL.Name, workloadError(vm.DL.Name, err))
 		}
+		d.replyDeployment(vm.ReplyTo, vmResult(vm), resErr)
 	}
 }
 
-// CancelDeployment cancel deployments from grid
-func (d *Deployer) CancelDeployment(contractID uint64, netContractID uint64, dlType string, dlName string) error {
-	// cancel deployment
-	err := d.tfPluginClient.SubstrateConn.CancelContract(d.tfPluginClient.Identity, contractID)
-	if err != nil {
-		return err
+// batchDeployK8s deploys clusters with their networks and replies with the result of each of them
+func (d *Deployer) batchDeployK8s(ctx context.Context, clusters []streams.K8sDeployment) {
+	var nets []workloads.Network
+	for _, cluster := range clusters {
+		nets = append(nets, cluster.Net)
+	}
+
+	netErr := d.grid.BatchDeployNetworks(ctx, nets)
+	if netErr != nil {
+		log.Error().Err(netErr).Msg("failed to batch deploy network")
 	}
 
-	// cancel network
-	err = d.tfPluginClient.SubstrateConn.CancelContract(d.tfPluginClient.Identity, netContractID)
+	// clusters of failed networks are not deployed
+	var deploying []streams.K8sDeployment
+	var dls []*workloads.K8sCluster
+	for _, cluster := range clusters {
+		if netErr != nil && !networkDeployed(cluster.Net) {
+			d.replyDeployment(cluster.ReplyTo, k8sResult(cluster), fmt.Errorf("failed to deploy network '%s': %w", cluster.Net.Name, workloadError(cluster.Net.Name, netErr)))
+			continue
+		}
+
+		deploying = append(deploying, cluster)
+		dls = append(dls, cluster.DL)
+	}
+
+	if len(dls) == 0 {
+		return
+	}
+
+	err := d.grid.BatchDeployK8s(ctx, dls)
 	if err != nil {
-		return err
+		log.Error().Err(err).Msg("failed to batch deploy clusters")
+	}
+
+	for _, cluster := range deploying {
+		var resErr error
+		if err != nil && (cluster.DL.Master == nil || cluster.DL.NodeDeploymentID[cluster.DL.Master.NodeID] == 0) {
+			name := ""
+			if cluster.DL.Master != nil {
+				name = cluster.DL.Master.Name
+			}
+			resErr = fmt.Errorf("failed to deploy kubernetes cluster '%s': %w", name, workloadError(name, err))
+		}
+		d.replyDeployment(cluster.ReplyTo, k8sResult(cluster), resErr)
 	}
+}
 
-	// update state
-	for node, contracts := range d.tfPluginClient.State.CurrentNodeDeployments {
-		contracts = workloads.Delete(contracts, contractID)
-		contracts = workloads.Delete(contracts, netContractID)
-		d.tfPluginClient.State.CurrentNodeDeployments[node] = contracts
+// vmResult returns the result of a vm deployment with the contracts it created
+func vmResult(vm streams.VMDeployment) streams.DeploymentResult {
+	return streams.DeploymentResult{
+		ID:                vm.ID,
+		NetworkName:       vm.Net.Name,
+		ContractID:        vm.DL.ContractID,
+		NetworkContractID: vm.Net.NodeDeploymentID[vm.DL.NodeID],
+	}
+}
 
-		d.tfPluginClient.State.Networks.DeleteNetwork(fmt.Sprintf("%s%sNet", dlType, dlName))
+// k8sResult returns the result of a kubernetes deployment with the contracts it created
+func k8sResult(cluster streams.K8sDeployment) streams.DeploymentResult {
+	result := streams.DeploymentResult{ID: cluster.ID, NetworkName: cluster.Net.Name}
+	if cluster.DL.Master != nil {
+		result.ContractID = cluster.DL.NodeDeploymentID[cluster.DL.Master.NodeID]
+		result.NetworkContractID = cluster.Net.NodeDeploymentID[cluster.DL.Master.NodeID]
 	}
+	return result
+}
 
-	return nil
+// CancelDeployment cancel deployments from grid, their networks are named <name><type>Net as they are created.
+// Contracts that were never created are zero and skipped
+func (d *Deployer) CancelDeployment(contractID uint64, netContractID uint64, dlType string, dlName string) error {
+	return d.grid.CancelContracts(fmt.Sprintf("%s%sNet", dlName, dlType), createdContracts(contractID, netContractID)...)
+}
+
+func createdContracts(contractIDs ...uint64) []uint64 {
+	var created []uint64
+	for _, id := range contractIDs {
+		if id != 0 {
+			created = append(created, id)
+		}
+	}
+	return created
 }
 
 func buildNetwork(node uint32, name string) (workloads.ZNet, error) {
@@ -208,19 +289,3 @@ func calcNodeResources(resources string, public bool) (uint64, uint64, uint64, u
 	}
 	return cru, mru, sru, ips, nil
 }
-
-func calcNeededQuota(resources string) (int, error) {
-	var neededQuota int
-	switch resources {
-	case "small":
-		neededQuota += smallQuota
-	case "medium":
-		neededQuota += mediumQuota
-	case "large":
-		neededQuota += largeQuota
-	default:
-		return 0, fmt.Errorf("unknown resource type %s", resources)
-	}
-
-	return neededQuota, nil
-}
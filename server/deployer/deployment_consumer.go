@@ -5,210 +5,227 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"net/http"
-	"reflect"
 	"sync"
 
 	"github.com/codescalers/cloud4students/models"
 	"github.com/codescalers/cloud4students/streams"
-	"github.com/go-redis/redis"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
-	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
 )
 
 // ConsumeVMRequest to consume api requests of vm deployments
-func (d *Deployer) ConsumeVMRequest(ctx context.Context, pending bool) {
-	result, err := d.Redis.Read(streams.ReqVMStreamName, streams.ReqVMConsumerGroupName, 0, pending)
+func (d *Deployer) ConsumeVMRequest(ctx context.Context) {
+	messages, err := d.Queue.ReadGroup(streams.ReqVMStreamName, streams.ReqVMConsumerGroupName, 0, false)
 	if err != nil {
-		if errors.Is(err, redis.Nil) {
-			return
-		}
 		log.Error().Err(err).Msg("failed to read vm stream request")
 		return
 	}
 
 	var vmWG sync.WaitGroup
 
-	for _, s := range result {
-		for _, message := range s.Messages {
-			vmWG.Add(1)
-			go func(message redis.XMessage) {
-				defer vmWG.Done()
-
-				var codeErr int
-				var resErr error
-				var req streams.VMDeployRequest
-
-				for _, v := range message.Values {
-					err = json.Unmarshal([]byte(v.(string)), &req)
-					if err != nil {
-						log.Error().Err(err).Msg("failed to unmarshal vm request")
-						continue
-					}
-
-					codeErr, resErr = d.deployVMRequest(ctx, req.User, req.Input, req.AdminSSHKey)
-					if resErr != nil {
-						log.Error().Err(resErr).Msg("failed to deploy vm request")
-						continue
-					}
-				}
-
-				if err := d.Redis.DB.XAck(streams.ReqVMStreamName, streams.ReqVMConsumerGroupName, message.ID).Err(); err != nil {
-					log.Error().Err(err).Msgf("failed to acknowledge vm request with ID: %s", message.ID)
-					resErr = err
-					codeErr = http.StatusInternalServerError
-				}
-
-				msg := fmt.Sprintf("Your virtual machine '%s' failed to be deployed with error: %s", req.Input.Name, resErr)
-				if codeErr == 0 {
-					msg = fmt.Sprintf("Your virtual machine '%s' is deployed successfully 🎆", req.Input.Name)
-				}
-
-				notification := models.Notification{
-					UserID: req.User.ID.String(),
-					Msg:    msg,
-					Type:   models.VMsType,
-				}
-				err = d.db.CreateNotification(&notification)
-				if err != nil {
-					log.Error().Err(err).Msgf("failed to create notification: %+v", notification)
-				}
-
-			}(message)
-		}
-		vmWG.Wait()
+	for _, message := range messages {
+		vmWG.Add(1)
+		go func(message streams.Message) {
+			defer vmWG.Done()
+			d.processVMRequest(ctx, message, 1)
+		}(message)
 	}
+	vmWG.Wait()
 }
 
 // ConsumeK8sRequest to consume api requests of k8s deployments
-func (d *Deployer) ConsumeK8sRequest(ctx context.Context, pending bool) {
-	result, err := d.Redis.Read(streams.ReqK8sStreamName, streams.ReqK8sConsumerGroupName, 0, pending)
+func (d *Deployer) ConsumeK8sRequest(ctx context.Context) {
+	messages, err := d.Queue.ReadGroup(streams.ReqK8sStreamName, streams.ReqK8sConsumerGroupName, 0, false)
 	if err != nil {
-		if errors.Is(err, redis.Nil) {
-			return
-		}
 		log.Error().Err(err).Msg("failed to read k8s stream request")
 		return
 	}
 
 	var k8sWG sync.WaitGroup
 
-	for _, s := range result {
-		for _, message := range s.Messages {
-			k8sWG.Add(1)
-			go func(message redis.XMessage) {
-				defer k8sWG.Done()
-
-				var codeErr int
-				var resErr error
-				var req streams.K8sDeployRequest
-
-				for _, v := range message.Values {
-					err = json.Unmarshal([]byte(v.(string)), &req)
-					if err != nil {
-						log.Error().Err(err).Msg("failed to unmarshal k8s request")
-						continue
-					}
-
-					codeErr, resErr = d.deployK8sRequest(ctx, req.User, req.Input, req.AdminSSHKey)
-					if resErr != nil {
-						log.Error().Err(resErr).Msg("failed to deploy k8s request")
-						continue
-					}
-				}
-
-				if err := d.Redis.DB.XAck(streams.ReqK8sStreamName, streams.ReqK8sConsumerGroupName, message.ID).Err(); err != nil {
-					log.Error().Err(err).Msgf("failed to acknowledge k8s request with ID: %s", message.ID)
-					resErr = err
-					codeErr = http.StatusInternalServerError
-				}
-
-				msg := fmt.Sprintf("Your kubernetes cluster '%s' failed to be deployed with error: %s", req.Input.MasterName, resErr)
-				if codeErr == 0 {
-					msg = fmt.Sprintf("Your kubernetes cluster '%s' is deployed successfully 🎆", req.Input.MasterName)
-				}
-
-				notification := models.Notification{
-					UserID: req.User.ID.String(),
-					Msg:    msg,
-					Type:   models.K8sType,
-				}
-				err = d.db.CreateNotification(&notification)
-				if err != nil {
-					log.Error().Err(err).Msgf("failed to create notification: %+v", notification)
-				}
-
-			}(message)
+	for _, message := range messages {
+		k8sWG.Add(1)
+		go func(message streams.Message) {
+			defer k8sWG.Done()
+			d.processK8sRequest(ctx, message, 1)
+		}(message)
+	}
+	k8sWG.Wait()
+}
+
+// RetryVMRequests retries failed vm requests after their backoff
+func (d *Deployer) RetryVMRequests(ctx context.Context) {
+	d.retryRequests(ctx, streams.ReqVMStreamName, streams.ReqVMConsumerGroupName, d.processVMRequest)
+}
+
+// RetryK8sRequests retries failed k8s requests after their backoff
+func (d *Deployer) RetryK8sRequests(ctx context.Context) {
+	d.retryRequests(ctx, streams.ReqK8sStreamName, streams.ReqK8sConsumerGroupName, d.processK8sRequest)
+}
+
+func (d *Deployer) processVMRequest(ctx context.Context, message streams.Message, attempts int64) {
+	var req streams.VMDeployRequest
+	if err := json.Unmarshal(message.Data, &req); err != nil {
+		log.Error().Err(err).Msgf("failed to unmarshal vm request with ID: %s", message.ID)
+		err = d.deadLetter(streams.ReqVMStreamName, streams.ReqVMConsumerGroupName, streams.DeadVMStreamName, message, attempts, err)
+		if err != nil {
+			log.Error().Err(err).Msgf("failed to move vm request with ID: %s to dead letters", message.ID)
+		}
+		return
+	}
+
+	if !d.startJob(req.JobID) {
+		log.Info().Msgf("vm request with ID: %s is canceled", message.ID)
+		if err := d.Queue.Ack(streams.ReqVMStreamName, streams.ReqVMConsumerGroupName, message.ID); err != nil {
+			log.Error().Err(err).Msgf("failed to acknowledge vm request with ID: %s", message.ID)
+		}
+		return
+	}
+
+	codeErr, resErr := d.deployVMRequest(ctx, req.JobID, req.User, req.Input, req.AdminSSHKey)
+	if resErr != nil {
+		log.Error().Err(resErr).Msg("failed to deploy vm request")
+		if d.retryLater(message.ID, codeErr, attempts) {
+			d.updateJob(req.JobID, models.JobQueued, fmt.Errorf("attempt %d failed and will be retried: %w", attempts, resErr))
+			return
 		}
-		k8sWG.Wait()
 	}
+
+	codeErr, resErr = d.completeRequest(streams.ReqVMStreamName, streams.ReqVMConsumerGroupName, streams.DeadVMStreamName, message, attempts, codeErr, resErr)
+
+	d.finishJob(req.JobID, codeErr, resErr)
+	if resErr != nil {
+		d.releaseJobQuota(req.JobID)
+	}
+
+	msg := fmt.Sprintf("Your virtual machine '%s' failed to be deployed with error: %s", req.Input.Name, resErr)
+	if codeErr == 0 {
+		msg = fmt.Sprintf("Your virtual machine '%s' is deployed successfully 🎆", req.Input.Name)
+	}
+
+	notification := models.Notification{
+		UserID:   req.User.ID.String(),
+		Msg:      msg,
+		Type:     models.VMsType,
+		Severity: deploymentSeverity(codeErr),
+		Payload:  models.NotificationPayload{DeploymentID: d.jobDeploymentID(req.JobID)},
+	}
+	d.notify(notification)
 }
 
-func (d *Deployer) consumeVMs() (nets []workloads.Network, vms []*workloads.Deployment, err error) {
-	result, err := d.Redis.Read(streams.DeployVMStreamName, streams.DeployVMConsumerGroupName, 5, false)
+func (d *Deployer) processK8sRequest(ctx context.Context, message streams.Message, attempts int64) {
+	var req streams.K8sDeployRequest
+	if err := json.Unmarshal(message.Data, &req); err != nil {
+		log.Error().Err(err).Msgf("failed to unmarshal k8s request with ID: %s", message.ID)
+		err = d.deadLetter(streams.ReqK8sStreamName, streams.ReqK8sConsumerGroupName, streams.DeadK8sStreamName, message, attempts, err)
+		if err != nil {
+			log.Error().Err(err).Msgf("failed to move k8s request with ID: %s to dead letters", message.ID)
+		}
+		return
+	}
+
+	if !d.startJob(req.JobID) {
+		log.Info().Msgf("k8s request with ID: %s is canceled", message.ID)
+		if err := d.Queue.Ack(streams.ReqK8sStreamName, streams.ReqK8sConsumerGroupName, message.ID); err != nil {
+			log.Error().Err(err).Msgf("failed to acknowledge k8s request with ID: %s", message.ID)
+		}
+		return
+	}
+
+	codeErr, resErr := d.deployK8sRequest(ctx, req.JobID, req.User, req.Input, req.AdminSSHKey)
+	if resErr != nil {
+		log.Error().Err(resErr).Msg("failed to deploy k8s request")
+		if d.retryLater(message.ID, codeErr, attempts) {
+			d.updateJob(req.JobID, models.JobQueued, fmt.Errorf("attempt %d failed and will be retried: %w", attempts, resErr))
+			return
+		}
+	}
+
+	codeErr, resErr = d.completeRequest(streams.ReqK8sStreamName, streams.ReqK8sConsumerGroupName, streams.DeadK8sStreamName, message, attempts, codeErr, resErr)
+
+	d.finishJob(req.JobID, codeErr, resErr)
+	if resErr != nil {
+		d.releaseJobQuota(req.JobID)
+	}
+
+	msg := fmt.Sprintf("Your kubernetes cluster '%s' failed to be deployed with error: %s", req.Input.MasterName, resErr)
+	if codeErr == 0 {
+		msg = fmt.Sprintf("Your kubernetes cluster '%s' is deployed successfully 🎆", req.Input.MasterName)
+	}
+
+	notification := models.Notification{
+		UserID:   req.User.ID.String(),
+		Msg:      msg,
+		Type:     models.K8sType,
+		Severity: deploymentSeverity(codeErr),
+		Payload:  models.NotificationPayload{DeploymentID: d.jobDeploymentID(req.JobID)},
+	}
+	d.notify(notification)
+}
+
+// notify creates a notification and pushes it to the user if they are streaming notifications
+func (d *Deployer) notify(notification models.Notification) {
+	if err := d.db.CreateNotification(&notification); err != nil {
+		log.Error().Err(err).Msgf("failed to create notification: %+v", notification)
+		return
+	}
+
+	if err := streams.PublishNotification(d.Queue, notification); err != nil {
+		log.Error().Err(err).Msgf("failed to publish notification: %+v", notification)
+	}
+}
+
+func deploymentSeverity(codeErr int) models.NotificationSeverity {
+	if codeErr == 0 {
+		return models.SeveritySuccess
+	}
+	return models.SeverityError
+}
+
+func (d *Deployer) consumeVMs() (vms []streams.VMDeployment, err error) {
+	messages, err := d.Queue.ReadGroup(streams.DeployVMStreamName, streams.DeployVMConsumerGroupName, 5, false)
 	if err != nil {
-		if errors.Is(err, redis.Nil) {
-			return nets, vms, nil
+		return vms, errors.Wrap(err, "failed to read vm stream deployment")
+	}
+
+	for _, message := range messages {
+		var vm streams.VMDeployment
+		if err = json.Unmarshal(message.Data, &vm); err != nil {
+			log.Err(err).Msg("failed to unmarshal vm request")
 		}
-		return nets, vms, errors.Wrap(err, "failed to read vm stream deployment")
-	}
-
-	for _, s := range result {
-		for i, message := range s.Messages {
-			var vm streams.VMDeployment
-			for _, v := range message.Values {
-				err = json.Unmarshal([]byte(v.(string)), &vm)
-				if err != nil {
-					log.Err(err).Msg("failed to unmarshal vm request")
-					continue
-				}
-			}
-
-			if !reflect.DeepEqual(vm, streams.VMDeployment{}) {
-				vms = append(vms, vm.DL)
-				nets = append(nets, vm.Net)
-			}
-
-			if err = d.Redis.DB.XAck(streams.DeployVMStreamName, streams.DeployVMConsumerGroupName, s.Messages[i].ID).Err(); err != nil {
-				log.Error().Err(err).Msgf("failed to acknowledge vm request with ID: %s", s.Messages[i].ID)
-			}
+
+		if vm.Net != nil && vm.DL != nil {
+			vms = append(vms, vm)
+		}
+
+		if err = d.Queue.Ack(streams.DeployVMStreamName, streams.DeployVMConsumerGroupName, message.ID); err != nil {
+			log.Error().Err(err).Msgf("failed to acknowledge vm request with ID: %s", message.ID)
 		}
 	}
 
-	return
+	return vms, nil
 }
 
-func (d *Deployer) consumeK8s() (nets []workloads.Network, clusters []*workloads.K8sCluster, err error) {
-	result, err := d.Redis.Read(streams.DeployK8sStreamName, streams.DeployK8sConsumerGroupName, 5, false)
+func (d *Deployer) consumeK8s() (clusters []streams.K8sDeployment, err error) {
+	messages, err := d.Queue.ReadGroup(streams.DeployK8sStreamName, streams.DeployK8sConsumerGroupName, 5, false)
 	if err != nil {
-		if errors.Is(err, redis.Nil) {
-			return nets, clusters, nil
+		return clusters, errors.Wrap(err, "failed to read clusters stream deployment")
+	}
+
+	for _, message := range messages {
+		var k8s streams.K8sDeployment
+		if err = json.Unmarshal(message.Data, &k8s); err != nil {
+			log.Err(err).Msg("failed to unmarshal k8s request")
+		}
+
+		if k8s.Net != nil && k8s.DL != nil {
+			clusters = append(clusters, k8s)
 		}
-		return nets, clusters, errors.Wrap(err, "failed to read clusters stream deployment")
-	}
-
-	for _, s := range result {
-		for i, message := range s.Messages {
-			var k8s streams.K8sDeployment
-			for _, v := range message.Values {
-				err = json.Unmarshal([]byte(v.(string)), &k8s)
-				if err != nil {
-					log.Err(err).Msg("failed to unmarshal k8s request")
-					continue
-				}
-			}
-
-			if !reflect.DeepEqual(k8s, streams.K8sDeployment{}) {
-				clusters = append(clusters, k8s.DL)
-				nets = append(nets, k8s.Net)
-			}
-
-			if err = d.Redis.DB.XAck(streams.DeployK8sStreamName, streams.DeployK8sConsumerGroupName, s.Messages[i].ID).Err(); err != nil {
-				log.Error().Err(err).Msgf("failed to acknowledge k8s request with ID: %s", s.Messages[i].ID)
-			}
+
+		if err = d.Queue.Ack(streams.DeployK8sStreamName, streams.DeployK8sConsumerGroupName, message.ID); err != nil {
+			log.Error().Err(err).Msgf("failed to acknowledge k8s request with ID: %s", message.ID)
 		}
 	}
 
-	return
+	return clusters, nil
 }
@@ -0,0 +1,191 @@
+// Package deployer for handling deployments
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codescalers/cloud4students/streams"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
+)
+
+// deploymentTimeout is how long a request waits for the result of its deployment.
+// It is shorter than staleClaimIdle so a timed out request is retried before other replicas take it over
+const deploymentTimeout = 30 * time.Minute
+
+// deploymentResults holds the requests waiting for their deployments by deployment ID
+type deploymentResults struct {
+	mu      sync.Mutex
+	waiters map[string]chan streams.DeploymentResult
+}
+
+func newDeploymentResults() *deploymentResults {
+	return &deploymentResults{waiters: map[string]chan streams.DeploymentResult{}}
+}
+
+// register returns the channel the result of the deployment is delivered to
+func (r *deploymentResults) register(id string) chan streams.DeploymentResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := make(chan streams.DeploymentResult, 1)
+	r.waiters[id] = result
+	return result
+}
+
+func (r *deploymentResults) unregister(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.waiters, id)
+}
+
+// deliver sends the result to the request waiting for the deployment, it returns false if no request waits for it
+func (r *deploymentResults) deliver(res streams.DeploymentResult) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result, ok := r.waiters[res.ID]
+	if !ok {
+		return false
+	}
+
+	delete(r.waiters, res.ID)
+	result <- res
+	return true
+}
+
+// waitDeployment waits for the result of the deployment with the given ID, the result holds the contracts
+// the deployment created even if it failed
+func (d *Deployer) waitDeployment(ctx context.Context, id string, result chan streams.DeploymentResult) (streams.DeploymentResult, error) {
+	timer := time.NewTimer(d.deployTimeout)
+	defer timer.Stop()
+
+	select {
+	case res := <-result:
+		if res.Error != "" {
+			return res, errors.New(res.Error)
+		}
+		return res, nil
+	case <-timer.C:
+		return streams.DeploymentResult{}, fmt.Errorf("timeout waiting for deployment %s after %s", id, d.deployTimeout)
+	case <-ctx.Done():
+		return streams.DeploymentResult{}, ctx.Err()
+	}
+}
+
+// cancelAbandoned cancels the contracts of a deployment no request waits for anymore, the request timed out
+// and is retried or dead lettered so the contracts would be paid for without a deployment
+func (d *Deployer) cancelAbandoned(result streams.DeploymentResult) {
+	contracts := createdContracts(result.ContractID, result.NetworkContractID)
+	if len(contracts) == 0 {
+		return
+	}
+
+	err := d.grid.CancelContracts(result.NetworkName, contracts...)
+	if err != nil && !strings.Contains(err.Error(), "ContractNotExists") {
+		log.Error().Err(err).Msgf("failed to cancel contracts %v of abandoned deployment with ID: %s", contracts, result.ID)
+		return
+	}
+	log.Warn().Msgf("canceled contracts %v of abandoned deployment with ID: %s", contracts, result.ID)
+}
+
+// replyDeployment pushes the result of a deployment to the results stream of the consumer waiting for it
+func (d *Deployer) replyDeployment(replyTo string, result streams.DeploymentResult, err error) {
+	if err != nil {
+		log.Error().Err(err).Msgf("deployment with ID: %s failed", result.ID)
+		result.Error = err.Error()
+	}
+
+	if replyTo == "" || result.ID == "" {
+		log.Warn().Msg("deployment has no one waiting for its result")
+		d.cancelAbandoned(result)
+		return
+	}
+
+	if err := streams.PushDeploymentResult(d.Queue, replyTo, result); err != nil {
+		log.Error().Err(err).Msgf("failed to push result of deployment with ID: %s", result.ID)
+	}
+}
+
+// ListenDeploymentResults delivers the results of deployments to the requests of this consumer waiting for them
+func (d *Deployer) ListenDeploymentResults(ctx context.Context) {
+	for ctx.Err() == nil {
+		if err := d.consumeDeploymentResults(); err != nil {
+			log.Error().Err(err).Msg("failed to consume deployment results")
+			time.Sleep(time.Second)
+		}
+	}
+}
+
+func (d *Deployer) consumeDeploymentResults() error {
+	stream := streams.DeployResultStreamName(d.Queue.Consumer())
+	messages, err := d.Queue.ReadGroup(stream, streams.DeployResultConsumerGroupName, 0, false)
+	if err != nil {
+		return err
+	}
+
+	for _, message := range messages {
+		var result streams.DeploymentResult
+		if err := json.Unmarshal(message.Data, &result); err != nil {
+			log.Error().Err(err).Msgf("failed to unmarshal deployment result with ID: %s", message.ID)
+		} else {
+			// the request may have timed out or this replica restarted since
+			if !d.results.deliver(result) {
+				log.Warn().Msgf("no request is waiting for deployment with ID: %s", result.ID)
+				d.cancelAbandoned(result)
+			}
+		}
+
+		if err := d.Queue.Ack(stream, streams.DeployResultConsumerGroupName, message.ID); err != nil {
+			log.Error().Err(err).Msgf("failed to acknowledge deployment result with ID: %s", message.ID)
+		}
+		if err := d.Queue.Delete(stream, message.ID); err != nil {
+			log.Error().Err(err).Msgf("failed to delete deployment result with ID: %s", message.ID)
+		}
+	}
+
+	return nil
+}
+
+// workloadError returns the errors of a batch deployment that belong to the workload with the given name,
+// or the whole error if they can't be told apart
+func workloadError(name string, batchErr error) error {
+	var errs []error
+	switch e := batchErr.(type) {
+	case interface{ Unwrap() []error }:
+		errs = e.Unwrap()
+	case interface{ WrappedErrors() []error }:
+		errs = e.WrappedErrors()
+	default:
+		return batchErr
+	}
+
+	var matched []error
+	for _, err := range errs {
+		if strings.Contains(err.Error(), fmt.Sprintf("'%s'", name)) {
+			matched = append(matched, err)
+		}
+	}
+
+	if len(matched) == 0 {
+		return batchErr
+	}
+	return errors.Join(matched...)
+}
+
+// networkDeployed checks if a network of a batch deployment got a contract on all of its nodes
+func networkDeployed(net *workloads.ZNet) bool {
+	for _, node := range net.Nodes {
+		if net.NodeDeploymentID[node] == 0 {
+			return false
+		}
+	}
+	return true
+}
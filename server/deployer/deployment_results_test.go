@@ -0,0 +1,131 @@
+// Package deployer for handling deployments
+package deployer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/codescalers/cloud4students/streams"
+	"github.com/stretchr/testify/assert"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
+)
+
+func testVMDeployment(d Deployer, name string) (streams.VMDeployment, chan streams.DeploymentResult) {
+	id := name + "-id"
+	return streams.VMDeployment{
+		ID:      id,
+		ReplyTo: d.Queue.Consumer(),
+		Net:     &workloads.ZNet{Name: name + "Net", Nodes: []uint32{1}},
+		DL:      &workloads.Deployment{Name: name, NodeID: 1},
+	}, d.results.register(id)
+}
+
+func TestBatchDeployVMsResults(t *testing.T) {
+	ctx := context.Background()
+	d, _, grid, _ := setupRetryDeployer(t)
+
+	t.Run("each deployment gets its own result", func(t *testing.T) {
+		grid.FailWorkload("bad", errors.New("node is down"))
+		defer grid.FailWorkload("bad", nil)
+
+		good, goodResult := testVMDeployment(d, "good")
+		bad, badResult := testVMDeployment(d, "bad")
+
+		d.batchDeployVMs(ctx, []streams.VMDeployment{good, bad})
+		err := d.consumeDeploymentResults()
+		assert.NoError(t, err)
+
+		result := <-goodResult
+		assert.Empty(t, result.Error)
+		assert.NotZero(t, result.ContractID)
+
+		result = <-badResult
+		assert.Contains(t, result.Error, "failed to deploy vm 'bad'")
+		assert.Contains(t, result.Error, "node is down")
+		assert.NotContains(t, result.Error, "'good'")
+
+		// the network of the failed vm is deployed and returned to be canceled
+		assert.Zero(t, result.ContractID)
+		assert.NotZero(t, result.NetworkContractID)
+		assert.Equal(t, "badNet", result.NetworkName)
+	})
+
+	t.Run("vm of a failed network is not deployed", func(t *testing.T) {
+		grid.FailWorkload("noNetNet", errors.New("no ips left"))
+		defer grid.FailWorkload("noNetNet", nil)
+
+		noNet, noNetResult := testVMDeployment(d, "noNet")
+		other, otherResult := testVMDeployment(d, "other")
+
+		d.batchDeployVMs(ctx, []streams.VMDeployment{noNet, other})
+		err := d.consumeDeploymentResults()
+		assert.NoError(t, err)
+
+		assert.Contains(t, (<-noNetResult).Error, "failed to deploy network 'noNetNet'")
+		assert.Empty(t, (<-otherResult).Error)
+
+		_, err = grid.LoadDeploymentFromGrid(ctx, 1, "noNet")
+		assert.Error(t, err)
+	})
+
+	t.Run("result without a waiter is dropped", func(t *testing.T) {
+		d.replyDeployment(d.Queue.Consumer(), streams.DeploymentResult{ID: "unknown"}, nil)
+		err := d.consumeDeploymentResults()
+		assert.NoError(t, err)
+
+		messages, err := d.Queue.Range(streams.DeployResultStreamName(d.Queue.Consumer()), 0)
+		assert.NoError(t, err)
+		assert.Empty(t, messages)
+	})
+
+	t.Run("contracts of a result without a waiter are canceled", func(t *testing.T) {
+		abandoned, _ := testVMDeployment(d, "abandoned")
+		d.results.unregister(abandoned.ID)
+
+		active := len(grid.ActiveContracts())
+		d.batchDeployVMs(ctx, []streams.VMDeployment{abandoned})
+		assert.Len(t, grid.ActiveContracts(), active+2)
+
+		err := d.consumeDeploymentResults()
+		assert.NoError(t, err)
+		assert.Len(t, grid.ActiveContracts(), active)
+	})
+}
+
+func TestWaitDeployment(t *testing.T) {
+	d, _, _, _ := setupRetryDeployer(t)
+	d.deployTimeout = 10 * time.Millisecond
+
+	result := d.results.register("id")
+	defer d.results.unregister("id")
+
+	_, err := d.waitDeployment(context.Background(), "id", result)
+	assert.ErrorContains(t, err, "timeout waiting for deployment id")
+
+	assert.True(t, d.results.deliver(streams.DeploymentResult{ID: "id", ContractID: 1}))
+	deployed, err := d.waitDeployment(context.Background(), "id", result)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), deployed.ContractID)
+	assert.False(t, d.results.deliver(streams.DeploymentResult{ID: "id"}))
+
+	result = d.results.register("id")
+	assert.True(t, d.results.deliver(streams.DeploymentResult{ID: "id", Error: "node is down"}))
+	_, err = d.waitDeployment(context.Background(), "id", result)
+	assert.EqualError(t, err, "node is down")
+}
+
+func TestWorkloadError(t *testing.T) {
+	batchErr := errors.Join(
+		fmt.Errorf("failed to deploy deployment 'vm1': %w", errors.New("node is down")),
+		fmt.Errorf("failed to deploy deployment 'vm2': %w", errors.New("no capacity")),
+	)
+
+	assert.EqualError(t, workloadError("vm2", batchErr), "failed to deploy deployment 'vm2': no capacity")
+	assert.Equal(t, batchErr, workloadError("vm3", batchErr))
+
+	other := errors.New("grid is down")
+	assert.Equal(t, other, workloadError("vm1", other))
+}
@@ -0,0 +1,114 @@
+// Package deployer for handling deployments
+package deployer
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/deployer"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-proxy/pkg/types"
+)
+
+// GridBackend holds the grid operations used by the deployer
+type GridBackend interface {
+	// FilterNodes returns nodes matching the filter with enough storage for the given disks
+	FilterNodes(ctx context.Context, filter types.NodeFilter, ssdDisks, rootfs []uint64, limit uint64) ([]types.Node, error)
+
+	// BatchDeployNetworks deploys networks in one batch
+	BatchDeployNetworks(ctx context.Context, nets []workloads.Network) error
+	// BatchDeployDeployments deploys vm deployments in one batch
+	BatchDeployDeployments(ctx context.Context, dls []*workloads.Deployment) error
+	// BatchDeployK8s deploys kubernetes clusters in one batch
+	BatchDeployK8s(ctx context.Context, clusters []*workloads.K8sCluster) error
+
+	// LoadNetworkFromGrid loads a deployed network by its name
+	LoadNetworkFromGrid(ctx context.Context, name string) (workloads.ZNet, error)
+	// LoadDeploymentFromGrid loads a deployed deployment by its node and name
+	LoadDeploymentFromGrid(ctx context.Context, nodeID uint32, name string) (workloads.Deployment, error)
+	// LoadK8sFromGrid loads a deployed kubernetes cluster by its nodes and name
+	LoadK8sFromGrid(ctx context.Context, nodeIDs []uint32, name string) (workloads.K8sCluster, error)
+
+	// CancelContracts cancels contracts and drops them with their network from the state
+	CancelContracts(networkName string, contractIDs ...uint64) error
+
+	// GetBalance returns the free balance of the grid account in TFT
+	GetBalance() (float64, error)
+}
+
+// TFGridBackend is a grid backend using the tfgrid-sdk plugin client
+type TFGridBackend struct {
+	tfPluginClient deployer.TFPluginClient
+}
+
+// NewTFGridBackend creates a new grid backend from a tfgrid-sdk plugin client
+func NewTFGridBackend(tfPluginClient deployer.TFPluginClient) *TFGridBackend {
+	return &TFGridBackend{tfPluginClient: tfPluginClient}
+}
+
+// FilterNodes returns nodes matching the filter with enough storage for the given disks
+func (g *TFGridBackend) FilterNodes(ctx context.Context, filter types.NodeFilter, ssdDisks, rootfs []uint64, limit uint64) ([]types.Node, error) {
+	return deployer.FilterNodes(ctx, g.tfPluginClient, filter, ssdDisks, nil, rootfs, limit)
+}
+
+// BatchDeployNetworks deploys networks in one batch
+func (g *TFGridBackend) BatchDeployNetworks(ctx context.Context, nets []workloads.Network) error {
+	return g.tfPluginClient.NetworkDeployer.BatchDeploy(ctx, nets)
+}
+
+// BatchDeployDeployments deploys vm deployments in one batch
+func (g *TFGridBackend) BatchDeployDeployments(ctx context.Context, dls []*workloads.Deployment) error {
+	return g.tfPluginClient.DeploymentDeployer.BatchDeploy(ctx, dls)
+}
+
+// BatchDeployK8s deploys kubernetes clusters in one batch
+func (g *TFGridBackend) BatchDeployK8s(ctx context.Context, clusters []*workloads.K8sCluster) error {
+	return g.tfPluginClient.K8sDeployer.BatchDeploy(ctx, clusters)
+}
+
+// LoadNetworkFromGrid loads a deployed network by its name
+func (g *TFGridBackend) LoadNetworkFromGrid(ctx context.Context, name string) (workloads.ZNet, error) {
+	return g.tfPluginClient.State.LoadNetworkFromGrid(ctx, name)
+}
+
+// LoadDeploymentFromGrid loads a deployed deployment by its node and name
+func (g *TFGridBackend) LoadDeploymentFromGrid(ctx context.Context, nodeID uint32, name string) (workloads.Deployment, error) {
+	return g.tfPluginClient.State.LoadDeploymentFromGrid(ctx, nodeID, name)
+}
+
+// LoadK8sFromGrid loads a deployed kubernetes cluster by its nodes and name
+func (g *TFGridBackend) LoadK8sFromGrid(ctx context.Context, nodeIDs []uint32, name string) (workloads.K8sCluster, error) {
+	return g.tfPluginClient.State.LoadK8sFromGrid(ctx, nodeIDs, name)
+}
+
+// CancelContracts cancels contracts and drops them with their network from the state
+func (g *TFGridBackend) CancelContracts(networkName string, contractIDs ...uint64) error {
+	for _, contractID := range contractIDs {
+		err := g.tfPluginClient.SubstrateConn.CancelContract(g.tfPluginClient.Identity, contractID)
+		if err != nil {
+			return err
+		}
+	}
+
+	// update state
+	for node, contracts := range g.tfPluginClient.State.CurrentNodeDeployments {
+		for _, contractID := range contractIDs {
+			contracts = workloads.Delete(contracts, contractID)
+		}
+		g.tfPluginClient.State.CurrentNodeDeployments[node] = contracts
+
+		g.tfPluginClient.State.Networks.DeleteNetwork(networkName)
+	}
+
+	return nil
+}
+
+// GetBalance returns the free balance of the grid account in TFT
+func (g *TFGridBackend) GetBalance() (float64, error) {
+	balance, err := g.tfPluginClient.SubstrateConn.GetBalance(g.tfPluginClient.Identity)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get account balance with the given mnemonics")
+	}
+
+	return float64(balance.Free.Int64()) / 1e7, nil
+}
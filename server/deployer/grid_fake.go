@@ -0,0 +1,387 @@
+// Package deployer for handling deployments
+package deployer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-proxy/pkg/types"
+)
+
+// fake grid backend operations that failures can be injected into
+const (
+	FakeOpFilterNodes            = "FilterNodes"
+	FakeOpBatchDeployNetworks    = "BatchDeployNetworks"
+	FakeOpBatchDeployDeployments = "BatchDeployDeployments"
+	FakeOpBatchDeployK8s         = "BatchDeployK8s"
+	FakeOpLoadNetwork            = "LoadNetworkFromGrid"
+	FakeOpLoadDeployment         = "LoadDeploymentFromGrid"
+	FakeOpLoadK8s                = "LoadK8sFromGrid"
+	FakeOpCancelContracts        = "CancelContracts"
+	FakeOpGetBalance             = "GetBalance"
+)
+
+// FakeGridBackend is a deterministic in-memory grid backend used for testing
+type FakeGridBackend struct {
+	mu sync.Mutex
+
+	nodes          []uint32
+	balance        float64
+	nextContractID uint64
+
+	// injected failures by operation and by workload name
+	opFailures       map[string]error
+	workloadFailures map[string]error
+
+	networks    map[string]workloads.ZNet
+	deployments map[string]workloads.Deployment
+	clusters    map[string]workloads.K8sCluster
+	contracts   map[uint64]bool
+}
+
+// NewFakeGridBackend creates a new fake grid backend with the given node IDs
+func NewFakeGridBackend(nodes ...uint32) *FakeGridBackend {
+	if len(nodes) == 0 {
+		nodes = []uint32{1}
+	}
+
+	return &FakeGridBackend{
+		nodes:            nodes,
+		balance:          10000,
+		opFailures:       map[string]error{},
+		workloadFailures: map[string]error{},
+		networks:         map[string]workloads.ZNet{},
+		deployments:      map[string]workloads.Deployment{},
+		clusters:         map[string]workloads.K8sCluster{},
+		contracts:        map[uint64]bool{},
+	}
+}
+
+// FailOn makes every call of the given operation return err, a nil err removes the failure
+func (g *FakeGridBackend) FailOn(op string, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err == nil {
+		delete(g.opFailures, op)
+		return
+	}
+	g.opFailures[op] = err
+}
+
+// FailWorkload makes batch deployments of the workload with the given name fail with err,
+// a nil err removes the failure
+func (g *FakeGridBackend) FailWorkload(name string, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err == nil {
+		delete(g.workloadFailures, name)
+		return
+	}
+	g.workloadFailures[name] = err
+}
+
+// SetBalance sets the balance returned by GetBalance
+func (g *FakeGridBackend) SetBalance(balance float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.balance = balance
+}
+
+// ActiveContracts returns the IDs of the contracts that are not canceled yet
+func (g *FakeGridBackend) ActiveContracts() []uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var ids []uint64
+	for id := uint64(1); id <= g.nextContractID; id++ {
+		if g.contracts[id] {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// FilterNodes returns the fake nodes up to the given limit
+func (g *FakeGridBackend) FilterNodes(ctx context.Context, filter types.NodeFilter, ssdDisks, rootfs []uint64, limit uint64) ([]types.Node, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := g.opFailures[FakeOpFilterNodes]; err != nil {
+		return nil, err
+	}
+
+	var nodes []types.Node
+	for _, node := range g.nodes {
+		if limit != 0 && uint64(len(nodes)) == limit {
+			break
+		}
+		nodes = append(nodes, types.Node{NodeID: int(node), FarmID: 1})
+	}
+
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("could not find enough nodes with options: %+v", filter)
+	}
+
+	return nodes, nil
+}
+
+// BatchDeployNetworks deploys networks creating a contract on each of their nodes
+func (g *FakeGridBackend) BatchDeployNetworks(ctx context.Context, nets []workloads.Network) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := g.opFailures[FakeOpBatchDeployNetworks]; err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, net := range nets {
+		if err := g.workloadFailures[net.GetName()]; err != nil {
+			errs = append(errs, fmt.Errorf("failed to deploy network '%s': %w", net.GetName(), err))
+			continue
+		}
+
+		nodeDeploymentID := map[uint32]uint64{}
+		for _, node := range net.GetNodes() {
+			nodeDeploymentID[node] = g.newContract()
+		}
+
+		znet := workloads.ZNet{Name: net.GetName(), Nodes: net.GetNodes()}
+		if z, ok := net.(*workloads.ZNet); ok {
+			z.NodeDeploymentID = nodeDeploymentID
+			znet = *z
+		}
+		znet.NodeDeploymentID = nodeDeploymentID
+
+		g.networks[znet.Name] = znet
+	}
+
+	return errors.Join(errs...)
+}
+
+// BatchDeployDeployments deploys vm deployments creating a contract for each of them
+func (g *FakeGridBackend) BatchDeployDeployments(ctx context.Context, dls []*workloads.Deployment) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := g.opFailures[FakeOpBatchDeployDeployments]; err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, dl := range dls {
+		if err := g.workloadFailures[dl.Name]; err != nil {
+			errs = append(errs, fmt.Errorf("failed to deploy deployment '%s': %w", dl.Name, err))
+			continue
+		}
+
+		contractID := g.newContract()
+		dl.ContractID = contractID
+		dl.NodeDeploymentID = map[uint32]uint64{dl.NodeID: contractID}
+
+		for i := range dl.Vms {
+			fillFakeVMIPs(&dl.Vms[i], contractID, i)
+		}
+
+		loaded := *dl
+		loaded.Vms = append([]workloads.VM{}, dl.Vms...)
+		g.deployments[dl.Name] = loaded
+	}
+
+	return errors.Join(errs...)
+}
+
+// BatchDeployK8s deploys kubernetes clusters creating a contract on each of their nodes
+func (g *FakeGridBackend) BatchDeployK8s(ctx context.Context, clusters []*workloads.K8sCluster) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := g.opFailures[FakeOpBatchDeployK8s]; err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, cluster := range clusters {
+		if cluster.Master == nil || cluster.Master.VM == nil {
+			errs = append(errs, fmt.Errorf("kubernetes cluster has no master"))
+			continue
+		}
+
+		name := cluster.Master.Name
+		if err := g.workloadFailures[name]; err != nil {
+			errs = append(errs, fmt.Errorf("failed to deploy kubernetes cluster '%s': %w", name, err))
+			continue
+		}
+
+		nodeDeploymentID := map[uint32]uint64{cluster.Master.NodeID: g.newContract()}
+		for _, worker := range cluster.Workers {
+			if _, ok := nodeDeploymentID[worker.NodeID]; !ok {
+				nodeDeploymentID[worker.NodeID] = g.newContract()
+			}
+		}
+		cluster.NodeDeploymentID = nodeDeploymentID
+
+		fillFakeVMIPs(cluster.Master.VM, nodeDeploymentID[cluster.Master.NodeID], 0)
+		for i := range cluster.Workers {
+			fillFakeVMIPs(cluster.Workers[i].VM, nodeDeploymentID[cluster.Workers[i].NodeID], i+1)
+		}
+
+		g.clusters[name] = copyK8sCluster(*cluster)
+	}
+
+	return errors.Join(errs...)
+}
+
+// LoadNetworkFromGrid loads a deployed network by its name
+func (g *FakeGridBackend) LoadNetworkFromGrid(ctx context.Context, name string) (workloads.ZNet, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := g.opFailures[FakeOpLoadNetwork]; err != nil {
+		return workloads.ZNet{}, err
+	}
+
+	znet, ok := g.networks[name]
+	if !ok {
+		return workloads.ZNet{}, fmt.Errorf("network '%s' is not found", name)
+	}
+
+	return znet, nil
+}
+
+// LoadDeploymentFromGrid loads a deployed deployment by its node and name
+func (g *FakeGridBackend) LoadDeploymentFromGrid(ctx context.Context, nodeID uint32, name string) (workloads.Deployment, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := g.opFailures[FakeOpLoadDeployment]; err != nil {
+		return workloads.Deployment{}, err
+	}
+
+	dl, ok := g.deployments[name]
+	if !ok || dl.NodeID != nodeID {
+		return workloads.Deployment{}, fmt.Errorf("deployment '%s' is not found on node %d", name, nodeID)
+	}
+
+	dl.Vms = append([]workloads.VM{}, dl.Vms...)
+	return dl, nil
+}
+
+// LoadK8sFromGrid loads a deployed kubernetes cluster by its nodes and name
+func (g *FakeGridBackend) LoadK8sFromGrid(ctx context.Context, nodeIDs []uint32, name string) (workloads.K8sCluster, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := g.opFailures[FakeOpLoadK8s]; err != nil {
+		return workloads.K8sCluster{}, err
+	}
+
+	cluster, ok := g.clusters[name]
+	if !ok {
+		return workloads.K8sCluster{}, fmt.Errorf("kubernetes cluster '%s' is not found on nodes %v", name, nodeIDs)
+	}
+
+	for _, node := range nodeIDs {
+		if _, ok := cluster.NodeDeploymentID[node]; !ok {
+			return workloads.K8sCluster{}, fmt.Errorf("kubernetes cluster '%s' is not found on node %d", name, node)
+		}
+	}
+
+	return copyK8sCluster(cluster), nil
+}
+
+// CancelContracts cancels contracts and drops the workloads using them
+func (g *FakeGridBackend) CancelContracts(networkName string, contractIDs ...uint64) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := g.opFailures[FakeOpCancelContracts]; err != nil {
+		return err
+	}
+
+	for _, contractID := range contractIDs {
+		if !g.contracts[contractID] {
+			return fmt.Errorf("ContractNotExists: contract %d is not found", contractID)
+		}
+		g.contracts[contractID] = false
+
+		for name, dl := range g.deployments {
+			if dl.ContractID == contractID {
+				delete(g.deployments, name)
+			}
+		}
+
+		for name, cluster := range g.clusters {
+			for _, id := range cluster.NodeDeploymentID {
+				if id == contractID {
+					delete(g.clusters, name)
+				}
+			}
+		}
+	}
+
+	delete(g.networks, networkName)
+	return nil
+}
+
+// GetBalance returns the fake account balance
+func (g *FakeGridBackend) GetBalance() (float64, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := g.opFailures[FakeOpGetBalance]; err != nil {
+		return 0, err
+	}
+
+	return g.balance, nil
+}
+
+func (g *FakeGridBackend) newContract() uint64 {
+	g.nextContractID++
+	g.contracts[g.nextContractID] = true
+	return g.nextContractID
+}
+
+func fillFakeVMIPs(vm *workloads.VM, contractID uint64, index int) {
+	vm.IP = fmt.Sprintf("10.20.2.%d", index+2)
+	if vm.Planetary {
+		vm.PlanetaryIP = fmt.Sprintf("300:%x::%d", contractID, index+1)
+	}
+	if len(vm.MyceliumIPSeed) != 0 {
+		vm.MyceliumIP = fmt.Sprintf("400:%x::%d", contractID, index+1)
+	}
+	if vm.PublicIP {
+		vm.ComputedIP = fmt.Sprintf("185.%d.%d.%d/24", contractID/256%256, contractID%256, index+1)
+	}
+}
+
+func copyK8sCluster(cluster workloads.K8sCluster) workloads.K8sCluster {
+	if cluster.Master != nil && cluster.Master.VM != nil {
+		vm := *cluster.Master.VM
+		cluster.Master = &workloads.K8sNode{VM: &vm, DiskSizeGB: cluster.Master.DiskSizeGB}
+	}
+
+	workers := make([]workloads.K8sNode, 0, len(cluster.Workers))
+	for _, worker := range cluster.Workers {
+		if worker.VM != nil {
+			vm := *worker.VM
+			worker.VM = &vm
+		}
+		workers = append(workers, worker)
+	}
+	cluster.Workers = workers
+
+	nodeDeploymentID := make(map[uint32]uint64, len(cluster.NodeDeploymentID))
+	for node, id := range cluster.NodeDeploymentID {
+		nodeDeploymentID[node] = id
+	}
+	cluster.NodeDeploymentID = nodeDeploymentID
+
+	return cluster
+}
@@ -0,0 +1,136 @@
+// Package deployer for handling deployments
+package deployer
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/codescalers/cloud4students/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-proxy/pkg/types"
+)
+
+func TestFakeGridBackendVM(t *testing.T) {
+	ctx := context.Background()
+	grid := NewFakeGridBackend(11, 12)
+
+	nodes, err := grid.FilterNodes(ctx, types.NodeFilter{}, nil, nil, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []types.Node{{NodeID: 11, FarmID: 1}}, nodes)
+
+	network, err := buildNetwork(11, "vmvmNet")
+	assert.NoError(t, err)
+
+	vm := workloads.VM{Name: "vm", NodeID: 11, Planetary: true, PublicIP: true, NetworkName: network.Name}
+	dl := workloads.NewDeployment("vm", 11, "", nil, network.Name, nil, nil, []workloads.VM{vm}, nil, nil, nil)
+
+	t.Run("deploy and load", func(t *testing.T) {
+		err := grid.BatchDeployNetworks(ctx, []workloads.Network{&network})
+		assert.NoError(t, err)
+
+		err = grid.BatchDeployDeployments(ctx, []*workloads.Deployment{&dl})
+		assert.NoError(t, err)
+
+		loadedNet, err := grid.LoadNetworkFromGrid(ctx, network.Name)
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(1), loadedNet.NodeDeploymentID[11])
+
+		loadedDl, err := grid.LoadDeploymentFromGrid(ctx, 11, "vm")
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(2), loadedDl.ContractID)
+		assert.NotEmpty(t, loadedDl.Vms[0].PlanetaryIP)
+		assert.NotEmpty(t, loadedDl.Vms[0].ComputedIP)
+
+		_, err = grid.LoadDeploymentFromGrid(ctx, 12, "vm")
+		assert.Error(t, err)
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		d := Deployer{grid: grid}
+
+		err := d.CancelDeployment(2, 1, "vm", "vm")
+		assert.NoError(t, err)
+		assert.Empty(t, grid.ActiveContracts())
+
+		_, err = grid.LoadDeploymentFromGrid(ctx, 11, "vm")
+		assert.Error(t, err)
+
+		err = d.CancelDeployment(2, 1, "vm", "vm")
+		assert.ErrorContains(t, err, "ContractNotExists")
+	})
+
+	t.Run("injected failures", func(t *testing.T) {
+		failure := errors.New("grid is down")
+
+		grid.FailOn(FakeOpFilterNodes, failure)
+		_, err := grid.FilterNodes(ctx, types.NodeFilter{}, nil, nil, 1)
+		assert.ErrorIs(t, err, failure)
+		grid.FailOn(FakeOpFilterNodes, nil)
+
+		grid.FailWorkload("vm", failure)
+		err = grid.BatchDeployDeployments(ctx, []*workloads.Deployment{&dl})
+		assert.ErrorIs(t, err, failure)
+		grid.FailWorkload("vm", nil)
+
+		grid.SetBalance(5)
+		balance, err := (&Deployer{grid: grid}).GetBalance()
+		assert.NoError(t, err)
+		assert.Equal(t, float64(5), balance)
+	})
+}
+
+func TestCancelDeploymentNetwork(t *testing.T) {
+	ctx := context.Background()
+	grid := NewFakeGridBackend(11)
+	d := Deployer{grid: grid}
+
+	network, err := buildNetwork(11, "webvmNet")
+	assert.NoError(t, err)
+
+	dl := workloads.NewDeployment("web", 11, "", nil, network.Name, nil, nil, []workloads.VM{{Name: "web", NodeID: 11, NetworkName: network.Name}}, nil, nil, nil)
+	err = grid.BatchDeployNetworks(ctx, []workloads.Network{&network})
+	assert.NoError(t, err)
+	err = grid.BatchDeployDeployments(ctx, []*workloads.Deployment{&dl})
+	assert.NoError(t, err)
+
+	err = d.CancelDeployment(dl.ContractID, network.NodeDeploymentID[11], "vm", "web")
+	assert.NoError(t, err)
+	assert.Empty(t, grid.ActiveContracts())
+
+	// the network is dropped by the name it is created with
+	_, err = grid.LoadNetworkFromGrid(ctx, "webvmNet")
+	assert.Error(t, err)
+}
+
+func TestFakeGridBackendK8s(t *testing.T) {
+	ctx := context.Background()
+	grid := NewFakeGridBackend()
+
+	input := models.K8sDeployInput{
+		MasterName: "master",
+		Resources:  "small",
+		Public:     true,
+		Workers:    []models.Worker{{Name: "worker", Resources: "small"}},
+	}
+
+	cluster, err := buildK8sCluster(1, "key", "masterk8sNet", input)
+	assert.NoError(t, err)
+
+	err = grid.BatchDeployK8s(ctx, []*workloads.K8sCluster{&cluster})
+	assert.NoError(t, err)
+
+	loaded, err := grid.LoadK8sFromGrid(ctx, []uint32{1}, "master")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), loaded.NodeDeploymentID[1])
+	assert.NotEmpty(t, loaded.Master.ComputedIP)
+	assert.NotEmpty(t, loaded.Workers[0].PlanetaryIP)
+	assert.Empty(t, loaded.Workers[0].ComputedIP)
+
+	err = grid.CancelContracts("masterk8sNet", 1)
+	assert.NoError(t, err)
+
+	_, err = grid.LoadK8sFromGrid(ctx, []uint32{1}, "master")
+	assert.Error(t, err)
+}
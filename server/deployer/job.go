@@ -0,0 +1,73 @@
+// Package deployer for handling deployments
+package deployer
+
+import (
+	"github.com/codescalers/cloud4students/models"
+	"github.com/rs/zerolog/log"
+)
+
+// updateJob updates the state of a request job, requests pushed before jobs existed have no job
+func (d *Deployer) updateJob(jobID int, state models.JobState, jobErr error) {
+	if jobID == 0 {
+		return
+	}
+
+	msg := ""
+	if jobErr != nil {
+		msg = jobErr.Error()
+	}
+
+	if err := d.db.UpdateJobState(jobID, state, msg); err != nil {
+		log.Error().Err(err).Msgf("failed to update job %d to state %s", jobID, state)
+	}
+}
+
+// startJob marks a request job as started, it returns false if the job was canceled
+func (d *Deployer) startJob(jobID int) bool {
+	if jobID == 0 {
+		return true
+	}
+
+	started, err := d.db.StartJob(jobID)
+	if err != nil {
+		// the request is still deployed if its job can't be updated
+		log.Error().Err(err).Msgf("failed to start job %d", jobID)
+		return true
+	}
+	return started
+}
+
+// updateJobDeployment sets the deployment created by a request job
+func (d *Deployer) updateJobDeployment(jobID int, deploymentID int) {
+	if jobID == 0 {
+		return
+	}
+
+	if err := d.db.UpdateJobDeployment(jobID, deploymentID); err != nil {
+		log.Error().Err(err).Msgf("failed to set deployment of job %d", jobID)
+	}
+}
+
+// finishJob marks a request job as succeeded or failed
+func (d *Deployer) finishJob(jobID int, codeErr int, resErr error) {
+	if codeErr == 0 {
+		d.updateJob(jobID, models.JobSucceeded, nil)
+		return
+	}
+
+	d.updateJob(jobID, models.JobFailed, resErr)
+}
+
+// jobDeploymentID returns the deployment created by a request job, it is 0 if it has no deployment
+func (d *Deployer) jobDeploymentID(jobID int) int {
+	if jobID == 0 {
+		return 0
+	}
+
+	job, err := d.db.GetJob(jobID)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to get job %d", jobID)
+		return 0
+	}
+	return job.DeploymentID
+}
@@ -9,12 +9,11 @@ import (
 	"github.com/codescalers/cloud4students/middlewares"
 	"github.com/codescalers/cloud4students/models"
 	"github.com/codescalers/cloud4students/streams"
+	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
-	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/deployer"
 	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
 	"github.com/threefoldtech/tfgrid-sdk-go/grid-proxy/pkg/types"
-	"gorm.io/gorm"
 )
 
 func buildK8sCluster(node uint32, sshKey, network string, k models.K8sDeployInput) (workloads.K8sCluster, error) {
@@ -64,7 +63,7 @@ func buildK8sCluster(node uint32, sshKey, network string, k models.K8sDeployInpu
 			},
 		}
 
-		cru, mru, sru, _, err := calcNodeResources(k.Resources, false)
+		cru, mru, sru, _, err := calcNodeResources(worker.Resources, false)
 		if err != nil {
 			return workloads.K8sCluster{}, err
 		}
@@ -87,7 +86,10 @@ func buildK8sCluster(node uint32, sshKey, network string, k models.K8sDeployInpu
 	return k8sCluster, nil
 }
 
-func (d *Deployer) deployK8sClusterWithNetwork(ctx context.Context, k8sDeployInput models.K8sDeployInput, sshKey string, adminSSHKey string) (uint32, uint64, uint64, error) {
+// deployK8sClusterWithNetwork deploys a cluster with its network, if it fails it returns the contracts it created with the error
+func (d *Deployer) deployK8sClusterWithNetwork(ctx context.Context, jobID int, k8sDeployInput models.K8sDeployInput, sshKey string, adminSSHKey string) (uint32, uint64, uint64, error) {
+	d.updateJob(jobID, models.JobPlacing, nil)
+
 	// get available nodes
 	node, err := d.getK8sAvailableNode(ctx, k8sDeployInput)
 	if err != nil {
@@ -110,28 +112,35 @@ func (d *Deployer) deployK8sClusterWithNetwork(ctx context.Context, k8sDeployInp
 		return 0, 0, 0, err
 	}
 
+	d.updateJob(jobID, models.JobDeploying, nil)
+
 	// add network and cluster to be deployed
-	err = d.Redis.PushK8s(streams.K8sDeployment{Net: &network, DL: &cluster})
+	id := uuid.NewString()
+	result := d.results.register(id)
+	defer d.results.unregister(id)
+
+	err = streams.PushK8s(d.Queue, streams.K8sDeployment{ID: id, ReplyTo: d.Queue.Consumer(), Net: &network, DL: &cluster})
 	if err != nil {
 		return 0, 0, 0, err
 	}
 
 	// wait for deployments
-	for {
-		if <-d.k8sDeployed {
-			break
-		}
+	deployed, err := d.waitDeployment(ctx, id, result)
+	if err != nil {
+		return node, deployed.NetworkContractID, deployed.ContractID, err
 	}
 
+	d.updateJob(jobID, models.JobVerifying, nil)
+
 	// checks that network and k8s are deployed successfully
-	loadedNet, err := d.tfPluginClient.State.LoadNetworkFromGrid(ctx, cluster.NetworkName)
+	loadedNet, err := d.grid.LoadNetworkFromGrid(ctx, cluster.NetworkName)
 	if err != nil {
-		return 0, 0, 0, errors.Wrapf(err, "failed to load network '%s' on nodes %v", cluster.NetworkName, network.Nodes)
+		return node, deployed.NetworkContractID, deployed.ContractID, errors.Wrapf(err, "failed to load network '%s' on nodes %v", cluster.NetworkName, network.Nodes)
 	}
 
-	loadedCluster, err := d.tfPluginClient.State.LoadK8sFromGrid(ctx, []uint32{node}, cluster.Master.Name)
+	loadedCluster, err := d.grid.LoadK8sFromGrid(ctx, []uint32{node}, cluster.Master.Name)
 	if err != nil {
-		return 0, 0, 0, errors.Wrapf(err, "failed to load kubernetes cluster '%s' on nodes %v", cluster.Master.Name, network.Nodes)
+		return node, deployed.NetworkContractID, deployed.ContractID, errors.Wrapf(err, "failed to load kubernetes cluster '%s' on nodes %v", cluster.Master.Name, network.Nodes)
 	}
 
 	return node, loadedNet.NodeDeploymentID[node], loadedCluster.NodeDeploymentID[node], nil
@@ -139,7 +148,7 @@ func (d *Deployer) deployK8sClusterWithNetwork(ctx context.Context, k8sDeployInp
 
 func (d *Deployer) loadK8s(ctx context.Context, k8sDeployInput models.K8sDeployInput, userID string, node uint32, networkContractID uint64, k8sContractID uint64) (models.K8sCluster, error) {
 	// load cluster
-	resCluster, err := d.tfPluginClient.State.LoadK8sFromGrid(ctx, []uint32{node}, k8sDeployInput.MasterName)
+	resCluster, err := d.grid.LoadK8sFromGrid(ctx, []uint32{node}, k8sDeployInput.MasterName)
 	if err != nil {
 		return models.K8sCluster{}, err
 	}
@@ -184,6 +193,7 @@ func (d *Deployer) loadK8s(ctx context.Context, k8sDeployInput models.K8sDeployI
 	}
 	k8sCluster := models.K8sCluster{
 		UserID:          userID,
+		TeamID:          k8sDeployInput.TeamID,
 		NetworkContract: int(networkContractID),
 		ClusterContract: int(k8sContractID),
 		Master:          master,
@@ -223,7 +233,7 @@ func (d *Deployer) getK8sAvailableNode(ctx context.Context, k models.K8sDeployIn
 		IPv6:    &trueVal,
 	}
 
-	nodes, err := deployer.FilterNodes(ctx, d.tfPluginClient, filter, []uint64{*freeSRU}, nil, rootfs, 1)
+	nodes, err := d.grid.FilterNodes(ctx, filter, []uint64{*freeSRU}, rootfs, 1)
 	if err != nil {
 		return 0, err
 	}
@@ -231,78 +241,106 @@ func (d *Deployer) getK8sAvailableNode(ctx context.Context, k models.K8sDeployIn
 	return uint32(nodes[0].NodeID), nil
 }
 
-// ValidateK8sQuota validates the quota a k8s deployment need
-func ValidateK8sQuota(k models.K8sDeployInput, availableResourcesQuota, availablePublicIPsQuota int) (int, error) {
-	neededQuota, err := calcNeededQuota(k.Resources)
+// ValidateK8sQuota validates the available quota covers the resources a k8s deployment needs and returns them
+func ValidateK8sQuota(k models.K8sDeployInput, available models.Resources) (models.Resources, error) {
+	needed, err := calcK8sNeededQuota(k)
 	if err != nil {
-		return 0, err
+		return models.Resources{}, err
+	}
+
+	return needed, validateQuota(needed, available)
+}
+
+// calcK8sNeededQuota returns the resources of the master, with its public ip, and of all the workers
+func calcK8sNeededQuota(k models.K8sDeployInput) (models.Resources, error) {
+	needed, err := neededQuota(k.Resources, k.Public)
+	if err != nil {
+		return models.Resources{}, err
 	}
 
 	for _, worker := range k.Workers {
-		workerQuota, err := calcNeededQuota(worker.Resources)
+		workerQuota, err := neededQuota(worker.Resources, false)
 		if err != nil {
-			return 0, err
+			return models.Resources{}, err
 		}
-		neededQuota += workerQuota
-	}
-
-	if availableResourcesQuota < neededQuota {
-		return 0, fmt.Errorf("no available quota %d for kubernetes deployment, you can request a new voucher", availableResourcesQuota)
-	}
-	if k.Public && availablePublicIPsQuota < publicQuota {
-		return 0, fmt.Errorf("no available quota %d for public ips", availablePublicIPsQuota)
+		needed = needed.Add(workerQuota)
 	}
 
-	return neededQuota, nil
+	return needed, nil
 }
 
-func (d *Deployer) deployK8sRequest(ctx context.Context, user models.User, k8sDeployInput models.K8sDeployInput, adminSSHKey string) (int, error) {
+func (d *Deployer) deployK8sRequest(ctx context.Context, jobID int, user models.User, k8sDeployInput models.K8sDeployInput, adminSSHKey string) (codeErr int, resErr error) {
 	// quota verification
-	quota, err := d.db.GetUserQuota(user.ID.String())
-	if err == gorm.ErrRecordNotFound {
-		log.Error().Err(err).Send()
-		return http.StatusNotFound, errors.New("user quota is not found")
-	}
+	needed, err := calcK8sNeededQuota(k8sDeployInput)
 	if err != nil {
 		log.Error().Err(err).Send()
-		return http.StatusInternalServerError, errors.New(internalServerErrorMsg)
+		return http.StatusBadRequest, err
 	}
 
-	neededQuota, err := ValidateK8sQuota(k8sDeployInput, quota.Vms, quota.PublicIPs)
+	reservation, codeErr, err := d.reserveQuota(jobID, user.ID.String(), k8sDeployInput.TeamID, needed)
 	if err != nil {
-		log.Error().Err(err).Send()
-		return http.StatusBadRequest, err
+		return codeErr, err
+	}
+	if jobID == 0 {
+		// requests without a job don't keep their reservation between attempts
+		defer func() {
+			if resErr != nil {
+				d.releaseQuota(reservation)
+			}
+		}()
 	}
 
 	// deploy network and cluster
-	node, networkContractID, k8sContractID, err := d.deployK8sClusterWithNetwork(ctx, k8sDeployInput, user.SSHKey, adminSSHKey)
+	sshKeys, err := d.sshKeys(user, k8sDeployInput.TeamID)
 	if err != nil {
 		log.Error().Err(err).Send()
 		return http.StatusInternalServerError, errors.New(internalServerErrorMsg)
 	}
 
-	k8sCluster, err := d.loadK8s(ctx, k8sDeployInput, user.ID.String(), node, networkContractID, k8sContractID)
+	node, networkContractID, k8sContractID, err := d.deployK8sClusterWithNetwork(ctx, jobID, k8sDeployInput, sshKeys, adminSSHKey)
+	compensation := models.Compensation{
+		UserID:            user.ID.String(),
+		JobID:             jobID,
+		Type:              models.K8sType,
+		Name:              k8sDeployInput.MasterName,
+		ContractID:        k8sContractID,
+		NetworkContractID: networkContractID,
+	}
 	if err != nil {
 		log.Error().Err(err).Send()
+		// contracts of the failed attempt are canceled before it is retried or dead lettered
+		if k8sContractID != 0 || networkContractID != 0 {
+			compensation.Error = err.Error()
+			d.compensate("k8s", compensation)
+		}
 		return http.StatusInternalServerError, errors.New(internalServerErrorMsg)
 	}
-	publicIPsQuota := quota.PublicIPs
-	if k8sDeployInput.Public {
-		publicIPsQuota -= publicQuota
-	}
-	// update quota
-	err = d.db.UpdateUserQuota(user.ID.String(), quota.Vms-neededQuota, publicIPsQuota)
-	if err == gorm.ErrRecordNotFound {
-		return http.StatusNotFound, errors.New("user quota is not found")
-	}
+
+	k8sCluster, err := d.loadK8s(ctx, k8sDeployInput, user.ID.String(), node, networkContractID, k8sContractID)
 	if err != nil {
 		log.Error().Err(err).Send()
+		compensation.Error = err.Error()
+		d.compensate("k8s", compensation)
 		return http.StatusInternalServerError, errors.New(internalServerErrorMsg)
 	}
 
-	err = d.db.CreateK8s(&k8sCluster)
+	// the cluster and its quota are saved together, the contracts are canceled if they can't be saved
+	err = d.db.Transaction(func(tx models.Store) error {
+		if err := tx.CreateK8s(&k8sCluster); err != nil {
+			return err
+		}
+		if err := tx.CommitQuotaReservation(reservation.ID); err != nil {
+			return err
+		}
+		if jobID != 0 {
+			return tx.UpdateJobDeployment(jobID, k8sCluster.ID)
+		}
+		return nil
+	})
 	if err != nil {
 		log.Error().Err(err).Send()
+		compensation.Error = err.Error()
+		d.compensate("k8s", compensation)
 		return http.StatusInternalServerError, errors.New(internalServerErrorMsg)
 	}
 
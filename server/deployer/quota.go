@@ -0,0 +1,83 @@
+// Package deployer for handling deployments
+package deployer
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/codescalers/cloud4students/models"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// neededQuota returns the resources a vm of the resources size needs, with a public ip if it is public
+func neededQuota(resources string, public bool) (models.Resources, error) {
+	cru, mru, sru, ips, err := calcNodeResources(resources, public)
+	if err != nil {
+		return models.Resources{}, err
+	}
+
+	return models.Resources{CRU: int(cru), MRU: int(mru), SRU: int(sru), PublicIPs: int(ips)}, nil
+}
+
+// validateQuota checks the available quota covers the needed resources
+func validateQuota(needed, available models.Resources) error {
+	if !needed.Fits(available) {
+		return fmt.Errorf("no available quota for deployment, it needs %s but %s are available, you can request a new voucher", needed, available)
+	}
+	return nil
+}
+
+// reserveQuota returns the quota reservation of the request job, it is taken from the team quota if teamID is set.
+// Requests queued before reservations existed, or requeued after their reservation was released, reserve it now
+func (d *Deployer) reserveQuota(jobID int, userID string, teamID int, needed models.Resources) (models.QuotaReservation, int, error) {
+	if jobID != 0 {
+		reservation, err := d.db.GetJobQuotaReservation(jobID)
+		if err == nil {
+			return reservation, 0, nil
+		}
+		if err != gorm.ErrRecordNotFound {
+			log.Error().Err(err).Send()
+			return models.QuotaReservation{}, http.StatusInternalServerError, errors.New(internalServerErrorMsg)
+		}
+	}
+
+	reservation := models.QuotaReservation{UserID: userID, TeamID: teamID, JobID: jobID, Resources: needed}
+	err := d.db.ReserveQuota(&reservation)
+	if err == models.ErrInsufficientQuota {
+		return models.QuotaReservation{}, http.StatusBadRequest, errors.New("no available quota for deployment, you can request a new voucher")
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return models.QuotaReservation{}, http.StatusInternalServerError, errors.New(internalServerErrorMsg)
+	}
+
+	return reservation, 0, nil
+}
+
+// releaseQuota gives the reserved quota of a failed or canceled request back to its user or team
+func (d *Deployer) releaseQuota(reservation models.QuotaReservation) {
+	err := d.db.ReleaseQuotaReservation(reservation.ID)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		log.Error().Err(err).Msgf("failed to release quota reservation %d", reservation.ID)
+	}
+}
+
+// releaseJobQuota gives the reserved quota of a request job back to its user or team
+func (d *Deployer) releaseJobQuota(jobID int) {
+	if jobID == 0 {
+		return
+	}
+
+	reservation, err := d.db.GetJobQuotaReservation(jobID)
+	if err == gorm.ErrRecordNotFound {
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to get quota reservation of job %d", jobID)
+		return
+	}
+
+	d.releaseQuota(reservation)
+}
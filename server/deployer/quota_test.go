@@ -0,0 +1,53 @@
+// Package deployer for handling deployments
+package deployer
+
+import (
+	"testing"
+
+	"github.com/codescalers/cloud4students/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateVMQuota(t *testing.T) {
+	available := models.Resources{CRU: 4, MRU: 8, SRU: 100}
+
+	t.Run("needed resources of the vm", func(t *testing.T) {
+		needed, err := ValidateVMQuota(models.DeployVMInput{Resources: "medium"}, available)
+		assert.NoError(t, err)
+		assert.Equal(t, models.Resources{CRU: 2, MRU: 4, SRU: 50}, needed)
+	})
+
+	t.Run("public ip is needed", func(t *testing.T) {
+		_, err := ValidateVMQuota(models.DeployVMInput{Resources: "small", Public: true}, available)
+		assert.Error(t, err)
+	})
+
+	t.Run("every resource is needed", func(t *testing.T) {
+		_, err := ValidateVMQuota(models.DeployVMInput{Resources: "large"}, models.Resources{CRU: 8, MRU: 16, SRU: 50})
+		assert.Error(t, err)
+	})
+
+	t.Run("unknown size", func(t *testing.T) {
+		_, err := ValidateVMQuota(models.DeployVMInput{Resources: "huge"}, available)
+		assert.Error(t, err)
+	})
+}
+
+func TestValidateK8sQuota(t *testing.T) {
+	k := models.K8sDeployInput{
+		Resources: "medium",
+		Public:    true,
+		Workers:   []models.Worker{{Name: "small", Resources: "small"}, {Name: "large", Resources: "large"}},
+	}
+
+	t.Run("needed resources of the master and workers", func(t *testing.T) {
+		needed, err := ValidateK8sQuota(k, models.Resources{CRU: 7, MRU: 14, SRU: 175, PublicIPs: 1})
+		assert.NoError(t, err)
+		assert.Equal(t, models.Resources{CRU: 7, MRU: 14, SRU: 175, PublicIPs: 1}, needed)
+	})
+
+	t.Run("not enough quota", func(t *testing.T) {
+		_, err := ValidateK8sQuota(k, models.Resources{CRU: 6, MRU: 14, SRU: 175, PublicIPs: 1})
+		assert.Error(t, err)
+	})
+}
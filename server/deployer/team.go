@@ -0,0 +1,30 @@
+// Package deployer for handling deployments
+package deployer
+
+import (
+	"strings"
+
+	"github.com/codescalers/cloud4students/models"
+)
+
+// sshKeys returns the ssh keys a deployment is accessed with, team deployments are accessed by all the team members
+func (d *Deployer) sshKeys(user models.User, teamID int) (string, error) {
+	if teamID == 0 {
+		return user.SSHKey, nil
+	}
+
+	members, err := d.db.ListTeamMembers(teamID)
+	if err != nil {
+		return "", err
+	}
+
+	keys := []string{user.SSHKey}
+	for _, member := range members {
+		key := strings.TrimSpace(member.SSHKey)
+		if key == "" || member.UserID == user.ID.String() {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return strings.Join(keys, "\n"), nil
+}
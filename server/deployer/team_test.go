@@ -0,0 +1,40 @@
+// Package deployer for handling deployments
+package deployer
+
+import (
+	"testing"
+
+	"github.com/codescalers/cloud4students/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSSHKeys(t *testing.T) {
+	d, db, _, user := setupRetryDeployer(t)
+	user.SSHKey = "user-key"
+
+	team := models.Team{Name: "team", OwnerID: user.ID.String()}
+	err := db.CreateTeam(&team)
+	assert.NoError(t, err)
+
+	for _, m := range []models.User{{Email: "member@gmail.com", SSHKey: "member-key"}, {Email: "nokey@gmail.com"}} {
+		err := db.CreateUser(&m)
+		assert.NoError(t, err)
+		invitation := models.TeamInvitation{TeamID: team.ID, Email: m.Email}
+		_, err = db.CreateTeamInvitation(&invitation)
+		assert.NoError(t, err)
+		err = db.AcceptTeamInvitation(invitation.ID, m.ID.String())
+		assert.NoError(t, err)
+	}
+
+	t.Run("personal deployments have the user key", func(t *testing.T) {
+		keys, err := d.sshKeys(user, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, "user-key", keys)
+	})
+
+	t.Run("team deployments have the keys of all members", func(t *testing.T) {
+		keys, err := d.sshKeys(user, team.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "user-key\nmember-key", keys)
+	})
+}
@@ -9,15 +9,17 @@ import (
 	"github.com/codescalers/cloud4students/middlewares"
 	"github.com/codescalers/cloud4students/models"
 	"github.com/codescalers/cloud4students/streams"
+	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
-	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/deployer"
 	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
 	"github.com/threefoldtech/tfgrid-sdk-go/grid-proxy/pkg/types"
-	"gorm.io/gorm"
 )
 
-func (d *Deployer) deployVM(ctx context.Context, vmInput models.DeployVMInput, sshKey string, adminSSHKey string) (*workloads.VM, uint64, uint64, uint64, error) {
+// deployVM deploys a vm with its network, if it fails it returns the contracts it created with the error
+func (d *Deployer) deployVM(ctx context.Context, jobID int, vmInput models.DeployVMInput, sshKey string, adminSSHKey string) (*workloads.VM, uint64, uint64, uint64, error) {
+	d.updateJob(jobID, models.JobPlacing, nil)
+
 	// filter nodes
 	cru, mru, sru, ips, err := calcNodeResources(vmInput.Resources, vmInput.Public)
 	if err != nil {
@@ -36,7 +38,7 @@ func (d *Deployer) deployVM(ctx context.Context, vmInput models.DeployVMInput, s
 		IPv6:     &trueVal,
 	}
 
-	nodeIDs, err := deployer.FilterNodes(ctx, d.tfPluginClient, filter, []uint64{*freeSRU}, nil, nil, 1)
+	nodeIDs, err := d.grid.FilterNodes(ctx, filter, []uint64{*freeSRU}, nil, 1)
 	if err != nil {
 		return nil, 0, 0, 0, err
 	}
@@ -82,74 +84,96 @@ func (d *Deployer) deployVM(ctx context.Context, vmInput models.DeployVMInput, s
 	dl := workloads.NewDeployment(vmInput.Name, nodeID, "", nil, network.Name, []workloads.Disk{disk}, nil, []workloads.VM{vm}, nil, nil, nil)
 	dl.SolutionType = vmInput.Name
 
+	d.updateJob(jobID, models.JobDeploying, nil)
+
 	// add network and deployment to be deployed
-	err = d.Redis.PushVM(streams.VMDeployment{Net: &network, DL: &dl})
+	id := uuid.NewString()
+	result := d.results.register(id)
+	defer d.results.unregister(id)
+
+	err = streams.PushVM(d.Queue, streams.VMDeployment{ID: id, ReplyTo: d.Queue.Consumer(), Net: &network, DL: &dl})
 	if err != nil {
 		return nil, 0, 0, 0, err
 	}
 
 	// wait for deployments
-	for {
-		if <-d.vmDeployed {
-			break
-		}
+	deployed, err := d.waitDeployment(ctx, id, result)
+	if err != nil {
+		return nil, deployed.ContractID, deployed.NetworkContractID, 0, err
 	}
 
+	d.updateJob(jobID, models.JobVerifying, nil)
+
 	// checks that network and vm are deployed successfully
-	loadedNet, err := d.tfPluginClient.State.LoadNetworkFromGrid(ctx, dl.NetworkName)
+	loadedNet, err := d.grid.LoadNetworkFromGrid(ctx, dl.NetworkName)
 	if err != nil {
-		return nil, 0, 0, 0, errors.Wrapf(err, "failed to load network '%s' on node %v", dl.NetworkName, dl.NodeID)
+		return nil, deployed.ContractID, deployed.NetworkContractID, 0, errors.Wrapf(err, "failed to load network '%s' on node %v", dl.NetworkName, dl.NodeID)
 	}
 
-	loadedDl, err := d.tfPluginClient.State.LoadDeploymentFromGrid(ctx, nodeID, dl.Name)
+	loadedDl, err := d.grid.LoadDeploymentFromGrid(ctx, nodeID, dl.Name)
 	if err != nil {
-		return nil, 0, 0, 0, errors.Wrapf(err, "failed to load vm '%s' on node %v", dl.Name, dl.NodeID)
+		return nil, deployed.ContractID, deployed.NetworkContractID, 0, errors.Wrapf(err, "failed to load vm '%s' on node %v", dl.Name, dl.NodeID)
 	}
 
 	return &loadedDl.Vms[0], loadedDl.ContractID, loadedNet.NodeDeploymentID[nodeID], disk.SizeGB, nil
 }
 
-// ValidateVMQuota validates the quota a vm deployment need
-func ValidateVMQuota(vm models.DeployVMInput, availableResourcesQuota, availablePublicIPsQuota int) (int, error) {
-	neededQuota, err := calcNeededQuota(vm.Resources)
+// ValidateVMQuota validates the available quota covers the resources a vm deployment needs and returns them
+func ValidateVMQuota(vm models.DeployVMInput, available models.Resources) (models.Resources, error) {
+	needed, err := neededQuota(vm.Resources, vm.Public)
 	if err != nil {
-		return 0, err
+		return models.Resources{}, err
 	}
 
-	if availableResourcesQuota < neededQuota {
-		return 0, fmt.Errorf("no available quota %d for deployment for resources %s, you can request a new voucher", availableResourcesQuota, vm.Resources)
-	}
-	if vm.Public && availablePublicIPsQuota < publicQuota {
-		return 0, fmt.Errorf("no available quota %d for public ips", availablePublicIPsQuota)
-	}
-
-	return neededQuota, nil
+	return needed, validateQuota(needed, available)
 }
 
-func (d *Deployer) deployVMRequest(ctx context.Context, user models.User, input models.DeployVMInput, adminSSHKey string) (int, error) {
-	// check quota of user
-	quota, err := d.db.GetUserQuota(user.ID.String())
-	if err == gorm.ErrRecordNotFound {
-		return http.StatusNotFound, errors.New("user quota is not found")
+func (d *Deployer) deployVMRequest(ctx context.Context, jobID int, user models.User, input models.DeployVMInput, adminSSHKey string) (codeErr int, resErr error) {
+	needed, err := neededQuota(input.Resources, input.Public)
+	if err != nil {
+		return http.StatusBadRequest, err
 	}
+
+	reservation, codeErr, err := d.reserveQuota(jobID, user.ID.String(), input.TeamID, needed)
 	if err != nil {
-		log.Error().Err(err).Send()
-		return http.StatusInternalServerError, errors.New(internalServerErrorMsg)
+		return codeErr, err
+	}
+	if jobID == 0 {
+		// requests without a job don't keep their reservation between attempts
+		defer func() {
+			if resErr != nil {
+				d.releaseQuota(reservation)
+			}
+		}()
 	}
 
-	neededQuota, err := ValidateVMQuota(input, quota.Vms, quota.PublicIPs)
+	sshKeys, err := d.sshKeys(user, input.TeamID)
 	if err != nil {
-		return http.StatusBadRequest, err
+		log.Error().Err(err).Send()
+		return http.StatusInternalServerError, errors.New(internalServerErrorMsg)
 	}
 
-	vm, contractID, networkContractID, diskSize, err := d.deployVM(ctx, input, user.SSHKey, adminSSHKey)
+	vm, contractID, networkContractID, diskSize, err := d.deployVM(ctx, jobID, input, sshKeys, adminSSHKey)
 	if err != nil {
 		log.Error().Err(err).Send()
+		// contracts of the failed attempt are canceled before it is retried or dead lettered
+		if contractID != 0 || networkContractID != 0 {
+			d.compensate("vm", models.Compensation{
+				UserID:            user.ID.String(),
+				JobID:             jobID,
+				Type:              models.VMsType,
+				Name:              input.Name,
+				ContractID:        contractID,
+				NetworkContractID: networkContractID,
+				Error:             err.Error(),
+			})
+		}
 		return http.StatusInternalServerError, errors.New(internalServerErrorMsg)
 	}
 
 	userVM := models.VM{
 		UserID:            user.ID.String(),
+		TeamID:            input.TeamID,
 		Name:              vm.Name,
 		YggIP:             vm.PlanetaryIP,
 		MyceliumIP:        vm.MyceliumIP,
@@ -163,23 +187,30 @@ func (d *Deployer) deployVMRequest(ctx context.Context, user models.User, input
 		NetworkContractID: networkContractID,
 	}
 
-	err = d.db.CreateVM(&userVM)
-	if err != nil {
-		log.Error().Err(err).Send()
-		return http.StatusInternalServerError, errors.New(internalServerErrorMsg)
-	}
-
-	publicIPsQuota := quota.PublicIPs
-	if input.Public {
-		publicIPsQuota -= publicQuota
-	}
-	// update quota of user
-	err = d.db.UpdateUserQuota(user.ID.String(), quota.Vms-neededQuota, publicIPsQuota)
-	if err == gorm.ErrRecordNotFound {
-		return http.StatusNotFound, errors.New("User quota is not found")
-	}
+	// the vm and its quota are saved together, the contracts are canceled if they can't be saved
+	err = d.db.Transaction(func(tx models.Store) error {
+		if err := tx.CreateVM(&userVM); err != nil {
+			return err
+		}
+		if err := tx.CommitQuotaReservation(reservation.ID); err != nil {
+			return err
+		}
+		if jobID != 0 {
+			return tx.UpdateJobDeployment(jobID, userVM.ID)
+		}
+		return nil
+	})
 	if err != nil {
 		log.Error().Err(err).Send()
+		d.compensate("vm", models.Compensation{
+			UserID:            user.ID.String(),
+			JobID:             jobID,
+			Type:              models.VMsType,
+			Name:              input.Name,
+			ContractID:        contractID,
+			NetworkContractID: networkContractID,
+			Error:             err.Error(),
+		})
 		return http.StatusInternalServerError, errors.New(internalServerErrorMsg)
 	}
 
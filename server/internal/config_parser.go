@@ -3,24 +3,31 @@ package internal
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 
+	"github.com/codescalers/cloud4students/models"
 	"gopkg.in/validator.v2"
 )
 
 // Configuration struct to hold app configurations
 type Configuration struct {
-	Server                    Server      `json:"server"`
-	MailSender                MailSender  `json:"mailSender"`
-	Database                  DB          `json:"database"`
-	Token                     JwtToken    `json:"token"`
-	Account                   GridAccount `json:"account"`
-	Version                   string      `json:"version" validate:"nonzero"`
-	Admins                    []string    `json:"admins"`
-	NotifyAdminsIntervalHours int         `json:"notifyAdminsIntervalHours"`
-	AdminSSHKey               string      `json:"adminSSHKey"`
-	BalanceThreshold          int         `json:"balanceThreshold"`
+	Server                    Server           `json:"server"`
+	MailSender                MailSender       `json:"mailSender"`
+	Database                  DB               `json:"database"`
+	Token                     JwtToken         `json:"token"`
+	Account                   GridAccount      `json:"account"`
+	Version                   string           `json:"version" validate:"nonzero"`
+	Admins                    []string         `json:"admins"`
+	NotifyAdminsIntervalHours int              `json:"notifyAdminsIntervalHours"`
+	AdminSSHKey               string           `json:"adminSSHKey"`
+	BalanceThreshold          int              `json:"balanceThreshold"`
+	DeployRetry               DeployRetry      `json:"deployRetry"`
+	VerificationCode          VerificationCode `json:"verificationCode"`
+	RateLimits                RateLimits       `json:"rateLimits"`
+	TwoFactor                 TwoFactor        `json:"twoFactor"`
+	OIDC                      []OIDCProvider   `json:"oidc"`
 }
 
 // Server struct to hold server's information
@@ -28,27 +35,255 @@ type Server struct {
 	Host string `json:"host" validate:"nonzero"`
 	Port string `json:"port" validate:"nonzero"`
 
-	RedisHost string `json:"redisHost" validate:"nonzero"`
-	RedisPort string `json:"redisPort" validate:"nonzero"`
+	// Queue is either redis or memory, default is redis
+	Queue     string `json:"queue"`
+	RedisHost string `json:"redisHost"`
+	RedisPort string `json:"redisPort"`
 	RedisPass string `json:"redisPass"`
 }
 
-// MailSender struct to hold sender's email, password
+const (
+	// RedisQueue queues deployments in redis streams
+	RedisQueue = "redis"
+	// MemoryQueue queues deployments in memory for single node installations
+	MemoryQueue = "memory"
+)
+
+func (s Server) validate() error {
+	switch s.Queue {
+	case "", RedisQueue:
+		if len(s.RedisHost) == 0 || len(s.RedisPort) == 0 {
+			return errors.New("redis host and port are required for redis queue")
+		}
+	case MemoryQueue:
+	default:
+		return fmt.Errorf("queue '%s' is not supported", s.Queue)
+	}
+	return nil
+}
+
+// DeployRetry struct to hold retries of failed deployment requests
+type DeployRetry struct {
+	// MaxAttempts of a request before it is moved to the dead letter stream
+	MaxAttempts int64 `json:"maxAttempts" validate:"min=1"`
+	// BackoffSeconds before the first retry, it doubles after every attempt
+	BackoffSeconds int `json:"backoffSeconds" validate:"min=1"`
+}
+
+// RateLimits struct to hold the rate limits of route groups
+type RateLimits struct {
+	// Store is either memory or redis to share limits between replicas, default is memory
+	Store string `json:"store"`
+	// TrustProxyHeaders uses X-Real-IP and X-Forwarded-For as the client ip, only set it behind a proxy
+	TrustProxyHeaders bool `json:"trustProxyHeaders"`
+	// Auth limits sign up, sign in and verification codes requests
+	Auth RateLimit `json:"auth"`
+	// Deploy limits deployment requests
+	Deploy RateLimit `json:"deploy"`
+}
+
+// RateLimit struct to hold a token bucket limit, requests are limited per key
+type RateLimit struct {
+	// RequestsPerMinute refills the bucket, 0 disables the limit
+	RequestsPerMinute int `json:"requestsPerMinute" validate:"min=0"`
+	// Burst is the size of the bucket
+	Burst int `json:"burst" validate:"min=0"`
+	// Key is either ip, user or ip_user, user falls back to ip for requests without a user
+	Key string `json:"key"`
+}
+
+const (
+	// MemoryRateLimitStore keeps rate limits in memory of every replica
+	MemoryRateLimitStore = "memory"
+	// RedisRateLimitStore keeps rate limits in redis
+	RedisRateLimitStore = "redis"
+
+	// IPRateLimitKey limits requests per client ip
+	IPRateLimitKey = "ip"
+	// UserRateLimitKey limits requests per user
+	UserRateLimitKey = "user"
+	// IPUserRateLimitKey limits requests per client ip and user
+	IPUserRateLimitKey = "ip_user"
+)
+
+func (r RateLimits) validate(server Server) error {
+	switch r.Store {
+	case "", MemoryRateLimitStore:
+	case RedisRateLimitStore:
+		if len(server.RedisHost) == 0 || len(server.RedisPort) == 0 {
+			return errors.New("redis host and port are required for redis rate limits store")
+		}
+	default:
+		return fmt.Errorf("rate limits store '%s' is not supported", r.Store)
+	}
+
+	for _, limit := range []RateLimit{r.Auth, r.Deploy} {
+		switch limit.Key {
+		case IPRateLimitKey, UserRateLimitKey, IPUserRateLimitKey:
+		default:
+			return fmt.Errorf("rate limit key '%s' is not supported", limit.Key)
+		}
+
+		if limit.RequestsPerMinute > 0 && limit.Burst == 0 {
+			return errors.New("rate limit burst is required")
+		}
+	}
+	return nil
+}
+
+// VerificationCode struct to hold the limits of codes sent to verify users
+type VerificationCode struct {
+	// MaxAttempts of guessing a code before it is locked and a new one has to be requested
+	MaxAttempts int `json:"maxAttempts" validate:"min=1"`
+	// ResendCooldownSeconds before a new code can be requested for the same purpose
+	ResendCooldownSeconds int `json:"resendCooldownSeconds"`
+}
+
+// TwoFactor struct to hold the configuration of TOTP two factor authentication
+type TwoFactor struct {
+	// Issuer is the name authenticator apps show for the account
+	Issuer string `json:"issuer" validate:"nonzero"`
+	// RequiredForAdmins rejects admins from admin routes until they enable two factor authentication
+	RequiredForAdmins bool `json:"requiredForAdmins"`
+}
+
+// OIDCProvider struct to hold the configuration of an OpenID Connect provider users sign in with
+type OIDCProvider struct {
+	// Name identifies the provider in routes, like `google`
+	Name string `json:"name" validate:"nonzero"`
+	// DisplayName is shown on the sign in button
+	DisplayName  string `json:"displayName"`
+	Issuer       string `json:"issuer" validate:"nonzero"`
+	ClientID     string `json:"clientID" validate:"nonzero"`
+	ClientSecret string `json:"clientSecret"`
+	// RedirectURL is the page of the client the provider redirects to with the code
+	RedirectURL string `json:"redirectURL" validate:"nonzero"`
+	// College is set as the college of users signing up with the provider
+	College string `json:"college"`
+	// CollegeClaim is the claim of the id token holding the college of the user, it overrides College
+	CollegeClaim string   `json:"collegeClaim"`
+	Scopes       []string `json:"scopes"`
+}
+
+// defaultOIDCScopes are requested if the scopes of a provider are not configured
+var defaultOIDCScopes = []string{"openid", "email", "profile"}
+
+func validateOIDC(providers []OIDCProvider) error {
+	names := map[string]bool{}
+	for i, provider := range providers {
+		if names[provider.Name] {
+			return fmt.Errorf("oidc provider '%s' is repeated", provider.Name)
+		}
+		names[provider.Name] = true
+
+		if len(provider.Scopes) == 0 {
+			providers[i].Scopes = defaultOIDCScopes
+		}
+		if !Contains(providers[i].Scopes, "openid") {
+			return fmt.Errorf("oidc provider '%s' scopes should include openid", provider.Name)
+		}
+		if provider.DisplayName == "" {
+			providers[i].DisplayName = provider.Name
+		}
+	}
+	return nil
+}
+
+// MailSender struct to hold sender's email and the configuration of its provider
 type MailSender struct {
-	Email       string `json:"email" validate:"nonzero"`
-	SendGridKey string `json:"sendgrid_key" validate:"nonzero"`
-	Timeout     int    `json:"timeout" validate:"min=30"`
+	Email string `json:"email" validate:"nonzero"`
+	// Provider is either sendgrid, smtp, file or log, default is sendgrid
+	Provider    string `json:"provider"`
+	SendGridKey string `json:"sendgrid_key"`
+	SMTP        SMTP   `json:"smtp"`
+	// Dir is where the file provider writes mails
+	Dir     string      `json:"dir"`
+	Timeout int         `json:"timeout" validate:"min=30"`
+	Outbox  EmailOutbox `json:"outbox"`
+}
+
+// EmailOutbox struct to hold the delivery of queued emails
+type EmailOutbox struct {
+	// MaxAttempts of an email before it is marked as failed
+	MaxAttempts int `json:"maxAttempts" validate:"min=1"`
+	// BackoffSeconds before the first retry, it doubles after every attempt
+	BackoffSeconds int `json:"backoffSeconds" validate:"min=1"`
+	// RatePerMinute is the maximum count of emails sent per minute
+	RatePerMinute int `json:"ratePerMinute" validate:"min=1"`
+}
+
+// SMTP struct to hold smtp server configuration
+type SMTP struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// StartTLS requires upgrading the connection to tls before authenticating
+	StartTLS bool `json:"startTLS"`
 }
 
-// DB struct to hold database file
+func (m MailSender) validate() error {
+	switch m.Provider {
+	case "", SendGridProvider:
+		if len(m.SendGridKey) == 0 {
+			return errors.New("sendgrid key is required for sendgrid mail provider")
+		}
+	case SMTPProvider:
+		if len(m.SMTP.Host) == 0 || m.SMTP.Port == 0 {
+			return errors.New("smtp host and port are required for smtp mail provider")
+		}
+	case FileProvider:
+		if len(m.Dir) == 0 {
+			return errors.New("mails directory is required for file mail provider")
+		}
+	case LogProvider:
+	default:
+		return fmt.Errorf("mail provider '%s' is not supported", m.Provider)
+	}
+	return nil
+}
+
+// DB struct to hold database configuration
 type DB struct {
-	File string `json:"file" validate:"nonzero"`
+	// Driver is either sqlite or postgres, default is sqlite
+	Driver string `json:"driver"`
+	// File is the sqlite database file
+	File string `json:"file"`
+	// DSN is the postgres connection string
+	DSN string `json:"dsn"`
+}
+
+// DataSource returns the file for sqlite and the dsn for postgres
+func (d DB) DataSource() string {
+	if d.Driver == models.PostgresDriver {
+		return d.DSN
+	}
+	return d.File
+}
+
+func (d DB) validate() error {
+	switch d.Driver {
+	case "", models.SQLiteDriver:
+		if len(d.File) == 0 {
+			return errors.New("database file is required for sqlite driver")
+		}
+	case models.PostgresDriver:
+		if len(d.DSN) == 0 {
+			return errors.New("database dsn is required for postgres driver")
+		}
+	default:
+		return fmt.Errorf("database driver '%s' is not supported", d.Driver)
+	}
+	return nil
 }
 
 // JwtToken struct to hold JWT information
 type JwtToken struct {
-	Secret  string `json:"secret" validate:"nonzero"`
-	Timeout int    `json:"timeout" validate:"min=5"`
+	Secret string `json:"secret" validate:"nonzero"`
+	// Timeout of access tokens in minutes
+	Timeout int `json:"timeout" validate:"min=5"`
+	// RefreshTimeout of sessions in minutes, a session can be refreshed until it times out
+	RefreshTimeout int `json:"refreshTimeout" validate:"min=5"`
 }
 
 // GridAccount struct to hold grid account mnemonics
@@ -59,7 +294,19 @@ type GridAccount struct {
 
 // ReadConfFile read configurations of json file
 func ReadConfFile(path string) (Configuration, error) {
-	config := Configuration{NotifyAdminsIntervalHours: 6, BalanceThreshold: 2000}
+	config := Configuration{
+		NotifyAdminsIntervalHours: 6,
+		BalanceThreshold:          2000,
+		DeployRetry:               DeployRetry{MaxAttempts: 5, BackoffSeconds: 30},
+		MailSender:                MailSender{Outbox: EmailOutbox{MaxAttempts: 5, BackoffSeconds: 30, RatePerMinute: 60}},
+		VerificationCode:          VerificationCode{MaxAttempts: 5, ResendCooldownSeconds: 60},
+		Token:                     JwtToken{RefreshTimeout: 30 * 24 * 60},
+		TwoFactor:                 TwoFactor{Issuer: "cloud4students"},
+		RateLimits: RateLimits{
+			Auth:   RateLimit{RequestsPerMinute: 10, Burst: 5, Key: IPRateLimitKey},
+			Deploy: RateLimit{RequestsPerMinute: 5, Burst: 5, Key: UserRateLimitKey},
+		},
+	}
 	file, err := os.Open(path)
 	if err != nil {
 		return Configuration{}, fmt.Errorf("failed to open config file: %w", err)
@@ -70,5 +317,25 @@ func ReadConfFile(path string) (Configuration, error) {
 		return Configuration{}, fmt.Errorf("failed to load config: %w", err)
 	}
 
-	return config, validator.Validate(config)
+	if err := validator.Validate(config); err != nil {
+		return config, err
+	}
+
+	if err := config.Server.validate(); err != nil {
+		return config, err
+	}
+
+	if err := config.MailSender.validate(); err != nil {
+		return config, err
+	}
+
+	if err := config.RateLimits.validate(config.Server); err != nil {
+		return config, err
+	}
+
+	if err := validateOIDC(config.OIDC); err != nil {
+		return config, err
+	}
+
+	return config, config.Database.validate()
 }
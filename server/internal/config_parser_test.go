@@ -4,6 +4,7 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -118,8 +119,9 @@ func TestParseConf(t *testing.T) {
 				Mnemonics: "my mnemonics",
 			},
 			Token: JwtToken{
-				Secret:  "secret",
-				Timeout: 10,
+				Secret:         "secret",
+				Timeout:        10,
+				RefreshTimeout: 30 * 24 * 60,
 			},
 			Database: DB{
 				File: "testing.db",
@@ -134,6 +136,7 @@ func TestParseConf(t *testing.T) {
 		assert.Equal(t, got.Token, expected.Token)
 		assert.Equal(t, got.Database, expected.Database)
 		assert.Equal(t, got.Version, expected.Version)
+		assert.Equal(t, VerificationCode{MaxAttempts: 5, ResendCooldownSeconds: 60}, got.VerificationCode)
 	})
 
 	t.Run("no file", func(t *testing.T) {
@@ -190,6 +193,111 @@ func TestParseConf(t *testing.T) {
 
 	})
 
+	t.Run("mail provider configuration", func(t *testing.T) {
+		dir := t.TempDir()
+		configPath := filepath.Join(dir, "/config.json")
+
+		for provider, valid := range map[string]bool{
+			`"provider": "log"`:                  true,
+			`"provider": "file", "dir": "mails"`: true,
+			`"provider": "file"`:                 false,
+			`"provider": "smtp", "smtp": {"host": "localhost", "port": 25}`: true,
+			`"provider": "smtp"`:   false,
+			`"provider": "pigeon"`: false,
+		} {
+			config := strings.Replace(rightConfig, `"sendgrid_key": "my sendgrid_key",`, provider+",", 1)
+			err := os.WriteFile(configPath, []byte(config), 0644)
+			assert.NoError(t, err)
+
+			_, err = ReadConfFile(configPath)
+			if valid {
+				assert.NoError(t, err, provider)
+			} else {
+				assert.Error(t, err, provider)
+			}
+		}
+	})
+
+	t.Run("rate limits configuration", func(t *testing.T) {
+		dir := t.TempDir()
+		configPath := filepath.Join(dir, "/config.json")
+
+		for rateLimits, valid := range map[string]bool{
+			`{"store": "redis"}`: true,
+			`{"store": "memory", "auth": {"requestsPerMinute": 20, "key": "ip_user"}}`: true,
+			`{"deploy": {"requestsPerMinute": 0, "burst": 0}}`:                         true,
+			`{"store": "etcd"}`:                                 false,
+			`{"auth": {"key": "email"}}`:                        false,
+			`{"deploy": {"requestsPerMinute": 20, "burst": 0}}`: false,
+		} {
+			config := strings.Replace(rightConfig, `"version": "v1",`, `"version": "v1", "rateLimits": `+rateLimits+",", 1)
+			err := os.WriteFile(configPath, []byte(config), 0644)
+			assert.NoError(t, err)
+
+			got, err := ReadConfFile(configPath)
+			if valid {
+				assert.NoError(t, err, rateLimits)
+				assert.NotEmpty(t, got.RateLimits.Auth.Key, rateLimits)
+			} else {
+				assert.Error(t, err, rateLimits)
+			}
+		}
+	})
+
+	t.Run("two factor configuration", func(t *testing.T) {
+		dir := t.TempDir()
+		configPath := filepath.Join(dir, "/config.json")
+
+		for twoFactor, want := range map[string]TwoFactor{
+			`{}`: {Issuer: "cloud4students"},
+			`{"issuer": "c4s", "requiredForAdmins": true}`: {Issuer: "c4s", RequiredForAdmins: true},
+		} {
+			config := strings.Replace(rightConfig, `"version": "v1",`, `"version": "v1", "twoFactor": `+twoFactor+",", 1)
+			err := os.WriteFile(configPath, []byte(config), 0644)
+			assert.NoError(t, err)
+
+			got, err := ReadConfFile(configPath)
+			assert.NoError(t, err, twoFactor)
+			assert.Equal(t, want, got.TwoFactor, twoFactor)
+		}
+
+		config := strings.Replace(rightConfig, `"version": "v1",`, `"version": "v1", "twoFactor": {"issuer": ""},`, 1)
+		err := os.WriteFile(configPath, []byte(config), 0644)
+		assert.NoError(t, err)
+
+		_, err = ReadConfFile(configPath)
+		assert.Error(t, err)
+	})
+
+	t.Run("oidc configuration", func(t *testing.T) {
+		dir := t.TempDir()
+		configPath := filepath.Join(dir, "/config.json")
+
+		provider := `{"name": "google", "issuer": "https://accounts.google.com", "clientID": "id", "redirectURL": "http://localhost/oidc"}`
+		config := strings.Replace(rightConfig, `"version": "v1",`, `"version": "v1", "oidc": [`+provider+`],`, 1)
+		err := os.WriteFile(configPath, []byte(config), 0644)
+		assert.NoError(t, err)
+
+		got, err := ReadConfFile(configPath)
+		assert.NoError(t, err)
+		assert.Len(t, got.OIDC, 1)
+		assert.Equal(t, []string{"openid", "email", "profile"}, got.OIDC[0].Scopes)
+		assert.Equal(t, "google", got.OIDC[0].DisplayName)
+
+		for _, providers := range []string{
+			provider + "," + provider,
+			`{"name": "google", "clientID": "id", "redirectURL": "http://localhost/oidc"}`,
+			`{"name": "google", "issuer": "https://accounts.google.com", "clientID": "id", "redirectURL": "http://localhost/oidc", "scopes": ["email"]}`,
+		} {
+			config := strings.Replace(rightConfig, `"version": "v1",`, `"version": "v1", "oidc": [`+providers+`],`, 1)
+			err := os.WriteFile(configPath, []byte(config), 0644)
+			assert.NoError(t, err)
+
+			_, err = ReadConfFile(configPath)
+			assert.Error(t, err, providers)
+		}
+	})
+
 	t.Run("no database configuration", func(t *testing.T) {
 		config :=
 			`
@@ -370,3 +478,75 @@ func TestParseConf(t *testing.T) {
 
 	})
 }
+
+func TestDatabaseConfig(t *testing.T) {
+	writeConfig := func(t *testing.T, database string) string {
+		config := strings.Replace(rightConfig, `"file": "testing.db"`, database, 1)
+
+		configPath := filepath.Join(t.TempDir(), "/config.json")
+		err := os.WriteFile(configPath, []byte(config), 0644)
+		assert.NoError(t, err)
+		return configPath
+	}
+
+	t.Run("sqlite by default", func(t *testing.T) {
+		config, err := ReadConfFile(writeConfig(t, `"file": "testing.db"`))
+		assert.NoError(t, err)
+		assert.Equal(t, "testing.db", config.Database.DataSource())
+	})
+
+	t.Run("sqlite without file", func(t *testing.T) {
+		_, err := ReadConfFile(writeConfig(t, `"driver": "sqlite"`))
+		assert.Error(t, err)
+	})
+
+	t.Run("postgres", func(t *testing.T) {
+		dsn := "host=localhost user=postgres dbname=c4s"
+		config, err := ReadConfFile(writeConfig(t, `"driver": "postgres", "dsn": "`+dsn+`"`))
+		assert.NoError(t, err)
+		assert.Equal(t, dsn, config.Database.DataSource())
+	})
+
+	t.Run("postgres without dsn", func(t *testing.T) {
+		_, err := ReadConfFile(writeConfig(t, `"driver": "postgres"`))
+		assert.Error(t, err)
+	})
+
+	t.Run("unsupported driver", func(t *testing.T) {
+		_, err := ReadConfFile(writeConfig(t, `"driver": "mysql", "file": "testing.db"`))
+		assert.Error(t, err)
+	})
+}
+
+func TestQueueConfig(t *testing.T) {
+	writeConfig := func(t *testing.T, server string) string {
+		config := strings.Replace(rightConfig, `"redisHost": "localhost",
+		"redisPort": "6379",`, server, 1)
+
+		configPath := filepath.Join(t.TempDir(), "/config.json")
+		err := os.WriteFile(configPath, []byte(config), 0644)
+		assert.NoError(t, err)
+		return configPath
+	}
+
+	t.Run("redis by default", func(t *testing.T) {
+		_, err := ReadConfFile(writeConfig(t, `"redisHost": "localhost", "redisPort": "6379",`))
+		assert.NoError(t, err)
+	})
+
+	t.Run("redis without host", func(t *testing.T) {
+		_, err := ReadConfFile(writeConfig(t, `"queue": "redis",`))
+		assert.Error(t, err)
+	})
+
+	t.Run("memory without redis", func(t *testing.T) {
+		config, err := ReadConfFile(writeConfig(t, `"queue": "memory",`))
+		assert.NoError(t, err)
+		assert.Equal(t, MemoryQueue, config.Server.Queue)
+	})
+
+	t.Run("unsupported queue", func(t *testing.T) {
+		_, err := ReadConfFile(writeConfig(t, `"queue": "kafka",`))
+		assert.Error(t, err)
+	})
+}
@@ -2,161 +2,230 @@
 package internal
 
 import (
-	_ "embed"
+	"bytes"
+	"embed"
+	"errors"
 	"fmt"
+	htmlTemplate "html/template"
 	"strings"
+	textTemplate "text/template"
 
-	"github.com/codescalers/cloud4students/validators"
-	"github.com/sendgrid/sendgrid-go"
-	"github.com/sendgrid/sendgrid-go/helpers/mail"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
 )
 
-var (
-	//go:embed templates/signup.html
-	signUpMail []byte
-
-	//go:embed templates/welcome.html
-	welcomeMail []byte
+//go:embed templates
+var mailTemplates embed.FS
+
+// names of mail templates
+const (
+	SignUpMail          = "signup"
+	WelcomeMail         = "welcome"
+	ResetPasswordMail   = "reset_password"
+	ApprovedVoucherMail = "approved_voucher"
+	RejectedVoucherMail = "rejected_voucher"
+	PendingVouchersMail = "pending_vouchers"
+	LowBalanceMail      = "low_balance"
+	AnnouncementMail    = "announcement"
+	AdminMail           = "admin_email"
+	TeamInvitationMail  = "team_invitation"
+)
 
-	//go:embed templates/reset_pass.html
-	resetPassMail []byte
+// ErrMailTemplateNotFound is returned for unknown mail templates
+var ErrMailTemplateNotFound = errors.New("mail template is not found")
 
-	//go:embed templates/approvedVoucher.html
-	approveVoucherMail []byte
+// sensitiveMails hold secrets like verification codes and vouchers, their bodies aren't kept once they are sent
+var sensitiveMails = map[string]bool{
+	SignUpMail:          true,
+	ResetPasswordMail:   true,
+	ApprovedVoucherMail: true,
+}
 
-	//go:embed templates/rejectedVoucher.html
-	rejectedVoucherMail []byte
+// Mail is a rendered mail with its html body and plain text alternative
+type Mail struct {
+	Subject   string `json:"subject"`
+	HTML      string `json:"html"`
+	Text      string `json:"text"`
+	Sensitive bool   `json:"sensitive"`
+}
 
-	//go:embed templates/voucherNotification.html
-	notifyVoucherMail []byte
+// SignUpMailData is the data of the sign up verification mail
+type SignUpMailData struct {
+	Name    string
+	Code    int
+	Timeout int
+}
 
-	//go:embed templates/balanceNotification.html
-	balanceMail []byte
+// WelcomeMailData is the data of the welcome mail
+type WelcomeMailData struct {
+	Name string
+}
 
-	//go:embed templates/adminAnnouncement.html
-	adminAnnouncement []byte
-)
+// ResetPasswordMailData is the data of the reset password verification mail
+type ResetPasswordMailData struct {
+	Name    string
+	Code    int
+	Timeout int
+}
 
-// SendMail sends verification mails
-func SendMail(sender, sendGridKey, receiver, subject, body string) error {
-	from := mail.NewEmail("Cloud4Students", sender)
+// ApprovedVoucherMailData is the data of the approved voucher mail
+type ApprovedVoucherMailData struct {
+	Name    string
+	Voucher string
+}
 
-	err := validators.ValidMail(receiver)
-	if err != nil {
-		return fmt.Errorf("email %v is not valid", receiver)
-	}
+// RejectedVoucherMailData is the data of the rejected voucher mail
+type RejectedVoucherMailData struct {
+	Name string
+}
 
-	to := mail.NewEmail("Cloud4Students User", receiver)
+// PendingVouchersMailData is the data of the mail notifying admins of pending vouchers
+type PendingVouchersMailData struct {
+	Vouchers int
+}
 
-	message := mail.NewSingleEmail(from, subject, to, "", body)
-	client := sendgrid.NewSendClient(sendGridKey)
-	_, err = client.Send(message)
+// LowBalanceMailData is the data of the mail notifying admins of low balance
+type LowBalanceMailData struct {
+	Balance float64
+}
 
-	return err
+// AnnouncementMailData is the data of announcements and emails sent by admins
+type AnnouncementMailData struct {
+	Name    string
+	Subject string
+	Body    string
 }
 
-// SignUpMailContent gets the email content for sign up
-func SignUpMailContent(code int, timeout int, username, host string) (string, string) {
-	subject := "Welcome to Cloud4Students 🎉"
-	body := string(signUpMail)
+// TeamInvitationMailData is the data of the mail inviting a user to a team
+type TeamInvitationMailData struct {
+	Owner string
+	Team  string
+}
 
-	body = strings.ReplaceAll(body, "-code-", fmt.Sprint(code))
-	body = strings.ReplaceAll(body, "-time-", fmt.Sprint(timeout))
-	body = strings.ReplaceAll(body, "-name-", cases.Title(language.Und).String(username))
-	body = strings.ReplaceAll(body, "-host-", host)
+// mailSamples are the data mail templates are previewed with
+var mailSamples = map[string]interface{}{
+	SignUpMail:          SignUpMailData{Name: "student", Code: 123456, Timeout: 60},
+	WelcomeMail:         WelcomeMailData{Name: "student"},
+	ResetPasswordMail:   ResetPasswordMailData{Name: "student", Code: 123456, Timeout: 60},
+	ApprovedVoucherMail: ApprovedVoucherMailData{Name: "student", Voucher: "voucher"},
+	RejectedVoucherMail: RejectedVoucherMailData{Name: "student"},
+	PendingVouchersMail: PendingVouchersMailData{Vouchers: 7},
+	LowBalanceMail:      LowBalanceMailData{Balance: 200},
+	AnnouncementMail:    AnnouncementMailData{Name: "student", Subject: "New features", Body: "Kubernetes clusters are available.\nTry them now!"},
+	AdminMail:           AnnouncementMailData{Name: "student", Subject: "Your deployments", Body: "Your deployments will be moved.\nNo action is needed."},
+	TeamInvitationMail:  TeamInvitationMailData{Owner: "student", Team: "graduation project"},
+}
 
-	return subject, body
+// mailTemplate is the html and plain text templates of a mail
+type mailTemplate struct {
+	html *htmlTemplate.Template
+	text *textTemplate.Template
 }
 
-// WelcomeMailContent gets the email content for welcome messages
-func WelcomeMailContent(username, host string) (string, string) {
-	subject := "Welcome to Cloud4Students 🎉"
-	body := string(welcomeMail)
+// mailData is passed to mail templates
+type mailData struct {
+	Host string
+	Data interface{}
+}
 
-	body = strings.ReplaceAll(body, "-name-", cases.Title(language.Und).String(username))
-	body = strings.ReplaceAll(body, "-host-", host)
+var templateFuncs = map[string]interface{}{
+	"title": cases.Title(language.Und).String,
+	"lines": func(s string) []string { return strings.Split(s, "\n") },
+}
 
-	return subject, body
+var parsedMailTemplates = parseMailTemplates()
+
+func parseMailTemplates() map[string]mailTemplate {
+	templates := make(map[string]mailTemplate, len(mailSamples))
+	for name := range mailSamples {
+		templates[name] = mailTemplate{
+			html: htmlTemplate.Must(htmlTemplate.New(name).Funcs(templateFuncs).
+				ParseFS(mailTemplates, "templates/layout.html", fmt.Sprintf("templates/%s.html", name))),
+			text: textTemplate.Must(textTemplate.New(name).Funcs(templateFuncs).
+				ParseFS(mailTemplates, "templates/layout.txt", fmt.Sprintf("templates/%s.txt", name))),
+		}
+	}
+	return templates
 }
 
-// ResetPasswordMailContent gets the email content for reset password
-func ResetPasswordMailContent(code int, timeout int, username, host string) (string, string) {
-	subject := "Reset password"
-	body := string(resetPassMail)
+// RenderMail renders the mail template with the given name and data
+func RenderMail(name, host string, data interface{}) (Mail, error) {
+	t, ok := parsedMailTemplates[name]
+	if !ok {
+		return Mail{}, ErrMailTemplateNotFound
+	}
 
-	body = strings.ReplaceAll(body, "-code-", fmt.Sprint(code))
-	body = strings.ReplaceAll(body, "-time-", fmt.Sprint(timeout))
-	body = strings.ReplaceAll(body, "-name-", cases.Title(language.Und).String(username))
-	body = strings.ReplaceAll(body, "-host-", host)
+	d := mailData{Host: host, Data: data}
 
-	return subject, body
+	var subject, html, text bytes.Buffer
+	if err := t.text.ExecuteTemplate(&subject, "subject", d); err != nil {
+		return Mail{}, err
+	}
+	if err := t.html.ExecuteTemplate(&html, "layout", d); err != nil {
+		return Mail{}, err
+	}
+	if err := t.text.ExecuteTemplate(&text, "layout", d); err != nil {
+		return Mail{}, err
+	}
+
+	return Mail{Subject: subject.String(), HTML: html.String(), Text: text.String(), Sensitive: sensitiveMails[name]}, nil
 }
 
-// ApprovedVoucherMailContent gets the content for approved voucher
-func ApprovedVoucherMailContent(voucher string, username, host string) (string, string) {
-	subject := "Your voucher request is approved 🎆"
-	body := string(approveVoucherMail)
+// PreviewMail renders the mail template with the given name and sample data
+func PreviewMail(name, host string) (Mail, error) {
+	data, ok := mailSamples[name]
+	if !ok {
+		return Mail{}, ErrMailTemplateNotFound
+	}
+	return RenderMail(name, host, data)
+}
 
-	body = strings.ReplaceAll(body, "-voucher-", fmt.Sprint(voucher))
-	body = strings.ReplaceAll(body, "-name-", cases.Title(language.Und).String(username))
-	body = strings.ReplaceAll(body, "-host-", host)
+// SignUpMailContent gets the email content for sign up
+func SignUpMailContent(host string, data SignUpMailData) (Mail, error) {
+	return RenderMail(SignUpMail, host, data)
+}
 
-	return subject, body
+// WelcomeMailContent gets the email content for welcome messages
+func WelcomeMailContent(host string, data WelcomeMailData) (Mail, error) {
+	return RenderMail(WelcomeMail, host, data)
 }
 
-// RejectedVoucherMailContent gets the content for rejected voucher
-func RejectedVoucherMailContent(username, host string) (string, string) {
-	subject := "Your voucher request is rejected 😔"
-	body := string(rejectedVoucherMail)
+// ResetPasswordMailContent gets the email content for reset password
+func ResetPasswordMailContent(host string, data ResetPasswordMailData) (Mail, error) {
+	return RenderMail(ResetPasswordMail, host, data)
+}
 
-	body = strings.ReplaceAll(body, "-name-", cases.Title(language.Und).String(username))
-	body = strings.ReplaceAll(body, "-host-", host)
+// ApprovedVoucherMailContent gets the content for approved voucher
+func ApprovedVoucherMailContent(host string, data ApprovedVoucherMailData) (Mail, error) {
+	return RenderMail(ApprovedVoucherMail, host, data)
+}
 
-	return subject, body
+// RejectedVoucherMailContent gets the content for rejected voucher
+func RejectedVoucherMailContent(host string, data RejectedVoucherMailData) (Mail, error) {
+	return RenderMail(RejectedVoucherMail, host, data)
 }
 
 // NotifyAdminsMailContent gets the content for notifying admins
-func NotifyAdminsMailContent(vouchers int, host string) (string, string) {
-	subject := "There're pending voucher requests for you to review"
-	body := string(notifyVoucherMail)
-
-	body = strings.ReplaceAll(body, "-vouchers-", fmt.Sprint(vouchers))
-	body = strings.ReplaceAll(body, "-host-", host)
-
-	return subject, body
+func NotifyAdminsMailContent(host string, data PendingVouchersMailData) (Mail, error) {
+	return RenderMail(PendingVouchersMail, host, data)
 }
 
 // NotifyAdminsMailLowBalanceContent gets the content for notifying admins when balance becomes low
-func NotifyAdminsMailLowBalanceContent(balance float64, host string) (string, string) {
-	subject := "Your account balance is low"
-	body := string(balanceMail)
-
-	body = strings.ReplaceAll(body, "-balance-", fmt.Sprint(balance))
-	body = strings.ReplaceAll(body, "-host-", host)
-
-	return subject, body
+func NotifyAdminsMailLowBalanceContent(host string, data LowBalanceMailData) (Mail, error) {
+	return RenderMail(LowBalanceMail, host, data)
 }
 
 // AdminAnnouncementMailContent gets the email content for administrator announcements
-func AdminAnnouncementMailContent(adminSubject, announcement, host, username string) (string, string) {
-	subject := "New Announcement! 📢 " + adminSubject
-	body := string(adminAnnouncement)
-	body = strings.ReplaceAll(body, "-subject-", adminSubject)
-	body = strings.ReplaceAll(body, "-announcement-", strings.ReplaceAll(announcement, "\n", "<br>"))
-	body = strings.ReplaceAll(body, "-name-", cases.Title(language.Und).String(username))
-	body = strings.ReplaceAll(body, "-host-", host)
-	return subject, body
+func AdminAnnouncementMailContent(host string, data AnnouncementMailData) (Mail, error) {
+	return RenderMail(AnnouncementMail, host, data)
 }
 
 // AdminMailContent gets the email content for administrator emails
-func AdminMailContent(adminSubject, email, host, username string) (string, string) {
-	subject := "Hey! 📢 " + adminSubject
-	body := string(adminAnnouncement)
-	body = strings.ReplaceAll(body, "-subject-", adminSubject)
-	body = strings.ReplaceAll(body, "-announcement-", strings.ReplaceAll(email, "\n", "<br>"))
-	body = strings.ReplaceAll(body, "-name-", cases.Title(language.Und).String(username))
-	body = strings.ReplaceAll(body, "-host-", host)
-	return subject, body
+func AdminMailContent(host string, data AnnouncementMailData) (Mail, error) {
+	return RenderMail(AdminMail, host, data)
+}
+
+// TeamInvitationMailContent gets the email content for team invitations
+func TeamInvitationMailContent(host string, data TeamInvitationMailData) (Mail, error) {
+	return RenderMail(TeamInvitationMail, host, data)
 }
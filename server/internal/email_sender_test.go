@@ -1,105 +1,171 @@
 package internal
 
 import (
-	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
-	"golang.org/x/text/cases"
-	"golang.org/x/text/language"
 )
 
 func TestSendMail(t *testing.T) {
+	dir := t.TempDir()
+	mailer := NewFileMailer("sender@gmail.com", dir)
+
 	t.Run("send valid mail", func(t *testing.T) {
-		err := SendMail("sender@gmail.com", "1234", "receiver@gmail.com", "subject", "body")
+		err := mailer.Send("receiver@gmail.com", Mail{Subject: "subject 🎉", HTML: "<p>body</p>", Text: "body"})
+		assert.NoError(t, err)
+
+		files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+		assert.NoError(t, err)
+		assert.Len(t, files, 1)
+
+		file, err := os.Open(files[0])
+		assert.NoError(t, err)
+		defer file.Close()
+
+		msg, err := mail.ReadMessage(file)
 		assert.NoError(t, err)
+		assert.Equal(t, `"Cloud4Students" <sender@gmail.com>`, msg.Header.Get("From"))
+		assert.Equal(t, `"Cloud4Students User" <receiver@gmail.com>`, msg.Header.Get("To"))
+
+		subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+		assert.NoError(t, err)
+		assert.Equal(t, "subject 🎉", subject)
+
+		mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+		assert.NoError(t, err)
+		assert.Equal(t, "multipart/alternative", mediaType)
+
+		parts := multipart.NewReader(msg.Body, params["boundary"])
+		for _, want := range []struct{ contentType, body string }{
+			{"text/plain; charset=UTF-8", "body"},
+			{"text/html; charset=UTF-8", "<p>body</p>"},
+		} {
+			part, err := parts.NextRawPart()
+			assert.NoError(t, err)
+			assert.Equal(t, want.contentType, part.Header.Get("Content-Type"))
+
+			body, err := io.ReadAll(quotedprintable.NewReader(part))
+			assert.NoError(t, err)
+			assert.Equal(t, want.body, string(body))
+		}
 	})
 
 	t.Run("send invalid mail", func(t *testing.T) {
-		err := SendMail("sender@gmail.com", "1234", "receiver", "subject", "body")
+		err := mailer.Send("receiver", Mail{Subject: "subject", HTML: "body"})
 		assert.Error(t, err)
 	})
 }
 
 func TestSignUpMailContent(t *testing.T) {
-	subject, body := SignUpMailContent(1234, 60, "user", "")
-	assert.Equal(t, subject, "Welcome to Cloud4Students 🎉")
-
-	want := string(signUpMail)
-	want = strings.ReplaceAll(want, "-code-", fmt.Sprint(1234))
-	want = strings.ReplaceAll(want, "-time-", fmt.Sprint(60))
-	want = strings.ReplaceAll(want, "-name-", cases.Title(language.Und).String("user"))
-	want = strings.ReplaceAll(want, "-host-", "")
-
-	assert.Equal(t, body, want)
+	mail, err := SignUpMailContent("host", SignUpMailData{Name: "user", Code: 1234, Timeout: 60})
+	assert.NoError(t, err)
+	assert.Equal(t, "Welcome to Cloud4Students 🎉", mail.Subject)
+	assert.True(t, mail.Sensitive)
+
+	assert.Contains(t, mail.HTML, "Welcome, User!")
+	assert.Contains(t, mail.HTML, "1234")
+	assert.Contains(t, mail.HTML, "expire after 60 seconds")
+	assert.Contains(t, mail.HTML, `href="host"`)
+
+	assert.Contains(t, mail.Text, "Welcome, User!")
+	assert.Contains(t, mail.Text, "Your code is 1234, it will expire after 60 seconds")
+	assert.NotContains(t, mail.Text, "<")
 }
 
 func TestResetPassMailContent(t *testing.T) {
-	subject, body := ResetPasswordMailContent(1234, 60, "user", "")
-	assert.Equal(t, subject, "Reset password")
-
-	want := string(resetPassMail)
-	want = strings.ReplaceAll(want, "-code-", fmt.Sprint(1234))
-	want = strings.ReplaceAll(want, "-time-", fmt.Sprint(60))
-	want = strings.ReplaceAll(want, "-name-", cases.Title(language.Und).String("user"))
-	want = strings.ReplaceAll(want, "-host-", "")
-
-	assert.Equal(t, body, want)
+	mail, err := ResetPasswordMailContent("", ResetPasswordMailData{Name: "user", Code: 1234, Timeout: 60})
+	assert.NoError(t, err)
+	assert.Equal(t, "Reset password", mail.Subject)
+	assert.True(t, mail.Sensitive)
+	assert.Contains(t, mail.HTML, "1234")
+	assert.Contains(t, mail.Text, "Your code is 1234")
 }
 
 func TestApprovedVoucherMailContent(t *testing.T) {
-	subject, body := ApprovedVoucherMailContent("1234", "user", "")
-	assert.Equal(t, subject, "Your voucher request is approved 🎆")
-
-	want := string(approveVoucherMail)
-	want = strings.ReplaceAll(want, "-voucher-", fmt.Sprint(1234))
-	want = strings.ReplaceAll(want, "-name-", cases.Title(language.Und).String("user"))
-	want = strings.ReplaceAll(want, "-host-", "")
-
-	assert.Equal(t, body, want)
+	mail, err := ApprovedVoucherMailContent("", ApprovedVoucherMailData{Name: "user", Voucher: "1234"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Your voucher request is approved 🎆", mail.Subject)
+	assert.True(t, mail.Sensitive)
+	assert.Contains(t, mail.HTML, "Welcome, User!")
+	assert.Contains(t, mail.HTML, "1234")
+	assert.Contains(t, mail.Text, "Your voucher is 1234")
 }
 
 func TestRejectedVoucherMailContent(t *testing.T) {
-	subject, body := RejectedVoucherMailContent("user", "")
-	assert.Equal(t, subject, "Your voucher request is rejected 😔")
+	mail, err := RejectedVoucherMailContent("", RejectedVoucherMailData{Name: "user"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Your voucher request is rejected 😔", mail.Subject)
+	assert.False(t, mail.Sensitive)
+	assert.Contains(t, mail.HTML, "has been rejected")
+	assert.Contains(t, mail.Text, "has been rejected")
+}
 
-	want := string(rejectedVoucherMail)
-	want = strings.ReplaceAll(want, "-name-", cases.Title(language.Und).String("user"))
-	want = strings.ReplaceAll(want, "-host-", "")
+func TestNotifyVoucherMailContent(t *testing.T) {
+	mail, err := NotifyAdminsMailContent("", PendingVouchersMailData{Vouchers: 7})
+	assert.NoError(t, err)
+	assert.Equal(t, "There're pending voucher requests for you to review", mail.Subject)
+	assert.Contains(t, mail.HTML, "There are 7 voucher requests")
+	assert.Contains(t, mail.Text, "There are 7 voucher requests")
+}
 
-	assert.Equal(t, body, want)
+func TestNotifyBalanceMailContent(t *testing.T) {
+	mail, err := NotifyAdminsMailLowBalanceContent("", LowBalanceMailData{Balance: 200})
+	assert.NoError(t, err)
+	assert.Equal(t, "Your account balance is low", mail.Subject)
+	assert.Contains(t, mail.HTML, "(200 tft)")
+	assert.Contains(t, mail.Text, "(200 tft)")
 }
 
-func TestNotifyVoucherMailContent(t *testing.T) {
-	subject, body := NotifyAdminsMailContent(7, "")
-	assert.Equal(t, subject, "There're pending voucher requests for you to review")
+func TestAdminAnnouncementMailContent(t *testing.T) {
+	mail, err := AdminAnnouncementMailContent("", AnnouncementMailData{
+		Name:    "<b>user</b>",
+		Subject: "subject!",
+		Body:    "announcement!\n<script>alert(1)</script>",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "New Announcement! 📢 subject!", mail.Subject)
 
-	want := string(notifyVoucherMail)
-	want = strings.ReplaceAll(want, "-vouchers-", fmt.Sprint(7))
-	want = strings.ReplaceAll(want, "-host-", "")
+	assert.Contains(t, mail.HTML, "subject!")
+	assert.Contains(t, mail.HTML, "announcement!<br />&lt;script&gt;alert(1)&lt;/script&gt;")
+	assert.NotContains(t, mail.HTML, "<script>")
+	assert.NotContains(t, mail.HTML, "<b>user</b>")
 
-	assert.Equal(t, body, want)
+	assert.Contains(t, mail.Text, "announcement!\n<script>alert(1)</script>")
 }
 
-func TestNotifyBalanceMailContent(t *testing.T) {
-	subject, body := NotifyAdminsMailLowBalanceContent(200, "")
-	assert.Equal(t, subject, "Your account balance is low")
-
-	want := string(balanceMail)
-	want = strings.ReplaceAll(want, "-balance-", fmt.Sprint(200))
-	want = strings.ReplaceAll(want, "-host-", "")
+func TestAdminMailContent(t *testing.T) {
+	mail, err := AdminMailContent("", AnnouncementMailData{Name: "user", Subject: "subject!", Body: "email!"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Hey! 📢 subject!", mail.Subject)
+	assert.Contains(t, mail.HTML, "Dear User,")
+	assert.Contains(t, mail.HTML, "email!")
+}
 
-	assert.Equal(t, body, want)
+func TestTeamInvitationMailContent(t *testing.T) {
+	mail, err := TeamInvitationMailContent("", TeamInvitationMailData{Owner: "owner", Team: "project"})
+	assert.NoError(t, err)
+	assert.Equal(t, "You are invited to join project 🤝", mail.Subject)
+	assert.Contains(t, mail.HTML, "Owner invited you to join the team project")
+	assert.Contains(t, mail.Text, "Owner invited you to join the team project")
 }
 
-func TestAdminAnnouncementMailContent(t *testing.T) {
-	subject, body := AdminAnnouncementMailContent("subject!", "announcement!", "", "")
-	assert.Equal(t, subject, "New Announcement! 📢 subject!")
-	want := string(adminAnnouncement)
-	want = strings.ReplaceAll(want, "-subject-", "subject!")
-	want = strings.ReplaceAll(want, "-announcement-", "announcement!")
-	want = strings.ReplaceAll(want, "-host-", "")
-	want = strings.ReplaceAll(want, "-name-", "")
-	assert.Equal(t, body, want)
+func TestPreviewMail(t *testing.T) {
+	for name := range mailSamples {
+		mail, err := PreviewMail(name, "host")
+		assert.NoError(t, err, name)
+		assert.NotEmpty(t, mail.Subject, name)
+		assert.True(t, strings.HasPrefix(mail.HTML, "<!DOCTYPE html>"), name)
+		assert.Contains(t, mail.Text, "Codescalers team", name)
+	}
+
+	_, err := PreviewMail("unknown", "host")
+	assert.Equal(t, ErrMailTemplateNotFound, err)
 }
@@ -1,6 +1,10 @@
 package internal
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
 
 func TestGenerateRandomVoucher(t *testing.T) {
 	voucher := GenerateRandomVoucher(10)
@@ -10,8 +14,16 @@ func TestGenerateRandomVoucher(t *testing.T) {
 }
 
 func TestGenerateRandomCode(t *testing.T) {
-	code := GenerateRandomCode()
-	if code < 1000 || code > 9999 {
-		t.Errorf("Expected code to be between 1000 and 9999, got %d", code)
+	code, err := GenerateRandomCode()
+	assert.NoError(t, err)
+	if code < 100000 || code > 999999 {
+		t.Errorf("Expected code to be between 100000 and 999999, got %d", code)
 	}
 }
+
+func TestHashCode(t *testing.T) {
+	hashed := HashCode("secret", 123456)
+	assert.True(t, VerifyCode("secret", hashed, 123456))
+	assert.False(t, VerifyCode("secret", hashed, 123457))
+	assert.False(t, VerifyCode("other secret", hashed, 123456))
+}
@@ -2,7 +2,12 @@
 package internal
 
 import (
-	"math/rand"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+	mathRand "math/rand"
 )
 
 const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
@@ -11,14 +16,33 @@ const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 func GenerateRandomVoucher(n int) string {
 	b := make([]byte, n)
 	for i := range b {
-		b[i] = letterBytes[rand.Intn(len(letterBytes))]
+		b[i] = letterBytes[mathRand.Intn(len(letterBytes))]
 	}
 	return string(b)
 }
 
-// GenerateRandomCode generates random code of 4 digits
-func GenerateRandomCode() int {
-	min := 1000
-	max := 9999
-	return rand.Intn(max-min) + min
+const (
+	minCode = 100000
+	maxCode = 999999
+)
+
+// GenerateRandomCode generates a crypto random code of 6 digits
+func GenerateRandomCode() (int, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(maxCode-minCode+1))
+	if err != nil {
+		return 0, err
+	}
+	return int(n.Int64()) + minCode, nil
+}
+
+// HashCode hashes a verification code with a secret so leaked hashes can't be checked against all codes
+func HashCode(secret string, code int) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(fmt.Sprint(code)))
+	return mac.Sum(nil)
+}
+
+// VerifyCode checks if given code is same as hashed one
+func VerifyCode(secret string, hashedCode []byte, code int) bool {
+	return hmac.Equal(HashCode(secret, code), hashedCode)
 }
@@ -5,29 +5,139 @@ import (
 	"bytes"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
 	"io"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
 )
 
-const saltLen = 16
+const (
+	saltLen = 16
+
+	// argon2id parameters, the second recommended option of RFC 9106
+	argon2Prefix  = "$argon2id$"
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+	argon2KeyLen  = 32
+)
+
+// legacyHashLen is the length of legacy hashes, a salt followed by its salted sha256 of the password
+const legacyHashLen = saltLen + sha256.Size
+
+var errInvalidHash = errors.New("invalid password hash")
 
-// HashAndSaltPassword hashes password of user
+// argon2Hash is a decoded argon2id hash
+type argon2Hash struct {
+	version int
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+// HashAndSaltPassword hashes password of user with argon2id.
+// The hash is encoded as $argon2id$v=19$m=65536,t=3,p=4$<salt>$<key> so its parameters can change later.
 func HashAndSaltPassword(password []byte) ([]byte, error) {
 	salt := make([]byte, saltLen)
 	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
 		return []byte{}, err
 	}
 
-	hashedPassword := sha256.Sum256(append(salt, password...))
-	return append(salt, hashedPassword[:]...), nil
+	h := argon2Hash{
+		version: argon2.Version,
+		memory:  argon2Memory,
+		time:    argon2Time,
+		threads: argon2Threads,
+		salt:    salt,
+	}
+	h.key = argon2.IDKey(password, h.salt, h.time, h.memory, h.threads, argon2KeyLen)
+
+	return []byte(h.String()), nil
 }
 
-// VerifyPassword checks if given password is same as hashed one
+// VerifyPassword checks if given password is same as hashed one, hashed in the current or legacy format
 func VerifyPassword(hashedPassword []byte, password string) bool {
-	hashedPasswordCopy := make([]byte, len(hashedPassword))
+	if !bytes.HasPrefix(hashedPassword, []byte(argon2Prefix)) {
+		return verifyLegacyPassword(hashedPassword, password)
+	}
+
+	h, err := decodeArgon2Hash(string(hashedPassword))
+	if err != nil {
+		return false
+	}
+
+	key := argon2.IDKey([]byte(password), h.salt, h.time, h.memory, h.threads, uint32(len(h.key)))
+	return subtle.ConstantTimeCompare(key, h.key) == 1
+}
+
+// PasswordNeedsRehash checks if the hashed password uses the legacy format or outdated parameters
+func PasswordNeedsRehash(hashedPassword []byte) bool {
+	h, err := decodeArgon2Hash(string(hashedPassword))
+	if err != nil {
+		return true
+	}
+
+	return h.version != argon2.Version || h.memory != argon2Memory || h.time != argon2Time ||
+		h.threads != argon2Threads || len(h.key) != argon2KeyLen
+}
 
-	copy(hashedPasswordCopy, hashedPassword)
-	salt := hashedPasswordCopy[:saltLen]
+// verifyLegacyPassword checks the password against a single round of salted sha256
+func verifyLegacyPassword(hashedPassword []byte, password string) bool {
+	if len(hashedPassword) != legacyHashLen {
+		return false
+	}
+
+	salt := make([]byte, saltLen)
+	copy(salt, hashedPassword[:saltLen])
 
 	checkedPass := sha256.Sum256(append(salt, []byte(password)...))
-	return bytes.Equal(append(salt, checkedPass[:]...), hashedPassword)
+	return subtle.ConstantTimeCompare(checkedPass[:], hashedPassword[saltLen:]) == 1
+}
+
+func (h argon2Hash) String() string {
+	return fmt.Sprintf(
+		"%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2Prefix, h.version, h.memory, h.time, h.threads,
+		base64.RawStdEncoding.EncodeToString(h.salt),
+		base64.RawStdEncoding.EncodeToString(h.key),
+	)
+}
+
+func decodeArgon2Hash(encoded string) (argon2Hash, error) {
+	var h argon2Hash
+	if !strings.HasPrefix(encoded, argon2Prefix) {
+		return h, errInvalidHash
+	}
+
+	// version, parameters, salt and key
+	parts := strings.Split(strings.TrimPrefix(encoded, argon2Prefix), "$")
+	if len(parts) != 4 {
+		return h, errInvalidHash
+	}
+
+	if _, err := fmt.Sscanf(parts[0], "v=%d", &h.version); err != nil {
+		return h, errInvalidHash
+	}
+	if _, err := fmt.Sscanf(parts[1], "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.threads); err != nil {
+		return h, errInvalidHash
+	}
+	if h.time == 0 || h.threads == 0 {
+		return h, errInvalidHash
+	}
+
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[2]); err != nil {
+		return h, errInvalidHash
+	}
+	if h.key, err = base64.RawStdEncoding.DecodeString(parts[3]); err != nil || len(h.key) == 0 {
+		return h, errInvalidHash
+	}
+
+	return h, nil
 }
@@ -1,6 +1,8 @@
 package internal
 
 import (
+	"crypto/sha256"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -11,9 +13,11 @@ func TestHashPassword(t *testing.T) {
 		password := "strongPassword1234"
 		hashed, err := HashAndSaltPassword([]byte(password))
 		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(string(hashed), "$argon2id$v=19$m=65536,t=3,p=4$"))
 
 		valid := VerifyPassword(hashed, password)
 		assert.True(t, valid)
+		assert.False(t, PasswordNeedsRehash(hashed))
 	})
 
 	t.Run("check password is not correct", func(t *testing.T) {
@@ -29,4 +33,32 @@ func TestHashPassword(t *testing.T) {
 
 	})
 
+	t.Run("check legacy password", func(t *testing.T) {
+		salt := []byte("saltsaltsaltsalt")
+		sum := sha256.Sum256(append(salt, []byte("password1234")...))
+		hashed := append(salt, sum[:]...)
+
+		assert.True(t, VerifyPassword(hashed, "password1234"))
+		assert.False(t, VerifyPassword(hashed, "password2345"))
+		assert.True(t, PasswordNeedsRehash(hashed))
+	})
+
+	t.Run("check outdated parameters", func(t *testing.T) {
+		h := argon2Hash{version: 19, memory: 1024, time: 1, threads: 1, salt: []byte("saltsaltsaltsalt")}
+		h.key = []byte("0123456789abcdef0123456789abcdef")
+
+		assert.True(t, PasswordNeedsRehash([]byte(h.String())))
+	})
+
+	t.Run("check invalid hashes", func(t *testing.T) {
+		for _, hashed := range []string{
+			"",
+			"short",
+			"$argon2id$v=19$m=65536,t=3,p=4$c2FsdA",
+			"$argon2id$v=19$m=65536,t=0,p=4$c2FsdA$a2V5",
+			"$argon2id$v=19$m=65536,t=3,p=4$!!$a2V5",
+		} {
+			assert.False(t, VerifyPassword([]byte(hashed), "password"), hashed)
+		}
+	})
 }
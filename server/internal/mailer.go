@@ -0,0 +1,304 @@
+// Package internal for internal details
+package internal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/codescalers/cloud4students/validators"
+	"github.com/rs/zerolog/log"
+	"github.com/sendgrid/sendgrid-go"
+	sgmail "github.com/sendgrid/sendgrid-go/helpers/mail"
+)
+
+const (
+	// SendGridProvider sends mails using sendgrid api
+	SendGridProvider = "sendgrid"
+	// SMTPProvider sends mails using an smtp server
+	SMTPProvider = "smtp"
+	// FileProvider writes mails as .eml files to a directory instead of sending them
+	FileProvider = "file"
+	// LogProvider logs mails instead of sending them
+	LogProvider = "log"
+)
+
+const (
+	senderName   = "Cloud4Students"
+	receiverName = "Cloud4Students User"
+	smtpTimeout  = 30 * time.Second
+)
+
+// Mailer sends mails to users
+type Mailer interface {
+	Send(receiver string, mail Mail) error
+}
+
+// NewMailer creates the mailer of the configured provider
+func NewMailer(config MailSender) (Mailer, error) {
+	switch config.Provider {
+	case "", SendGridProvider:
+		return NewSendGridMailer(config.Email, config.SendGridKey), nil
+	case SMTPProvider:
+		return NewSMTPMailer(config.Email, config.SMTP), nil
+	case FileProvider:
+		return NewFileMailer(config.Email, config.Dir), nil
+	case LogProvider:
+		return NewLogMailer(config.Email), nil
+	default:
+		return nil, fmt.Errorf("mail provider '%s' is not supported", config.Provider)
+	}
+}
+
+// SendGridMailer sends mails using sendgrid api
+type SendGridMailer struct {
+	sender string
+	key    string
+}
+
+// NewSendGridMailer creates a new sendgrid mailer
+func NewSendGridMailer(sender, key string) *SendGridMailer {
+	return &SendGridMailer{sender: sender, key: key}
+}
+
+// Send sends a mail using sendgrid
+func (m *SendGridMailer) Send(receiver string, mail Mail) error {
+	if err := validators.ValidMail(receiver); err != nil {
+		return fmt.Errorf("email %v is not valid", receiver)
+	}
+
+	from := sgmail.NewEmail(senderName, m.sender)
+	to := sgmail.NewEmail(receiverName, receiver)
+
+	message := sgmail.NewSingleEmail(from, mail.Subject, to, mail.Text, mail.HTML)
+	client := sendgrid.NewSendClient(m.key)
+	res, err := client.Send(message)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("sendgrid failed to send mail with status %d: %s", res.StatusCode, res.Body)
+	}
+	return nil
+}
+
+// SMTPMailer sends mails using an smtp server
+type SMTPMailer struct {
+	sender string
+	config SMTP
+}
+
+// NewSMTPMailer creates a new smtp mailer
+func NewSMTPMailer(sender string, config SMTP) *SMTPMailer {
+	return &SMTPMailer{sender: sender, config: config}
+}
+
+// Send sends a mail using the smtp server
+func (m *SMTPMailer) Send(receiver string, mail Mail) error {
+	msg, err := buildMessage(m.sender, receiver, mail)
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(m.config.Host, fmt.Sprint(m.config.Port))
+	conn, err := net.DialTimeout("tcp", addr, smtpTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to connect to smtp server %s: %w", addr, err)
+	}
+	if err := conn.SetDeadline(time.Now().Add(smtpTimeout)); err != nil {
+		conn.Close()
+		return err
+	}
+
+	client, err := smtp.NewClient(conn, m.config.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if m.config.StartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("smtp server %s doesn't support STARTTLS", addr)
+		}
+		if err := client.StartTLS(&tls.Config{ServerName: m.config.Host}); err != nil {
+			return fmt.Errorf("failed to start tls: %w", err)
+		}
+	}
+
+	if m.config.Username != "" {
+		// plain auth refuses to send the password without tls unless the server is on localhost
+		if err := client.Auth(smtp.PlainAuth("", m.config.Username, m.config.Password, m.config.Host)); err != nil {
+			return fmt.Errorf("failed to authenticate to smtp server: %w", err)
+		}
+	}
+
+	if err := client.Mail(m.sender); err != nil {
+		return err
+	}
+	if err := client.Rcpt(receiver); err != nil {
+		return err
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+// FileMailer writes mails as .eml files to a directory instead of sending them, for development and tests
+type FileMailer struct {
+	sender string
+	dir    string
+}
+
+// NewFileMailer creates a new file mailer
+func NewFileMailer(sender, dir string) *FileMailer {
+	return &FileMailer{sender: sender, dir: dir}
+}
+
+// Send writes the mail to a new .eml file
+func (m *FileMailer) Send(receiver string, mail Mail) error {
+	msg, err := buildMessage(m.sender, receiver, mail)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(m.dir, fmt.Sprintf("%s-*.eml", time.Now().UTC().Format("20060102T150405")))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(msg)
+	return err
+}
+
+// LogMailer logs mails instead of sending them
+type LogMailer struct {
+	sender string
+}
+
+// NewLogMailer creates a new log mailer
+func NewLogMailer(sender string) *LogMailer {
+	return &LogMailer{sender: sender}
+}
+
+// Send logs the mail
+func (m *LogMailer) Send(receiver string, mail Mail) error {
+	if err := validators.ValidMail(receiver); err != nil {
+		return fmt.Errorf("email %v is not valid", receiver)
+	}
+
+	log.Info().Str("from", m.sender).Str("to", receiver).Str("subject", mail.Subject).Msg("mail is logged instead of being sent")
+	log.Debug().Str("to", receiver).Msg(mail.Text)
+	return nil
+}
+
+// buildMessage builds a mail message with the html body and its plain text alternative if it has one
+func buildMessage(sender, receiver string, mail Mail) ([]byte, error) {
+	if err := validators.ValidMail(receiver); err != nil {
+		return nil, fmt.Errorf("email %v is not valid", receiver)
+	}
+
+	if strings.ContainsAny(mail.Subject, "\r\n") {
+		return nil, errors.New("mail subject can't have new lines")
+	}
+
+	from := netmail.Address{Name: senderName, Address: sender}
+	to := netmail.Address{Name: receiverName, Address: receiver}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from.String())
+	fmt.Fprintf(&msg, "To: %s\r\n", to.String())
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", mail.Subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Message-ID: %s\r\n", messageID(sender))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+
+	if mail.Text == "" {
+		if err := writePart(&msg, "text/html", mail.HTML); err != nil {
+			return nil, err
+		}
+		return msg.Bytes(), nil
+	}
+
+	w := multipart.NewWriter(&msg)
+	fmt.Fprintf(&msg, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", w.Boundary())
+
+	// the last part is the preferred one
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain", mail.Text},
+		{"text/html", mail.HTML},
+	} {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", part.contentType+"; charset=UTF-8")
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+
+		pw, err := w.CreatePart(header)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(pw, part.body); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return msg.Bytes(), nil
+}
+
+// writePart writes the headers and body of a single part message
+func writePart(msg *bytes.Buffer, contentType, body string) error {
+	fmt.Fprintf(msg, "Content-Type: %s; charset=UTF-8\r\n", contentType)
+	msg.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	return writeQuotedPrintable(msg, body)
+}
+
+func writeQuotedPrintable(w io.Writer, body string) error {
+	qw := quotedprintable.NewWriter(w)
+	if _, err := qw.Write([]byte(body)); err != nil {
+		return err
+	}
+	return qw.Close()
+}
+
+func messageID(sender string) string {
+	domain := "localhost"
+	if at := strings.LastIndex(sender, "@"); at != -1 {
+		domain = sender[at+1:]
+	}
+
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(id), domain)
+}
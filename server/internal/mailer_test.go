@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSMTPServer accepts one mail without tls or auth and sends the received commands and data to the returned channel
+func fakeSMTPServer(t *testing.T) (int, chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var session strings.Builder
+		r := bufio.NewReader(conn)
+		fmt.Fprint(conn, "220 localhost ESMTP\r\n")
+
+		data := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			session.WriteString(line)
+
+			switch {
+			case data && line == ".\r\n":
+				data = false
+				fmt.Fprint(conn, "250 OK\r\n")
+			case data:
+			case strings.HasPrefix(line, "EHLO"):
+				fmt.Fprint(conn, "250 localhost\r\n")
+			case strings.HasPrefix(line, "DATA"):
+				data = true
+				fmt.Fprint(conn, "354 send data\r\n")
+			case strings.HasPrefix(line, "QUIT"):
+				fmt.Fprint(conn, "221 bye\r\n")
+				received <- session.String()
+				return
+			default:
+				fmt.Fprint(conn, "250 OK\r\n")
+			}
+		}
+	}()
+
+	return listener.Addr().(*net.TCPAddr).Port, received
+}
+
+func TestSMTPMailer(t *testing.T) {
+	t.Run("send mail", func(t *testing.T) {
+		port, received := fakeSMTPServer(t)
+		mailer := NewSMTPMailer("sender@gmail.com", SMTP{Host: "127.0.0.1", Port: port})
+
+		err := mailer.Send("receiver@gmail.com", Mail{Subject: "subject", HTML: "body"})
+		assert.NoError(t, err)
+
+		session := <-received
+		assert.Contains(t, session, "MAIL FROM:<sender@gmail.com>")
+		assert.Contains(t, session, "RCPT TO:<receiver@gmail.com>")
+		assert.Contains(t, session, "Subject: subject")
+	})
+
+	t.Run("starttls is required", func(t *testing.T) {
+		port, _ := fakeSMTPServer(t)
+		mailer := NewSMTPMailer("sender@gmail.com", SMTP{Host: "127.0.0.1", Port: port, StartTLS: true})
+
+		err := mailer.Send("receiver@gmail.com", Mail{Subject: "subject", HTML: "body"})
+		assert.ErrorContains(t, err, "doesn't support STARTTLS")
+	})
+
+	t.Run("invalid mail", func(t *testing.T) {
+		mailer := NewSMTPMailer("sender@gmail.com", SMTP{Host: "127.0.0.1", Port: 1})
+
+		err := mailer.Send("receiver", Mail{Subject: "subject", HTML: "body"})
+		assert.Error(t, err)
+	})
+}
+
+func TestBuildMessage(t *testing.T) {
+	t.Run("html only", func(t *testing.T) {
+		msg, err := buildMessage("sender@gmail.com", "receiver@gmail.com", Mail{Subject: "subject", HTML: "<p>code 123456</p>"})
+		assert.NoError(t, err)
+		assert.Contains(t, string(msg), "Content-Type: text/html")
+		assert.Contains(t, string(msg), "<p>code 123456</p>")
+		assert.NotContains(t, string(msg), "multipart/alternative")
+	})
+
+	t.Run("html with text alternative", func(t *testing.T) {
+		msg, err := buildMessage("sender@gmail.com", "receiver@gmail.com", Mail{Subject: "subject", HTML: "<p>code 123456</p>", Text: "code 123456"})
+		assert.NoError(t, err)
+		assert.Contains(t, string(msg), "multipart/alternative")
+		assert.Contains(t, string(msg), "Content-Type: text/plain")
+		assert.Contains(t, string(msg), "<p>code 123456</p>")
+	})
+}
+
+func TestNewMailer(t *testing.T) {
+	mailer, err := NewMailer(MailSender{Email: "sender@gmail.com", Provider: LogProvider})
+	assert.NoError(t, err)
+	assert.IsType(t, &LogMailer{}, mailer)
+	assert.NoError(t, mailer.Send("receiver@gmail.com", Mail{Subject: "subject", HTML: "body"}))
+
+	mailer, err = NewMailer(MailSender{Email: "sender@gmail.com", Dir: t.TempDir(), Provider: FileProvider})
+	assert.NoError(t, err)
+	assert.IsType(t, &FileMailer{}, mailer)
+
+	mailer, err = NewMailer(MailSender{Email: "sender@gmail.com", SendGridKey: "key"})
+	assert.NoError(t, err)
+	assert.IsType(t, &SendGridMailer{}, mailer)
+
+	_, err = NewMailer(MailSender{Email: "sender@gmail.com", Provider: "pigeon"})
+	assert.Error(t, err)
+}
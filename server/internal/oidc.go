@@ -0,0 +1,357 @@
+// Package internal for internal details
+package internal
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// oidcHTTPTimeout is the timeout of requests to providers
+const oidcHTTPTimeout = 10 * time.Second
+
+// ErrOIDCEmailNotVerified is returned if the provider didn't verify the email of the user
+var ErrOIDCEmailNotVerified = errors.New("email is not verified by the provider")
+
+// OIDCClaims are the claims of the id token of a user
+type OIDCClaims struct {
+	Subject string
+	Email   string
+	Name    string
+	// College is the claim configured as the college, the college of the provider if not set
+	College string
+}
+
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// OIDCClient signs users in with an OpenID Connect provider using the authorization code flow with PKCE
+type OIDCClient struct {
+	provider OIDCProvider
+	client   *http.Client
+
+	m        sync.Mutex
+	metadata *oidcMetadata
+	keys     map[string]crypto.PublicKey
+}
+
+// NewOIDCClient creates a new client of the provider
+func NewOIDCClient(provider OIDCProvider) *OIDCClient {
+	return &OIDCClient{provider: provider, client: &http.Client{Timeout: oidcHTTPTimeout}}
+}
+
+// Provider is the configuration of the provider of the client
+func (c *OIDCClient) Provider() OIDCProvider {
+	return c.provider
+}
+
+// NewPKCE generates a code verifier and its S256 challenge
+func NewPKCE() (string, string, error) {
+	verifier, err := GenerateOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	hash := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(hash[:]), nil
+}
+
+// AuthCodeURL is the url of the provider the user signs in at
+func (c *OIDCClient) AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+	metadata, err := c.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	authURL, err := url.Parse(metadata.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid authorization endpoint: %w", err)
+	}
+
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", c.provider.ClientID)
+	query.Set("redirect_uri", c.provider.RedirectURL)
+	query.Set("scope", strings.Join(c.provider.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", codeChallenge)
+	query.Set("code_challenge_method", "S256")
+	authURL.RawQuery = query.Encode()
+
+	return authURL.String(), nil
+}
+
+// Exchange exchanges the code the provider redirected with for an id token and verifies it
+func (c *OIDCClient) Exchange(ctx context.Context, code, codeVerifier, nonce string) (OIDCClaims, error) {
+	metadata, err := c.discover(ctx)
+	if err != nil {
+		return OIDCClaims{}, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", c.provider.RedirectURL)
+	form.Set("client_id", c.provider.ClientID)
+	form.Set("code_verifier", codeVerifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return OIDCClaims{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.provider.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(c.provider.ClientID), url.QueryEscape(c.provider.ClientSecret))
+	}
+
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := c.do(req, &token); err != nil {
+		return OIDCClaims{}, fmt.Errorf("failed to exchange code: %w", err)
+	}
+	if token.IDToken == "" {
+		return OIDCClaims{}, errors.New("provider didn't return an id token")
+	}
+
+	return c.verify(ctx, token.IDToken, nonce)
+}
+
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce         string      `json:"nonce"`
+	Email         string      `json:"email"`
+	EmailVerified interface{} `json:"email_verified"`
+	Name          string      `json:"name"`
+}
+
+// verify checks the signature, issuer, audience, expiry and nonce of an id token
+func (c *OIDCClient) verify(ctx context.Context, idToken, nonce string) (OIDCClaims, error) {
+	metadata, err := c.discover(ctx)
+	if err != nil {
+		return OIDCClaims{}, err
+	}
+
+	claims := idTokenClaims{}
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}))
+	_, err = parser.ParseWithClaims(idToken, &claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return c.key(ctx, kid)
+	})
+	if err != nil {
+		return OIDCClaims{}, fmt.Errorf("invalid id token: %w", err)
+	}
+
+	if claims.Issuer != metadata.Issuer {
+		return OIDCClaims{}, fmt.Errorf("id token is issued by '%s' not '%s'", claims.Issuer, metadata.Issuer)
+	}
+	if !claims.VerifyAudience(c.provider.ClientID, true) {
+		return OIDCClaims{}, errors.New("id token is not issued for the client")
+	}
+	if claims.ExpiresAt == nil {
+		return OIDCClaims{}, errors.New("id token has no expiry")
+	}
+	if claims.Nonce != nonce {
+		return OIDCClaims{}, errors.New("id token nonce doesn't match")
+	}
+	if claims.Subject == "" || claims.Email == "" {
+		return OIDCClaims{}, errors.New("id token has no subject or email")
+	}
+
+	// some providers send email_verified as a string
+	verified := claims.EmailVerified == true || claims.EmailVerified == "true"
+	if !verified {
+		return OIDCClaims{}, ErrOIDCEmailNotVerified
+	}
+
+	result := OIDCClaims{
+		Subject: claims.Subject,
+		Email:   claims.Email,
+		Name:    claims.Name,
+		College: c.provider.College,
+	}
+
+	// the token is verified already, it is parsed again for the claim of the college
+	if c.provider.CollegeClaim != "" {
+		mapClaims := jwt.MapClaims{}
+		if _, _, err := parser.ParseUnverified(idToken, mapClaims); err == nil {
+			if college, ok := mapClaims[c.provider.CollegeClaim].(string); ok && college != "" {
+				result.College = college
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// discover fetches the metadata of the provider once
+func (c *OIDCClient) discover(ctx context.Context) (*oidcMetadata, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.metadata != nil {
+		return c.metadata, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(c.provider.Issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var metadata oidcMetadata
+	if err := c.do(req, &metadata); err != nil {
+		return nil, fmt.Errorf("failed to discover provider '%s': %w", c.provider.Name, err)
+	}
+	if metadata.Issuer != c.provider.Issuer {
+		return nil, fmt.Errorf("provider issuer '%s' doesn't match the configured issuer '%s'", metadata.Issuer, c.provider.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, fmt.Errorf("provider '%s' metadata is missing endpoints", c.provider.Name)
+	}
+
+	c.metadata = &metadata
+	return c.metadata, nil
+}
+
+// key gets a signing key of the provider, keys are fetched again for unknown key ids as providers rotate them
+func (c *OIDCClient) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	c.m.Lock()
+	key, ok := c.keys[kid]
+	jwksURI := c.metadata.JWKSURI
+	c.m.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, jwksURI, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := c.do(req, &jwks); err != nil {
+		return nil, fmt.Errorf("failed to get provider keys: %w", err)
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		publicKey, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = publicKey
+	}
+
+	c.m.Lock()
+	c.keys = keys
+	c.m.Unlock()
+
+	key, ok = keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("signing key '%s' is not found", kid)
+	}
+	return key, nil
+}
+
+// do sends the request and decodes the json response
+func (c *OIDCClient) do(req *http.Request, v interface{}) error {
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d: %s", res.StatusCode, string(body))
+	}
+	return json.Unmarshal(body, v)
+}
+
+// jsonWebKey is a public key of a jwks as in rfc 7517
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve '%s'", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type '%s'", k.Kty)
+	}
+}
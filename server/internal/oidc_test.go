@@ -0,0 +1,108 @@
+// Package internal for internal details
+package internal
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/codescalers/cloud4students/internal/oidctest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOIDCClient(t *testing.T) {
+	issuer, err := oidctest.NewIssuer()
+	assert.NoError(t, err)
+	defer issuer.Close()
+
+	client := NewOIDCClient(OIDCProvider{
+		Name:         "test",
+		Issuer:       issuer.URL,
+		ClientID:     "client",
+		RedirectURL:  "http://localhost/oidc/test",
+		College:      "university",
+		CollegeClaim: "organization",
+		Scopes:       defaultOIDCScopes,
+	})
+	ctx := context.Background()
+
+	signIn := func(t *testing.T, claims map[string]interface{}, nonce string) (string, string) {
+		verifier, challenge, err := NewPKCE()
+		assert.NoError(t, err)
+
+		authURL, err := client.AuthCodeURL(ctx, "state", nonce, challenge)
+		assert.NoError(t, err)
+
+		u, err := url.Parse(authURL)
+		assert.NoError(t, err)
+		assert.Equal(t, "openid email profile", u.Query().Get("scope"))
+		assert.Equal(t, "http://localhost/oidc/test", u.Query().Get("redirect_uri"))
+
+		code, state, err := issuer.Authorize(authURL, claims)
+		assert.NoError(t, err)
+		assert.Equal(t, "state", state)
+		return code, verifier
+	}
+
+	t.Run("exchange code", func(t *testing.T) {
+		code, verifier := signIn(t, map[string]interface{}{
+			"sub": "1", "email": "name@gmail.com", "email_verified": true, "name": "name",
+		}, "nonce")
+
+		claims, err := client.Exchange(ctx, code, verifier, "nonce")
+		assert.NoError(t, err)
+		assert.Equal(t, OIDCClaims{Subject: "1", Email: "name@gmail.com", Name: "name", College: "university"}, claims)
+
+		// codes are used once
+		_, err = client.Exchange(ctx, code, verifier, "nonce")
+		assert.Error(t, err)
+	})
+
+	t.Run("college claim", func(t *testing.T) {
+		code, verifier := signIn(t, map[string]interface{}{
+			"sub": "1", "email": "name@gmail.com", "email_verified": "true", "organization": "college",
+		}, "nonce")
+
+		claims, err := client.Exchange(ctx, code, verifier, "nonce")
+		assert.NoError(t, err)
+		assert.Equal(t, "college", claims.College)
+	})
+
+	t.Run("wrong code verifier", func(t *testing.T) {
+		code, _ := signIn(t, map[string]interface{}{"sub": "1", "email": "name@gmail.com", "email_verified": true}, "nonce")
+
+		verifier, _, err := NewPKCE()
+		assert.NoError(t, err)
+		_, err = client.Exchange(ctx, code, verifier, "nonce")
+		assert.Error(t, err)
+	})
+
+	t.Run("wrong nonce", func(t *testing.T) {
+		code, verifier := signIn(t, map[string]interface{}{"sub": "1", "email": "name@gmail.com", "email_verified": true}, "nonce")
+
+		_, err := client.Exchange(ctx, code, verifier, "other")
+		assert.Error(t, err)
+	})
+
+	t.Run("wrong audience", func(t *testing.T) {
+		code, verifier := signIn(t, map[string]interface{}{
+			"sub": "1", "email": "name@gmail.com", "email_verified": true, "aud": "other",
+		}, "nonce")
+
+		_, err := client.Exchange(ctx, code, verifier, "nonce")
+		assert.Error(t, err)
+	})
+
+	t.Run("email not verified", func(t *testing.T) {
+		code, verifier := signIn(t, map[string]interface{}{"sub": "1", "email": "name@gmail.com"}, "nonce")
+
+		_, err := client.Exchange(ctx, code, verifier, "nonce")
+		assert.ErrorIs(t, err, ErrOIDCEmailNotVerified)
+	})
+
+	t.Run("wrong issuer", func(t *testing.T) {
+		client := NewOIDCClient(OIDCProvider{Name: "test", Issuer: issuer.URL + "/other"})
+		_, err := client.AuthCodeURL(ctx, "state", "nonce", "challenge")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,134 @@
+// Package internal for internal details
+package internal
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/codescalers/cloud4students/models"
+	"github.com/codescalers/cloud4students/validators"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	outboxPollInterval = 5 * time.Second
+	outboxBatchSize    = 50
+	// emailClaimTimeout is how long a claimed email is left to its worker before others can send it
+	emailClaimTimeout = 5 * time.Minute
+)
+
+// Outbox queues emails in the database and delivers them in the background with retries
+type Outbox struct {
+	db     models.Store
+	mailer Mailer
+	config EmailOutbox
+}
+
+// NewOutbox creates a new email outbox
+func NewOutbox(db models.Store, mailer Mailer, config EmailOutbox) *Outbox {
+	return &Outbox{db: db, mailer: mailer, config: config}
+}
+
+// NewEmail creates a queued email
+func NewEmail(receiver string, mail Mail) models.Email {
+	return models.Email{
+		Receiver:      receiver,
+		Subject:       mail.Subject,
+		Body:          mail.HTML,
+		Text:          mail.Text,
+		Sensitive:     mail.Sensitive,
+		State:         models.EmailQueued,
+		NextAttemptAt: time.Now(),
+	}
+}
+
+// Enqueue adds an email to the outbox
+func (o *Outbox) Enqueue(receiver string, mail Mail) error {
+	return o.EnqueueAll([]models.Email{NewEmail(receiver, mail)})
+}
+
+// EnqueueAll adds emails to the outbox at once
+func (o *Outbox) EnqueueAll(emails []models.Email) error {
+	for _, email := range emails {
+		if err := validators.ValidMail(email.Receiver); err != nil {
+			return fmt.Errorf("email %v is not valid", email.Receiver)
+		}
+	}
+
+	return o.db.CreateEmails(emails)
+}
+
+// Run delivers due emails until ctx is done, emails are sent at most at the configured rate
+func (o *Outbox) Run(ctx context.Context) {
+	limiter := time.NewTicker(time.Minute / time.Duration(o.config.RatePerMinute))
+	defer limiter.Stop()
+
+	for ctx.Err() == nil {
+		delivered, err := o.deliverDue(ctx, limiter.C)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to deliver emails")
+		}
+
+		// wait for new emails if all due emails are delivered
+		if delivered < outboxBatchSize {
+			select {
+			case <-ctx.Done():
+			case <-time.After(outboxPollInterval):
+			}
+		}
+	}
+}
+
+// deliverDue delivers a batch of due emails, it returns how many it tried to deliver
+func (o *Outbox) deliverDue(ctx context.Context, limiter <-chan time.Time) (int, error) {
+	emails, err := o.db.DueEmails(outboxBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, email := range emails {
+		select {
+		case <-ctx.Done():
+			return i, nil
+		case <-limiter:
+		}
+
+		o.deliver(email)
+	}
+
+	return len(emails), nil
+}
+
+func (o *Outbox) deliver(email models.Email) {
+	claimed, err := o.db.ClaimEmail(email.ID, time.Now().Add(emailClaimTimeout))
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to claim email %d", email.ID)
+		return
+	}
+	if !claimed {
+		return
+	}
+	attempts := email.Attempts + 1
+
+	state, emailErr, nextAttemptAt := models.EmailSent, "", time.Now()
+	if err := o.mailer.Send(email.Receiver, Mail{Subject: email.Subject, HTML: email.Body, Text: email.Text}); err != nil {
+		log.Error().Err(err).Msgf("attempt %d to send email %d failed", attempts, email.ID)
+
+		emailErr = err.Error()
+		state = models.EmailFailed
+		if attempts < o.config.MaxAttempts {
+			state = models.EmailQueued
+			nextAttemptAt = time.Now().Add(o.backoff(attempts))
+		}
+	}
+
+	if err := o.db.UpdateEmailState(email.ID, state, emailErr, nextAttemptAt); err != nil {
+		log.Error().Err(err).Msgf("failed to update state of email %d to %s", email.ID, state)
+	}
+}
+
+// backoff is the delay before the next attempt, it doubles after every failed attempt
+func (o *Outbox) backoff(attempts int) time.Duration {
+	return time.Duration(o.config.BackoffSeconds) * time.Second << (attempts - 1)
+}
@@ -0,0 +1,145 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/codescalers/cloud4students/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeMailer fails the first failures mails then records the sent ones
+type fakeMailer struct {
+	failures int
+	sent     []string
+}
+
+func (m *fakeMailer) Send(receiver string, mail Mail) error {
+	if m.failures > 0 {
+		m.failures--
+		return errors.New("mail server is down")
+	}
+	m.sent = append(m.sent, receiver)
+	return nil
+}
+
+func setupOutbox(t *testing.T, mailer Mailer) (*Outbox, models.Store) {
+	db := models.NewDB()
+	err := db.Connect(filepath.Join(t.TempDir(), "testing.db"))
+	assert.NoError(t, err)
+	err = db.Migrate()
+	assert.NoError(t, err)
+
+	return NewOutbox(db, mailer, EmailOutbox{MaxAttempts: 2, BackoffSeconds: 60, RatePerMinute: 60}), db
+}
+
+// noLimit never blocks deliveries
+func noLimit() <-chan time.Time {
+	limiter := make(chan time.Time)
+	close(limiter)
+	return limiter
+}
+
+func TestOutbox(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("enqueue invalid mail", func(t *testing.T) {
+		outbox, _ := setupOutbox(t, &fakeMailer{})
+		err := outbox.Enqueue("receiver", Mail{Subject: "subject", HTML: "body"})
+		assert.Error(t, err)
+	})
+
+	t.Run("deliver queued mails", func(t *testing.T) {
+		mailer := &fakeMailer{}
+		outbox, db := setupOutbox(t, mailer)
+
+		err := outbox.EnqueueAll([]models.Email{
+			NewEmail("first@gmail.com", Mail{Subject: "subject", HTML: "body"}),
+			NewEmail("second@gmail.com", Mail{Subject: "subject", HTML: "body"}),
+		})
+		assert.NoError(t, err)
+
+		delivered, err := outbox.deliverDue(ctx, noLimit())
+		assert.NoError(t, err)
+		assert.Equal(t, 2, delivered)
+		assert.Equal(t, []string{"first@gmail.com", "second@gmail.com"}, mailer.sent)
+
+		sent, err := db.ListEmails(models.EmailsFilter{States: []models.EmailState{models.EmailSent}})
+		assert.NoError(t, err)
+		assert.Len(t, sent, 2)
+		assert.NotNil(t, sent[0].SentAt)
+
+		delivered, err = outbox.deliverDue(ctx, noLimit())
+		assert.NoError(t, err)
+		assert.Zero(t, delivered)
+	})
+
+	t.Run("retry with backoff then fail", func(t *testing.T) {
+		mailer := &fakeMailer{failures: 2}
+		outbox, db := setupOutbox(t, mailer)
+
+		err := outbox.Enqueue("receiver@gmail.com", Mail{Subject: "subject", HTML: "body"})
+		assert.NoError(t, err)
+
+		_, err = outbox.deliverDue(ctx, noLimit())
+		assert.NoError(t, err)
+
+		email, err := db.GetEmail(1)
+		assert.NoError(t, err)
+		assert.Equal(t, models.EmailQueued, email.State)
+		assert.Equal(t, 1, email.Attempts)
+		assert.Equal(t, "mail server is down", email.Error)
+		assert.True(t, email.NextAttemptAt.After(time.Now().Add(50*time.Second)))
+
+		// the email isn't due before its backoff
+		delivered, err := outbox.deliverDue(ctx, noLimit())
+		assert.NoError(t, err)
+		assert.Zero(t, delivered)
+
+		err = db.UpdateEmailState(email.ID, models.EmailQueued, email.Error, time.Now())
+		assert.NoError(t, err)
+		_, err = outbox.deliverDue(ctx, noLimit())
+		assert.NoError(t, err)
+
+		email, err = db.GetEmail(1)
+		assert.NoError(t, err)
+		assert.Equal(t, models.EmailFailed, email.State)
+		assert.Equal(t, 2, email.Attempts)
+
+		resent, err := db.ResendEmail(email.ID)
+		assert.NoError(t, err)
+		assert.True(t, resent)
+
+		_, err = outbox.deliverDue(ctx, noLimit())
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"receiver@gmail.com"}, mailer.sent)
+	})
+
+	t.Run("claimed mail is sent once", func(t *testing.T) {
+		mailer := &fakeMailer{}
+		outbox, db := setupOutbox(t, mailer)
+
+		err := outbox.Enqueue("receiver@gmail.com", Mail{Subject: "subject", HTML: "body"})
+		assert.NoError(t, err)
+
+		due, err := db.DueEmails(10)
+		assert.NoError(t, err)
+		assert.Len(t, due, 1)
+
+		claimed, err := db.ClaimEmail(due[0].ID, time.Now().Add(time.Minute))
+		assert.NoError(t, err)
+		assert.True(t, claimed)
+
+		outbox.deliver(due[0])
+		assert.Empty(t, mailer.sent)
+	})
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	outbox := NewOutbox(nil, nil, EmailOutbox{BackoffSeconds: 30})
+	assert.Equal(t, 30*time.Second, outbox.backoff(1))
+	assert.Equal(t, 2*time.Minute, outbox.backoff(3))
+}
@@ -2,19 +2,34 @@
 package internal
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"fmt"
+	"io"
 	"time"
 
 	"github.com/codescalers/cloud4students/models"
 	"github.com/golang-jwt/jwt/v4"
 )
 
-// CreateJWT create token for user
-func CreateJWT(userID string, email string, secret string, timeout int) (string, error) {
+// opaqueTokenLen is the count of random bytes of opaque tokens
+const opaqueTokenLen = 32
+
+const (
+	// AccessTokenPrefix tells personal access tokens apart from JWTs and makes leaked tokens easy to scan for
+	AccessTokenPrefix = "c4s_"
+	// accessTokenShownLen is the length of the start of personal access tokens that is kept to tell them apart
+	accessTokenShownLen = len(AccessTokenPrefix) + 6
+)
+
+// CreateJWT create an access token of the user session
+func CreateJWT(userID string, email string, sessionID string, secret string, timeout int) (string, error) {
 	expirationTime := time.Now().Add(time.Duration(timeout) * time.Minute)
 	claims := &models.Claims{
-		UserID: userID,
-		Email:  email,
+		UserID:    userID,
+		Email:     email,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 		},
@@ -48,3 +63,30 @@ func ValidateJWTToken(token, secret string, timeout int) (models.Claims, error)
 
 	return *claims, nil
 }
+
+// GenerateOpaqueToken generates a random token such as refresh tokens
+func GenerateOpaqueToken() (string, error) {
+	b := make([]byte, opaqueTokenLen)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashOpaqueToken hashes an opaque token to be stored, tokens are random so they don't need a salt
+func HashOpaqueToken(token string) []byte {
+	hash := sha256.Sum256([]byte(token))
+	return hash[:]
+}
+
+// GenerateAccessToken generates a personal access token, it returns the token and its prefix that
+// is shown to tell tokens apart
+func GenerateAccessToken() (string, string, error) {
+	token, err := GenerateOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	token = AccessTokenPrefix + token
+	return token, token[:accessTokenShownLen], nil
+}
@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -8,8 +9,34 @@ import (
 
 func TestCreateJWT(t *testing.T) {
 	t.Run("create jwt token", func(t *testing.T) {
-		token, err := CreateJWT("1", "email@gmail.com", "secret", 60)
+		token, err := CreateJWT("1", "email@gmail.com", "session", "secret", 60)
 		assert.NoError(t, err)
 		assert.NotEmpty(t, token)
+
+		claims, err := ValidateJWTToken(token, "secret", 60)
+		assert.NoError(t, err)
+		assert.Equal(t, "1", claims.UserID)
+		assert.Equal(t, "session", claims.SessionID)
 	})
 }
+
+func TestOpaqueToken(t *testing.T) {
+	token, err := GenerateOpaqueToken()
+	assert.NoError(t, err)
+	assert.Len(t, token, 43)
+
+	other, err := GenerateOpaqueToken()
+	assert.NoError(t, err)
+	assert.NotEqual(t, token, other)
+
+	assert.Equal(t, HashOpaqueToken(token), HashOpaqueToken(token))
+	assert.NotEqual(t, HashOpaqueToken(token), HashOpaqueToken(other))
+}
+
+func TestAccessToken(t *testing.T) {
+	token, prefix, err := GenerateAccessToken()
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(token, AccessTokenPrefix))
+	assert.True(t, strings.HasPrefix(token, prefix))
+	assert.Len(t, prefix, len(AccessTokenPrefix)+6)
+}
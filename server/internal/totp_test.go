@@ -0,0 +1,88 @@
+// Package internal for internal details
+package internal
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// rfcSecret is the secret of the test vectors of rfc 6238
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+
+	for unix, want := range vectors {
+		code, err := TOTPCode(rfcSecret, TOTPStep(time.Unix(unix, 0)))
+		assert.NoError(t, err)
+		assert.Equal(t, want, code)
+	}
+
+	_, err := TOTPCode("not base32!", 1)
+	assert.Error(t, err)
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+
+	t.Run("current code", func(t *testing.T) {
+		step, ok := ValidateTOTP(rfcSecret, "081804", now)
+		assert.True(t, ok)
+		assert.Equal(t, TOTPStep(now), step)
+	})
+
+	t.Run("code of the last period", func(t *testing.T) {
+		code, err := TOTPCode(rfcSecret, TOTPStep(now)-1)
+		assert.NoError(t, err)
+
+		step, ok := ValidateTOTP(rfcSecret, code, now)
+		assert.True(t, ok)
+		assert.Equal(t, TOTPStep(now)-1, step)
+	})
+
+	t.Run("old code", func(t *testing.T) {
+		code, err := TOTPCode(rfcSecret, TOTPStep(now)-3)
+		assert.NoError(t, err)
+
+		_, ok := ValidateTOTP(rfcSecret, code, now)
+		assert.False(t, ok)
+	})
+
+	t.Run("invalid code", func(t *testing.T) {
+		_, ok := ValidateTOTP(rfcSecret, "12345", now)
+		assert.False(t, ok)
+	})
+}
+
+func TestTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	assert.NoError(t, err)
+	assert.Len(t, secret, 32)
+
+	uri, err := url.Parse(TOTPProvisioningURI("cloud4students", "name@gmail.com", secret))
+	assert.NoError(t, err)
+	assert.Equal(t, "otpauth", uri.Scheme)
+	assert.Equal(t, "totp", uri.Host)
+	assert.Equal(t, "/cloud4students:name@gmail.com", uri.Path)
+	assert.Equal(t, secret, uri.Query().Get("secret"))
+	assert.Equal(t, "cloud4students", uri.Query().Get("issuer"))
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes()
+	assert.NoError(t, err)
+	assert.Len(t, codes, RecoveryCodesCount)
+	assert.Len(t, codes[0], 11)
+	assert.NotEqual(t, codes[0], codes[1])
+
+	assert.Equal(t, HashRecoveryCode("secret", codes[0]), HashRecoveryCode("secret", " "+codes[0][:5]+codes[0][6:]))
+	assert.NotEqual(t, HashRecoveryCode("secret", codes[0]), HashRecoveryCode("other", codes[0]))
+}
@@ -2,6 +2,7 @@
 package middlewares
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 
@@ -10,8 +11,12 @@ import (
 	"gorm.io/gorm"
 )
 
-// AdminAccess to authorize admins in requests
-func AdminAccess(db models.DB) func(http.Handler) http.Handler {
+// RolesKey key of the roles of the user saved in request context by AdminAccess
+type RolesKey string
+
+// AdminAccess to authorize users with roles in requests, if requireTwoFactor is set they have to enable
+// two factor authentication first. Routes are limited to the permissions of the roles with RequirePermission
+func AdminAccess(db models.Store, requireTwoFactor bool) func(http.Handler) http.Handler {
 	return func(h http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			userID := r.Context().Value(UserIDKey("UserID")).(string)
@@ -26,10 +31,38 @@ func AdminAccess(db models.DB) func(http.Handler) http.Handler {
 				return
 			}
 
-			if !user.Admin {
+			roles, err := db.ListRolesOfUser(user)
+			if err != nil {
+				log.Error().Err(err).Send()
+				writeErrResponse(r, w, http.StatusInternalServerError, "something went wrong")
+				return
+			}
+
+			if len(roles) == 0 {
 				writeErrResponse(r, w, http.StatusUnauthorized, fmt.Sprintf("user '%s' doesn't have an admin access", user.Name))
 				return
 			}
+
+			if requireTwoFactor && !user.TOTPEnabled {
+				writeErrResponse(r, w, http.StatusForbidden, "two factor authentication is required for admins, please enable it first")
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), RolesKey("Roles"), roles)
+			h.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RequirePermission allows the request if one of the roles saved by AdminAccess has the permission
+func RequirePermission(permission models.Permission) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			roles, _ := r.Context().Value(RolesKey("Roles")).([]models.Role)
+			if !models.HasPermission(roles, permission) {
+				writeErrResponse(r, w, http.StatusForbidden, fmt.Sprintf("user doesn't have the '%s' permission", permission))
+				return
+			}
 			h.ServeHTTP(w, r)
 		})
 	}
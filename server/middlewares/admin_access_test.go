@@ -0,0 +1,34 @@
+// Package middlewares for middleware between api and backend
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/codescalers/cloud4students/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequirePermission(t *testing.T) {
+	handler := RequirePermission(models.ReviewVouchersPermission)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func(roles []models.Role) int {
+		req := httptest.NewRequest(http.MethodPut, "/", nil)
+		if roles != nil {
+			req = req.WithContext(context.WithValue(req.Context(), RolesKey("Roles"), roles))
+		}
+
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, req)
+		return response.Code
+	}
+
+	assert.Equal(t, http.StatusForbidden, serve(nil))
+	assert.Equal(t, http.StatusForbidden, serve([]models.Role{models.SupportRole, models.InstructorRole}))
+	assert.Equal(t, http.StatusOK, serve([]models.Role{models.SupportRole, models.VoucherReviewerRole}))
+	assert.Equal(t, http.StatusOK, serve([]models.Role{models.SuperAdminRole}))
+}
@@ -0,0 +1,96 @@
+// Package middlewares for middleware between api and backend
+package middlewares
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/codescalers/cloud4students/models"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+)
+
+// AuditEventKey key of the audit event of the request saved in request context by Audit
+type AuditEventKey string
+
+// statusRecorder keeps the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Audit records the requests of a route in the audit log with the action and the response status,
+// handlers add what they changed with AuditChange. The target is the url vars of the route unless
+// the handler sets it
+func Audit(db models.Store, action models.AuditAction, trustProxyHeaders bool) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, _ := r.Context().Value(UserIDKey("UserID")).(string)
+			event := &models.AuditEvent{
+				ActorID: userID,
+				Action:  action,
+				Target:  varsTarget(mux.Vars(r)),
+				IP:      ClientIP(r, trustProxyHeaders),
+			}
+			if user, err := db.GetUserByID(userID); err == nil {
+				event.ActorEmail = user.Email
+			}
+
+			recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			ctx := context.WithValue(r.Context(), AuditEventKey("AuditEvent"), event)
+			h.ServeHTTP(recorder, r.WithContext(ctx))
+
+			event.Status = recorder.status
+			if err := db.CreateAuditEvent(event); err != nil {
+				log.Error().Err(err).Str("action", string(action)).Msg("failed to record audit event")
+			}
+		})
+	}
+}
+
+// AuditChange adds the target of the action and the values before and after it to the audit event of
+// the request, an empty target keeps the target of the url vars
+func AuditChange(r *http.Request, target string, before, after interface{}) {
+	event, ok := r.Context().Value(AuditEventKey("AuditEvent")).(*models.AuditEvent)
+	if !ok {
+		return
+	}
+
+	if target != "" {
+		event.Target = target
+	}
+	event.Before = auditValue(before)
+	event.After = auditValue(after)
+}
+
+func auditValue(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to encode audit value")
+		return ""
+	}
+	return string(b)
+}
+
+// varsTarget formats url vars like `id=1 type=vms`
+func varsTarget(vars map[string]string) string {
+	pairs := make([]string, 0, len(vars))
+	for k, v := range vars {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, " ")
+}
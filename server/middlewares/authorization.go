@@ -5,17 +5,29 @@ import (
 	"context"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/codescalers/cloud4students/internal"
 	"github.com/codescalers/cloud4students/models"
+	"github.com/rs/zerolog/log"
 	"gorm.io/gorm"
 )
 
 // UserIDKey key saved in request context
 type UserIDKey string
 
+// SessionIDKey key of the session of the access token saved in request context
+type SessionIDKey string
+
+// TokenScopesKey key of the scopes of a personal access token saved in request context, it is only
+// set for requests authorized with personal access tokens
+type TokenScopesKey string
+
+// accessTokenTouchInterval is how often the last used time of personal access tokens is updated
+const accessTokenTouchInterval = time.Minute
+
 // Authorization to authorize users in requests
-func Authorization(db models.DB, secret string, timeout int) func(http.Handler) http.Handler {
+func Authorization(db models.Store, secret string, timeout int) func(http.Handler) http.Handler {
 	return func(h http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			reqToken := r.Header.Get("Authorization")
@@ -31,14 +43,49 @@ func Authorization(db models.DB, secret string, timeout int) func(http.Handler)
 			}
 			reqToken = splitToken[1]
 
-			claims, err := internal.ValidateJWTToken(reqToken, secret, timeout)
-			if err != nil {
-				writeErrResponse(r, w, http.StatusUnauthorized, "user is not authorized")
-				return
+			var ctx context.Context
+			var userID string
+			if strings.HasPrefix(reqToken, internal.AccessTokenPrefix) {
+				token, err := db.GetAccessToken(internal.HashOpaqueToken(reqToken))
+				if err != nil && err != gorm.ErrRecordNotFound {
+					writeErrResponse(r, w, http.StatusInternalServerError, "internal server error")
+					return
+				}
+				if err == gorm.ErrRecordNotFound || token.Expired() {
+					writeErrResponse(r, w, http.StatusUnauthorized, "user is not authorized")
+					return
+				}
+
+				if err := db.TouchAccessToken(token.ID, accessTokenTouchInterval); err != nil {
+					log.Error().Err(err).Msg("failed to update last used time of access token")
+				}
+
+				userID = token.UserID
+				ctx = context.WithValue(r.Context(), UserIDKey("UserID"), userID)
+				ctx = context.WithValue(ctx, TokenScopesKey("TokenScopes"), token.Scopes)
+			} else {
+				claims, err := internal.ValidateJWTToken(reqToken, secret, timeout)
+				if err != nil {
+					writeErrResponse(r, w, http.StatusUnauthorized, "user is not authorized")
+					return
+				}
+
+				session, err := db.GetSession(claims.SessionID)
+				if err != nil && err != gorm.ErrRecordNotFound {
+					writeErrResponse(r, w, http.StatusInternalServerError, "internal server error")
+					return
+				}
+				if err == gorm.ErrRecordNotFound || session.UserID != claims.UserID || !session.Active() {
+					writeErrResponse(r, w, http.StatusUnauthorized, "session is revoked, please sign in again")
+					return
+				}
+
+				userID = claims.UserID
+				ctx = context.WithValue(r.Context(), UserIDKey("UserID"), userID)
+				ctx = context.WithValue(ctx, SessionIDKey("SessionID"), claims.SessionID)
 			}
-			ctx := context.WithValue(r.Context(), UserIDKey("UserID"), claims.UserID)
 
-			user, err := db.GetUserByID(claims.UserID)
+			user, err := db.GetUserByID(userID)
 			if err == gorm.ErrRecordNotFound {
 				writeErrResponse(r, w, http.StatusNotFound, "user is not found")
 				return
@@ -56,3 +103,29 @@ func Authorization(db models.DB, secret string, timeout int) func(http.Handler)
 		})
 	}
 }
+
+// TokenScopes limits requests authorized with personal access tokens, read only requests need the read
+// or the write scope and other requests need the write scope. An empty scope rejects the requests so the
+// routes are only allowed with sessions
+func TokenScopes(read, write models.TokenScope) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scopes, ok := r.Context().Value(TokenScopesKey("TokenScopes")).(models.TokenScopes)
+			if !ok || r.Method == http.MethodOptions {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			allowed := write != "" && scopes.Has(write)
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				allowed = allowed || (read != "" && scopes.Has(read))
+			}
+
+			if !allowed {
+				writeErrResponse(r, w, http.StatusForbidden, "access token doesn't have the scope of the request")
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,54 @@
+// Package middlewares for middleware between api and backend
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/codescalers/cloud4students/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenScopes(t *testing.T) {
+	handler := TokenScopes(models.ReadScope, models.DeployScope)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	sessionOnly := TokenScopes("", "")(handler)
+
+	serve := func(h http.Handler, method string, scopes models.TokenScopes) int {
+		req := httptest.NewRequest(method, "/", nil)
+		if scopes != nil {
+			req = req.WithContext(context.WithValue(req.Context(), TokenScopesKey("TokenScopes"), scopes))
+		}
+
+		response := httptest.NewRecorder()
+		h.ServeHTTP(response, req)
+		return response.Code
+	}
+
+	t.Run("sessions are not limited", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve(handler, http.MethodPost, nil))
+		assert.Equal(t, http.StatusOK, serve(sessionOnly, http.MethodPost, nil))
+	})
+
+	t.Run("read scope", func(t *testing.T) {
+		scopes := models.TokenScopes{models.ReadScope}
+		assert.Equal(t, http.StatusOK, serve(handler, http.MethodGet, scopes))
+		assert.Equal(t, http.StatusForbidden, serve(handler, http.MethodPost, scopes))
+		assert.Equal(t, http.StatusForbidden, serve(sessionOnly, http.MethodGet, scopes))
+	})
+
+	t.Run("write scope", func(t *testing.T) {
+		scopes := models.TokenScopes{models.DeployScope}
+		assert.Equal(t, http.StatusOK, serve(handler, http.MethodGet, scopes))
+		assert.Equal(t, http.StatusOK, serve(handler, http.MethodDelete, scopes))
+	})
+
+	t.Run("other scopes", func(t *testing.T) {
+		scopes := models.TokenScopes{models.AdminScope}
+		assert.Equal(t, http.StatusForbidden, serve(handler, http.MethodGet, scopes))
+		assert.Equal(t, http.StatusOK, serve(handler, http.MethodOptions, scopes))
+	})
+}
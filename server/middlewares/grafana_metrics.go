@@ -27,7 +27,7 @@ var VoucherActivated = prometheus.NewCounterVec(
 		Name: "http_request_activate_voucher", // metric name
 		Help: "Count of activated voucher.",
 	},
-	[]string{"user", "voucher", "vms", "public_ips"}, // labels
+	[]string{"user", "voucher", "cru", "mru", "sru", "public_ips"}, // labels
 )
 
 // VoucherApplied metrics
@@ -36,7 +36,7 @@ var VoucherApplied = prometheus.NewCounterVec(
 		Name: "http_request_apply_voucher", // metric name
 		Help: "Count of applied voucher.",
 	},
-	[]string{"user", "voucher", "vms", "public_ips"}, // labels
+	[]string{"user", "voucher", "cru", "mru", "sru", "public_ips"}, // labels
 )
 
 // Deployments metrics
@@ -56,3 +56,12 @@ var Deletions = prometheus.NewCounterVec(
 	},
 	[]string{"user", "type"}, // labels
 )
+
+// RateLimited metrics
+var RateLimited = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "http_request_rate_limited", // metric name
+		Help: "Count of requests rejected by rate limits.",
+	},
+	[]string{"group"}, // labels
+)
@@ -0,0 +1,215 @@
+// Package middlewares for middleware between api and backend
+package middlewares
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codescalers/cloud4students/internal"
+	"github.com/go-redis/redis"
+	"github.com/rs/zerolog/log"
+)
+
+// RateLimitStore holds the token buckets of rate limits
+type RateLimitStore interface {
+	// Take takes a token from the bucket of the key, if the bucket is empty it returns false and
+	// how long until a token is available
+	Take(key string, limit internal.RateLimit) (bool, time.Duration, error)
+}
+
+// RateLimit limits requests of the route group with a token bucket per key, requests over the limit
+// are rejected with 429 Too Many Requests
+func RateLimit(group string, limit internal.RateLimit, trustProxyHeaders bool, store RateLimitStore) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		if limit.RequestsPerMinute == 0 {
+			return h
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodOptions {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			key := fmt.Sprintf("rate_limit:%s:%s", group, rateLimitKey(r, limit.Key, trustProxyHeaders))
+			allowed, retryAfter, err := store.Take(key, limit)
+			if err != nil {
+				// a broken store shouldn't take the api down with it
+				log.Error().Err(err).Msg("failed to check rate limit")
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			if !allowed {
+				RateLimited.WithLabelValues(group).Inc()
+				w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(retryAfter.Seconds()))))
+				writeErrResponse(r, w, http.StatusTooManyRequests, "too many requests, please try again later")
+				return
+			}
+
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateLimitKey identifies who the request is limited as
+func rateLimitKey(r *http.Request, key string, trustProxyHeaders bool) string {
+	ip := ClientIP(r, trustProxyHeaders)
+	userID, _ := r.Context().Value(UserIDKey("UserID")).(string)
+
+	switch {
+	case key == internal.UserRateLimitKey && userID != "":
+		return "user:" + userID
+	case key == internal.IPUserRateLimitKey && userID != "":
+		return fmt.Sprintf("ip:%s:user:%s", ip, userID)
+	default:
+		return "ip:" + ip
+	}
+}
+
+// ClientIP is the ip of the request, the proxy headers are only used if they are trusted
+func ClientIP(r *http.Request, trustProxyHeaders bool) string {
+	if trustProxyHeaders {
+		if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+			return ip
+		}
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// refillRate is the tokens added to the bucket per second
+func refillRate(limit internal.RateLimit) float64 {
+	return float64(limit.RequestsPerMinute) / 60
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	// rate and burst of the limit the bucket is for
+	rate  float64
+	burst int
+}
+
+// MemoryRateLimitStore keeps token buckets in memory
+type MemoryRateLimitStore struct {
+	m         sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// rateLimitSweepInterval is how often full buckets are removed from memory
+const rateLimitSweepInterval = time.Minute
+
+// NewMemoryRateLimitStore creates a new in memory rate limits store
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: make(map[string]*bucket), lastSweep: time.Now()}
+}
+
+// Take takes a token from the bucket of the key
+func (s *MemoryRateLimitStore) Take(key string, limit internal.RateLimit) (bool, time.Duration, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	now := time.Now()
+	rate := refillRate(limit)
+	s.sweep(now)
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now, rate: rate, burst: limit.Burst}
+		s.buckets[key] = b
+	}
+
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rate * float64(time.Second)), nil
+	}
+
+	b.tokens--
+	return true, 0, nil
+}
+
+// sweep removes buckets that are full again, they are the same as new buckets
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	s.lastSweep = now
+
+	for key, b := range s.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*b.rate >= float64(b.burst) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// takeTokenScript refills the bucket of KEYS[1] and takes a token from it, it returns 1 if a token
+// is taken or 0 and the milliseconds until a token is available.
+// ARGV is the refill rate per millisecond, the burst and the current time in milliseconds
+var takeTokenScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "last")
+local tokens = tonumber(bucket[1]) or burst
+local last = tonumber(bucket[2]) or now
+
+tokens = math.min(burst, tokens + math.max(0, now - last) * rate)
+
+local taken = 0
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	taken = 1
+else
+	wait = math.ceil((1 - tokens) / rate)
+end
+
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "last", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst / rate))
+return {taken, wait}
+`)
+
+// RedisRateLimitStore keeps token buckets in redis so all replicas share them
+type RedisRateLimitStore struct {
+	client *redis.Client
+}
+
+// NewRedisRateLimitStore creates a new redis rate limits store
+func NewRedisRateLimitStore(client *redis.Client) *RedisRateLimitStore {
+	return &RedisRateLimitStore{client: client}
+}
+
+// Take takes a token from the bucket of the key
+func (s *RedisRateLimitStore) Take(key string, limit internal.RateLimit) (bool, time.Duration, error) {
+	rate := refillRate(limit) / 1000
+	res, err := takeTokenScript.Run(s.client, []string{key}, rate, limit.Burst, time.Now().UnixMilli()).Result()
+	if err != nil {
+		return false, 0, err
+	}
+
+	values, ok := res.([]interface{})
+	if !ok || len(values) != 2 {
+		return false, 0, fmt.Errorf("unexpected rate limit result %v", res)
+	}
+	taken, _ := values[0].(int64)
+	wait, _ := values[1].(int64)
+
+	return taken == 1, time.Duration(wait) * time.Millisecond, nil
+}
@@ -0,0 +1,100 @@
+// Package middlewares for middleware between api and backend
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/codescalers/cloud4students/internal"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	limit := internal.RateLimit{RequestsPerMinute: 60, Burst: 2}
+
+	for i := 0; i < 2; i++ {
+		taken, _, err := store.Take("key", limit)
+		assert.NoError(t, err)
+		assert.True(t, taken)
+	}
+
+	taken, retryAfter, err := store.Take("key", limit)
+	assert.NoError(t, err)
+	assert.False(t, taken)
+	assert.InDelta(t, time.Second, retryAfter, float64(100*time.Millisecond))
+
+	// other keys have their own buckets
+	taken, _, err = store.Take("other key", limit)
+	assert.NoError(t, err)
+	assert.True(t, taken)
+
+	// a token is added every second
+	store.buckets["key"].last = time.Now().Add(-time.Second)
+	taken, _, err = store.Take("key", limit)
+	assert.NoError(t, err)
+	assert.True(t, taken)
+}
+
+func TestRateLimit(t *testing.T) {
+	limit := internal.RateLimit{RequestsPerMinute: 1, Burst: 1, Key: internal.UserRateLimitKey}
+	handler := RateLimit("test", limit, false, NewMemoryRateLimitStore())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := func(ip, userID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/vm", nil)
+		req.RemoteAddr = ip + ":1234"
+		if userID != "" {
+			req = req.WithContext(context.WithValue(req.Context(), UserIDKey("UserID"), userID))
+		}
+
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, req)
+		return response
+	}
+
+	t.Run("requests over the limit are rejected", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, request("10.0.0.1", "user").Code)
+
+		response := request("10.0.0.2", "user")
+		assert.Equal(t, http.StatusTooManyRequests, response.Code)
+		assert.Equal(t, "60", response.Header().Get("Retry-After"))
+		assert.Equal(t, float64(1), testutil.ToFloat64(RateLimited.WithLabelValues("test")))
+	})
+
+	t.Run("users are limited separately", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, request("10.0.0.1", "other user").Code)
+	})
+
+	t.Run("requests without a user are limited by ip", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, request("10.0.0.1", "").Code)
+		assert.Equal(t, http.StatusTooManyRequests, request("10.0.0.1", "").Code)
+		assert.Equal(t, http.StatusOK, request("10.0.0.2", "").Code)
+	})
+
+	t.Run("disabled limit", func(t *testing.T) {
+		h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+		disabled := RateLimit("test", internal.RateLimit{}, false, NewMemoryRateLimitStore())(h)
+
+		for i := 0; i < 3; i++ {
+			response := httptest.NewRecorder()
+			disabled.ServeHTTP(response, httptest.NewRequest(http.MethodPost, "/vm", nil))
+			assert.Equal(t, http.StatusOK, response.Code)
+		}
+	})
+}
+
+func TestClientIP(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "1.1.1.1, 10.0.0.1")
+
+	assert.Equal(t, "10.0.0.1", ClientIP(req, false))
+	assert.Equal(t, "1.1.1.1", ClientIP(req, true))
+
+	req.Header.Set("X-Real-IP", "2.2.2.2")
+	assert.Equal(t, "2.2.2.2", ClientIP(req, true))
+}
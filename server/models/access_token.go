@@ -0,0 +1,84 @@
+// Package models for database models
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TokenScope is what a personal access token is allowed to do
+type TokenScope string
+
+const (
+	// ReadScope allows reading the user's data and deployments
+	ReadScope TokenScope = "read"
+	// DeployScope allows managing the user's deployments
+	DeployScope TokenScope = "deploy"
+	// AdminScope allows the admin routes if the user is an admin
+	AdminScope TokenScope = "admin"
+)
+
+// TokenScopes are the scopes of a token, they are stored comma separated
+type TokenScopes []TokenScope
+
+// Has checks if the scope is one of the scopes
+func (s TokenScopes) Has(scope TokenScope) bool {
+	for _, sc := range s {
+		if sc == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Value stores the scopes comma separated
+func (s TokenScopes) Value() (driver.Value, error) {
+	scopes := make([]string, len(s))
+	for i, sc := range s {
+		scopes[i] = string(sc)
+	}
+	return strings.Join(scopes, ","), nil
+}
+
+// Scan reads the comma separated scopes
+func (s *TokenScopes) Scan(value interface{}) error {
+	var str string
+	switch v := value.(type) {
+	case string:
+		str = v
+	case []byte:
+		str = string(v)
+	case nil:
+	default:
+		return fmt.Errorf("failed to scan token scopes of type %T", value)
+	}
+
+	*s = TokenScopes{}
+	for _, sc := range strings.Split(str, ",") {
+		if sc != "" {
+			*s = append(*s, TokenScope(sc))
+		}
+	}
+	return nil
+}
+
+// AccessToken struct holds a personal access token of a user for scripted access, the token itself is only stored hashed
+type AccessToken struct {
+	ID     int    `json:"id" gorm:"primaryKey"`
+	UserID string `json:"-" gorm:"index"`
+	Name   string `json:"name"`
+	// Prefix is the start of the token to tell tokens apart
+	Prefix     string      `json:"prefix"`
+	TokenHash  []byte      `json:"-" gorm:"uniqueIndex"`
+	Scopes     TokenScopes `json:"scopes" gorm:"type:text"`
+	CreatedAt  time.Time   `json:"created_at"`
+	ExpiresAt  time.Time   `json:"expires_at"`
+	LastUsedAt *time.Time  `json:"last_used_at"`
+}
+
+// Expired checks if the token is expired
+func (t AccessToken) Expired() bool {
+	return t.ExpiresAt.Before(time.Now())
+}
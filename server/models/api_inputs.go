@@ -6,6 +6,8 @@ type DeployVMInput struct {
 	Name      string `json:"name" binding:"required" validate:"min=3,max=20"`
 	Resources string `json:"resources" binding:"required"`
 	Public    bool   `json:"public"`
+	// TeamID deploys the vm with the quota of the team and makes it owned by the team
+	TeamID int `json:"team_id"`
 }
 
 // K8sDeployInput deploy k8s cluster input
@@ -14,6 +16,8 @@ type K8sDeployInput struct {
 	Resources  string   `json:"resources"`
 	Public     bool     `json:"public"`
 	Workers    []Worker `json:"workers"`
+	// TeamID deploys the cluster with the quota of the team and makes it owned by the team
+	TeamID int `json:"team_id"`
 }
 
 // WorkerInput deploy k8s worker input
@@ -0,0 +1,86 @@
+// Package models for database models
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrAuditEventsAppendOnly is returned on updating or deleting audit events
+var ErrAuditEventsAppendOnly = errors.New("audit events can't be updated or deleted")
+
+// AuditAction is a privileged action recorded in the audit log
+type AuditAction string
+
+const (
+	// GenerateVoucherAction generates a voucher
+	GenerateVoucherAction AuditAction = "voucher.generate"
+	// ReviewVoucherAction approves or rejects a voucher request
+	ReviewVoucherAction AuditAction = "voucher.review"
+	// ApproveAllVouchersAction approves all pending voucher requests
+	ApproveAllVouchersAction AuditAction = "voucher.approve_all"
+	// SetAdminAction sets or removes an admin
+	SetAdminAction AuditAction = "user.set_admin"
+	// AssignRoleAction assigns a role to a user
+	AssignRoleAction AuditAction = "role.assign"
+	// UnassignRoleAction removes a role of a user
+	UnassignRoleAction AuditAction = "role.unassign"
+	// ResetQuotaAction resets the quota of all users
+	ResetQuotaAction AuditAction = "quota.reset"
+	// DeleteDeploymentsAction deletes the deployments of all users
+	DeleteDeploymentsAction AuditAction = "deployments.delete"
+	// UpdateMaintenanceAction turns the maintenance on or off
+	UpdateMaintenanceAction AuditAction = "maintenance.update"
+	// UpdateNextLaunchAction launches the platform
+	UpdateNextLaunchAction AuditAction = "next_launch.update"
+	// CreateAnnouncementAction sends an announcement to all users
+	CreateAnnouncementAction AuditAction = "announcement.create"
+	// SendEmailAction sends an email to users
+	SendEmailAction AuditAction = "email.send"
+	// ResendEmailAction sends an email of the outbox again
+	ResendEmailAction AuditAction = "email.resend"
+	// RequeueDeadLetterAction requeues a failed deployment request
+	RequeueDeadLetterAction AuditAction = "dead_letter.requeue"
+	// DeleteDeadLetterAction deletes a failed deployment request
+	DeleteDeadLetterAction AuditAction = "dead_letter.delete"
+	// PurgeDeadLettersAction deletes all failed deployment requests of a type
+	PurgeDeadLettersAction AuditAction = "dead_letter.purge"
+)
+
+// AuditEvent struct holds a privileged action of a user, events are only appended
+type AuditEvent struct {
+	ID         int         `json:"id" gorm:"primaryKey"`
+	ActorID    string      `json:"actor_id" gorm:"index"`
+	ActorEmail string      `json:"actor_email"`
+	Action     AuditAction `json:"action" gorm:"index"`
+	Target     string      `json:"target"`
+	// Before and After are the json values changed by the action
+	Before    string    `json:"before" gorm:"type:text"`
+	After     string    `json:"after" gorm:"type:text"`
+	IP        string    `json:"ip"`
+	Status    int       `json:"status"`
+	CreatedAt time.Time `json:"created_at" gorm:"index"`
+}
+
+// BeforeUpdate rejects updating audit events
+func (e *AuditEvent) BeforeUpdate(tx *gorm.DB) error {
+	return ErrAuditEventsAppendOnly
+}
+
+// BeforeDelete rejects deleting audit events
+func (e *AuditEvent) BeforeDelete(tx *gorm.DB) error {
+	return ErrAuditEventsAppendOnly
+}
+
+// AuditFilter struct holds the filters of listing audit events, zero values don't filter
+type AuditFilter struct {
+	ActorEmail string
+	Action     AuditAction
+	Target     string
+	From       time.Time
+	To         time.Time
+	Limit      int
+	Offset     int
+}
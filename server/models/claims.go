@@ -9,5 +9,7 @@ import (
 type Claims struct {
 	UserID string `json:"user_id"`
 	Email  string `json:"email"`
+	// SessionID is the session the token is issued for
+	SessionID string `json:"session_id"`
 	jwt.RegisteredClaims
 }
@@ -0,0 +1,22 @@
+// Package models for database models
+package models
+
+import "time"
+
+// Compensation records the contracts of a deployment that were canceled because the deployment couldn't be saved
+type Compensation struct {
+	ID     int    `json:"id" gorm:"primaryKey"`
+	UserID string `json:"user_id"`
+	JobID  int    `json:"job_id"`
+	// Type of the deployment, vms or k8s
+	Type              string `json:"type"`
+	Name              string `json:"name"`
+	ContractID        uint64 `json:"contract_id"`
+	NetworkContractID uint64 `json:"network_contract_id"`
+	// Error is why the deployment failed or couldn't be saved
+	Error string `json:"error"`
+	// Canceled is false if the contracts still need to be canceled manually
+	Canceled    bool      `json:"canceled"`
+	CancelError string    `json:"cancel_error"`
+	CreatedAt   time.Time `json:"created_at"`
+}
@@ -2,53 +2,64 @@
 package models
 
 import (
+	"fmt"
 	"time"
 
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm/clause"
 
 	"gorm.io/gorm"
 )
 
+const (
+	// SQLiteDriver database driver
+	SQLiteDriver = "sqlite"
+	// PostgresDriver database driver
+	PostgresDriver = "postgres"
+)
+
 // DB struct hold db instance
 type DB struct {
 	db *gorm.DB
 }
 
 // NewDB creates new DB
-func NewDB() DB {
-	return DB{}
+func NewDB() *DB {
+	return &DB{}
+}
+
+// Transaction runs fn with a store whose changes are committed if fn succeeds and rolled back otherwise
+func (d *DB) Transaction(fn func(tx Store) error) error {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		return fn(&DB{db: tx})
+	})
 }
 
 // Connect connects to database file
 func (d *DB) Connect(file string) error {
-	gormDB, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
-	if err != nil {
-		return err
-	}
-	d.db = gormDB
-	return nil
+	return d.ConnectDriver(SQLiteDriver, file)
 }
 
-// Migrate migrates db schema
-func (d *DB) Migrate() error {
-	err := d.db.AutoMigrate(&User{}, &Quota{}, &VM{}, &K8sCluster{}, &Master{}, &Worker{}, &Voucher{}, &Maintenance{}, &Notification{}, &NextLaunch{})
-	if err != nil {
-		return err
+// ConnectDriver connects to database using the given driver, dsn is the file path for sqlite
+func (d *DB) ConnectDriver(driver, dsn string) error {
+	var dialector gorm.Dialector
+	switch driver {
+	case SQLiteDriver, "":
+		dialector = sqlite.Open(dsn)
+	case PostgresDriver:
+		dialector = postgres.Open(dsn)
+	default:
+		return fmt.Errorf("database driver '%s' is not supported", driver)
 	}
 
-	// add maintenance
-	if err := d.db.Delete(&Maintenance{}, "1 = 1").Error; err != nil {
-		return err
-	}
-	// add next launch
-	if err := d.db.Delete(&NextLaunch{}, "1 = 1").Error; err != nil {
-		return err
-	}
-	if err := d.db.Create(&NextLaunch{Launched: true}).Error; err != nil {
+	gormDB, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
 		return err
 	}
-	return d.db.Create(&Maintenance{}).Error
+	d.db = gormDB
+	return nil
 }
 
 // CreateUser creates new user
@@ -67,6 +78,10 @@ func (d *DB) GetUserByEmail(email string) (User, error) {
 // GetUserByID returns user by its id
 func (d *DB) GetUserByID(id string) (User, error) {
 	var res User
+	// postgres fails on comparing invalid uuids
+	if _, err := uuid.Parse(id); err != nil {
+		return res, gorm.ErrRecordNotFound
+	}
 	query := d.db.First(&res, "id = ?", id)
 	return res, query.Error
 }
@@ -75,11 +90,14 @@ func (d *DB) GetUserByID(id string) (User, error) {
 func (d *DB) ListAllUsers() ([]UserUsedQuota, error) {
 	var res []UserUsedQuota
 	query := d.db.Table("users").
-		Select("*, users.id as user_id, sum(vouchers.vms) as vms, sum(vouchers.public_ips) as public_ips, sum(vouchers.vms) - quota.vms as used_vms, sum(vouchers.public_ips) - quota.public_ips as used_public_ips").
-		Joins("left join quota on quota.user_id = users.id").
-		Joins("left join vouchers on vouchers.used = true and vouchers.user_id = users.id").
+		Select("users.*, users.id as user_id, " +
+			"coalesce(sum(vouchers.cru), 0) as cru, coalesce(sum(vouchers.mru), 0) as mru, coalesce(sum(vouchers.sru), 0) as sru, coalesce(sum(vouchers.public_ips), 0) as public_ips, " +
+			"coalesce(sum(vouchers.cru) - quota.cru, 0) as used_cru, coalesce(sum(vouchers.mru) - quota.mru, 0) as used_mru, " +
+			"coalesce(sum(vouchers.sru) - quota.sru, 0) as used_sru, coalesce(sum(vouchers.public_ips) - quota.public_ips, 0) as used_public_ips").
+		Joins("left join quota on quota.user_id = cast(users.id as text)").
+		Joins("left join vouchers on vouchers.used = true and vouchers.team_id = 0 and vouchers.user_id = cast(users.id as text)").
 		Where("verified = true").
-		Group("users.id").
+		Group("users.id, quota.cru, quota.mru, quota.sru, quota.public_ips").
 		Scan(&res)
 	return res, query.Error
 }
@@ -101,13 +119,13 @@ func (d *DB) CountAllDeployments() (DeploymentsCount, error) {
 	dlsCount := k8sCount + vmsCount
 
 	var vmIPsCount int64
-	result = d.db.Table("vms").Where("public_ip = true").Count(&vmIPsCount)
+	result = d.db.Table("vms").Where("public = true").Count(&vmIPsCount)
 	if result.Error != nil {
 		return DeploymentsCount{}, result.Error
 	}
 
 	var k8sIPsCount int64
-	result = d.db.Table("masters").Where("public_ip = true").Count(&k8sIPsCount)
+	result = d.db.Table("masters").Where("public = true").Count(&k8sIPsCount)
 	if result.Error != nil {
 		return DeploymentsCount{}, result.Error
 	}
@@ -125,16 +143,6 @@ func (d *DB) ListAdmins() ([]User, error) {
 	return admins, d.db.Where("admin = true and verified = true").Find(&admins).Error
 }
 
-// GetCodeByEmail returns verification code for unit testing
-func (d *DB) GetCodeByEmail(email string) (int, error) {
-	var res User
-	query := d.db.First(&res, "email = ?", email)
-	if query.Error != nil {
-		return 0, query.Error
-	}
-	return res.Code, nil
-}
-
 // UpdatePassword updates password of user
 func (d *DB) UpdatePassword(email string, password []byte) error {
 	var res User
@@ -151,9 +159,23 @@ func (d *DB) UpdateUserByID(user User) error {
 	return result.Error
 }
 
-// UpdateAdminUserByID updates admin information of user.
+// UpdateAdminUserByID updates admin information of user, the last admin can't be removed.
 func (d *DB) UpdateAdminUserByID(id string, admin bool) error {
-	return d.db.Model(&User{}).Where("id = ?", id).Updates(map[string]interface{}{"admin": admin, "updated_at": time.Now()}).Error
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&User{}).Where("id = ?", id).Updates(map[string]interface{}{"admin": admin, "updated_at": time.Now()})
+		if result.Error != nil || admin {
+			return result.Error
+		}
+
+		var count int64
+		if err := tx.Model(&User{}).Where("admin = true").Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrLastSuperAdmin
+		}
+		return nil
+	})
 }
 
 // UpdateVerification updates if user is verified or not
@@ -229,17 +251,139 @@ func (d *DB) CreateQuota(q *Quota) error {
 }
 
 // UpdateUserQuota updates quota
-func (d *DB) UpdateUserQuota(userID string, vms int, publicIPs int) error {
-	return d.db.Model(&Quota{}).Where("user_id = ?", userID).Updates(map[string]interface{}{"vms": vms, "public_ips": publicIPs}).Error
+func (d *DB) UpdateUserQuota(userID string, quota Resources) error {
+	return d.db.Model(&Quota{}).Where("user_id = ?", userID).Updates(map[string]interface{}{
+		"cru":        quota.CRU,
+		"mru":        quota.MRU,
+		"sru":        quota.SRU,
+		"public_ips": quota.PublicIPs,
+	}).Error
 }
 
-// GetUserQuota gets user quota available vms (vms will be used for both vms and k8s clusters)
+// GetUserQuota gets user quota of available resources used by both vms and k8s clusters
 func (d *DB) GetUserQuota(userID string) (Quota, error) {
 	var res Quota
 	query := d.db.First(&res, "user_id = ?", userID)
 	return res, query.Error
 }
 
+// GetUserQuotaUsage gets the available, reserved and used quota of user
+func (d *DB) GetUserQuotaUsage(userID string) (QuotaUsage, error) {
+	quota, err := d.GetUserQuota(userID)
+	if err != nil {
+		return QuotaUsage{}, err
+	}
+
+	var reserved, vouchers Resources
+	err = d.db.Model(&QuotaReservation{}).
+		Select(sumResources).
+		Where("user_id = ? and team_id = 0", userID).
+		Scan(&reserved).Error
+	if err != nil {
+		return QuotaUsage{}, err
+	}
+
+	err = d.db.Model(&Voucher{}).
+		Select(sumResources).
+		Where("used = true and team_id = 0 and user_id = ?", userID).
+		Scan(&vouchers).Error
+	if err != nil {
+		return QuotaUsage{}, err
+	}
+
+	return QuotaUsage{
+		UserID:    userID,
+		Resources: quota.Resources,
+		Reserved:  reserved,
+		Used:      vouchers.Sub(quota.Resources).Sub(reserved),
+	}, nil
+}
+
+// sumResources selects the sum of the resources of rows
+const sumResources = "coalesce(sum(cru), 0) as cru, coalesce(sum(mru), 0) as mru, coalesce(sum(sru), 0) as sru, coalesce(sum(public_ips), 0) as public_ips"
+
+// updateResources adds the resources to the quota of a row, or takes them from it if op is "-"
+func updateResources(op string, r Resources) map[string]interface{} {
+	return map[string]interface{}{
+		"cru":        gorm.Expr("cru "+op+" ?", r.CRU),
+		"mru":        gorm.Expr("mru "+op+" ?", r.MRU),
+		"sru":        gorm.Expr("sru "+op+" ?", r.SRU),
+		"public_ips": gorm.Expr("public_ips "+op+" ?", r.PublicIPs),
+	}
+}
+
+// ReserveQuota takes the quota of the reservation from the available quota of its user or team
+func (d *DB) ReserveQuota(r *QuotaReservation) error {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		return reserveQuota(tx, r)
+	})
+}
+
+// CreateJobWithReservation creates a job and reserves its quota, none of them is created if the quota isn't available
+func (d *DB) CreateJobWithReservation(j *Job, r *QuotaReservation) error {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(j).Error; err != nil {
+			return err
+		}
+
+		r.JobID = j.ID
+		return reserveQuota(tx, r)
+	})
+}
+
+// reservationQuota is the quota a reservation is taken from, the quota of its team or of its user
+func reservationQuota(tx *gorm.DB, r QuotaReservation) *gorm.DB {
+	if r.TeamID != 0 {
+		return tx.Model(&Team{}).Where("id = ?", r.TeamID)
+	}
+	return tx.Model(&Quota{}).Where("user_id = ?", r.UserID)
+}
+
+func reserveQuota(tx *gorm.DB, r *QuotaReservation) error {
+	// the condition and the update are done in one statement so concurrent requests can't take the same quota
+	res := reservationQuota(tx, *r).
+		Where("cru >= ? and mru >= ? and sru >= ? and public_ips >= ?", r.CRU, r.MRU, r.SRU, r.PublicIPs).
+		Updates(updateResources("-", r.Resources))
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrInsufficientQuota
+	}
+
+	return tx.Create(r).Error
+}
+
+// GetJobQuotaReservation gets the quota reservation of a job
+func (d *DB) GetJobQuotaReservation(jobID int) (QuotaReservation, error) {
+	var res QuotaReservation
+	query := d.db.First(&res, "job_id = ?", jobID)
+	return res, query.Error
+}
+
+// CommitQuotaReservation removes the reservation keeping its quota used
+func (d *DB) CommitQuotaReservation(id int) error {
+	return d.db.Delete(&QuotaReservation{}, id).Error
+}
+
+// ReleaseQuotaReservation removes the reservation giving its quota back to its user or team
+func (d *DB) ReleaseQuotaReservation(id int) error {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		var r QuotaReservation
+		if err := tx.First(&r, id).Error; err != nil {
+			return err
+		}
+
+		// only the release that removes the reservation gives the quota back
+		res := tx.Delete(&QuotaReservation{}, id)
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+
+		return reservationQuota(tx, r).Updates(updateResources("+", r.Resources)).Error
+	})
+}
+
 // CreateVoucher creates a new voucher
 func (d *DB) CreateVoucher(v *Voucher) error {
 	result := d.db.Create(&v)
@@ -287,7 +431,37 @@ func (d *DB) GetAllPendingVouchers() ([]Voucher, error) {
 
 // DeactivateVoucher if it is used
 func (d *DB) DeactivateVoucher(userID string, voucher string) error {
-	return d.db.Model(Voucher{}).Where("voucher = ?", voucher).Updates(map[string]interface{}{"used": true, "user_id": userID}).Error
+	return d.db.Model(Voucher{}).Where("voucher = ? AND used = false", voucher).Updates(map[string]interface{}{"used": true, "user_id": userID}).Error
+}
+
+// ActivateVoucher marks the voucher as used by the user and adds its quota to the quota of the user
+func (d *DB) ActivateVoucher(userID string, voucher string) error {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		var v Voucher
+		if err := tx.First(&v, "voucher = ?", voucher).Error; err != nil {
+			return err
+		}
+
+		// the voucher is only used once even by concurrent requests
+		result := tx.Model(&Voucher{}).Where("id = ? AND used = false", v.ID).
+			Updates(map[string]interface{}{"used": true, "user_id": userID})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		// the quota is added to the row and not overwritten so reservations made meanwhile are kept
+		result = tx.Model(&Quota{}).Where("user_id = ?", userID).Updates(updateResources("+", v.Resources))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
 }
 
 // CreateK8s creates a new k8s cluster
@@ -383,16 +557,61 @@ func (d *DB) GetMaintenance() (Maintenance, error) {
 
 // notifications
 
-// ListNotifications returns a list of notifications for a user.
-func (d *DB) ListNotifications(userID string) ([]Notification, error) {
+// ListNotifications returns a page of notifications of a user, the newest first.
+func (d *DB) ListNotifications(userID string, filter NotificationsFilter) ([]Notification, error) {
 	var res []Notification
-	query := d.db.Where("user_id = ?", userID).Find(&res)
+	query := d.db.Where("user_id = ?", userID)
+	if filter.Cursor > 0 {
+		query = query.Where("id < ?", filter.Cursor)
+	}
+	if len(filter.Types) > 0 {
+		query = query.Where("type IN ?", filter.Types)
+	}
+	if filter.Unread {
+		query = query.Where("seen = ?", false)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	query = query.Order("id desc").Find(&res)
 	return res, query.Error
 }
 
-// UpdateNotification updates seen field for notification
-func (d *DB) UpdateNotification(id int, seen bool) error {
-	return d.db.Model(&Notification{}).Where("id = ?", id).Updates(map[string]interface{}{"seen": seen}).Error
+// CountUnreadNotifications returns the count of notifications a user hasn't seen
+func (d *DB) CountUnreadNotifications(userID string) (int64, error) {
+	var count int64
+	query := d.db.Model(&Notification{}).Where("user_id = ? and seen = ?", userID, false).Count(&count)
+	return count, query.Error
+}
+
+// UpdateNotification updates seen field for a notification of a user
+func (d *DB) UpdateNotification(id int, userID string, seen bool) error {
+	query := d.db.Model(&Notification{}).Where("id = ? and user_id = ?", id, userID).Updates(map[string]interface{}{"seen": seen})
+	if query.Error != nil {
+		return query.Error
+	}
+	if query.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// UpdateAllNotifications updates seen field for all notifications of a user
+func (d *DB) UpdateAllNotifications(userID string, seen bool) error {
+	return d.db.Model(&Notification{}).Where("user_id = ? and seen = ?", userID, !seen).Updates(map[string]interface{}{"seen": seen}).Error
+}
+
+// DeleteNotification deletes a notification of a user
+func (d *DB) DeleteNotification(id int, userID string) error {
+	query := d.db.Where("id = ? and user_id = ?", id, userID).Delete(&Notification{})
+	if query.Error != nil {
+		return query.Error
+	}
+	if query.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
 }
 
 // CreateNotification adds a new notification for a user
@@ -411,3 +630,725 @@ func (d *DB) GetNextLaunch() (NextLaunch, error) {
 	query := d.db.First(&res)
 	return res, query.Error
 }
+
+// CreateJob creates a new deployment job
+func (d *DB) CreateJob(j *Job) error {
+	return d.db.Create(j).Error
+}
+
+// GetJob returns a job by its id
+func (d *DB) GetJob(id int) (Job, error) {
+	var res Job
+	query := d.db.First(&res, id)
+	return res, query.Error
+}
+
+// ListJobs returns all jobs of a user, the newest first
+func (d *DB) ListJobs(userID string) ([]Job, error) {
+	var res []Job
+	query := d.db.Where("user_id = ?", userID).Order("id desc").Find(&res)
+	return res, query.Error
+}
+
+// UpdateJobState updates the state of a job and its error
+func (d *DB) UpdateJobState(id int, state JobState, jobErr string) error {
+	// requeued jobs are not finished anymore
+	var finishedAt *time.Time
+	if state.Finished() {
+		now := time.Now()
+		finishedAt = &now
+	}
+
+	updates := map[string]interface{}{"state": state, "error": jobErr, "updated_at": time.Now(), "finished_at": finishedAt}
+	return d.db.Model(&Job{}).Where("id = ?", id).Updates(updates).Error
+}
+
+// StartJob moves a job to placing, it returns false if the job was canceled
+func (d *DB) StartJob(id int) (bool, error) {
+	res := d.db.Model(&Job{}).Where("id = ? and state != ?", id, JobCanceled).
+		Updates(map[string]interface{}{"state": JobPlacing, "error": "", "finished_at": nil, "updated_at": time.Now()})
+	return res.RowsAffected > 0, res.Error
+}
+
+// CancelJob cancels a job that is still queued, it returns false if the job isn't queued
+func (d *DB) CancelJob(id int) (bool, error) {
+	now := time.Now()
+	res := d.db.Model(&Job{}).Where("id = ? and state = ?", id, JobQueued).
+		Updates(map[string]interface{}{"state": JobCanceled, "finished_at": &now, "updated_at": now})
+	return res.RowsAffected > 0, res.Error
+}
+
+// UpdateJobDeployment sets the deployment created by a job
+func (d *DB) UpdateJobDeployment(id int, deploymentID int) error {
+	return d.db.Model(&Job{}).Where("id = ?", id).Updates(map[string]interface{}{"deployment_id": deploymentID, "updated_at": time.Now()}).Error
+}
+
+// CreateCompensation records the canceled contracts of a deployment that couldn't be saved
+func (d *DB) CreateCompensation(c *Compensation) error {
+	return d.db.Create(c).Error
+}
+
+// ListCompensations returns all recorded compensations, the newest first
+func (d *DB) ListCompensations() ([]Compensation, error) {
+	var res []Compensation
+	query := d.db.Order("id desc").Find(&res)
+	return res, query.Error
+}
+
+// CreateEmails adds emails to the outbox
+func (d *DB) CreateEmails(emails []Email) error {
+	if len(emails) == 0 {
+		return nil
+	}
+	return d.db.CreateInBatches(&emails, 100).Error
+}
+
+// GetEmail returns an email of the outbox by its id
+func (d *DB) GetEmail(id int) (Email, error) {
+	var res Email
+	query := d.db.First(&res, id)
+	return res, query.Error
+}
+
+// ListEmails returns a page of the emails of the outbox in the states of the filter, the newest first
+func (d *DB) ListEmails(filter EmailsFilter) ([]Email, error) {
+	query := d.db.Where("state IN ?", filter.States)
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	if filter.Offset > 0 {
+		query = query.Offset(filter.Offset)
+	}
+
+	var res []Email
+	return res, query.Order("id desc").Find(&res).Error
+}
+
+// DueEmails returns the queued emails that are due and the sending emails whose claim expired, the oldest first
+func (d *DB) DueEmails(limit int) ([]Email, error) {
+	var res []Email
+	query := d.db.Where("state IN ? and next_attempt_at <= ?", []EmailState{EmailQueued, EmailSending}, time.Now()).
+		Order("id").Limit(limit).Find(&res)
+	return res, query.Error
+}
+
+// ClaimEmail moves a due email to sending until the claim expires, it returns false if another worker claimed it
+func (d *DB) ClaimEmail(id int, until time.Time) (bool, error) {
+	res := d.db.Model(&Email{}).Where("id = ? and state IN ? and next_attempt_at <= ?", id, []EmailState{EmailQueued, EmailSending}, time.Now()).
+		Updates(map[string]interface{}{"state": EmailSending, "next_attempt_at": until, "attempts": gorm.Expr("attempts + 1"), "updated_at": time.Now()})
+	return res.RowsAffected > 0, res.Error
+}
+
+// UpdateEmailState updates the state of a claimed email, the error and when the next attempt is due
+func (d *DB) UpdateEmailState(id int, state EmailState, emailErr string, nextAttemptAt time.Time) error {
+	now := time.Now()
+	updates := map[string]interface{}{"state": state, "error": emailErr, "next_attempt_at": nextAttemptAt, "updated_at": now}
+	if state == EmailSent {
+		updates["sent_at"] = &now
+	}
+	// secrets of sensitive emails aren't kept once they won't be sent anymore
+	if state == EmailSent || state == EmailFailed {
+		updates["body"] = gorm.Expr("CASE WHEN sensitive THEN '' ELSE body END")
+		updates["text"] = gorm.Expr("CASE WHEN sensitive THEN '' ELSE text END")
+	}
+	return d.db.Model(&Email{}).Where("id = ?", id).Updates(updates).Error
+}
+
+// ResendEmail queues a failed or sent email again with new attempts, sensitive emails have no body to send again
+func (d *DB) ResendEmail(id int) (bool, error) {
+	res := d.db.Model(&Email{}).Where("id = ? and state IN ? and sensitive = false", id, []EmailState{EmailFailed, EmailSent}).
+		Updates(map[string]interface{}{"state": EmailQueued, "attempts": 0, "error": "", "next_attempt_at": time.Now(), "updated_at": time.Now()})
+	return res.RowsAffected > 0, res.Error
+}
+
+// SaveVerificationCode replaces the code of the user for the same purpose and resets its attempts
+func (d *DB) SaveVerificationCode(c *VerificationCode) error {
+	c.Attempts = 0
+	return d.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "purpose"}},
+		DoUpdates: clause.AssignmentColumns([]string{"hashed_code", "attempts", "expires_at", "sent_at"}),
+	}).Create(c).Error
+}
+
+// GetVerificationCode returns the code of the user for a purpose
+func (d *DB) GetVerificationCode(userID string, purpose CodePurpose) (VerificationCode, error) {
+	var c VerificationCode
+	return c, d.db.First(&c, "user_id = ? AND purpose = ?", userID, purpose).Error
+}
+
+// AttemptVerificationCode counts a guess of the code, it returns false if the code has no attempts left
+func (d *DB) AttemptVerificationCode(id int, maxAttempts int) (bool, error) {
+	result := d.db.Model(&VerificationCode{}).
+		Where("id = ? AND attempts < ?", id, maxAttempts).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	return result.RowsAffected == 1, result.Error
+}
+
+// DeleteVerificationCode deletes a code so it can't be used again
+func (d *DB) DeleteVerificationCode(id int) error {
+	return d.db.Delete(&VerificationCode{}, id).Error
+}
+
+// CreateSession creates a session with its first refresh token
+func (d *DB) CreateSession(s *Session, t *RefreshToken) error {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(s).Error; err != nil {
+			return err
+		}
+		t.SessionID = s.ID
+		return tx.Create(t).Error
+	})
+}
+
+// GetSession returns a session by its id
+func (d *DB) GetSession(id string) (Session, error) {
+	var s Session
+	return s, d.db.First(&s, "id = ?", id).Error
+}
+
+// ListSessions returns the active sessions of the user, the last used first
+func (d *DB) ListSessions(userID string) ([]Session, error) {
+	var sessions []Session
+	return sessions, d.db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_used_at desc").Find(&sessions).Error
+}
+
+// RevokeSession revokes a session of the user
+func (d *DB) RevokeSession(id string, userID string) error {
+	result := d.db.Model(&Session{}).Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).Update("revoked_at", time.Now())
+	if result.Error == nil && result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return result.Error
+}
+
+// RevokeUserSessions revokes the sessions of the user except the kept one, an empty keptID revokes all of them
+func (d *DB) RevokeUserSessions(userID string, keptID string) error {
+	return d.db.Model(&Session{}).Where("user_id = ? AND id <> ? AND revoked_at IS NULL", userID, keptID).Update("revoked_at", time.Now()).Error
+}
+
+// GetRefreshToken returns a refresh token by its hash
+func (d *DB) GetRefreshToken(hash []byte) (RefreshToken, error) {
+	var t RefreshToken
+	return t, d.db.First(&t, "token_hash = ?", hash).Error
+}
+
+// RotateRefreshToken marks the refresh token as used and creates the next token of its session, it returns
+// false if the token is already used
+func (d *DB) RotateRefreshToken(id int, next *RefreshToken) (bool, error) {
+	rotated := false
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.Model(&RefreshToken{}).Where("id = ? AND used_at IS NULL", id).Update("used_at", now)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+
+		if err := tx.Create(next).Error; err != nil {
+			return err
+		}
+		rotated = true
+		return tx.Model(&Session{}).Where("id = ?", next.SessionID).Update("last_used_at", now).Error
+	})
+	return rotated, err
+}
+
+// SetTOTPSecret saves a new secret of the user that is not enabled until it is confirmed
+func (d *DB) SetTOTPSecret(userID string, secret string) error {
+	result := d.db.Model(&User{}).Where("id = ? AND totp_enabled = ?", userID, false).
+		Updates(map[string]interface{}{"totp_secret": secret, "totp_last_step": 0})
+	if result.RowsAffected == 0 && result.Error == nil {
+		return gorm.ErrRecordNotFound
+	}
+	return result.Error
+}
+
+// EnableTOTP enables the secret of the user confirmed with the code of the step, and replaces the recovery codes
+func (d *DB) EnableTOTP(userID string, step int64, codes []RecoveryCode) error {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&User{}).Where("id = ? AND totp_enabled = ? AND totp_secret <> ''", userID, false).
+			Updates(map[string]interface{}{"totp_enabled": true, "totp_last_step": step})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return replaceRecoveryCodes(tx, userID, codes)
+	})
+}
+
+// DisableTOTP removes the secret and recovery codes of the user
+func (d *DB) DisableTOTP(userID string) error {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&User{}).Where("id = ?", userID).
+			Updates(map[string]interface{}{"totp_enabled": false, "totp_secret": "", "totp_last_step": 0}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error
+	})
+}
+
+// UseTOTPStep marks the step of a code as used, it returns false if a code of the same or a later step is used already
+func (d *DB) UseTOTPStep(userID string, step int64) (bool, error) {
+	result := d.db.Model(&User{}).Where("id = ? AND totp_last_step < ?", userID, step).Update("totp_last_step", step)
+	return result.RowsAffected == 1, result.Error
+}
+
+// ReplaceRecoveryCodes removes the recovery codes of the user and saves the new ones
+func (d *DB) ReplaceRecoveryCodes(userID string, codes []RecoveryCode) error {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		return replaceRecoveryCodes(tx, userID, codes)
+	})
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, userID string, codes []RecoveryCode) error {
+	if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+		return err
+	}
+	for i := range codes {
+		codes[i].UserID = userID
+	}
+	if len(codes) == 0 {
+		return nil
+	}
+	return tx.Create(&codes).Error
+}
+
+// UseRecoveryCode marks an unused recovery code of the user as used, it returns false if there is no such code
+func (d *DB) UseRecoveryCode(userID string, hashedCode []byte) (bool, error) {
+	result := d.db.Model(&RecoveryCode{}).Where("user_id = ? AND hashed_code = ? AND used_at IS NULL", userID, hashedCode).Update("used_at", time.Now())
+	return result.RowsAffected == 1, result.Error
+}
+
+// CountRecoveryCodes counts the unused recovery codes of the user
+func (d *DB) CountRecoveryCodes(userID string) (int64, error) {
+	var count int64
+	err := d.db.Model(&RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count).Error
+	return count, err
+}
+
+// CreateAccessToken creates a personal access token
+func (d *DB) CreateAccessToken(t *AccessToken) error {
+	return d.db.Create(t).Error
+}
+
+// GetAccessToken gets a personal access token by its hash
+func (d *DB) GetAccessToken(hash []byte) (AccessToken, error) {
+	var t AccessToken
+	return t, d.db.Where("token_hash = ?", hash).First(&t).Error
+}
+
+// ListAccessTokens lists the personal access tokens of the user
+func (d *DB) ListAccessTokens(userID string) ([]AccessToken, error) {
+	var tokens []AccessToken
+	return tokens, d.db.Where("user_id = ?", userID).Order("created_at desc").Find(&tokens).Error
+}
+
+// DeleteAccessToken deletes a personal access token of the user
+func (d *DB) DeleteAccessToken(id int, userID string) error {
+	result := d.db.Where("id = ? AND user_id = ?", id, userID).Delete(&AccessToken{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// TouchAccessToken updates the last used time of a personal access token, it is only updated once
+// per interval to avoid a write for every request
+func (d *DB) TouchAccessToken(id int, interval time.Duration) error {
+	now := time.Now()
+	return d.db.Model(&AccessToken{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", id, now.Add(-interval)).
+		Update("last_used_at", now).Error
+}
+
+// CreateOIDCLogin creates a pending single sign on, expired ones are deleted with it
+func (d *DB) CreateOIDCLogin(l *OIDCLogin) error {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("expires_at < ?", time.Now()).Delete(&OIDCLogin{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(l).Error
+	})
+}
+
+// ConsumeOIDCLogin gets a pending single sign on by the hash of its state and deletes it, so a state
+// can only be used once
+func (d *DB) ConsumeOIDCLogin(stateHash []byte) (OIDCLogin, error) {
+	var l OIDCLogin
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("state_hash = ?", stateHash).First(&l).Error; err != nil {
+			return err
+		}
+		result := tx.Delete(&OIDCLogin{}, l.ID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+	return l, err
+}
+
+// GetUserIdentity gets the identity of a provider account
+func (d *DB) GetUserIdentity(provider, subject string) (UserIdentity, error) {
+	var i UserIdentity
+	return i, d.db.Where("provider = ? AND subject = ?", provider, subject).First(&i).Error
+}
+
+// CreateUserIdentity links a provider account to a user
+func (d *DB) CreateUserIdentity(i *UserIdentity) error {
+	return d.db.Create(i).Error
+}
+
+// ClaimUnverifiedUser verifies a user whose email was verified by a provider, the password and the data of
+// the unverified sign up are dropped as anyone could have signed up with the email
+func (d *DB) ClaimUnverifiedUser(id, name, college string) error {
+	result := d.db.Model(&User{}).Where("id = ? AND verified = ?", id, false).Updates(map[string]interface{}{
+		"name":            name,
+		"college":         college,
+		"hashed_password": []byte{},
+		"ssh_key":         "",
+		"team_size":       0,
+		"project_desc":    "",
+		"verified":        true,
+		"updated_at":      time.Now(),
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// ListUserRoles lists the roles of the user, admins have the superadmin role
+func (d *DB) ListUserRoles(userID string) ([]Role, error) {
+	user, err := d.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	return d.ListRolesOfUser(user)
+}
+
+// ListRolesOfUser lists the roles of a loaded user, admins have the superadmin role
+func (d *DB) ListRolesOfUser(user User) ([]Role, error) {
+	var roles []Role
+	if user.Admin {
+		roles = append(roles, SuperAdminRole)
+	}
+
+	var assigned []Role
+	if err := d.db.Model(&UserRole{}).Where("user_id = ?", user.ID.String()).Order("role").Pluck("role", &assigned).Error; err != nil {
+		return nil, err
+	}
+	return append(roles, assigned...), nil
+}
+
+// AddUserRole assigns a role to the user, it returns false if the user already has it
+func (d *DB) AddUserRole(userID string, role Role) (bool, error) {
+	result := d.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&UserRole{UserID: userID, Role: role})
+	return result.RowsAffected == 1, result.Error
+}
+
+// RemoveUserRole removes a role of the user
+func (d *DB) RemoveUserRole(userID string, role Role) error {
+	result := d.db.Where("user_id = ? AND role = ?", userID, role).Delete(&UserRole{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// ListStaff lists the verified users who have roles
+func (d *DB) ListStaff() ([]StaffUser, error) {
+	var users []User
+	err := d.db.Where("verified = true AND (admin = true OR cast(id as text) IN (?))", d.db.Model(&UserRole{}).Select("user_id")).
+		Order("name").Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var assigned []UserRole
+	if err := d.db.Order("role").Find(&assigned).Error; err != nil {
+		return nil, err
+	}
+
+	roles := map[string][]Role{}
+	for _, r := range assigned {
+		roles[r.UserID] = append(roles[r.UserID], r.Role)
+	}
+
+	staff := make([]StaffUser, 0, len(users))
+	for _, user := range users {
+		s := StaffUser{User: user}
+		if user.Admin {
+			s.Roles = append(s.Roles, SuperAdminRole)
+		}
+		s.Roles = append(s.Roles, roles[user.ID.String()]...)
+		staff = append(staff, s)
+	}
+	return staff, nil
+}
+
+// CreateAuditEvent appends an event to the audit log
+func (d *DB) CreateAuditEvent(e *AuditEvent) error {
+	return d.db.Create(e).Error
+}
+
+// ListAuditEvents lists the audit events matching the filter, the newest first
+func (d *DB) ListAuditEvents(filter AuditFilter) ([]AuditEvent, error) {
+	query := d.db.Model(&AuditEvent{})
+	if filter.ActorEmail != "" {
+		query = query.Where("actor_email = ?", filter.ActorEmail)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.Target != "" {
+		query = query.Where("target = ?", filter.Target)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("created_at < ?", filter.To)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	if filter.Offset > 0 {
+		query = query.Offset(filter.Offset)
+	}
+
+	events := []AuditEvent{}
+	return events, query.Order("id desc").Find(&events).Error
+}
+
+// CreateTeam creates a new team with its owner as its first member
+func (d *DB) CreateTeam(t *Team) error {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(t).Error; err != nil {
+			return err
+		}
+		return tx.Create(&TeamMember{TeamID: t.ID, UserID: t.OwnerID, Role: TeamOwnerRole}).Error
+	})
+}
+
+// GetTeam gets a team by its id
+func (d *DB) GetTeam(id int) (Team, error) {
+	var res Team
+	query := d.db.First(&res, id)
+	return res, query.Error
+}
+
+// ListUserTeams lists the teams of the user with the role of the user in each of them
+func (d *DB) ListUserTeams(userID string) ([]UserTeam, error) {
+	teams := []UserTeam{}
+	query := d.db.Table("teams").
+		Select("teams.*, team_members.role as role").
+		Joins("join team_members on team_members.team_id = teams.id").
+		Where("team_members.user_id = ?", userID).
+		Order("teams.id").
+		Scan(&teams)
+	return teams, query.Error
+}
+
+// DeleteTeam deletes a team with its members and invitations, teams with deployments or deployment
+// requests can't be deleted
+func (d *DB) DeleteTeam(id int) error {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		for _, model := range []interface{}{&VM{}, &K8sCluster{}, &QuotaReservation{}} {
+			var count int64
+			if err := tx.Model(model).Where("team_id = ?", id).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return ErrTeamHasDeployments
+			}
+		}
+
+		if err := tx.Where("team_id = ?", id).Delete(&TeamMember{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("team_id = ?", id).Delete(&TeamInvitation{}).Error; err != nil {
+			return err
+		}
+
+		result := tx.Delete(&Team{}, id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+// GetTeamMember gets the membership of the user in a team
+func (d *DB) GetTeamMember(teamID int, userID string) (TeamMember, error) {
+	var res TeamMember
+	query := d.db.First(&res, "team_id = ? AND user_id = ?", teamID, userID)
+	return res, query.Error
+}
+
+// ListTeamMembers lists the members of a team, the owner first
+func (d *DB) ListTeamMembers(teamID int) ([]TeamMemberUser, error) {
+	members := []TeamMemberUser{}
+	query := d.db.Table("team_members").
+		Select("team_members.user_id, users.name, users.email, users.ssh_key, team_members.role, team_members.created_at as joined_at").
+		Joins("join users on cast(users.id as text) = team_members.user_id").
+		Where("team_members.team_id = ?", teamID).
+		Order("team_members.role = 'owner' desc, team_members.id").
+		Scan(&members)
+	return members, query.Error
+}
+
+// RemoveTeamMember removes a member of a team, the team deployments of the member are given to the owner of the team
+func (d *DB) RemoveTeamMember(teamID int, userID string) error {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		var team Team
+		if err := tx.First(&team, teamID).Error; err != nil {
+			return err
+		}
+
+		result := tx.Where("team_id = ? AND user_id = ? AND role = ?", teamID, userID, TeamMemberRole).Delete(&TeamMember{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		for _, model := range []interface{}{&VM{}, &K8sCluster{}} {
+			if err := tx.Model(model).Where("team_id = ? AND user_id = ?", teamID, userID).Update("user_id", team.OwnerID).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// CreateTeamInvitation invites an email to a team, it returns false if the email is already invited
+func (d *DB) CreateTeamInvitation(i *TeamInvitation) (bool, error) {
+	result := d.db.Clauses(clause.OnConflict{DoNothing: true}).Create(i)
+	return result.RowsAffected == 1, result.Error
+}
+
+// GetTeamInvitation gets a team invitation by its id
+func (d *DB) GetTeamInvitation(id int) (TeamInvitation, error) {
+	var res TeamInvitation
+	query := d.db.First(&res, id)
+	return res, query.Error
+}
+
+// ListTeamInvitations lists the pending invitations of a team
+func (d *DB) ListTeamInvitations(teamID int) ([]TeamInvitation, error) {
+	invitations := []TeamInvitation{}
+	return invitations, d.db.Where("team_id = ?", teamID).Order("id").Find(&invitations).Error
+}
+
+// ListEmailInvitations lists the pending team invitations of an email
+func (d *DB) ListEmailInvitations(email string) ([]TeamInvitation, error) {
+	invitations := []TeamInvitation{}
+	return invitations, d.db.Where("email = ?", email).Order("id").Find(&invitations).Error
+}
+
+// AcceptTeamInvitation removes the invitation and adds the user as a member of its team
+func (d *DB) AcceptTeamInvitation(id int, userID string) error {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		var invitation TeamInvitation
+		if err := tx.First(&invitation, id).Error; err != nil {
+			return err
+		}
+
+		// only the accept that removes the invitation adds the member
+		result := tx.Delete(&TeamInvitation{}, id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		member := TeamMember{TeamID: invitation.TeamID, UserID: userID, Role: TeamMemberRole}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&member).Error
+	})
+}
+
+// DeleteTeamInvitation deletes a team invitation
+func (d *DB) DeleteTeamInvitation(id int) error {
+	result := d.db.Delete(&TeamInvitation{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// ActivateTeamVoucher marks the voucher as used by the user and adds its quota to the pooled quota of the team
+func (d *DB) ActivateTeamVoucher(teamID int, userID string, voucher string) error {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		var v Voucher
+		if err := tx.First(&v, "voucher = ?", voucher).Error; err != nil {
+			return err
+		}
+
+		// the voucher is only used once even by concurrent requests
+		result := tx.Model(&Voucher{}).Where("id = ? AND used = false", v.ID).
+			Updates(map[string]interface{}{"used": true, "user_id": userID, "team_id": teamID})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		result = tx.Model(&Team{}).Where("id = ?", teamID).Updates(updateResources("+", v.Resources))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+// GetTeamVms returns all vms of a team
+func (d *DB) GetTeamVms(teamID int) ([]VM, error) {
+	vms := []VM{}
+	return vms, d.db.Where("team_id = ?", teamID).Find(&vms).Error
+}
+
+// GetTeamK8s returns all k8s clusters of a team
+func (d *DB) GetTeamK8s(teamID int) ([]K8sCluster, error) {
+	var clusters []K8sCluster
+	if err := d.db.Find(&clusters, "team_id = ?", teamID).Error; err != nil {
+		return nil, err
+	}
+
+	res := make([]K8sCluster, 0, len(clusters))
+	for _, cluster := range clusters {
+		k8s, err := d.GetK8s(cluster.ID)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, k8s)
+	}
+	return res, nil
+}
@@ -2,20 +2,41 @@
 package models
 
 import (
+	"fmt"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"gorm.io/gorm"
 )
 
-func setupDB(t *testing.T) DB {
+// postgresDSNEnv runs the tests against postgres instead of sqlite if it is set
+const postgresDSNEnv = "POSTGRES_TEST_DSN"
+
+func setupDB(t *testing.T) *DB {
 	db := NewDB()
-	testDir := t.TempDir()
 
-	dbName := "test.db"
-	err := db.Connect(testDir + dbName)
-	require.NoError(t, err)
-	err = db.Migrate()
+	if dsn := os.Getenv(postgresDSNEnv); dsn != "" {
+		err := db.ConnectDriver(PostgresDriver, dsn)
+		require.NoError(t, err)
+
+		// start every test with empty tables
+		tables, err := db.db.Migrator().GetTables()
+		require.NoError(t, err)
+		for _, table := range tables {
+			err = db.db.Migrator().DropTable(table)
+			require.NoError(t, err)
+		}
+	} else {
+		testDir := t.TempDir()
+
+		dbName := "test.db"
+		err := db.Connect(testDir + dbName)
+		require.NoError(t, err)
+	}
+
+	err := db.Migrate()
 	require.NoError(t, err)
 	return db
 }
@@ -32,6 +53,10 @@ func TestConnect(t *testing.T) {
 		err := db.Connect(testDir + dbName)
 		require.NoError(t, err)
 	})
+	t.Run("unsupported driver", func(t *testing.T) {
+		err := db.ConnectDriver("mysql", testDir+dbName)
+		require.Error(t, err)
+	})
 }
 
 func TestCreateUser(t *testing.T) {
@@ -118,31 +143,87 @@ func TestListAllUsers(t *testing.T) {
 		require.Equal(t, users[0].HashedPassword, user1.HashedPassword)
 
 	})
+
+	t.Run("list used quota of users", func(t *testing.T) {
+		users, err := db.ListAllUsers()
+		require.NoError(t, err)
+		require.Len(t, users, 1)
+
+		err = db.CreateQuota(&Quota{UserID: users[0].UserID, Resources: Resources{CRU: 1, MRU: 2, SRU: 25, PublicIPs: 1}})
+		require.NoError(t, err)
+		err = db.CreateVoucher(&Voucher{UserID: users[0].UserID, Voucher: "voucher", Resources: Resources{CRU: 4, MRU: 8, SRU: 100, PublicIPs: 1}, Used: true})
+		require.NoError(t, err)
+
+		users, err = db.ListAllUsers()
+		require.NoError(t, err)
+		require.Len(t, users, 1)
+		require.Equal(t, 4, users[0].CRU)
+		require.Equal(t, 3, users[0].UsedCRU)
+		require.Equal(t, 6, users[0].UsedMRU)
+		require.Equal(t, 75, users[0].UsedSRU)
+		require.Equal(t, 1, users[0].PublicIPs)
+		require.Equal(t, 0, users[0].UsedPublicIPs)
+	})
 }
 
-func TestGetCodeByEmail(t *testing.T) {
+func TestVerificationCodes(t *testing.T) {
 	db := setupDB(t)
-	t.Run("user not found", func(t *testing.T) {
-		_, err := db.GetCodeByEmail("email@gmail.com")
+	t.Run("code not found", func(t *testing.T) {
+		_, err := db.GetVerificationCode("user", SignUpCode)
 		require.Equal(t, err, gorm.ErrRecordNotFound)
 	})
 
-	t.Run("get code of user", func(t *testing.T) {
-		user := User{
-			Name:           "user",
-			Email:          "user@gmail.com",
-			HashedPassword: []byte{},
-			Verified:       true,
-			Code:           1234,
+	t.Run("save code", func(t *testing.T) {
+		code := VerificationCode{UserID: "user", Purpose: SignUpCode, HashedCode: []byte("hash"), ExpiresAt: time.Now().Add(time.Minute)}
+		err := db.SaveVerificationCode(&code)
+		require.NoError(t, err)
+
+		err = db.SaveVerificationCode(&VerificationCode{UserID: "user", Purpose: ResetPasswordCode, HashedCode: []byte("other hash")})
+		require.NoError(t, err)
+
+		c, err := db.GetVerificationCode("user", SignUpCode)
+		require.NoError(t, err)
+		require.Equal(t, []byte("hash"), c.HashedCode)
+	})
+
+	t.Run("attempts are limited", func(t *testing.T) {
+		c, err := db.GetVerificationCode("user", SignUpCode)
+		require.NoError(t, err)
+
+		for i := 0; i < 2; i++ {
+			attempted, err := db.AttemptVerificationCode(c.ID, 2)
+			require.NoError(t, err)
+			require.True(t, attempted)
 		}
 
-		err := db.CreateUser(&user)
+		attempted, err := db.AttemptVerificationCode(c.ID, 2)
 		require.NoError(t, err)
-		code, err := db.GetCodeByEmail("user@gmail.com")
+		require.False(t, attempted)
+	})
+
+	t.Run("new code replaces the old one", func(t *testing.T) {
+		err := db.SaveVerificationCode(&VerificationCode{UserID: "user", Purpose: SignUpCode, HashedCode: []byte("new hash")})
+		require.NoError(t, err)
+
+		c, err := db.GetVerificationCode("user", SignUpCode)
 		require.NoError(t, err)
-		require.Equal(t, code, user.Code)
+		require.Equal(t, []byte("new hash"), c.HashedCode)
+		require.Zero(t, c.Attempts)
 	})
 
+	t.Run("delete code", func(t *testing.T) {
+		c, err := db.GetVerificationCode("user", SignUpCode)
+		require.NoError(t, err)
+
+		err = db.DeleteVerificationCode(c.ID)
+		require.NoError(t, err)
+
+		_, err = db.GetVerificationCode("user", SignUpCode)
+		require.Equal(t, err, gorm.ErrRecordNotFound)
+
+		_, err = db.GetVerificationCode("user", ResetPasswordCode)
+		require.NoError(t, err)
+	})
 }
 
 func TestUpdatePassword(t *testing.T) {
@@ -470,6 +551,22 @@ func TestDeleteAllVMs(t *testing.T) {
 	})
 }
 
+func TestCountAllDeployments(t *testing.T) {
+	db := setupDB(t)
+
+	err := db.CreateVM(&VM{UserID: "user", Name: "public", Public: true, PublicIP: "10.0.0.1/24"})
+	require.NoError(t, err)
+	err = db.CreateVM(&VM{UserID: "user", Name: "private"})
+	require.NoError(t, err)
+	err = db.CreateK8s(&K8sCluster{UserID: "user", Master: Master{Name: "master", Public: true, PublicIP: "10.0.0.2/24"}})
+	require.NoError(t, err)
+
+	// deployments with public ips are counted by their public flag
+	count, err := db.CountAllDeployments()
+	require.NoError(t, err)
+	require.Equal(t, DeploymentsCount{VMs: 3, IPs: 2}, count)
+}
+
 func TestCreateQuota(t *testing.T) {
 	db := setupDB(t)
 	quota := Quota{UserID: "user"}
@@ -484,7 +581,7 @@ func TestCreateQuota(t *testing.T) {
 func TestUpdateUserQuota(t *testing.T) {
 	db := setupDB(t)
 	t.Run("quota not found so no updates", func(t *testing.T) {
-		err := db.UpdateUserQuota("user", 5, 0)
+		err := db.UpdateUserQuota("user", Resources{CRU: 5})
 		require.NoError(t, err)
 	})
 	t.Run("quota found", func(t *testing.T) {
@@ -496,17 +593,17 @@ func TestUpdateUserQuota(t *testing.T) {
 		err = db.CreateQuota(&quota2)
 		require.NoError(t, err)
 
-		err = db.UpdateUserQuota("user", 5, 10)
+		err = db.UpdateUserQuota("user", Resources{CRU: 5, MRU: 10, SRU: 100, PublicIPs: 10})
 		require.NoError(t, err)
 
 		var q Quota
 		err = db.db.First(&q, "user_id = 'user'").Error
 		require.NoError(t, err)
-		require.Equal(t, q.Vms, 5)
+		require.Equal(t, q.Resources, Resources{CRU: 5, MRU: 10, SRU: 100, PublicIPs: 10})
 
 		err = db.db.First(&q, "user_id = 'new-user'").Error
 		require.NoError(t, err)
-		require.Equal(t, q.Vms, 0)
+		require.Equal(t, q.Resources, Resources{})
 
 	})
 
@@ -514,7 +611,7 @@ func TestUpdateUserQuota(t *testing.T) {
 		quota := Quota{UserID: "1"}
 		err := db.CreateQuota(&quota)
 		require.NoError(t, err)
-		err = db.UpdateUserQuota("1", 0, 0)
+		err = db.UpdateUserQuota("1", Resources{})
 		require.NoError(t, err)
 	})
 }
@@ -663,6 +760,50 @@ func TestDeactivateVoucher(t *testing.T) {
 	})
 }
 
+func TestActivateVoucher(t *testing.T) {
+	db := setupDB(t)
+	err := db.CreateQuota(&Quota{UserID: "user", Resources: Resources{CRU: 4, MRU: 8, SRU: 100}})
+	require.NoError(t, err)
+	err = db.CreateVoucher(&Voucher{Voucher: "voucher", Resources: Resources{CRU: 2, MRU: 4, SRU: 50, PublicIPs: 1}, Approved: true})
+	require.NoError(t, err)
+
+	t.Run("voucher is added to the quota with its reservations", func(t *testing.T) {
+		err := db.ReserveQuota(&QuotaReservation{UserID: "user", Resources: Resources{CRU: 1, MRU: 2, SRU: 25}})
+		require.NoError(t, err)
+
+		err = db.ActivateVoucher("user", "voucher")
+		require.NoError(t, err)
+
+		quota, err := db.GetUserQuota("user")
+		require.NoError(t, err)
+		require.Equal(t, Resources{CRU: 5, MRU: 10, SRU: 125, PublicIPs: 1}, quota.Resources)
+	})
+
+	t.Run("voucher is used once", func(t *testing.T) {
+		err := db.ActivateVoucher("other", "voucher")
+		require.Equal(t, gorm.ErrRecordNotFound, err)
+
+		err = db.DeactivateVoucher("other", "voucher")
+		require.NoError(t, err)
+
+		v, err := db.GetVoucher("voucher")
+		require.NoError(t, err)
+		require.Equal(t, "user", v.UserID)
+	})
+
+	t.Run("user without quota", func(t *testing.T) {
+		err := db.CreateVoucher(&Voucher{Voucher: "voucher2", Approved: true})
+		require.NoError(t, err)
+
+		err = db.ActivateVoucher("other", "voucher2")
+		require.Equal(t, gorm.ErrRecordNotFound, err)
+
+		v, err := db.GetVoucher("voucher2")
+		require.NoError(t, err)
+		require.False(t, v.Used)
+	})
+}
+
 func TestCreateK8s(t *testing.T) {
 	db := setupDB(t)
 	k8s := K8sCluster{
@@ -946,3 +1087,780 @@ func TestGetNextLaunch(t *testing.T) {
 	require.NoError(t, err)
 	require.True(t, m.Launched)
 }
+
+func TestJobs(t *testing.T) {
+	db := setupDB(t)
+
+	job := Job{UserID: "user", Type: VMsType, Name: "vm", State: JobQueued}
+	err := db.CreateJob(&job)
+	require.NoError(t, err)
+
+	t.Run("get job", func(t *testing.T) {
+		j, err := db.GetJob(job.ID)
+		require.NoError(t, err)
+		require.Equal(t, JobQueued, j.State)
+		require.Nil(t, j.FinishedAt)
+
+		_, err = db.GetJob(job.ID + 1)
+		require.Equal(t, gorm.ErrRecordNotFound, err)
+	})
+
+	t.Run("update job", func(t *testing.T) {
+		err := db.UpdateJobState(job.ID, JobFailed, "failed")
+		require.NoError(t, err)
+
+		err = db.UpdateJobDeployment(job.ID, 5)
+		require.NoError(t, err)
+
+		j, err := db.GetJob(job.ID)
+		require.NoError(t, err)
+		require.Equal(t, JobFailed, j.State)
+		require.Equal(t, "failed", j.Error)
+		require.Equal(t, 5, j.DeploymentID)
+		require.NotNil(t, j.FinishedAt)
+
+		err = db.UpdateJobState(job.ID, JobQueued, "")
+		require.NoError(t, err)
+
+		j, err = db.GetJob(job.ID)
+		require.NoError(t, err)
+		require.Nil(t, j.FinishedAt)
+	})
+
+	t.Run("list jobs", func(t *testing.T) {
+		second := Job{UserID: "user", Type: K8sType, Name: "k8s", State: JobQueued}
+		err := db.CreateJob(&second)
+		require.NoError(t, err)
+
+		err = db.CreateJob(&Job{UserID: "other", Type: VMsType, Name: "other", State: JobQueued})
+		require.NoError(t, err)
+
+		jobs, err := db.ListJobs("user")
+		require.NoError(t, err)
+		require.Len(t, jobs, 2)
+		require.Equal(t, second.ID, jobs[0].ID)
+	})
+}
+
+func TestStartAndCancelJob(t *testing.T) {
+	db := setupDB(t)
+
+	job := Job{UserID: "user", Type: VMsType, Name: "vm", State: JobQueued}
+	err := db.CreateJob(&job)
+	require.NoError(t, err)
+
+	started, err := db.StartJob(job.ID)
+	require.NoError(t, err)
+	require.True(t, started)
+
+	canceled, err := db.CancelJob(job.ID)
+	require.NoError(t, err)
+	require.False(t, canceled)
+
+	err = db.UpdateJobState(job.ID, JobQueued, "")
+	require.NoError(t, err)
+
+	canceled, err = db.CancelJob(job.ID)
+	require.NoError(t, err)
+	require.True(t, canceled)
+
+	j, err := db.GetJob(job.ID)
+	require.NoError(t, err)
+	require.Equal(t, JobCanceled, j.State)
+	require.NotNil(t, j.FinishedAt)
+
+	started, err = db.StartJob(job.ID)
+	require.NoError(t, err)
+	require.False(t, started)
+}
+
+func TestQuotaReservation(t *testing.T) {
+	db := setupDB(t)
+
+	err := db.CreateQuota(&Quota{UserID: "user", Resources: Resources{CRU: 5, MRU: 10, SRU: 100, PublicIPs: 1}})
+	require.NoError(t, err)
+	err = db.CreateVoucher(&Voucher{UserID: "user", Voucher: "voucher", Resources: Resources{CRU: 5, MRU: 10, SRU: 100, PublicIPs: 1}, Used: true})
+	require.NoError(t, err)
+
+	job := Job{UserID: "user", Type: VMsType, Name: "vm", State: JobQueued}
+	reservation := QuotaReservation{UserID: "user", Resources: Resources{CRU: 3, MRU: 6, SRU: 75, PublicIPs: 1}}
+	err = db.CreateJobWithReservation(&job, &reservation)
+	require.NoError(t, err)
+	require.Equal(t, job.ID, reservation.JobID)
+
+	t.Run("reserved quota is not available", func(t *testing.T) {
+		usage, err := db.GetUserQuotaUsage("user")
+		require.NoError(t, err)
+		require.Equal(t, QuotaUsage{UserID: "user", Resources: Resources{CRU: 2, MRU: 4, SRU: 25}, Reserved: Resources{CRU: 3, MRU: 6, SRU: 75, PublicIPs: 1}}, usage)
+
+		second := Job{UserID: "user", Type: VMsType, Name: "vm2", State: JobQueued}
+		err = db.CreateJobWithReservation(&second, &QuotaReservation{UserID: "user", Resources: Resources{CRU: 1, MRU: 2, SRU: 50}})
+		require.Equal(t, ErrInsufficientQuota, err)
+
+		// the job isn't created without its reservation
+		jobs, err := db.ListJobs("user")
+		require.NoError(t, err)
+		require.Len(t, jobs, 1)
+	})
+
+	t.Run("release reservation", func(t *testing.T) {
+		r, err := db.GetJobQuotaReservation(job.ID)
+		require.NoError(t, err)
+		require.Equal(t, reservation.ID, r.ID)
+
+		err = db.ReleaseQuotaReservation(r.ID)
+		require.NoError(t, err)
+
+		// a released reservation isn't released twice
+		err = db.ReleaseQuotaReservation(r.ID)
+		require.Equal(t, gorm.ErrRecordNotFound, err)
+
+		quota, err := db.GetUserQuota("user")
+		require.NoError(t, err)
+		require.Equal(t, Quota{UserID: "user", Resources: Resources{CRU: 5, MRU: 10, SRU: 100, PublicIPs: 1}}, quota)
+	})
+
+	t.Run("commit reservation", func(t *testing.T) {
+		r := QuotaReservation{UserID: "user", Resources: Resources{CRU: 2, MRU: 4, SRU: 50}}
+		err := db.ReserveQuota(&r)
+		require.NoError(t, err)
+
+		err = db.CommitQuotaReservation(r.ID)
+		require.NoError(t, err)
+
+		usage, err := db.GetUserQuotaUsage("user")
+		require.NoError(t, err)
+		require.Equal(t, QuotaUsage{UserID: "user", Resources: Resources{CRU: 3, MRU: 6, SRU: 50, PublicIPs: 1}, Used: Resources{CRU: 2, MRU: 4, SRU: 50}}, usage)
+	})
+}
+
+func TestSessions(t *testing.T) {
+	db := setupDB(t)
+	newSession := func(t *testing.T, id string, hash string) {
+		s := Session{ID: id, UserID: "user", LastUsedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+		err := db.CreateSession(&s, &RefreshToken{TokenHash: []byte(hash)})
+		require.NoError(t, err)
+	}
+
+	t.Run("session not found", func(t *testing.T) {
+		_, err := db.GetSession("session")
+		require.Equal(t, err, gorm.ErrRecordNotFound)
+	})
+
+	t.Run("create and list sessions", func(t *testing.T) {
+		newSession(t, "first", "first hash")
+		newSession(t, "second", "second hash")
+
+		sessions, err := db.ListSessions("user")
+		require.NoError(t, err)
+		require.Len(t, sessions, 2)
+
+		token, err := db.GetRefreshToken([]byte("first hash"))
+		require.NoError(t, err)
+		require.Equal(t, "first", token.SessionID)
+	})
+
+	t.Run("rotate refresh token once", func(t *testing.T) {
+		token, err := db.GetRefreshToken([]byte("first hash"))
+		require.NoError(t, err)
+
+		rotated, err := db.RotateRefreshToken(token.ID, &RefreshToken{SessionID: "first", TokenHash: []byte("next hash")})
+		require.NoError(t, err)
+		require.True(t, rotated)
+
+		rotated, err = db.RotateRefreshToken(token.ID, &RefreshToken{SessionID: "first", TokenHash: []byte("other hash")})
+		require.NoError(t, err)
+		require.False(t, rotated)
+
+		token, err = db.GetRefreshToken([]byte("first hash"))
+		require.NoError(t, err)
+		require.NotNil(t, token.UsedAt)
+	})
+
+	t.Run("revoke session", func(t *testing.T) {
+		err := db.RevokeSession("first", "other user")
+		require.Equal(t, err, gorm.ErrRecordNotFound)
+
+		err = db.RevokeSession("first", "user")
+		require.NoError(t, err)
+
+		s, err := db.GetSession("first")
+		require.NoError(t, err)
+		require.False(t, s.Active())
+
+		sessions, err := db.ListSessions("user")
+		require.NoError(t, err)
+		require.Len(t, sessions, 1)
+	})
+
+	t.Run("revoke user sessions", func(t *testing.T) {
+		newSession(t, "third", "third hash")
+
+		err := db.RevokeUserSessions("user", "third")
+		require.NoError(t, err)
+
+		sessions, err := db.ListSessions("user")
+		require.NoError(t, err)
+		require.Len(t, sessions, 1)
+		require.Equal(t, "third", sessions[0].ID)
+
+		err = db.RevokeUserSessions("user", "")
+		require.NoError(t, err)
+
+		sessions, err = db.ListSessions("user")
+		require.NoError(t, err)
+		require.Empty(t, sessions)
+	})
+}
+
+func TestAccessTokens(t *testing.T) {
+	db := setupDB(t)
+	token := AccessToken{
+		UserID:    "user",
+		Name:      "ci",
+		TokenHash: []byte("hash"),
+		Scopes:    TokenScopes{ReadScope, DeployScope},
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+
+	t.Run("create and get token", func(t *testing.T) {
+		err := db.CreateAccessToken(&token)
+		require.NoError(t, err)
+
+		got, err := db.GetAccessToken([]byte("hash"))
+		require.NoError(t, err)
+		require.Equal(t, token.Scopes, got.Scopes)
+		require.True(t, got.Scopes.Has(DeployScope))
+		require.False(t, got.Scopes.Has(AdminScope))
+		require.False(t, got.Expired())
+	})
+
+	t.Run("touch token once per interval", func(t *testing.T) {
+		err := db.TouchAccessToken(token.ID, time.Hour)
+		require.NoError(t, err)
+
+		got, err := db.GetAccessToken([]byte("hash"))
+		require.NoError(t, err)
+		require.NotNil(t, got.LastUsedAt)
+
+		err = db.TouchAccessToken(token.ID, time.Hour)
+		require.NoError(t, err)
+
+		again, err := db.GetAccessToken([]byte("hash"))
+		require.NoError(t, err)
+		require.Equal(t, got.LastUsedAt.Unix(), again.LastUsedAt.Unix())
+	})
+
+	t.Run("list and delete tokens", func(t *testing.T) {
+		tokens, err := db.ListAccessTokens("user")
+		require.NoError(t, err)
+		require.Len(t, tokens, 1)
+
+		err = db.DeleteAccessToken(token.ID, "other user")
+		require.Equal(t, err, gorm.ErrRecordNotFound)
+
+		err = db.DeleteAccessToken(token.ID, "user")
+		require.NoError(t, err)
+
+		_, err = db.GetAccessToken([]byte("hash"))
+		require.Equal(t, err, gorm.ErrRecordNotFound)
+	})
+}
+
+func TestTwoFactor(t *testing.T) {
+	db := setupDB(t)
+	user := User{Name: "user", Email: "user@gmail.com"}
+	err := db.CreateUser(&user)
+	require.NoError(t, err)
+	userID := user.ID.String()
+
+	t.Run("enable without a secret", func(t *testing.T) {
+		err := db.EnableTOTP(userID, 1, nil)
+		require.Equal(t, err, gorm.ErrRecordNotFound)
+	})
+
+	t.Run("enable", func(t *testing.T) {
+		err := db.SetTOTPSecret(userID, "secret")
+		require.NoError(t, err)
+
+		err = db.EnableTOTP(userID, 10, []RecoveryCode{{HashedCode: []byte("first")}, {HashedCode: []byte("second")}})
+		require.NoError(t, err)
+
+		u, err := db.GetUserByID(userID)
+		require.NoError(t, err)
+		require.True(t, u.TOTPEnabled)
+		require.Equal(t, "secret", u.TOTPSecret)
+
+		// the secret of an enabled user can't be replaced
+		err = db.SetTOTPSecret(userID, "other secret")
+		require.Equal(t, err, gorm.ErrRecordNotFound)
+	})
+
+	t.Run("steps are used once", func(t *testing.T) {
+		used, err := db.UseTOTPStep(userID, 10)
+		require.NoError(t, err)
+		require.False(t, used)
+
+		used, err = db.UseTOTPStep(userID, 11)
+		require.NoError(t, err)
+		require.True(t, used)
+	})
+
+	t.Run("recovery codes are used once", func(t *testing.T) {
+		used, err := db.UseRecoveryCode(userID, []byte("first"))
+		require.NoError(t, err)
+		require.True(t, used)
+
+		used, err = db.UseRecoveryCode(userID, []byte("first"))
+		require.NoError(t, err)
+		require.False(t, used)
+
+		count, err := db.CountRecoveryCodes(userID)
+		require.NoError(t, err)
+		require.Equal(t, int64(1), count)
+
+		err = db.ReplaceRecoveryCodes(userID, []RecoveryCode{{HashedCode: []byte("third")}})
+		require.NoError(t, err)
+
+		used, err = db.UseRecoveryCode(userID, []byte("second"))
+		require.NoError(t, err)
+		require.False(t, used)
+	})
+
+	t.Run("disable", func(t *testing.T) {
+		err := db.DisableTOTP(userID)
+		require.NoError(t, err)
+
+		u, err := db.GetUserByID(userID)
+		require.NoError(t, err)
+		require.False(t, u.TOTPEnabled)
+		require.Empty(t, u.TOTPSecret)
+
+		count, err := db.CountRecoveryCodes(userID)
+		require.NoError(t, err)
+		require.Zero(t, count)
+	})
+}
+
+func TestTransaction(t *testing.T) {
+	db := setupDB(t)
+
+	t.Run("rolled back on error", func(t *testing.T) {
+		err := db.Transaction(func(tx Store) error {
+			if err := tx.CreateVM(&VM{UserID: "user", Name: "vm"}); err != nil {
+				return err
+			}
+			return tx.CreateVM(&VM{UserID: "user", Name: "vm"})
+		})
+		require.Error(t, err)
+
+		vms, err := db.GetAllVms("user")
+		require.NoError(t, err)
+		require.Empty(t, vms)
+	})
+
+	t.Run("committed", func(t *testing.T) {
+		err := db.Transaction(func(tx Store) error {
+			return tx.CreateVM(&VM{UserID: "user", Name: "vm"})
+		})
+		require.NoError(t, err)
+
+		vms, err := db.GetAllVms("user")
+		require.NoError(t, err)
+		require.Len(t, vms, 1)
+	})
+}
+
+func TestCompensations(t *testing.T) {
+	db := setupDB(t)
+
+	err := db.CreateCompensation(&Compensation{UserID: "user", Type: VMsType, Name: "vm", ContractID: 2, NetworkContractID: 1, Canceled: true})
+	require.NoError(t, err)
+	err = db.CreateCompensation(&Compensation{UserID: "user", Type: K8sType, Name: "k8s", ContractID: 4, CancelError: "grid is down"})
+	require.NoError(t, err)
+
+	compensations, err := db.ListCompensations()
+	require.NoError(t, err)
+	require.Len(t, compensations, 2)
+	require.Equal(t, "k8s", compensations[0].Name)
+	require.False(t, compensations[0].Canceled)
+}
+
+func TestNotifications(t *testing.T) {
+	db := setupDB(t)
+
+	for i, nType := range []string{VMsType, K8sType, VoucherType, VMsType} {
+		err := db.CreateNotification(&Notification{UserID: "user", Msg: fmt.Sprint(i), Type: nType, Severity: SeverityInfo})
+		require.NoError(t, err)
+	}
+	err := db.CreateNotification(&Notification{UserID: "other", Msg: "other", Type: VMsType})
+	require.NoError(t, err)
+
+	t.Run("list pages newest first", func(t *testing.T) {
+		page, err := db.ListNotifications("user", NotificationsFilter{Limit: 3})
+		require.NoError(t, err)
+		require.Len(t, page, 3)
+		require.Equal(t, "3", page[0].Msg)
+		require.False(t, page[0].CreatedAt.IsZero())
+
+		page, err = db.ListNotifications("user", NotificationsFilter{Cursor: page[2].ID, Limit: 3})
+		require.NoError(t, err)
+		require.Len(t, page, 1)
+		require.Equal(t, "0", page[0].Msg)
+	})
+
+	t.Run("list by types", func(t *testing.T) {
+		notifications, err := db.ListNotifications("user", NotificationsFilter{Types: []string{K8sType, VoucherType}})
+		require.NoError(t, err)
+		require.Len(t, notifications, 2)
+	})
+
+	t.Run("update is owned", func(t *testing.T) {
+		notifications, err := db.ListNotifications("other", NotificationsFilter{})
+		require.NoError(t, err)
+
+		err = db.UpdateNotification(notifications[0].ID, "user", true)
+		require.Equal(t, gorm.ErrRecordNotFound, err)
+
+		err = db.UpdateNotification(notifications[0].ID, "other", true)
+		require.NoError(t, err)
+
+		count, err := db.CountUnreadNotifications("other")
+		require.NoError(t, err)
+		require.Zero(t, count)
+	})
+
+	t.Run("read all and list unread", func(t *testing.T) {
+		count, err := db.CountUnreadNotifications("user")
+		require.NoError(t, err)
+		require.Equal(t, int64(4), count)
+
+		err = db.UpdateAllNotifications("user", true)
+		require.NoError(t, err)
+
+		unread, err := db.ListNotifications("user", NotificationsFilter{Unread: true})
+		require.NoError(t, err)
+		require.Empty(t, unread)
+	})
+
+	t.Run("delete is owned", func(t *testing.T) {
+		notifications, err := db.ListNotifications("user", NotificationsFilter{})
+		require.NoError(t, err)
+
+		err = db.DeleteNotification(notifications[0].ID, "other")
+		require.Equal(t, gorm.ErrRecordNotFound, err)
+
+		err = db.DeleteNotification(notifications[0].ID, "user")
+		require.NoError(t, err)
+
+		left, err := db.ListNotifications("user", NotificationsFilter{})
+		require.NoError(t, err)
+		require.Len(t, left, 3)
+	})
+}
+
+func TestSingleSignOn(t *testing.T) {
+	db := setupDB(t)
+
+	t.Run("consume login once", func(t *testing.T) {
+		expired := OIDCLogin{StateHash: []byte("expired"), Provider: "test", ExpiresAt: time.Now().Add(-time.Minute)}
+		err := db.CreateOIDCLogin(&expired)
+		require.NoError(t, err)
+
+		login := OIDCLogin{StateHash: []byte("state"), Provider: "test", Nonce: "nonce", ExpiresAt: time.Now().Add(time.Minute)}
+		err = db.CreateOIDCLogin(&login)
+		require.NoError(t, err)
+
+		// expired logins are deleted with new ones
+		_, err = db.ConsumeOIDCLogin([]byte("expired"))
+		require.Equal(t, err, gorm.ErrRecordNotFound)
+
+		got, err := db.ConsumeOIDCLogin([]byte("state"))
+		require.NoError(t, err)
+		require.Equal(t, "nonce", got.Nonce)
+
+		_, err = db.ConsumeOIDCLogin([]byte("state"))
+		require.Equal(t, err, gorm.ErrRecordNotFound)
+	})
+
+	t.Run("identities", func(t *testing.T) {
+		_, err := db.GetUserIdentity("test", "subject")
+		require.Equal(t, err, gorm.ErrRecordNotFound)
+
+		err = db.CreateUserIdentity(&UserIdentity{UserID: "user", Provider: "test", Subject: "subject"})
+		require.NoError(t, err)
+
+		got, err := db.GetUserIdentity("test", "subject")
+		require.NoError(t, err)
+		require.Equal(t, "user", got.UserID)
+
+		err = db.CreateUserIdentity(&UserIdentity{UserID: "other", Provider: "test", Subject: "subject"})
+		require.Error(t, err)
+	})
+
+	t.Run("claim unverified user", func(t *testing.T) {
+		user := User{Name: "user", Email: "user@gmail.com", HashedPassword: []byte("password"), College: "college"}
+		err := db.CreateUser(&user)
+		require.NoError(t, err)
+
+		err = db.ClaimUnverifiedUser(user.ID.String(), "name", "university")
+		require.NoError(t, err)
+
+		got, err := db.GetUserByID(user.ID.String())
+		require.NoError(t, err)
+		require.True(t, got.Verified)
+		require.Empty(t, got.HashedPassword)
+		require.Equal(t, "name", got.Name)
+		require.Equal(t, "university", got.College)
+
+		// verified users can't be claimed
+		err = db.ClaimUnverifiedUser(user.ID.String(), "other", "other")
+		require.Equal(t, err, gorm.ErrRecordNotFound)
+	})
+}
+
+func TestRoles(t *testing.T) {
+	db := setupDB(t)
+
+	admin := User{Name: "admin", Email: "admin@gmail.com", Verified: true, Admin: true}
+	err := db.CreateUser(&admin)
+	require.NoError(t, err)
+
+	reviewer := User{Name: "reviewer", Email: "reviewer@gmail.com", Verified: true}
+	err = db.CreateUser(&reviewer)
+	require.NoError(t, err)
+
+	student := User{Name: "student", Email: "student@gmail.com", Verified: true}
+	err = db.CreateUser(&student)
+	require.NoError(t, err)
+
+	t.Run("admins are superadmins", func(t *testing.T) {
+		roles, err := db.ListUserRoles(admin.ID.String())
+		require.NoError(t, err)
+		require.Equal(t, []Role{SuperAdminRole}, roles)
+		require.True(t, HasPermission(roles, ManageRolesPermission))
+	})
+
+	t.Run("add and remove roles", func(t *testing.T) {
+		added, err := db.AddUserRole(reviewer.ID.String(), VoucherReviewerRole)
+		require.NoError(t, err)
+		require.True(t, added)
+
+		added, err = db.AddUserRole(reviewer.ID.String(), VoucherReviewerRole)
+		require.NoError(t, err)
+		require.False(t, added)
+
+		_, err = db.AddUserRole(reviewer.ID.String(), InstructorRole)
+		require.NoError(t, err)
+
+		roles, err := db.ListUserRoles(reviewer.ID.String())
+		require.NoError(t, err)
+		require.Equal(t, []Role{InstructorRole, VoucherReviewerRole}, roles)
+		require.True(t, HasPermission(roles, ReviewVouchersPermission))
+		require.False(t, HasPermission(roles, DeleteDeploymentsPermission))
+
+		err = db.RemoveUserRole(reviewer.ID.String(), InstructorRole)
+		require.NoError(t, err)
+
+		err = db.RemoveUserRole(reviewer.ID.String(), InstructorRole)
+		require.Equal(t, err, gorm.ErrRecordNotFound)
+	})
+
+	t.Run("list staff", func(t *testing.T) {
+		staff, err := db.ListStaff()
+		require.NoError(t, err)
+		require.Len(t, staff, 2)
+		require.Equal(t, "admin", staff[0].User.Name)
+		require.Equal(t, []Role{SuperAdminRole}, staff[0].Roles)
+		require.Equal(t, "reviewer", staff[1].User.Name)
+		require.Equal(t, []Role{VoucherReviewerRole}, staff[1].Roles)
+	})
+
+	t.Run("no roles", func(t *testing.T) {
+		roles, err := db.ListUserRoles(student.ID.String())
+		require.NoError(t, err)
+		require.Empty(t, roles)
+		require.False(t, HasPermission(roles, ReadUsersPermission))
+	})
+
+	t.Run("the last superadmin can't be removed", func(t *testing.T) {
+		err := db.UpdateAdminUserByID(reviewer.ID.String(), true)
+		require.NoError(t, err)
+
+		err = db.UpdateAdminUserByID(admin.ID.String(), false)
+		require.NoError(t, err)
+
+		err = db.UpdateAdminUserByID(reviewer.ID.String(), false)
+		require.Equal(t, ErrLastSuperAdmin, err)
+
+		roles, err := db.ListRolesOfUser(User{ID: reviewer.ID, Admin: true})
+		require.NoError(t, err)
+		require.Equal(t, []Role{SuperAdminRole, VoucherReviewerRole}, roles)
+
+		got, err := db.GetUserByID(reviewer.ID.String())
+		require.NoError(t, err)
+		require.True(t, got.Admin)
+	})
+}
+
+func TestAuditEvents(t *testing.T) {
+	db := setupDB(t)
+
+	events := []AuditEvent{
+		{ActorEmail: "admin@gmail.com", Action: ReviewVoucherAction, Target: "voucher 1", Before: `{"approved":false}`, After: `{"approved":true}`},
+		{ActorEmail: "admin@gmail.com", Action: ResetQuotaAction},
+		{ActorEmail: "reviewer@gmail.com", Action: ReviewVoucherAction, Target: "voucher 2"},
+	}
+	for i := range events {
+		err := db.CreateAuditEvent(&events[i])
+		require.NoError(t, err)
+	}
+
+	t.Run("list with filters", func(t *testing.T) {
+		got, err := db.ListAuditEvents(AuditFilter{})
+		require.NoError(t, err)
+		require.Len(t, got, 3)
+		require.Equal(t, "voucher 2", got[0].Target)
+
+		got, err = db.ListAuditEvents(AuditFilter{Action: ReviewVoucherAction, ActorEmail: "admin@gmail.com"})
+		require.NoError(t, err)
+		require.Len(t, got, 1)
+		require.Equal(t, `{"approved":true}`, got[0].After)
+
+		got, err = db.ListAuditEvents(AuditFilter{Limit: 1, Offset: 1})
+		require.NoError(t, err)
+		require.Len(t, got, 1)
+		require.Equal(t, ResetQuotaAction, got[0].Action)
+
+		got, err = db.ListAuditEvents(AuditFilter{From: time.Now().Add(time.Hour)})
+		require.NoError(t, err)
+		require.Empty(t, got)
+	})
+
+	t.Run("events are append only", func(t *testing.T) {
+		err := db.db.Model(&events[0]).Update("target", "changed").Error
+		require.ErrorIs(t, err, ErrAuditEventsAppendOnly)
+
+		err = db.db.Delete(&events[0]).Error
+		require.ErrorIs(t, err, ErrAuditEventsAppendOnly)
+
+		got, err := db.ListAuditEvents(AuditFilter{})
+		require.NoError(t, err)
+		require.Len(t, got, 3)
+	})
+}
+
+func TestTeams(t *testing.T) {
+	db := setupDB(t)
+
+	owner := User{Name: "owner", Email: "owner@gmail.com", SSHKey: "owner-key"}
+	err := db.CreateUser(&owner)
+	require.NoError(t, err)
+	member := User{Name: "member", Email: "member@gmail.com", SSHKey: "member-key"}
+	err = db.CreateUser(&member)
+	require.NoError(t, err)
+
+	team := Team{Name: "team", OwnerID: owner.ID.String()}
+	err = db.CreateTeam(&team)
+	require.NoError(t, err)
+
+	t.Run("owner is a member", func(t *testing.T) {
+		m, err := db.GetTeamMember(team.ID, owner.ID.String())
+		require.NoError(t, err)
+		require.Equal(t, TeamOwnerRole, m.Role)
+
+		teams, err := db.ListUserTeams(owner.ID.String())
+		require.NoError(t, err)
+		require.Len(t, teams, 1)
+		require.Equal(t, TeamOwnerRole, teams[0].Role)
+		require.Equal(t, "team", teams[0].Name)
+	})
+
+	t.Run("invite and accept", func(t *testing.T) {
+		invitation := TeamInvitation{TeamID: team.ID, TeamName: team.Name, Email: member.Email, InvitedBy: owner.Email}
+		created, err := db.CreateTeamInvitation(&invitation)
+		require.NoError(t, err)
+		require.True(t, created)
+
+		created, err = db.CreateTeamInvitation(&TeamInvitation{TeamID: team.ID, TeamName: team.Name, Email: member.Email})
+		require.NoError(t, err)
+		require.False(t, created)
+
+		invitations, err := db.ListEmailInvitations(member.Email)
+		require.NoError(t, err)
+		require.Len(t, invitations, 1)
+
+		err = db.AcceptTeamInvitation(invitation.ID, member.ID.String())
+		require.NoError(t, err)
+
+		// an accepted invitation isn't accepted twice
+		err = db.AcceptTeamInvitation(invitation.ID, member.ID.String())
+		require.Equal(t, gorm.ErrRecordNotFound, err)
+
+		members, err := db.ListTeamMembers(team.ID)
+		require.NoError(t, err)
+		require.Len(t, members, 2)
+		require.Equal(t, owner.Email, members[0].Email)
+		require.Equal(t, TeamMemberRole, members[1].Role)
+		require.Equal(t, "member-key", members[1].SSHKey)
+	})
+
+	t.Run("team quota", func(t *testing.T) {
+		err := db.CreateVoucher(&Voucher{Voucher: "voucher", Resources: Resources{CRU: 5, MRU: 10, SRU: 100, PublicIPs: 1}, Approved: true})
+		require.NoError(t, err)
+
+		err = db.ActivateTeamVoucher(team.ID, member.ID.String(), "voucher")
+		require.NoError(t, err)
+
+		err = db.ActivateTeamVoucher(team.ID, member.ID.String(), "voucher")
+		require.Equal(t, gorm.ErrRecordNotFound, err)
+
+		r := QuotaReservation{UserID: member.ID.String(), TeamID: team.ID, Resources: Resources{CRU: 3, MRU: 6, SRU: 75, PublicIPs: 1}}
+		err = db.ReserveQuota(&r)
+		require.NoError(t, err)
+
+		got, err := db.GetTeam(team.ID)
+		require.NoError(t, err)
+		require.Equal(t, Resources{CRU: 2, MRU: 4, SRU: 25}, got.Resources)
+
+		err = db.DeleteTeam(team.ID)
+		require.Equal(t, ErrTeamHasDeployments, err)
+
+		err = db.ReleaseQuotaReservation(r.ID)
+		require.NoError(t, err)
+
+		got, err = db.GetTeam(team.ID)
+		require.NoError(t, err)
+		require.Equal(t, Resources{CRU: 5, MRU: 10, SRU: 100, PublicIPs: 1}, got.Resources)
+	})
+
+	t.Run("removed member deployments are given to the owner", func(t *testing.T) {
+		err := db.CreateVM(&VM{UserID: member.ID.String(), TeamID: team.ID, Name: "vm"})
+		require.NoError(t, err)
+
+		err = db.RemoveTeamMember(team.ID, owner.ID.String())
+		require.Equal(t, gorm.ErrRecordNotFound, err)
+
+		err = db.RemoveTeamMember(team.ID, member.ID.String())
+		require.NoError(t, err)
+
+		vms, err := db.GetTeamVms(team.ID)
+		require.NoError(t, err)
+		require.Len(t, vms, 1)
+		require.Equal(t, owner.ID.String(), vms[0].UserID)
+
+		err = db.DeleteVMByID(vms[0].ID)
+		require.NoError(t, err)
+	})
+
+	t.Run("delete team", func(t *testing.T) {
+		err := db.DeleteTeam(team.ID)
+		require.NoError(t, err)
+
+		_, err = db.GetTeamMember(team.ID, owner.ID.String())
+		require.Equal(t, gorm.ErrRecordNotFound, err)
+
+		err = db.DeleteTeam(team.ID)
+		require.Equal(t, gorm.ErrRecordNotFound, err)
+	})
+}
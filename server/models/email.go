@@ -0,0 +1,45 @@
+// Package models for database models
+package models
+
+import "time"
+
+// EmailState is the delivery state of an email in the outbox
+type EmailState string
+
+const (
+	// EmailQueued the email is waiting to be sent
+	EmailQueued EmailState = "queued"
+	// EmailSending the email is claimed by a worker to be sent
+	EmailSending EmailState = "sending"
+	// EmailSent the email is sent
+	EmailSent EmailState = "sent"
+	// EmailFailed the email failed all attempts
+	EmailFailed EmailState = "failed"
+)
+
+// Email struct holds an email of the outbox, its body isn't listed as it may hold secrets
+type Email struct {
+	ID       int    `json:"id" gorm:"primaryKey"`
+	Receiver string `json:"receiver"`
+	Subject  string `json:"subject"`
+	Body     string `json:"-"`
+	// Text is the plain text alternative of the html body
+	Text string `json:"-"`
+	// Sensitive emails hold secrets like verification codes, their bodies are purged once they are sent
+	Sensitive bool       `json:"sensitive" gorm:"not null;default:false"`
+	State     EmailState `json:"state" gorm:"index"`
+	Attempts  int        `json:"attempts"`
+	Error     string     `json:"error"`
+	// NextAttemptAt is when a queued email is due, or when the claim of a sending email expires
+	NextAttemptAt time.Time  `json:"next_attempt_at" gorm:"index"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	SentAt        *time.Time `json:"sent_at"`
+}
+
+// EmailsFilter filters and paginates the emails of the outbox
+type EmailsFilter struct {
+	States []EmailState
+	Limit  int
+	Offset int
+}
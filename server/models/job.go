@@ -0,0 +1,45 @@
+// Package models for database models
+package models
+
+import "time"
+
+// JobState is the state of a deployment job
+type JobState string
+
+const (
+	// JobQueued the request is waiting to be deployed
+	JobQueued JobState = "queued"
+	// JobPlacing a node is being chosen for the deployment
+	JobPlacing JobState = "placing"
+	// JobDeploying the deployment is being deployed on the grid
+	JobDeploying JobState = "deploying"
+	// JobVerifying the deployment is being loaded from the grid
+	JobVerifying JobState = "verifying"
+	// JobSucceeded the deployment is deployed
+	JobSucceeded JobState = "succeeded"
+	// JobFailed the deployment failed
+	JobFailed JobState = "failed"
+	// JobCanceled the request was canceled before it was deployed
+	JobCanceled JobState = "canceled"
+)
+
+// Finished returns true if the job will not change anymore
+func (s JobState) Finished() bool {
+	return s == JobSucceeded || s == JobFailed || s == JobCanceled
+}
+
+// Job struct holds the progress of a deployment request
+type Job struct {
+	ID     int    `json:"id" gorm:"primaryKey"`
+	UserID string `json:"user_id"`
+	// Type of the deployment, vms or k8s
+	Type  string   `json:"type"`
+	Name  string   `json:"name"`
+	State JobState `json:"state"`
+	Error string   `json:"error"`
+	// DeploymentID is the id of the vm or k8s cluster after it succeeds
+	DeploymentID int        `json:"deployment_id"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+	FinishedAt   *time.Time `json:"finished_at"`
+}
@@ -9,6 +9,8 @@ type K8sCluster struct {
 	ClusterContract int      `json:"contract_id"`
 	Master          Master   `json:"master" gorm:"foreignKey:ClusterID"`
 	Workers         []Worker `json:"workers" gorm:"foreignKey:ClusterID"`
+	// TeamID is the team owning the cluster, 0 if it is owned by its user only
+	TeamID int `json:"team_id" gorm:"not null;default:0;index"`
 }
 
 // Master struct for kubernetes master data
@@ -0,0 +1,441 @@
+// Package models for database models
+package models
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migration is a numbered schema change with its rollback
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// SchemaMigration records an applied migration
+type SchemaMigration struct {
+	Version   int       `json:"version" gorm:"primaryKey;autoIncrement:false"`
+	Name      string    `json:"name"`
+	AppliedAt time.Time `json:"applied_at"`
+}
+
+// TableName of applied migrations
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// MigrationStatus holds a migration and whether it is applied or not
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+// migrations are applied in order of their versions, applied versions must never be edited.
+// A migration can run on a database created before migrations were introduced so it should be
+// safe to run on tables that already exist. Migrations use the frozen schemas of their version
+// and never the models, which change with later versions.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create tables",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&v1User{}, &v1Quota{}, &v1VM{}, &v1K8sCluster{}, &v1Master{}, &v1Worker{}, &v1Voucher{}, &v1Maintenance{}, &v1Notification{}, &v1NextLaunch{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&v1User{}, &v1Quota{}, &v1VM{}, &v1K8sCluster{}, &v1Master{}, &v1Worker{}, &v1Voucher{}, &v1Maintenance{}, &v1Notification{}, &v1NextLaunch{})
+		},
+	},
+	{
+		Version: 2,
+		Name:    "add maintenance and next launch settings",
+		Up: func(tx *gorm.DB) error {
+			// keep the settings if they already exist
+			if err := tx.FirstOrCreate(&v1Maintenance{}).Error; err != nil {
+				return err
+			}
+			return tx.FirstOrCreate(&v1NextLaunch{}, v1NextLaunch{Launched: true}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Delete(&v1Maintenance{}, "1 = 1").Error; err != nil {
+				return err
+			}
+			return tx.Delete(&v1NextLaunch{}, "1 = 1").Error
+		},
+	},
+	{
+		Version: 3,
+		Name:    "create jobs",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&v3Job{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&v3Job{})
+		},
+	},
+	{
+		Version: 4,
+		Name:    "create quota reservations",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&v4QuotaReservation{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&v4QuotaReservation{})
+		},
+	},
+	{
+		Version: 5,
+		Name:    "create compensations",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&v5Compensation{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&v5Compensation{})
+		},
+	},
+	{
+		Version: 6,
+		Name:    "add notification timestamps, severity and payload",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&v6Notification{}); err != nil {
+				return err
+			}
+			// notifications created before have no timestamp
+			return tx.Model(&v6Notification{}).Where("created_at IS NULL").Update("created_at", time.Now()).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			for _, column := range []string{"severity", "payload_deployment_id", "payload_voucher_id", "created_at"} {
+				if tx.Migrator().HasColumn(&v6Notification{}, column) {
+					if err := tx.Migrator().DropColumn(&v6Notification{}, column); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 7,
+		Name:    "create email outbox",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&v7Email{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&v7Email{})
+		},
+	},
+	{
+		Version: 8,
+		Name:    "add plain text alternative of emails",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&v8Email{})
+		},
+		Down: func(tx *gorm.DB) error {
+			if !tx.Migrator().HasColumn(&v8Email{}, "text") {
+				return nil
+			}
+			return tx.Migrator().DropColumn(&v8Email{}, "text")
+		},
+	},
+	{
+		Version: 9,
+		Name:    "move verification codes to their own table",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&v9VerificationCode{}); err != nil {
+				return err
+			}
+			// codes sent before are plain text, users request new ones
+			if !tx.Migrator().HasColumn(&v1User{}, "code") {
+				return nil
+			}
+			return tx.Migrator().DropColumn(&v1User{}, "code")
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Exec("ALTER TABLE users ADD COLUMN code bigint").Error; err != nil {
+				return err
+			}
+			return tx.Migrator().DropTable(&v9VerificationCode{})
+		},
+	},
+	{
+		Version: 10,
+		Name:    "create sessions and refresh tokens",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&v10Session{}, &v10RefreshToken{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&v10Session{}, &v10RefreshToken{})
+		},
+	},
+	{
+		Version: 11,
+		Name:    "add two factor authentication",
+		Up: func(tx *gorm.DB) error {
+			for _, column := range []string{"TOTPSecret", "TOTPEnabled", "TOTPLastStep"} {
+				if tx.Migrator().HasColumn(&v11User{}, column) {
+					continue
+				}
+				if err := tx.Migrator().AddColumn(&v11User{}, column); err != nil {
+					return err
+				}
+			}
+			return tx.AutoMigrate(&v11RecoveryCode{})
+		},
+		Down: func(tx *gorm.DB) error {
+			for _, column := range []string{"TOTPSecret", "TOTPEnabled", "TOTPLastStep"} {
+				if err := tx.Migrator().DropColumn(&v11User{}, column); err != nil {
+					return err
+				}
+			}
+			return tx.Migrator().DropTable(&v11RecoveryCode{})
+		},
+	},
+	{
+		Version: 12,
+		Name:    "create personal access tokens",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&v12AccessToken{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&v12AccessToken{})
+		},
+	},
+	{
+		Version: 13,
+		Name:    "create oidc logins and user identities",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&v13OIDCLogin{}, &v13UserIdentity{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&v13OIDCLogin{}, &v13UserIdentity{})
+		},
+	},
+	{
+		Version: 14,
+		Name:    "create user roles",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&v14UserRole{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&v14UserRole{})
+		},
+	},
+	{
+		Version: 15,
+		Name:    "create audit events",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&v15AuditEvent{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&v15AuditEvent{})
+		},
+	},
+	{
+		Version: 16,
+		Name:    "create teams and add team owners of quota and deployments",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&v16Team{}, &v16TeamMember{}, &v16TeamInvitation{}, &v16VM{}, &v16K8sCluster{}, &v16Voucher{}, &v16QuotaReservation{}, &v16Notification{})
+		},
+		Down: func(tx *gorm.DB) error {
+			for _, model := range []interface{}{&v16VM{}, &v16K8sCluster{}} {
+				if tx.Migrator().HasIndex(model, "TeamID") {
+					if err := tx.Migrator().DropIndex(model, "TeamID"); err != nil {
+						return err
+					}
+				}
+			}
+			columns := map[interface{}]string{
+				&v16VM{}:               "team_id",
+				&v16K8sCluster{}:       "team_id",
+				&v16Voucher{}:          "team_id",
+				&v16QuotaReservation{}: "team_id",
+				&v16Notification{}:     "payload_team_id",
+			}
+			for model, column := range columns {
+				if tx.Migrator().HasColumn(model, column) {
+					if err := tx.Migrator().DropColumn(model, column); err != nil {
+						return err
+					}
+				}
+			}
+			return tx.Migrator().DropTable(&v16Team{}, &v16TeamMember{}, &v16TeamInvitation{})
+		},
+	},
+	{
+		Version: 17,
+		Name:    "count quota in resources instead of vm units",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&v17Quota{}, &v17QuotaReservation{}, &v17Voucher{}, &v17Team{}); err != nil {
+				return err
+			}
+			// units are converted at the rate of the large vm, 3 units for 4 vCPU, 8 GB memory and 100 GB disk,
+			// rounded up so every vm that fit the units before still fits
+			for _, model := range []interface{}{&v17Quota{}, &v17QuotaReservation{}, &v17Voucher{}, &v17Team{}} {
+				if !tx.Migrator().HasColumn(model, "vms") {
+					continue
+				}
+				err := tx.Model(model).Where("1 = 1").UpdateColumns(map[string]interface{}{
+					"cru": gorm.Expr("(vms * 4 + 2) / 3"),
+					"mru": gorm.Expr("(vms * 8 + 2) / 3"),
+					"sru": gorm.Expr("(vms * 100 + 2) / 3"),
+				}).Error
+				if err != nil {
+					return err
+				}
+				if err := tx.Migrator().DropColumn(model, "vms"); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			// resources are converted back to the units all of them cover
+			for _, model := range []interface{}{&v17Quota{}, &v17QuotaReservation{}, &v17Voucher{}, &v17Team{}} {
+				stmt := &gorm.Statement{DB: tx}
+				if err := stmt.Parse(model); err != nil {
+					return err
+				}
+				if err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN vms bigint", stmt.Schema.Table)).Error; err != nil {
+					return err
+				}
+				err := tx.Model(model).Where("1 = 1").UpdateColumn("vms", gorm.Expr(
+					"CASE WHEN cru * 3 / 4 <= mru * 3 / 8 AND cru * 3 / 4 <= sru * 3 / 100 THEN cru * 3 / 4 "+
+						"WHEN mru * 3 / 8 <= sru * 3 / 100 THEN mru * 3 / 8 ELSE sru * 3 / 100 END",
+				)).Error
+				if err != nil {
+					return err
+				}
+				for _, column := range []string{"cru", "mru", "sru"} {
+					if err := tx.Migrator().DropColumn(model, column); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+	},
+}
+
+// Migrate applies all pending migrations
+func (d *DB) Migrate() error {
+	return d.MigrateUp(0)
+}
+
+// MigrateUp applies pending migrations in order, steps limits how many are applied and 0 applies all of them
+func (d *DB) MigrateUp(steps int) error {
+	applied, err := d.appliedMigrations()
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	for _, m := range sortedMigrations() {
+		if steps > 0 && count == steps {
+			break
+		}
+
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+
+		err := d.db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("failed to apply migration %d '%s': %w", m.Version, m.Name, err)
+		}
+		count++
+	}
+
+	return nil
+}
+
+// MigrateDown rolls back applied migrations starting from the latest, steps limits how many are
+// rolled back and 0 rolls back all of them
+func (d *DB) MigrateDown(steps int) error {
+	applied, err := d.appliedMigrations()
+	if err != nil {
+		return err
+	}
+
+	sorted := sortedMigrations()
+	count := 0
+	for i := len(sorted) - 1; i >= 0; i-- {
+		m := sorted[i]
+		if steps > 0 && count == steps {
+			break
+		}
+
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+
+		err := d.db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{}, m.Version).Error
+		})
+		if err != nil {
+			return fmt.Errorf("failed to roll back migration %d '%s': %w", m.Version, m.Name, err)
+		}
+		count++
+	}
+
+	return nil
+}
+
+// MigrationsStatus returns all migrations with their applied state
+func (d *DB) MigrationsStatus() ([]MigrationStatus, error) {
+	applied, err := d.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []MigrationStatus
+	for _, m := range sortedMigrations() {
+		status := MigrationStatus{Version: m.Version, Name: m.Name}
+		if a, ok := applied[m.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = &a.AppliedAt
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+func (d *DB) appliedMigrations() (map[int]SchemaMigration, error) {
+	if err := d.db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return nil, err
+	}
+
+	var res []SchemaMigration
+	if err := d.db.Find(&res).Error; err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]SchemaMigration, len(res))
+	for _, m := range res {
+		applied[m.Version] = m
+	}
+	return applied, nil
+}
+
+func sortedMigrations() []Migration {
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+	return sorted
+}
@@ -0,0 +1,449 @@
+// Package models for database models
+package models
+
+import "time"
+
+// The schemas below are frozen copies of the tables as each migration creates or changes them,
+// so applying a version always produces the same schema whatever the models look like later.
+// They must never be edited, a change of a model needs a new migration with its own schema.
+
+// v1 create tables
+
+type v1User struct {
+	ID             string `gorm:"primary_key; unique; type:uuid; column:id"`
+	Name           string
+	Email          string `gorm:"unique"`
+	HashedPassword []byte
+	UpdatedAt      time.Time
+	Code           int
+	SSHKey         string
+	Verified       bool
+	TeamSize       int
+	ProjectDesc    string
+	College        string
+	Admin          bool
+}
+
+func (v1User) TableName() string { return "users" }
+
+type v1Quota struct {
+	UserID    string
+	Vms       int
+	PublicIPs int
+}
+
+func (v1Quota) TableName() string { return "quota" }
+
+type v1VM struct {
+	ID                int `gorm:"primaryKey"`
+	UserID            string
+	Name              string `gorm:"unique"`
+	YggIP             string
+	MyceliumIP        string
+	Public            bool
+	PublicIP          string
+	Resources         string
+	SRU               uint64
+	CRU               uint64
+	MRU               uint64
+	ContractID        uint64
+	NetworkContractID uint64
+}
+
+func (v1VM) TableName() string { return "vms" }
+
+type v1K8sCluster struct {
+	ID              int `gorm:"primaryKey"`
+	UserID          string
+	NetworkContract int
+	ClusterContract int
+	Master          v1Master   `gorm:"foreignKey:ClusterID"`
+	Workers         []v1Worker `gorm:"foreignKey:ClusterID"`
+}
+
+func (v1K8sCluster) TableName() string { return "k8s_clusters" }
+
+type v1Master struct {
+	ClusterID  int
+	Name       string `gorm:"unique"`
+	CRU        uint64
+	MRU        uint64
+	SRU        uint64
+	YggIP      string
+	MyceliumIP string
+	Public     bool
+	PublicIP   string
+	Resources  string
+}
+
+func (v1Master) TableName() string { return "masters" }
+
+type v1Worker struct {
+	ClusterID  int
+	Name       string
+	CRU        uint64
+	MRU        uint64
+	SRU        uint64
+	YggIP      string
+	MyceliumIP string
+	Public     bool
+	PublicIP   string
+	Resources  string
+}
+
+func (v1Worker) TableName() string { return "workers" }
+
+type v1Voucher struct {
+	ID        int `gorm:"primaryKey"`
+	UserID    string
+	Voucher   string `gorm:"unique"`
+	VMs       int
+	PublicIPs int
+	Reason    string
+	Used      bool
+	Approved  bool
+	Rejected  bool
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (v1Voucher) TableName() string { return "vouchers" }
+
+type v1Maintenance struct {
+	ID        int `gorm:"primaryKey"`
+	Active    bool
+	UpdatedAt time.Time
+}
+
+func (v1Maintenance) TableName() string { return "maintenances" }
+
+type v1Notification struct {
+	ID     int `gorm:"primaryKey"`
+	UserID string
+	Msg    string
+	Seen   bool
+	Type   string
+}
+
+func (v1Notification) TableName() string { return "notifications" }
+
+type v1NextLaunch struct {
+	ID        int `gorm:"primaryKey"`
+	Launched  bool
+	UpdatedAt time.Time
+}
+
+func (v1NextLaunch) TableName() string { return "next_launches" }
+
+// v3 create jobs
+
+type v3Job struct {
+	ID           int `gorm:"primaryKey"`
+	UserID       string
+	Type         string
+	Name         string
+	State        string
+	Error        string
+	DeploymentID int
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	FinishedAt   *time.Time
+}
+
+func (v3Job) TableName() string { return "jobs" }
+
+// v4 create quota reservations
+
+type v4QuotaReservation struct {
+	ID        int `gorm:"primaryKey"`
+	UserID    string
+	JobID     int `gorm:"index"`
+	Vms       int
+	PublicIPs int
+	CreatedAt time.Time
+}
+
+func (v4QuotaReservation) TableName() string { return "quota_reservations" }
+
+// v5 create compensations
+
+type v5Compensation struct {
+	ID                int `gorm:"primaryKey"`
+	UserID            string
+	JobID             int
+	Type              string
+	Name              string
+	ContractID        uint64
+	NetworkContractID uint64
+	Error             string
+	Canceled          bool
+	CancelError       string
+	CreatedAt         time.Time
+}
+
+func (v5Compensation) TableName() string { return "compensations" }
+
+// v6 add notification timestamps, severity and payload
+
+type v6Notification struct {
+	UserID    string                `gorm:"index"`
+	Severity  string                `gorm:"column:severity"`
+	Payload   v6NotificationPayload `gorm:"embedded;embeddedPrefix:payload_"`
+	CreatedAt time.Time             `gorm:"column:created_at"`
+}
+
+type v6NotificationPayload struct {
+	DeploymentID int
+	VoucherID    int
+}
+
+func (v6Notification) TableName() string { return "notifications" }
+
+// v7 create email outbox
+
+type v7Email struct {
+	ID            int `gorm:"primaryKey"`
+	Receiver      string
+	Subject       string
+	Body          string
+	Sensitive     bool   `gorm:"not null;default:false"`
+	State         string `gorm:"index"`
+	Attempts      int
+	Error         string
+	NextAttemptAt time.Time `gorm:"index"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	SentAt        *time.Time
+}
+
+func (v7Email) TableName() string { return "emails" }
+
+// v8 add plain text alternative of emails
+
+type v8Email struct {
+	Text string
+}
+
+func (v8Email) TableName() string { return "emails" }
+
+// v9 move verification codes to their own table
+
+type v9VerificationCode struct {
+	ID         int    `gorm:"primaryKey"`
+	UserID     string `gorm:"uniqueIndex:idx_verification_codes_user_purpose"`
+	Purpose    string `gorm:"uniqueIndex:idx_verification_codes_user_purpose"`
+	HashedCode []byte
+	Attempts   int
+	ExpiresAt  time.Time
+	SentAt     time.Time
+}
+
+func (v9VerificationCode) TableName() string { return "verification_codes" }
+
+// v10 create sessions and refresh tokens
+
+type v10Session struct {
+	ID         string `gorm:"primaryKey"`
+	UserID     string `gorm:"index"`
+	UserAgent  string
+	IP         string
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+	ExpiresAt  time.Time
+	RevokedAt  *time.Time
+}
+
+func (v10Session) TableName() string { return "sessions" }
+
+type v10RefreshToken struct {
+	ID        int    `gorm:"primaryKey"`
+	SessionID string `gorm:"index"`
+	TokenHash []byte `gorm:"uniqueIndex"`
+	CreatedAt time.Time
+	UsedAt    *time.Time
+}
+
+func (v10RefreshToken) TableName() string { return "refresh_tokens" }
+
+// v11 add two factor authentication
+
+type v11User struct {
+	TOTPSecret   string
+	TOTPEnabled  bool
+	TOTPLastStep int64
+}
+
+func (v11User) TableName() string { return "users" }
+
+type v11RecoveryCode struct {
+	ID         int    `gorm:"primaryKey"`
+	UserID     string `gorm:"index"`
+	HashedCode []byte
+	UsedAt     *time.Time
+}
+
+func (v11RecoveryCode) TableName() string { return "recovery_codes" }
+
+// v12 create personal access tokens
+
+type v12AccessToken struct {
+	ID         int    `gorm:"primaryKey"`
+	UserID     string `gorm:"index"`
+	Name       string
+	Prefix     string
+	TokenHash  []byte `gorm:"uniqueIndex"`
+	Scopes     string `gorm:"type:text"`
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	LastUsedAt *time.Time
+}
+
+func (v12AccessToken) TableName() string { return "access_tokens" }
+
+// v13 create oidc logins and user identities
+
+type v13OIDCLogin struct {
+	ID           int    `gorm:"primaryKey"`
+	StateHash    []byte `gorm:"uniqueIndex"`
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    time.Time
+}
+
+func (v13OIDCLogin) TableName() string { return "o_id_c_logins" }
+
+type v13UserIdentity struct {
+	ID        int    `gorm:"primaryKey"`
+	UserID    string `gorm:"index"`
+	Provider  string `gorm:"uniqueIndex:idx_provider_subject"`
+	Subject   string `gorm:"uniqueIndex:idx_provider_subject"`
+	Email     string
+	CreatedAt time.Time
+}
+
+func (v13UserIdentity) TableName() string { return "user_identities" }
+
+// v14 create user roles
+
+type v14UserRole struct {
+	ID        int    `gorm:"primaryKey"`
+	UserID    string `gorm:"uniqueIndex:idx_user_role"`
+	Role      string `gorm:"uniqueIndex:idx_user_role"`
+	CreatedAt time.Time
+}
+
+func (v14UserRole) TableName() string { return "user_roles" }
+
+// v15 create audit events
+
+type v15AuditEvent struct {
+	ID         int    `gorm:"primaryKey"`
+	ActorID    string `gorm:"index"`
+	ActorEmail string
+	Action     string `gorm:"index"`
+	Target     string
+	Before     string `gorm:"type:text"`
+	After      string `gorm:"type:text"`
+	IP         string
+	Status     int
+	CreatedAt  time.Time `gorm:"index"`
+}
+
+func (v15AuditEvent) TableName() string { return "audit_events" }
+
+// v16 create teams and add team owners of quota and deployments
+
+type v16Team struct {
+	ID        int `gorm:"primaryKey"`
+	Name      string
+	OwnerID   string `gorm:"index"`
+	Vms       int
+	PublicIPs int
+	CreatedAt time.Time
+}
+
+func (v16Team) TableName() string { return "teams" }
+
+type v16TeamMember struct {
+	ID        int    `gorm:"primaryKey"`
+	TeamID    int    `gorm:"uniqueIndex:idx_team_member"`
+	UserID    string `gorm:"uniqueIndex:idx_team_member;index"`
+	Role      string
+	CreatedAt time.Time
+}
+
+func (v16TeamMember) TableName() string { return "team_members" }
+
+type v16TeamInvitation struct {
+	ID        int `gorm:"primaryKey"`
+	TeamID    int `gorm:"uniqueIndex:idx_team_invitation"`
+	TeamName  string
+	Email     string `gorm:"uniqueIndex:idx_team_invitation;index"`
+	InvitedBy string
+	CreatedAt time.Time
+}
+
+func (v16TeamInvitation) TableName() string { return "team_invitations" }
+
+type v16VM struct {
+	TeamID int `gorm:"not null;default:0;index"`
+}
+
+func (v16VM) TableName() string { return "vms" }
+
+type v16K8sCluster struct {
+	TeamID int `gorm:"not null;default:0;index"`
+}
+
+func (v16K8sCluster) TableName() string { return "k8s_clusters" }
+
+type v16Voucher struct {
+	TeamID int `gorm:"not null;default:0"`
+}
+
+func (v16Voucher) TableName() string { return "vouchers" }
+
+type v16QuotaReservation struct {
+	TeamID int `gorm:"not null;default:0"`
+}
+
+func (v16QuotaReservation) TableName() string { return "quota_reservations" }
+
+type v16Notification struct {
+	PayloadTeamID int `gorm:"column:payload_team_id"`
+}
+
+func (v16Notification) TableName() string { return "notifications" }
+
+// v17 count quota in resources instead of vm units
+
+type v17Resources struct {
+	CRU int `gorm:"column:cru;not null;default:0"`
+	MRU int `gorm:"column:mru;not null;default:0"`
+	SRU int `gorm:"column:sru;not null;default:0"`
+}
+
+type v17Quota struct {
+	Resources v17Resources `gorm:"embedded"`
+}
+
+func (v17Quota) TableName() string { return "quota" }
+
+type v17QuotaReservation struct {
+	Resources v17Resources `gorm:"embedded"`
+}
+
+func (v17QuotaReservation) TableName() string { return "quota_reservations" }
+
+type v17Voucher struct {
+	Resources v17Resources `gorm:"embedded"`
+}
+
+func (v17Voucher) TableName() string { return "vouchers" }
+
+type v17Team struct {
+	Resources v17Resources `gorm:"embedded"`
+}
+
+func (v17Team) TableName() string { return "teams" }
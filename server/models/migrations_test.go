@@ -0,0 +1,181 @@
+// Package models for database models
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrate(t *testing.T) {
+	db := setupDB(t)
+
+	t.Run("all migrations applied", func(t *testing.T) {
+		statuses, err := db.MigrationsStatus()
+		require.NoError(t, err)
+		require.Len(t, statuses, len(migrations))
+		for _, s := range statuses {
+			require.True(t, s.Applied)
+			require.NotNil(t, s.AppliedAt)
+		}
+	})
+
+	t.Run("settings are kept on migrate", func(t *testing.T) {
+		err := db.UpdateMaintenance(true)
+		require.NoError(t, err)
+		err = db.UpdateNextLaunch(false)
+		require.NoError(t, err)
+
+		err = db.Migrate()
+		require.NoError(t, err)
+
+		maintenance, err := db.GetMaintenance()
+		require.NoError(t, err)
+		require.True(t, maintenance.Active)
+
+		nextLaunch, err := db.GetNextLaunch()
+		require.NoError(t, err)
+		require.False(t, nextLaunch.Launched)
+	})
+
+	t.Run("settings are kept for databases without applied migrations", func(t *testing.T) {
+		err := db.db.Delete(&SchemaMigration{}, "1 = 1").Error
+		require.NoError(t, err)
+
+		err = db.Migrate()
+		require.NoError(t, err)
+
+		var count int64
+		err = db.db.Model(&Maintenance{}).Count(&count).Error
+		require.NoError(t, err)
+		require.Equal(t, int64(1), count)
+
+		maintenance, err := db.GetMaintenance()
+		require.NoError(t, err)
+		require.True(t, maintenance.Active)
+	})
+}
+
+func TestMigrateDown(t *testing.T) {
+	db := setupDB(t)
+
+	t.Run("roll back one migration", func(t *testing.T) {
+		err := db.MigrateDown(1)
+		require.NoError(t, err)
+
+		statuses, err := db.MigrationsStatus()
+		require.NoError(t, err)
+		require.False(t, statuses[len(statuses)-1].Applied)
+		require.Nil(t, statuses[len(statuses)-1].AppliedAt)
+		require.True(t, statuses[0].Applied)
+	})
+
+	t.Run("roll back all migrations", func(t *testing.T) {
+		err := db.MigrateDown(0)
+		require.NoError(t, err)
+
+		statuses, err := db.MigrationsStatus()
+		require.NoError(t, err)
+		for _, s := range statuses {
+			require.False(t, s.Applied)
+		}
+		require.False(t, db.db.Migrator().HasTable(&User{}))
+	})
+
+	t.Run("apply one migration", func(t *testing.T) {
+		err := db.MigrateUp(1)
+		require.NoError(t, err)
+
+		statuses, err := db.MigrationsStatus()
+		require.NoError(t, err)
+		require.True(t, statuses[0].Applied)
+		require.False(t, statuses[1].Applied)
+		require.True(t, db.db.Migrator().HasTable(&User{}))
+
+		_, err = db.GetMaintenance()
+		require.Error(t, err)
+	})
+
+	t.Run("apply pending migrations", func(t *testing.T) {
+		err := db.MigrateUp(0)
+		require.NoError(t, err)
+
+		maintenance, err := db.GetMaintenance()
+		require.NoError(t, err)
+		require.False(t, maintenance.Active)
+
+		nextLaunch, err := db.GetNextLaunch()
+		require.NoError(t, err)
+		require.True(t, nextLaunch.Launched)
+	})
+}
+
+func TestMigrateQuotaResources(t *testing.T) {
+	db := setupDB(t)
+
+	err := db.CreateQuota(&Quota{UserID: "large", Resources: Resources{CRU: 4, MRU: 8, SRU: 100, PublicIPs: 1}})
+	require.NoError(t, err)
+	err = db.CreateQuota(&Quota{UserID: "small", Resources: Resources{CRU: 1, MRU: 8, SRU: 100}})
+	require.NoError(t, err)
+
+	t.Run("resources are converted to the units all of them cover", func(t *testing.T) {
+		err := db.MigrateDown(1)
+		require.NoError(t, err)
+
+		var units []struct {
+			UserID string
+			Vms    int
+		}
+		err = db.db.Table("quota").Select("user_id, vms").Order("user_id").Scan(&units).Error
+		require.NoError(t, err)
+		require.Len(t, units, 2)
+		require.Equal(t, 3, units[0].Vms)
+		require.Equal(t, 0, units[1].Vms)
+	})
+
+	t.Run("units are converted to resources", func(t *testing.T) {
+		err := db.db.Table("quota").Where("user_id = ?", "small").Update("vms", 1).Error
+		require.NoError(t, err)
+
+		err = db.MigrateUp(0)
+		require.NoError(t, err)
+
+		quota, err := db.GetUserQuota("large")
+		require.NoError(t, err)
+		require.Equal(t, Resources{CRU: 4, MRU: 8, SRU: 100, PublicIPs: 1}, quota.Resources)
+
+		quota, err = db.GetUserQuota("small")
+		require.NoError(t, err)
+		require.Equal(t, Resources{CRU: 2, MRU: 3, SRU: 34}, quota.Resources)
+	})
+}
+
+func TestMigrationsSchemasAreFrozen(t *testing.T) {
+	db := setupDB(t)
+
+	err := db.MigrateDown(0)
+	require.NoError(t, err)
+
+	t.Run("versions before resources create vm units", func(t *testing.T) {
+		err := db.MigrateUp(16)
+		require.NoError(t, err)
+
+		for _, table := range []string{"quota", "vouchers", "quota_reservations", "teams"} {
+			require.True(t, db.db.Migrator().HasColumn(table, "vms"), table)
+			require.False(t, db.db.Migrator().HasColumn(table, "cru"), table)
+		}
+	})
+
+	t.Run("units are converted on the fresh database", func(t *testing.T) {
+		err := db.db.Exec("INSERT INTO quota (user_id, vms, public_ips) VALUES ('user', 3, 1)").Error
+		require.NoError(t, err)
+
+		err = db.MigrateUp(0)
+		require.NoError(t, err)
+
+		require.False(t, db.db.Migrator().HasColumn("quota", "vms"))
+		quota, err := db.GetUserQuota("user")
+		require.NoError(t, err)
+		require.Equal(t, Resources{CRU: 4, MRU: 8, SRU: 100, PublicIPs: 1}, quota.Resources)
+	})
+}
@@ -1,19 +1,62 @@
 // Package models for database models
 package models
 
+import "time"
+
 const (
 	// VMsType deployment
 	VMsType = "vms"
 	// K8sType deployment
 	K8sType = "k8s"
+	// VoucherType notifications of voucher requests
+	VoucherType = "voucher"
+	// AnnouncementType notifications of admins announcements
+	AnnouncementType = "announcement"
+	// EmailType notifications of emails sent by admins
+	EmailType = "email"
+	// TeamType notifications of team invitations
+	TeamType = "team"
+)
+
+// NotificationSeverity is how important a notification is
+type NotificationSeverity string
+
+const (
+	// SeverityInfo for informational notifications
+	SeverityInfo NotificationSeverity = "info"
+	// SeveritySuccess for notifications of succeeded actions
+	SeveritySuccess NotificationSeverity = "success"
+	// SeverityWarning for notifications that need the user's attention
+	SeverityWarning NotificationSeverity = "warning"
+	// SeverityError for notifications of failed actions
+	SeverityError NotificationSeverity = "error"
 )
 
 // Notification struct holds data of notifications
 type Notification struct {
 	ID     int    `json:"id" gorm:"primaryKey"`
-	UserID string `json:"user_id"  binding:"required"`
+	UserID string `json:"user_id" binding:"required" gorm:"index"`
 	Msg    string `json:"msg" binding:"required"`
 	Seen   bool   `json:"seen" binding:"required"`
 	// to allow redirecting from notifications to the right pages
-	Type string `json:"type" binding:"required"`
+	Type      string               `json:"type" binding:"required"`
+	Severity  NotificationSeverity `json:"severity"`
+	Payload   NotificationPayload  `json:"payload" gorm:"embedded;embeddedPrefix:payload_"`
+	CreatedAt time.Time            `json:"created_at"`
+}
+
+// NotificationPayload holds the ids of the objects a notification is about
+type NotificationPayload struct {
+	DeploymentID int `json:"deployment_id,omitempty"`
+	VoucherID    int `json:"voucher_id,omitempty"`
+	TeamID       int `json:"team_id,omitempty"`
+}
+
+// NotificationsFilter filters and paginates the notifications of a user
+type NotificationsFilter struct {
+	// Cursor is the id of the last notification of the previous page, 0 starts from the newest one
+	Cursor int
+	Limit  int
+	Types  []string
+	Unread bool
 }
@@ -0,0 +1,25 @@
+// Package models for database models
+package models
+
+import "time"
+
+// OIDCLogin struct holds a sign in started with an OpenID Connect provider until the provider redirects back,
+// the state is only stored hashed
+type OIDCLogin struct {
+	ID           int       `json:"id" gorm:"primaryKey"`
+	StateHash    []byte    `json:"-" gorm:"uniqueIndex"`
+	Provider     string    `json:"provider"`
+	Nonce        string    `json:"-"`
+	CodeVerifier string    `json:"-"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// UserIdentity struct links a user to an account of an OpenID Connect provider
+type UserIdentity struct {
+	ID        int       `json:"id" gorm:"primaryKey"`
+	UserID    string    `json:"-" gorm:"index"`
+	Provider  string    `json:"provider" gorm:"uniqueIndex:idx_provider_subject"`
+	Subject   string    `json:"-" gorm:"uniqueIndex:idx_provider_subject"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
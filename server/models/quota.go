@@ -1,9 +1,72 @@
 // Package models for database models
 package models
 
-// Quota struct holds available vms for each user
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInsufficientQuota is returned if a reservation needs more quota than available
+var ErrInsufficientQuota = errors.New("no available quota")
+
+// Resources struct holds an amount of the resources quota is counted in
+type Resources struct {
+	// CRU is the number of virtual cpus
+	CRU int `json:"cru" gorm:"column:cru;not null;default:0"`
+	// MRU is the memory in GB
+	MRU int `json:"mru" gorm:"column:mru;not null;default:0"`
+	// SRU is the disk in GB
+	SRU int `json:"sru" gorm:"column:sru;not null;default:0"`
+	// PublicIPs is the number of public IPv4 addresses
+	PublicIPs int `json:"public_ips"`
+}
+
+// Add returns the sum of the resources
+func (r Resources) Add(o Resources) Resources {
+	return Resources{CRU: r.CRU + o.CRU, MRU: r.MRU + o.MRU, SRU: r.SRU + o.SRU, PublicIPs: r.PublicIPs + o.PublicIPs}
+}
+
+// Sub returns the resources left after taking o from them, none of them is less than 0
+func (r Resources) Sub(o Resources) Resources {
+	return Resources{
+		CRU:       max(r.CRU-o.CRU, 0),
+		MRU:       max(r.MRU-o.MRU, 0),
+		SRU:       max(r.SRU-o.SRU, 0),
+		PublicIPs: max(r.PublicIPs-o.PublicIPs, 0),
+	}
+}
+
+// Fits checks if the resources are within the available ones
+func (r Resources) Fits(available Resources) bool {
+	return r.CRU <= available.CRU && r.MRU <= available.MRU && r.SRU <= available.SRU && r.PublicIPs <= available.PublicIPs
+}
+
+func (r Resources) String() string {
+	return fmt.Sprintf("%d vCPU, %d GB memory, %d GB disk and %d public IPs", r.CRU, r.MRU, r.SRU, r.PublicIPs)
+}
+
+// Quota struct holds the available resources of each user
 type Quota struct {
-	UserID    string `json:"user_id"`
-	Vms       int    `json:"vms"`
-	PublicIPs int    `json:"public_ips"`
+	UserID string `json:"user_id"`
+	Resources
+}
+
+// QuotaReservation holds the quota of a deployment request from its acceptance until it is deployed or fails
+type QuotaReservation struct {
+	ID     int    `json:"id" gorm:"primaryKey"`
+	UserID string `json:"user_id"`
+	JobID  int    `json:"job_id" gorm:"index"`
+	Resources
+	CreatedAt time.Time `json:"created_at"`
+	// TeamID is the team the quota is reserved from, 0 if it is reserved from the quota of its user
+	TeamID int `json:"team_id" gorm:"not null;default:0"`
+}
+
+// QuotaUsage struct holds the available, reserved and used quota of a user
+type QuotaUsage struct {
+	UserID string `json:"user_id"`
+	Resources
+	Reserved Resources `json:"reserved"`
+	Used     Resources `json:"used"`
 }
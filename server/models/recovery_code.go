@@ -0,0 +1,12 @@
+// Package models for database models
+package models
+
+import "time"
+
+// RecoveryCode struct holds a one time code to sign in without the authenticator, the code itself is only stored hashed
+type RecoveryCode struct {
+	ID         int        `json:"id" gorm:"primaryKey"`
+	UserID     string     `json:"user_id" gorm:"index"`
+	HashedCode []byte     `json:"-"`
+	UsedAt     *time.Time `json:"used_at"`
+}
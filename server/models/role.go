@@ -0,0 +1,146 @@
+// Package models for database models
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrLastSuperAdmin is returned on removing the superadmin role of the last user who has it
+var ErrLastSuperAdmin = errors.New("the last superadmin can't be removed")
+
+// Role is a set of permissions of the admin routes a user is given
+type Role string
+
+const (
+	// SuperAdminRole has every permission, it is the admin flag of a user
+	SuperAdminRole Role = "superadmin"
+	// VoucherReviewerRole reviews and generates vouchers
+	VoucherReviewerRole Role = "voucher-reviewer"
+	// SupportRole helps users with their deployments and emails
+	SupportRole Role = "support"
+	// InstructorRole follows the users and the deployments of a class
+	InstructorRole Role = "instructor"
+)
+
+// Permission allows a role to use admin routes
+type Permission string
+
+const (
+	// ReadUsersPermission lists users and their quota
+	ReadUsersPermission Permission = "users:read"
+	// ResetQuotaPermission resets the quota of all users
+	ResetQuotaPermission Permission = "quota:reset"
+	// ReadDeploymentsPermission lists the deployments of all users
+	ReadDeploymentsPermission Permission = "deployments:read"
+	// DeleteDeploymentsPermission deletes the deployments of all users
+	DeleteDeploymentsPermission Permission = "deployments:delete"
+	// ReadBalancePermission gets the balance of the deployments account
+	ReadBalancePermission Permission = "balance:read"
+	// ManageMaintenancePermission updates the maintenance and the next launch
+	ManageMaintenancePermission Permission = "maintenance:manage"
+	// SendAnnouncementsPermission sends announcements and emails to users
+	SendAnnouncementsPermission Permission = "announcements:send"
+	// ReadVouchersPermission lists vouchers
+	ReadVouchersPermission Permission = "vouchers:read"
+	// ReviewVouchersPermission approves and rejects voucher requests
+	ReviewVouchersPermission Permission = "vouchers:review"
+	// GenerateVouchersPermission generates new vouchers
+	GenerateVouchersPermission Permission = "vouchers:generate"
+	// ManageDeadLettersPermission inspects, requeues and deletes failed deployment requests
+	ManageDeadLettersPermission Permission = "dead_letters:manage"
+	// ManageEmailsPermission inspects and resends emails
+	ManageEmailsPermission Permission = "emails:manage"
+	// ManageRolesPermission assigns roles to users
+	ManageRolesPermission Permission = "roles:manage"
+	// ReadAuditPermission lists and exports the audit log
+	ReadAuditPermission Permission = "audit:read"
+)
+
+// Permissions are all the permissions, the superadmin has all of them
+var Permissions = []Permission{
+	ReadUsersPermission,
+	ResetQuotaPermission,
+	ReadDeploymentsPermission,
+	DeleteDeploymentsPermission,
+	ReadBalancePermission,
+	ManageMaintenancePermission,
+	SendAnnouncementsPermission,
+	ReadVouchersPermission,
+	ReviewVouchersPermission,
+	GenerateVouchersPermission,
+	ManageDeadLettersPermission,
+	ManageEmailsPermission,
+	ManageRolesPermission,
+	ReadAuditPermission,
+}
+
+// RolePermissions are the permissions of every role except the superadmin who has all of them
+var RolePermissions = map[Role][]Permission{
+	VoucherReviewerRole: {
+		ReadUsersPermission,
+		ReadVouchersPermission,
+		ReviewVouchersPermission,
+		GenerateVouchersPermission,
+	},
+	SupportRole: {
+		ReadUsersPermission,
+		ReadDeploymentsPermission,
+		ReadBalancePermission,
+		ReadVouchersPermission,
+		ManageDeadLettersPermission,
+		ManageEmailsPermission,
+	},
+	InstructorRole: {
+		ReadUsersPermission,
+		ReadDeploymentsPermission,
+		ReadVouchersPermission,
+	},
+}
+
+// Valid checks if the role is one of the known roles
+func (r Role) Valid() bool {
+	_, ok := RolePermissions[r]
+	return ok || r == SuperAdminRole
+}
+
+// HasPermission checks if one of the roles has the permission
+func HasPermission(roles []Role, permission Permission) bool {
+	for _, role := range roles {
+		if role == SuperAdminRole {
+			return true
+		}
+		for _, p := range RolePermissions[role] {
+			if p == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// PermissionsOf lists the permissions of the roles
+func PermissionsOf(roles []Role) []Permission {
+	permissions := []Permission{}
+	for _, permission := range Permissions {
+		if HasPermission(roles, permission) {
+			permissions = append(permissions, permission)
+		}
+	}
+	return permissions
+}
+
+// UserRole struct holds a role assigned to a user, the superadmin role is the admin flag of the user
+// so it is never stored here
+type UserRole struct {
+	ID        int       `json:"id" gorm:"primaryKey"`
+	UserID    string    `json:"user_id" gorm:"uniqueIndex:idx_user_role"`
+	Role      Role      `json:"role" gorm:"uniqueIndex:idx_user_role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// StaffUser struct holds a user who has roles
+type StaffUser struct {
+	User  User   `json:"user"`
+	Roles []Role `json:"roles"`
+}
@@ -0,0 +1,33 @@
+// Package models for database models
+package models
+
+import "time"
+
+// Session struct holds a sign in of a user, access tokens of the session are rejected once it is revoked
+type Session struct {
+	ID         string     `json:"id" gorm:"primaryKey"`
+	UserID     string     `json:"-" gorm:"index"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt time.Time  `json:"last_used_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	// Current is set if the session is the one of the request
+	Current bool `json:"current" gorm:"-"`
+}
+
+// Active checks if the session is neither revoked nor expired
+func (s Session) Active() bool {
+	return s.RevokedAt == nil && s.ExpiresAt.After(time.Now())
+}
+
+// RefreshToken struct holds a refresh token of a session, a token can only be used once and is replaced
+// by a new one. The token itself is only stored hashed
+type RefreshToken struct {
+	ID        int        `json:"id" gorm:"primaryKey"`
+	SessionID string     `json:"session_id" gorm:"index"`
+	TokenHash []byte     `json:"-" gorm:"uniqueIndex"`
+	CreatedAt time.Time  `json:"created_at"`
+	UsedAt    *time.Time `json:"used_at"`
+}
@@ -0,0 +1,161 @@
+// Package models for database models
+package models
+
+import "time"
+
+// Store is the storage used by the app, DB implements it for sqlite and postgres
+type Store interface {
+	Migrate() error
+	Transaction(fn func(tx Store) error) error
+
+	// users
+	CreateUser(u *User) error
+	GetUserByEmail(email string) (User, error)
+	GetUserByID(id string) (User, error)
+	ListAllUsers() ([]UserUsedQuota, error)
+	ListAdmins() ([]User, error)
+	UpdatePassword(email string, password []byte) error
+	UpdateUserByID(user User) error
+	UpdateAdminUserByID(id string, admin bool) error
+	UpdateVerification(id string, verified bool) error
+
+	// roles
+	ListUserRoles(userID string) ([]Role, error)
+	ListRolesOfUser(user User) ([]Role, error)
+	AddUserRole(userID string, role Role) (bool, error)
+	RemoveUserRole(userID string, role Role) error
+	ListStaff() ([]StaffUser, error)
+
+	// teams
+	CreateTeam(t *Team) error
+	GetTeam(id int) (Team, error)
+	ListUserTeams(userID string) ([]UserTeam, error)
+	DeleteTeam(id int) error
+	GetTeamMember(teamID int, userID string) (TeamMember, error)
+	ListTeamMembers(teamID int) ([]TeamMemberUser, error)
+	RemoveTeamMember(teamID int, userID string) error
+	CreateTeamInvitation(i *TeamInvitation) (bool, error)
+	GetTeamInvitation(id int) (TeamInvitation, error)
+	ListTeamInvitations(teamID int) ([]TeamInvitation, error)
+	ListEmailInvitations(email string) ([]TeamInvitation, error)
+	AcceptTeamInvitation(id int, userID string) error
+	DeleteTeamInvitation(id int) error
+	ActivateTeamVoucher(teamID int, userID string, voucher string) error
+	GetTeamVms(teamID int) ([]VM, error)
+	GetTeamK8s(teamID int) ([]K8sCluster, error)
+
+	// audit log
+	CreateAuditEvent(e *AuditEvent) error
+	ListAuditEvents(filter AuditFilter) ([]AuditEvent, error)
+
+	// sessions
+	CreateSession(s *Session, t *RefreshToken) error
+	GetSession(id string) (Session, error)
+	ListSessions(userID string) ([]Session, error)
+	RevokeSession(id string, userID string) error
+	RevokeUserSessions(userID string, keptID string) error
+	GetRefreshToken(hash []byte) (RefreshToken, error)
+	RotateRefreshToken(id int, next *RefreshToken) (bool, error)
+
+	// single sign on
+	CreateOIDCLogin(l *OIDCLogin) error
+	ConsumeOIDCLogin(stateHash []byte) (OIDCLogin, error)
+	GetUserIdentity(provider, subject string) (UserIdentity, error)
+	CreateUserIdentity(i *UserIdentity) error
+	ClaimUnverifiedUser(id, name, college string) error
+
+	// personal access tokens
+	CreateAccessToken(t *AccessToken) error
+	GetAccessToken(hash []byte) (AccessToken, error)
+	ListAccessTokens(userID string) ([]AccessToken, error)
+	DeleteAccessToken(id int, userID string) error
+	TouchAccessToken(id int, interval time.Duration) error
+
+	// two factor authentication
+	SetTOTPSecret(userID string, secret string) error
+	EnableTOTP(userID string, step int64, codes []RecoveryCode) error
+	DisableTOTP(userID string) error
+	UseTOTPStep(userID string, step int64) (bool, error)
+	ReplaceRecoveryCodes(userID string, codes []RecoveryCode) error
+	UseRecoveryCode(userID string, hashedCode []byte) (bool, error)
+	CountRecoveryCodes(userID string) (int64, error)
+
+	// verification codes
+	SaveVerificationCode(c *VerificationCode) error
+	GetVerificationCode(userID string, purpose CodePurpose) (VerificationCode, error)
+	AttemptVerificationCode(id int, maxAttempts int) (bool, error)
+	DeleteVerificationCode(id int) error
+
+	// deployments
+	CountAllDeployments() (DeploymentsCount, error)
+	CreateVM(vm *VM) error
+	GetVMByID(id int) (VM, error)
+	GetAllVms(userID string) ([]VM, error)
+	AvailableVMName(name string) (bool, error)
+	DeleteVMByID(id int) error
+	DeleteAllVms(userID string) error
+	CreateK8s(k *K8sCluster) error
+	GetK8s(id int) (K8sCluster, error)
+	GetAllK8s(userID string) ([]K8sCluster, error)
+	DeleteK8s(id int) error
+	DeleteAllK8s(userID string) error
+	AvailableK8sName(name string) (bool, error)
+
+	// quota
+	CreateQuota(q *Quota) error
+	UpdateUserQuota(userID string, quota Resources) error
+	GetUserQuota(userID string) (Quota, error)
+	GetUserQuotaUsage(userID string) (QuotaUsage, error)
+	ReserveQuota(r *QuotaReservation) error
+	CreateJobWithReservation(j *Job, r *QuotaReservation) error
+	GetJobQuotaReservation(jobID int) (QuotaReservation, error)
+	CommitQuotaReservation(id int) error
+	ReleaseQuotaReservation(id int) error
+
+	// vouchers
+	CreateVoucher(v *Voucher) error
+	GetVoucher(voucher string) (Voucher, error)
+	GetVoucherByID(id int) (Voucher, error)
+	ListAllVouchers() ([]Voucher, error)
+	UpdateVoucher(id int, approved bool) (Voucher, error)
+	GetAllPendingVouchers() ([]Voucher, error)
+	GetNotUsedVoucherByUserID(id string) (Voucher, error)
+	DeactivateVoucher(userID string, voucher string) error
+	ActivateVoucher(userID string, voucher string) error
+
+	// maintenance and next launch
+	UpdateMaintenance(on bool) error
+	GetMaintenance() (Maintenance, error)
+	UpdateNextLaunch(on bool) error
+	GetNextLaunch() (NextLaunch, error)
+
+	// notifications
+	ListNotifications(userID string, filter NotificationsFilter) ([]Notification, error)
+	CountUnreadNotifications(userID string) (int64, error)
+	UpdateNotification(id int, userID string, seen bool) error
+	UpdateAllNotifications(userID string, seen bool) error
+	DeleteNotification(id int, userID string) error
+	CreateNotification(n *Notification) error
+
+	// jobs
+	CreateJob(j *Job) error
+	GetJob(id int) (Job, error)
+	ListJobs(userID string) ([]Job, error)
+	UpdateJobState(id int, state JobState, jobErr string) error
+	UpdateJobDeployment(id int, deploymentID int) error
+	StartJob(id int) (bool, error)
+	CancelJob(id int) (bool, error)
+
+	// compensations
+	CreateCompensation(c *Compensation) error
+	ListCompensations() ([]Compensation, error)
+
+	// emails
+	CreateEmails(emails []Email) error
+	GetEmail(id int) (Email, error)
+	ListEmails(filter EmailsFilter) ([]Email, error)
+	DueEmails(limit int) ([]Email, error)
+	ClaimEmail(id int, until time.Time) (bool, error)
+	UpdateEmailState(id int, state EmailState, emailErr string, nextAttemptAt time.Time) error
+	ResendEmail(id int) (bool, error)
+}
@@ -0,0 +1,65 @@
+// Package models for database models
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrTeamHasDeployments is returned on deleting a team that still has deployments or deployment requests
+var ErrTeamHasDeployments = errors.New("team has deployments")
+
+// TeamRole is the role of a member in a team
+type TeamRole string
+
+const (
+	// TeamOwnerRole created the team, invites and removes members and manages all team deployments
+	TeamOwnerRole TeamRole = "owner"
+	// TeamMemberRole deploys with the team quota and manages the team deployments it created
+	TeamMemberRole TeamRole = "member"
+)
+
+// Team struct holds a team of users sharing quota and deployments
+type Team struct {
+	ID      int    `json:"id" gorm:"primaryKey"`
+	Name    string `json:"name"`
+	OwnerID string `json:"owner_id" gorm:"index"`
+	// Resources are the pooled quota vouchers of the members are activated into
+	Resources
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TeamMember struct holds a user in a team
+type TeamMember struct {
+	ID        int       `json:"id" gorm:"primaryKey"`
+	TeamID    int       `json:"team_id" gorm:"uniqueIndex:idx_team_member"`
+	UserID    string    `json:"user_id" gorm:"uniqueIndex:idx_team_member;index"`
+	Role      TeamRole  `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TeamInvitation struct holds an invitation of an email to a team until it is accepted or declined
+type TeamInvitation struct {
+	ID        int       `json:"id" gorm:"primaryKey"`
+	TeamID    int       `json:"team_id" gorm:"uniqueIndex:idx_team_invitation"`
+	TeamName  string    `json:"team_name"`
+	Email     string    `json:"email" gorm:"uniqueIndex:idx_team_invitation;index"`
+	InvitedBy string    `json:"invited_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TeamMemberUser struct holds a member of a team with its user data
+type TeamMemberUser struct {
+	UserID   string    `json:"user_id"`
+	Name     string    `json:"name"`
+	Email    string    `json:"email"`
+	SSHKey   string    `json:"-"`
+	Role     TeamRole  `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// UserTeam struct holds a team of a user with the role of the user in it
+type UserTeam struct {
+	Team
+	Role TeamRole `json:"role"`
+}
@@ -15,7 +15,6 @@ type User struct {
 	Email          string    `json:"email" gorm:"unique" binding:"required"`
 	HashedPassword []byte    `json:"hashed_password" binding:"required"`
 	UpdatedAt      time.Time `json:"updated_at"`
-	Code           int       `json:"code"`
 	SSHKey         string    `json:"ssh_key"`
 	Verified       bool      `json:"verified"`
 	TeamSize       int       `json:"team_size" binding:"required"`
@@ -23,6 +22,11 @@ type User struct {
 	College        string    `json:"college" binding:"required"`
 	// checks if user type is admin
 	Admin bool `json:"admin"`
+	// TOTPSecret is set on enrollment and only used once TOTPEnabled is confirmed
+	TOTPSecret  string `json:"-"`
+	TOTPEnabled bool   `json:"totp_enabled"`
+	// TOTPLastStep is the step of the last used code so a code can't be used twice
+	TOTPLastStep int64 `json:"-"`
 }
 
 // BeforeCreate generates a new uuid
@@ -44,15 +48,18 @@ type UserUsedQuota struct {
 	HashedPassword []byte    `json:"hashed_password"`
 	Voucher        string    `json:"voucher"`
 	UpdatedAt      time.Time `json:"updated_at"`
-	Code           int       `json:"code"`
 	SSHKey         string    `json:"ssh_key"`
 	Verified       bool      `json:"verified"`
 	TeamSize       int       `json:"team_size"`
 	ProjectDesc    string    `json:"project_desc"`
 	College        string    `json:"college"`
 	Admin          bool      `json:"admin"`
-	Vms            int       `json:"vms"`
+	CRU            int       `json:"cru"`
+	MRU            int       `json:"mru"`
+	SRU            int       `json:"sru"`
 	PublicIPs      int       `json:"public_ips"`
-	UsedVms        int       `json:"used_vms"`
+	UsedCRU        int       `json:"used_cru"`
+	UsedMRU        int       `json:"used_mru"`
+	UsedSRU        int       `json:"used_sru"`
 	UsedPublicIPs  int       `json:"used_public_ips"`
 }
@@ -0,0 +1,28 @@
+// Package models for database models
+package models
+
+import "time"
+
+// CodePurpose is what a verification code is sent for
+type CodePurpose string
+
+const (
+	// SignUpCode verifies the email of a new user
+	SignUpCode CodePurpose = "signup"
+	// ResetPasswordCode verifies a user who forgot their password
+	ResetPasswordCode CodePurpose = "reset_password"
+	// TwoFactorSignIn holds the challenge of a user who signed in with a password and still needs a two factor code
+	TwoFactorSignIn CodePurpose = "two_factor_sign_in"
+)
+
+// VerificationCode struct holds the last code sent to a user for a purpose, the code itself is only stored hashed
+type VerificationCode struct {
+	ID         int         `json:"id" gorm:"primaryKey"`
+	UserID     string      `json:"user_id" gorm:"uniqueIndex:idx_verification_codes_user_purpose"`
+	Purpose    CodePurpose `json:"purpose" gorm:"uniqueIndex:idx_verification_codes_user_purpose"`
+	HashedCode []byte      `json:"-"`
+	// Attempts is the count of guesses of the code
+	Attempts  int       `json:"attempts"`
+	ExpiresAt time.Time `json:"expires_at"`
+	SentAt    time.Time `json:"sent_at"`
+}
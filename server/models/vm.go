@@ -16,6 +16,8 @@ type VM struct {
 	MRU               uint64 `json:"mru"`
 	ContractID        uint64 `json:"contractID"`
 	NetworkContractID uint64 `json:"networkContractID"`
+	// TeamID is the team owning the vm, 0 if it is owned by its user only
+	TeamID int `json:"team_id" gorm:"not null;default:0;index"`
 }
 
 // DeploymentsCount has the vms and ips reserved in the grid
@@ -2,27 +2,49 @@
 package streams
 
 import (
-	"time"
+	"errors"
+	"strings"
 
 	"github.com/go-redis/redis"
 )
 
-func (r *RedisClient) Read(stream, group string, count int64, pending bool) (result []redis.XStream, err error) {
+// ReadGroup reads new messages of the group, or its pending messages if pending is set.
+// count limits the read messages and 0 reads all of them
+func (r *RedisClient) ReadGroup(stream, group string, count int64, pending bool) ([]Message, error) {
 	IDs := ">"
 	if pending {
 		IDs = "0"
 	}
 
 	args := redis.XReadGroupArgs{
-		Streams: []string{stream, IDs},
-		Group:   group,
-		Block:   1 * time.Second,
+		Streams:  []string{stream, IDs},
+		Group:    group,
+		Consumer: r.consumer,
+		Block:    readBlock,
 	}
 
 	if count != 0 {
 		args.Count = count
 	}
 
-	result, err = r.DB.XReadGroup(&args).Result()
-	return
+	result, err := r.DB.XReadGroup(&args).Result()
+	if err != nil && strings.HasPrefix(err.Error(), "NOGROUP") {
+		// groups of streams named at runtime like deployment results are created on their first read
+		if err := r.DB.XGroupCreateMkStream(stream, group, "0").Err(); err != nil {
+			return nil, err
+		}
+		result, err = r.DB.XReadGroup(&args).Result()
+	}
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []Message
+	for _, s := range result {
+		messages = append(messages, toMessages(s.Messages)...)
+	}
+	return messages, nil
 }
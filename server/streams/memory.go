@@ -0,0 +1,279 @@
+// Package streams for redis streams
+package streams
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryConsumer is the consumer name of in memory pending messages
+const memoryConsumer = "memory"
+
+// MemoryQueue is an in process queue for single node installations and tests,
+// its messages are lost when the process stops
+type MemoryQueue struct {
+	mu      sync.Mutex
+	lastSeq uint64
+	streams map[string]*memoryStream
+	block   time.Duration
+
+	// subscribers of pub/sub channels
+	subscribers map[string]map[chan []byte]struct{}
+}
+
+type memoryStream struct {
+	// messages are kept until every group reads them, or until they are deleted for streams without groups
+	messages []memoryMessage
+	groups   map[string]*memoryGroup
+	// notify is closed and replaced whenever a message is pushed
+	notify chan struct{}
+}
+
+type memoryGroup struct {
+	lastSeq uint64
+	pending map[string]*memoryPending
+}
+
+type memoryMessage struct {
+	seq uint64
+	Message
+}
+
+type memoryPending struct {
+	memoryMessage
+	deliveredAt time.Time
+	retryCount  int64
+}
+
+// NewMemoryQueue creates a new in memory queue
+func NewMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{
+		streams:     map[string]*memoryStream{},
+		block:       readBlock,
+		subscribers: map[string]map[chan []byte]struct{}{},
+	}
+}
+
+func (q *MemoryQueue) stream(name string) *memoryStream {
+	s, ok := q.streams[name]
+	if !ok {
+		s = &memoryStream{groups: map[string]*memoryGroup{}, notify: make(chan struct{})}
+		q.streams[name] = s
+	}
+	return s
+}
+
+func (q *MemoryQueue) group(stream, name string) *memoryGroup {
+	s := q.stream(stream)
+	g, ok := s.groups[name]
+	if !ok {
+		// a new group reads the messages pushed before it was created
+		g = &memoryGroup{pending: map[string]*memoryPending{}}
+		if len(s.messages) > 0 {
+			g.lastSeq = s.messages[0].seq - 1
+		}
+		s.groups[name] = g
+	}
+	return g
+}
+
+// trim drops messages read by all groups
+func (s *memoryStream) trim() {
+	if len(s.groups) == 0 {
+		return
+	}
+
+	var minSeq uint64
+	first := true
+	for _, g := range s.groups {
+		if first || g.lastSeq < minSeq {
+			minSeq = g.lastSeq
+			first = false
+		}
+	}
+
+	i := 0
+	for i < len(s.messages) && s.messages[i].seq <= minSeq {
+		i++
+	}
+	s.messages = s.messages[i:]
+}
+
+// Push adds a message to the stream
+func (q *MemoryQueue) Push(stream string, data []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.lastSeq++
+	s := q.stream(stream)
+	s.messages = append(s.messages, memoryMessage{
+		seq:     q.lastSeq,
+		Message: Message{ID: fmt.Sprintf("%d-%d", time.Now().UnixMilli(), q.lastSeq), Data: data},
+	})
+
+	close(s.notify)
+	s.notify = make(chan struct{})
+	return nil
+}
+
+// ReadGroup reads new messages of the group, or its pending messages if pending is set.
+// count limits the read messages and 0 reads all of them
+func (q *MemoryQueue) ReadGroup(stream, group string, count int64, pending bool) ([]Message, error) {
+	if pending {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+
+		var messages []Message
+		for _, p := range q.sortedPending(q.group(stream, group), count) {
+			messages = append(messages, p.Message)
+		}
+		return messages, nil
+	}
+
+	timer := time.NewTimer(q.block)
+	defer timer.Stop()
+
+	for {
+		q.mu.Lock()
+		s := q.stream(stream)
+		g := q.group(stream, group)
+
+		var messages []Message
+		now := time.Now()
+		for _, m := range s.messages {
+			if count > 0 && int64(len(messages)) == count {
+				break
+			}
+			if m.seq <= g.lastSeq {
+				continue
+			}
+
+			g.pending[m.ID] = &memoryPending{memoryMessage: m, deliveredAt: now, retryCount: 1}
+			g.lastSeq = m.seq
+			messages = append(messages, m.Message)
+		}
+
+		if len(messages) > 0 {
+			s.trim()
+			q.mu.Unlock()
+			return messages, nil
+		}
+		notify := s.notify
+		q.mu.Unlock()
+
+		select {
+		case <-notify:
+		case <-timer.C:
+			return nil, nil
+		}
+	}
+}
+
+// Ack acknowledges messages of the group so they are no longer pending
+func (q *MemoryQueue) Ack(stream, group string, ids ...string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	g := q.group(stream, group)
+	for _, id := range ids {
+		delete(g.pending, id)
+	}
+	return nil
+}
+
+// Pending lists pending messages of the group, count limits them and 0 lists all of them
+func (q *MemoryQueue) Pending(stream, group string, count int64) ([]PendingMessage, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var pending []PendingMessage
+	now := time.Now()
+	for _, p := range q.sortedPending(q.group(stream, group), count) {
+		pending = append(pending, PendingMessage{
+			ID:         p.ID,
+			Consumer:   memoryConsumer,
+			Idle:       now.Sub(p.deliveredAt),
+			RetryCount: p.retryCount,
+		})
+	}
+	return pending, nil
+}
+
+// Claim takes over pending messages of the group that were idle for at least minIdle
+func (q *MemoryQueue) Claim(stream, group string, minIdle time.Duration, ids ...string) ([]Message, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	g := q.group(stream, group)
+	var messages []Message
+	now := time.Now()
+	for _, id := range ids {
+		p, ok := g.pending[id]
+		if !ok || now.Sub(p.deliveredAt) < minIdle {
+			continue
+		}
+
+		p.deliveredAt = now
+		p.retryCount++
+		messages = append(messages, p.Message)
+	}
+	return messages, nil
+}
+
+// Range lists messages of the stream from the oldest, count limits them and 0 lists all of them
+func (q *MemoryQueue) Range(stream string, count int64) ([]Message, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var messages []Message
+	for _, m := range q.stream(stream).messages {
+		if count > 0 && int64(len(messages)) == count {
+			break
+		}
+		messages = append(messages, m.Message)
+	}
+	return messages, nil
+}
+
+// Delete removes messages from the stream
+func (q *MemoryQueue) Delete(stream string, ids ...string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	deleted := map[string]bool{}
+	for _, id := range ids {
+		deleted[id] = true
+	}
+
+	s := q.stream(stream)
+	messages := s.messages[:0]
+	for _, m := range s.messages {
+		if !deleted[m.ID] {
+			messages = append(messages, m)
+		}
+	}
+	s.messages = messages
+	return nil
+}
+
+// Consumer is the name this queue reads messages as
+func (q *MemoryQueue) Consumer() string {
+	return memoryConsumer
+}
+
+func (q *MemoryQueue) sortedPending(g *memoryGroup, count int64) []*memoryPending {
+	pending := make([]*memoryPending, 0, len(g.pending))
+	for _, p := range g.pending {
+		pending = append(pending, p)
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].seq < pending[j].seq
+	})
+
+	if count > 0 && int(count) < len(pending) {
+		pending = pending[:count]
+	}
+	return pending
+}
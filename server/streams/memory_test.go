@@ -0,0 +1,135 @@
+// Package streams for redis streams
+package streams
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryQueue(t *testing.T) {
+	q := NewMemoryQueue()
+	q.block = 10 * time.Millisecond
+
+	t.Run("read empty stream", func(t *testing.T) {
+		messages, err := q.ReadGroup("stream", "group", 0, false)
+		assert.NoError(t, err)
+		assert.Empty(t, messages)
+	})
+
+	t.Run("push and read", func(t *testing.T) {
+		assert.NoError(t, q.Push("stream", []byte("1")))
+		assert.NoError(t, q.Push("stream", []byte("2")))
+		assert.NoError(t, q.Push("stream", []byte("3")))
+
+		messages, err := q.ReadGroup("stream", "group", 2, false)
+		assert.NoError(t, err)
+		assert.Len(t, messages, 2)
+		assert.Equal(t, []byte("1"), messages[0].Data)
+		assert.Equal(t, []byte("2"), messages[1].Data)
+
+		messages, err = q.ReadGroup("stream", "group", 0, false)
+		assert.NoError(t, err)
+		assert.Len(t, messages, 1)
+		assert.Equal(t, []byte("3"), messages[0].Data)
+	})
+
+	t.Run("pending and ack", func(t *testing.T) {
+		pending, err := q.Pending("stream", "group", 0)
+		assert.NoError(t, err)
+		assert.Len(t, pending, 3)
+		assert.Equal(t, int64(1), pending[0].RetryCount)
+
+		messages, err := q.ReadGroup("stream", "group", 0, true)
+		assert.NoError(t, err)
+		assert.Len(t, messages, 3)
+
+		assert.NoError(t, q.Ack("stream", "group", messages[0].ID, messages[1].ID))
+
+		pending, err = q.Pending("stream", "group", 0)
+		assert.NoError(t, err)
+		assert.Len(t, pending, 1)
+		assert.Equal(t, messages[2].ID, pending[0].ID)
+	})
+
+	t.Run("claim", func(t *testing.T) {
+		pending, err := q.Pending("stream", "group", 0)
+		assert.NoError(t, err)
+
+		messages, err := q.Claim("stream", "group", time.Hour, pending[0].ID)
+		assert.NoError(t, err)
+		assert.Empty(t, messages)
+
+		messages, err = q.Claim("stream", "group", 0, pending[0].ID)
+		assert.NoError(t, err)
+		assert.Len(t, messages, 1)
+		assert.Equal(t, []byte("3"), messages[0].Data)
+
+		pending, err = q.Pending("stream", "group", 0)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), pending[0].RetryCount)
+	})
+
+	t.Run("groups read all messages", func(t *testing.T) {
+		messages, err := q.ReadGroup("stream", "other-group", 0, false)
+		assert.NoError(t, err)
+		assert.Empty(t, messages)
+
+		assert.NoError(t, q.Push("stream", []byte("4")))
+
+		for _, group := range []string{"group", "other-group"} {
+			messages, err := q.ReadGroup("stream", group, 0, false)
+			assert.NoError(t, err)
+			assert.Len(t, messages, 1)
+			assert.Equal(t, []byte("4"), messages[0].Data)
+		}
+	})
+
+	t.Run("read waits for pushed messages", func(t *testing.T) {
+		q.block = time.Second
+
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			assert.NoError(t, q.Push("stream", []byte("5")))
+		}()
+
+		messages, err := q.ReadGroup("stream", "group", 0, false)
+		assert.NoError(t, err)
+		assert.Len(t, messages, 1)
+	})
+
+	t.Run("range and delete", func(t *testing.T) {
+		assert.NoError(t, q.Push("dead", []byte("1")))
+		assert.NoError(t, q.Push("dead", []byte("2")))
+
+		messages, err := q.Range("dead", 0)
+		assert.NoError(t, err)
+		assert.Len(t, messages, 2)
+
+		messages, err = q.Range("dead", 1)
+		assert.NoError(t, err)
+		assert.Len(t, messages, 1)
+		assert.Equal(t, []byte("1"), messages[0].Data)
+
+		assert.NoError(t, q.Delete("dead", messages[0].ID))
+
+		messages, err = q.Range("dead", 0)
+		assert.NoError(t, err)
+		assert.Len(t, messages, 1)
+		assert.Equal(t, []byte("2"), messages[0].Data)
+	})
+
+	t.Run("messages read by all groups are dropped", func(t *testing.T) {
+		messages, err := q.Range("stream", 0)
+		assert.NoError(t, err)
+		assert.Len(t, messages, 1)
+
+		_, err = q.ReadGroup("stream", "other-group", 0, false)
+		assert.NoError(t, err)
+
+		messages, err = q.Range("stream", 0)
+		assert.NoError(t, err)
+		assert.Empty(t, messages)
+	})
+}
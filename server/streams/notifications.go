@@ -0,0 +1,102 @@
+// Package streams for redis streams
+package streams
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/codescalers/cloud4students/models"
+	"github.com/rs/zerolog/log"
+)
+
+// NotificationsChannel is the pub/sub channel new notifications are published to
+const NotificationsChannel = "notifications"
+
+// PublishNotification publishes a new notification to the subscribers of its user on all replicas
+func PublishNotification(q Queue, n models.Notification) error {
+	bytes, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	return q.Publish(NotificationsChannel, bytes)
+}
+
+// NotificationHub fans out published notifications to the subscribers of their users on this replica,
+// the queue is subscribed once for all of them
+type NotificationHub struct {
+	queue Queue
+
+	mu          sync.Mutex
+	subscribers map[string]map[chan models.Notification]struct{}
+}
+
+// NewNotificationHub creates a new notification hub
+func NewNotificationHub(q Queue) *NotificationHub {
+	return &NotificationHub{
+		queue:       q,
+		subscribers: map[string]map[chan models.Notification]struct{}{},
+	}
+}
+
+// Subscribe receives new notifications of the user until the returned unsubscribe is called
+func (h *NotificationHub) Subscribe(userID string) (<-chan models.Notification, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch := make(chan models.Notification, subscriberBuffer)
+	if h.subscribers[userID] == nil {
+		h.subscribers[userID] = map[chan models.Notification]struct{}{}
+	}
+	h.subscribers[userID][ch] = struct{}{}
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+
+			delete(h.subscribers[userID], ch)
+			if len(h.subscribers[userID]) == 0 {
+				delete(h.subscribers, userID)
+			}
+			close(ch)
+		})
+	}
+}
+
+// Run delivers published notifications to their subscribers until ctx is done
+func (h *NotificationHub) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		messages, err := h.queue.Subscribe(ctx, NotificationsChannel)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to subscribe to notifications")
+			time.Sleep(time.Second)
+			continue
+		}
+
+		for data := range messages {
+			var n models.Notification
+			if err := json.Unmarshal(data, &n); err != nil {
+				log.Error().Err(err).Msg("failed to unmarshal notification")
+				continue
+			}
+			h.deliver(n)
+		}
+	}
+}
+
+func (h *NotificationHub) deliver(n models.Notification) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subscribers[n.UserID] {
+		select {
+		case ch <- n:
+		default:
+			log.Warn().Msgf("notifications subscriber of user %s is too slow, notification %d is dropped", n.UserID, n.ID)
+		}
+	}
+}
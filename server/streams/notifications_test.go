@@ -0,0 +1,71 @@
+// Package streams for redis streams
+package streams
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/codescalers/cloud4students/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryPubSub(t *testing.T) {
+	q := NewMemoryQueue()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first, err := q.Subscribe(ctx, "channel")
+	assert.NoError(t, err)
+	second, err := q.Subscribe(context.Background(), "channel")
+	assert.NoError(t, err)
+
+	err = q.Publish("channel", []byte("data"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("data"), <-first)
+	assert.Equal(t, []byte("data"), <-second)
+
+	cancel()
+	_, ok := <-first
+	assert.False(t, ok)
+
+	err = q.Publish("other", []byte("other"))
+	assert.NoError(t, err)
+	assert.Empty(t, second)
+}
+
+func TestNotificationHub(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	q := NewMemoryQueue()
+	hub := NewNotificationHub(q)
+
+	user, unsubscribeUser := hub.Subscribe("user")
+	other, unsubscribeOther := hub.Subscribe("other")
+	defer unsubscribeOther()
+
+	go hub.Run(ctx)
+
+	// wait for the hub to subscribe to the queue
+	assert.Eventually(t, func() bool {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		return len(q.subscribers[NotificationsChannel]) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	err := PublishNotification(q, models.Notification{ID: 1, UserID: "user", Msg: "deployed"})
+	assert.NoError(t, err)
+
+	select {
+	case n := <-user:
+		assert.Equal(t, "deployed", n.Msg)
+	case <-time.After(time.Second):
+		t.Fatal("notification is not delivered")
+	}
+	assert.Empty(t, other)
+
+	unsubscribeUser()
+	unsubscribeUser()
+	_, ok := <-user
+	assert.False(t, ok)
+}
@@ -3,58 +3,38 @@ package streams
 
 import (
 	"encoding/json"
-
-	"github.com/go-redis/redis"
 )
 
 // PushVM pushes a vm deployment to the stream
-func (r *RedisClient) PushVM(vm VMDeployment) error {
-	bytes, err := json.Marshal(vm)
-	if err != nil {
-		return err
-	}
-
-	return r.DB.XAdd(&redis.XAddArgs{
-		Stream: DeployVMStreamName,
-		Values: map[string]interface{}{vm.DL.Name: bytes},
-	}).Err()
+func PushVM(q Queue, vm VMDeployment) error {
+	return push(q, DeployVMStreamName, vm)
 }
 
 // PushK8s pushes a k8s cluster deployment to the stream
-func (r *RedisClient) PushK8s(k8s K8sDeployment) error {
-	bytes, err := json.Marshal(k8s)
-	if err != nil {
-		return err
-	}
+func PushK8s(q Queue, k8s K8sDeployment) error {
+	return push(q, DeployK8sStreamName, k8s)
+}
 
-	return r.DB.XAdd(&redis.XAddArgs{
-		Stream: DeployK8sStreamName,
-		Values: map[string]interface{}{k8s.DL.Master.Name: bytes},
-	}).Err()
+// PushDeploymentResult pushes the result of a deployment to the results stream of the consumer waiting for it
+func PushDeploymentResult(q Queue, consumer string, result DeploymentResult) error {
+	return push(q, DeployResultStreamName(consumer), result)
 }
 
 // PushVMRequest pushes a vm request to the stream
-func (r *RedisClient) PushVMRequest(vm VMDeployRequest) error {
-	bytes, err := json.Marshal(vm)
-	if err != nil {
-		return err
-	}
-
-	return r.DB.XAdd(&redis.XAddArgs{
-		Stream: ReqVMStreamName,
-		Values: map[string]interface{}{string(bytes): bytes},
-	}).Err()
+func PushVMRequest(q Queue, vm VMDeployRequest) error {
+	return push(q, ReqVMStreamName, vm)
 }
 
 // PushK8sRequest pushes a k8s request to the stream
-func (r *RedisClient) PushK8sRequest(k8s K8sDeployRequest) error {
-	bytes, err := json.Marshal(k8s)
+func PushK8sRequest(q Queue, k8s K8sDeployRequest) error {
+	return push(q, ReqK8sStreamName, k8s)
+}
+
+func push(q Queue, stream string, v any) error {
+	bytes, err := json.Marshal(v)
 	if err != nil {
 		return err
 	}
 
-	return r.DB.XAdd(&redis.XAddArgs{
-		Stream: ReqK8sStreamName,
-		Values: map[string]interface{}{string(bytes): bytes},
-	}).Err()
+	return q.Push(stream, bytes)
 }
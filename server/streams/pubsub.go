@@ -0,0 +1,91 @@
+// Package streams for redis streams
+package streams
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Publish sends data to the current subscribers of the channel
+func (r *RedisClient) Publish(channel string, data []byte) error {
+	return r.DB.Publish(channel, data).Err()
+}
+
+// Subscribe receives data published to the channel from all replicas until ctx is done
+func (r *RedisClient) Subscribe(ctx context.Context, channel string) (<-chan []byte, error) {
+	pubsub := r.DB.Subscribe(channel)
+
+	// wait for the subscription to be confirmed so nothing published after it is missed
+	if _, err := pubsub.Receive(); err != nil {
+		_ = pubsub.Close()
+		return nil, err
+	}
+
+	messages := pubsub.Channel()
+	ch := make(chan []byte, subscriberBuffer)
+
+	go func() {
+		defer close(ch)
+		defer pubsub.Close()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case m, ok := <-messages:
+				if !ok {
+					return
+				}
+
+				select {
+				case ch <- []byte(m.Payload):
+				default:
+					log.Warn().Msgf("subscriber of channel %s is too slow, message is dropped", channel)
+				}
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+// Publish sends data to the current subscribers of the channel
+func (q *MemoryQueue) Publish(channel string, data []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for ch := range q.subscribers[channel] {
+		select {
+		case ch <- data:
+		default:
+			log.Warn().Msgf("subscriber of channel %s is too slow, message is dropped", channel)
+		}
+	}
+
+	return nil
+}
+
+// Subscribe receives data published to the channel until ctx is done
+func (q *MemoryQueue) Subscribe(ctx context.Context, channel string) (<-chan []byte, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	ch := make(chan []byte, subscriberBuffer)
+	if q.subscribers[channel] == nil {
+		q.subscribers[channel] = map[chan []byte]struct{}{}
+	}
+	q.subscribers[channel][ch] = struct{}{}
+
+	go func() {
+		<-ctx.Done()
+
+		q.mu.Lock()
+		defer q.mu.Unlock()
+
+		delete(q.subscribers[channel], ch)
+		close(ch)
+	}()
+
+	return ch, nil
+}
@@ -0,0 +1,65 @@
+// Package streams for redis streams
+package streams
+
+import (
+	"context"
+	"time"
+
+	"github.com/codescalers/cloud4students/internal"
+)
+
+const (
+	// readBlock is how long a group read waits for new messages
+	readBlock = 1 * time.Second
+	// subscriberBuffer is how many published messages a slow subscriber can fall behind before they are dropped
+	subscriberBuffer = 64
+)
+
+// Message is a message read from a stream
+type Message struct {
+	ID   string
+	Data []byte
+}
+
+// PendingMessage is a message delivered to a consumer group and not acknowledged yet
+type PendingMessage struct {
+	ID         string
+	Consumer   string
+	Idle       time.Duration
+	RetryCount int64
+}
+
+// Queue holds streams read by consumer groups, a message is pending for a group until it is acknowledged
+type Queue interface {
+	// Push adds a message to the stream
+	Push(stream string, data []byte) error
+	// ReadGroup reads new messages of the group, or its pending messages if pending is set.
+	// count limits the read messages and 0 reads all of them
+	ReadGroup(stream, group string, count int64, pending bool) ([]Message, error)
+	// Ack acknowledges messages of the group so they are no longer pending
+	Ack(stream, group string, ids ...string) error
+	// Pending lists pending messages of the group, count limits them and 0 lists all of them
+	Pending(stream, group string, count int64) ([]PendingMessage, error)
+	// Claim takes over pending messages of the group that were idle for at least minIdle
+	Claim(stream, group string, minIdle time.Duration, ids ...string) ([]Message, error)
+	// Range lists messages of the stream from the oldest, count limits them and 0 lists all of them
+	Range(stream string, count int64) ([]Message, error)
+	// Delete removes messages from the stream
+	Delete(stream string, ids ...string) error
+	// Consumer is the name this queue reads messages as
+	Consumer() string
+
+	// Publish sends data to the current subscribers of the channel on all replicas, it isn't kept for later subscribers
+	Publish(channel string, data []byte) error
+	// Subscribe receives data published to the channel until ctx is done
+	Subscribe(ctx context.Context, channel string) (<-chan []byte, error)
+}
+
+// NewQueue creates the queue selected in the configuration
+func NewQueue(config internal.Configuration) (Queue, error) {
+	if config.Server.Queue == internal.MemoryQueue {
+		return NewMemoryQueue(), nil
+	}
+
+	return NewRedisClient(config)
+}
@@ -2,17 +2,24 @@
 package streams
 
 import (
+	"os"
+	"time"
+
 	"github.com/codescalers/cloud4students/internal"
 	"github.com/go-redis/redis"
 )
 
+// dataKey is the key of message data in redis streams
+const dataKey = "data"
+
 // RedisClient for redis DB handling streams
 type RedisClient struct {
-	DB *redis.Client
+	DB       *redis.Client
+	consumer string
 }
 
 // NewRedisClient creates a new RedisClient
-func NewRedisClient(config internal.Configuration) (RedisClient, error) {
+func NewRedisClient(config internal.Configuration) (*RedisClient, error) {
 	client := redis.NewClient(&redis.Options{
 		Addr:     config.Server.RedisHost + ":" + config.Server.RedisPort,
 		Password: config.Server.RedisPass,
@@ -21,7 +28,7 @@ func NewRedisClient(config internal.Configuration) (RedisClient, error) {
 
 	_, err := client.Ping().Result()
 	if err != nil {
-		return RedisClient{}, err
+		return nil, err
 	}
 
 	client.XGroupCreateMkStream(DeployK8sStreamName, DeployK8sConsumerGroupName, "$")
@@ -29,5 +36,120 @@ func NewRedisClient(config internal.Configuration) (RedisClient, error) {
 	client.XGroupCreateMkStream(ReqVMStreamName, ReqVMConsumerGroupName, "$")
 	client.XGroupCreateMkStream(ReqK8sStreamName, ReqK8sConsumerGroupName, "$")
 
-	return RedisClient{client}, nil
+	// every replica reads as its own consumer
+	consumer, err := os.Hostname()
+	if err != nil {
+		consumer = "c4s"
+	}
+
+	return &RedisClient{DB: client, consumer: consumer}, nil
+}
+
+// Push adds a message to the stream
+func (r *RedisClient) Push(stream string, data []byte) error {
+	return r.DB.XAdd(&redis.XAddArgs{
+		Stream: stream,
+		Values: map[string]interface{}{dataKey: data},
+	}).Err()
+}
+
+// Ack acknowledges messages of the group so they are no longer pending
+func (r *RedisClient) Ack(stream, group string, ids ...string) error {
+	return r.DB.XAck(stream, group, ids...).Err()
+}
+
+// Pending lists pending messages of the group, count limits them and 0 lists all of them
+func (r *RedisClient) Pending(stream, group string, count int64) ([]PendingMessage, error) {
+	if count == 0 {
+		summary, err := r.DB.XPending(stream, group).Result()
+		if err != nil {
+			return nil, err
+		}
+		count = summary.Count
+	}
+
+	if count == 0 {
+		return nil, nil
+	}
+
+	res, err := r.DB.XPendingExt(&redis.XPendingExtArgs{
+		Stream: stream,
+		Group:  group,
+		Start:  "-",
+		End:    "+",
+		Count:  count,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []PendingMessage
+	for _, p := range res {
+		pending = append(pending, PendingMessage{ID: p.Id, Consumer: p.Consumer, Idle: p.Idle, RetryCount: p.RetryCount})
+	}
+	return pending, nil
+}
+
+// Claim takes over pending messages of the group that were idle for at least minIdle
+func (r *RedisClient) Claim(stream, group string, minIdle time.Duration, ids ...string) ([]Message, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	res, err := r.DB.XClaim(&redis.XClaimArgs{
+		Stream:   stream,
+		Group:    group,
+		Consumer: r.consumer,
+		MinIdle:  minIdle,
+		Messages: ids,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	return toMessages(res), nil
+}
+
+// Range lists messages of the stream from the oldest, count limits them and 0 lists all of them
+func (r *RedisClient) Range(stream string, count int64) ([]Message, error) {
+	var res []redis.XMessage
+	var err error
+	if count == 0 {
+		res, err = r.DB.XRange(stream, "-", "+").Result()
+	} else {
+		res, err = r.DB.XRangeN(stream, "-", "+", count).Result()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return toMessages(res), nil
+}
+
+// Delete removes messages from the stream
+func (r *RedisClient) Delete(stream string, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return r.DB.XDel(stream, ids...).Err()
+}
+
+// Consumer is the name this queue reads messages as
+func (r *RedisClient) Consumer() string {
+	return r.consumer
+}
+
+func toMessages(xMessages []redis.XMessage) []Message {
+	var messages []Message
+	for _, m := range xMessages {
+		message := Message{ID: m.ID}
+		// messages pushed by older versions have the data under other keys
+		for _, v := range m.Values {
+			if data, ok := v.(string); ok {
+				message.Data = []byte(data)
+			}
+		}
+		messages = append(messages, message)
+	}
+	return messages
 }
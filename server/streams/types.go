@@ -2,6 +2,8 @@
 package streams
 
 import (
+	"time"
+
 	"github.com/codescalers/cloud4students/models"
 	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
 )
@@ -26,10 +28,24 @@ const (
 	ReqVMStreamName = "vms-req"
 	// ReqK8sStreamName stream name
 	ReqK8sStreamName = "k8s-req"
+
+	// DeployResultConsumerGroupName consumer group name of deployment results
+	DeployResultConsumerGroupName = "deployed-group"
+
+	// DeadVMStreamName stream name of vm requests that failed all attempts
+	DeadVMStreamName = "vms-req-dead"
+	// DeadK8sStreamName stream name of k8s requests that failed all attempts
+	DeadK8sStreamName = "k8s-req-dead"
 )
 
+// DeployResultStreamName is the stream of deployment results of the consumer waiting for them
+func DeployResultStreamName(consumer string) string {
+	return "deployed-" + consumer
+}
+
 // VMDeployRequest type for redis vm deployment request
 type VMDeployRequest struct {
+	JobID       int
 	User        models.User
 	Input       models.DeployVMInput
 	AdminSSHKey string
@@ -37,19 +53,45 @@ type VMDeployRequest struct {
 
 // K8sDeployRequest type for redis k8s deployment request
 type K8sDeployRequest struct {
+	JobID       int
 	User        models.User
 	Input       models.K8sDeployInput
 	AdminSSHKey string
 }
 
-// VMDeployment type for redis vm deployment
+// VMDeployment type for redis vm deployment, its result is pushed to the results stream of ReplyTo
 type VMDeployment struct {
-	Net *workloads.ZNet
-	DL  *workloads.Deployment
+	ID      string
+	ReplyTo string
+	Net     *workloads.ZNet
+	DL      *workloads.Deployment
 }
 
-// K8sDeployment type for redis k8s deployment
+// K8sDeployment type for redis k8s deployment, its result is pushed to the results stream of ReplyTo
 type K8sDeployment struct {
-	Net *workloads.ZNet
-	DL  *workloads.K8sCluster
+	ID      string
+	ReplyTo string
+	Net     *workloads.ZNet
+	DL      *workloads.K8sCluster
+}
+
+// DeploymentResult type for the result of a vm or k8s deployment, an empty error means it succeeded.
+// It holds the contracts the deployment created, even if it failed, so they can be canceled
+type DeploymentResult struct {
+	ID                string
+	Error             string
+	NetworkName       string
+	ContractID        uint64
+	NetworkContractID uint64
+}
+
+// DeadLetter type for a deployment request that can't be deployed
+type DeadLetter struct {
+	ID        string    `json:"id"`
+	Stream    string    `json:"stream"`
+	MessageID string    `json:"message_id"`
+	Data      string    `json:"data"`
+	Error     string    `json:"error"`
+	Attempts  int64     `json:"attempts"`
+	FailedAt  time.Time `json:"failed_at"`
 }